@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+const (
+	// maxAdminUploadSize bounds uploaded databases; GeoIP2 Enterprise, the
+	// largest edition, is well below it.
+	maxAdminUploadSize = 4 << 30
+	// adminUploadTimeout replaces the server's read and write timeouts for
+	// uploads, which take longer than a lookup.
+	adminUploadTimeout = 10 * time.Minute
+)
+
+// adminToken is the bearer token required by /admin endpoints; they are not
+// registered when it is empty.
+var adminToken string
+
+// adminUploadMu serializes database uploads.
+var adminUploadMu sync.Mutex
+
+// adminAuthorized reports whether r carries the admin bearer token.
+func adminAuthorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+// adminDBHandler serves POST /admin/db: it installs the uploaded database,
+// sent as the raw body or as the file of a multipart form, after checking
+// that it opens and answers a test lookup. The file replaces GEOIP_DB_PATH,
+// so the upload survives restarts.
+func adminDBHandler(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="ip-lookup admin"`)
+		writeJSONError(w, "A valid admin token is required", http.StatusUnauthorized, errCodeUnauthorized)
+		return
+	}
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Now().Add(adminUploadTimeout))
+	rc.SetWriteDeadline(time.Now().Add(adminUploadTimeout))
+
+	body := io.Reader(http.MaxBytesReader(w, r.Body, maxAdminUploadSize))
+	if mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil && mediaType == "multipart/form-data" {
+		part, err := uploadedFile(multipart.NewReader(body, params["boundary"]))
+		if err != nil {
+			writeJSONError(w, fmt.Sprintf("Invalid multipart upload: %v", err), http.StatusBadRequest, errCodeInvalidRequest)
+			return
+		}
+		defer part.Close()
+		body = part
+	}
+
+	adminUploadMu.Lock()
+	defer adminUploadMu.Unlock()
+	path := appConfig.GeoIPDBPath
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*.mmdb")
+	if err != nil {
+		log.Printf("Database upload: %v", err)
+		writeJSONError(w, "Could not store the uploaded database", http.StatusInternalServerError, errCodeInternal)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		writeJSONError(w, fmt.Sprintf("The uploaded database exceeds %d bytes", maxAdminUploadSize), http.StatusRequestEntityTooLarge, errCodePayloadTooLarge)
+		return
+	case err != nil:
+		writeJSONError(w, fmt.Sprintf("Reading the upload failed: %v", err), http.StatusBadRequest, errCodeInvalidRequest)
+		return
+	}
+	// CreateTemp makes the file private; other instances must be able to read it.
+	os.Chmod(tmp.Name(), 0o644)
+
+	reader, err := openGeoDB(tmp.Name())
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("The uploaded database is invalid: %v", err), http.StatusUnprocessableEntity, errCodeInvalidDatabase)
+		return
+	}
+	var record geoip2.City
+	_, _, err = lookupCityRecord(reader, dbProbeIP, &record)
+	metadata := reader.Metadata
+	reader.Close()
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("The uploaded database failed a test lookup: %v", err), http.StatusUnprocessableEntity, errCodeInvalidDatabase)
+		return
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		log.Printf("Database upload: %v", err)
+		writeJSONError(w, "Could not replace the database file", http.StatusInternalServerError, errCodeInternal)
+		return
+	}
+	if err := maxMindDB().install(path); err != nil {
+		log.Printf("Database upload: installing %s failed: %v", path, err)
+		writeJSONError(w, "Could not load the uploaded database", http.StatusInternalServerError, errCodeInternal)
+		return
+	}
+	built := time.Unix(int64(metadata.BuildEpoch), 0).UTC().Format(time.RFC3339)
+	log.Printf("Database uploaded through /admin/db: %s built %s.", metadata.DatabaseType, built)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":        "installed",
+		"database_type": metadata.DatabaseType,
+		"build_epoch":   metadata.BuildEpoch,
+		"build_time":    built,
+		"canary":        geoCanary.Load() != nil,
+	})
+}
+
+// uploadedFile returns the first file part of a multipart form.
+func uploadedFile(mr *multipart.Reader) (*multipart.Part, error) {
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, errors.New("no file part in the form")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FileName() != "" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
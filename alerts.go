@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// alertCheckInterval is how often operational conditions are evaluated.
+const alertCheckInterval = time.Minute
+
+// alertErrorRateWindow is the trailing window used for the 5xx rate check.
+const alertErrorRateWindow = 5 * time.Minute
+
+// alertErrorRateMinRequests avoids alerting on a handful of requests.
+const alertErrorRateMinRequests = 20
+
+// Alert keys identify an operational condition for deduplication.
+const (
+	alertKeyDBStale    = "db_stale"
+	alertKeyErrorRate  = "error_rate"
+	alertKeyDBUpdate   = "db_update"
+	alertKeyThrottling = "throttling"
+)
+
+// alerter posts operational alerts to a Slack or Discord incoming webhook,
+// suppressing repeats of the same condition within the cooldown.
+type alerter struct {
+	webhookURL string
+	discord    bool
+	cooldown   time.Duration
+	client     *http.Client
+
+	mu       sync.Mutex
+	lastSent map[string]time.Time
+}
+
+// alerts is the process-wide alerter; nil when no webhook is configured.
+var alerts *alerter
+
+func newAlerter(webhookURL string, cooldown time.Duration) *alerter {
+	discord := false
+	if u, err := url.Parse(webhookURL); err == nil {
+		host := strings.ToLower(u.Hostname())
+		discord = host == "discord.com" || host == "discordapp.com" || strings.HasSuffix(host, ".discord.com")
+	}
+	return &alerter{
+		webhookURL: webhookURL,
+		discord:    discord,
+		cooldown:   cooldown,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		lastSent:   make(map[string]time.Time),
+	}
+}
+
+// notify sends message for the condition key unless one was sent within the
+// cooldown. It is safe to call on a nil alerter.
+func (a *alerter) notify(key, message string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	if last, ok := a.lastSent[key]; ok && time.Since(last) < a.cooldown {
+		a.mu.Unlock()
+		return
+	}
+	a.lastSent[key] = time.Now()
+	a.mu.Unlock()
+
+	go func() {
+		if err := a.send(message); err != nil {
+			log.Printf("Alert webhook delivery failed: %v", err)
+		}
+	}()
+}
+
+// resolve clears the cooldown for key so the next occurrence alerts again.
+func (a *alerter) resolve(key string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.lastSent, key)
+}
+
+func (a *alerter) send(message string) error {
+	text := ":rotating_light: *ip-lookup*: " + message
+	payload := map[string]string{"text": text}
+	if a.discord {
+		payload = map[string]string{"content": strings.ReplaceAll(text, "*ip-lookup*", "**ip-lookup**")}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := a.client.Post(a.webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// runAlertChecks periodically evaluates database staleness, the 5xx rate
+// and the share of requests throttled until ctx is cancelled.
+func runAlertChecks(ctx context.Context, maxDBAge time.Duration, errorRateThreshold, rejectRateThreshold float64) {
+	ticker := time.NewTicker(alertCheckInterval)
+	defer ticker.Stop()
+	var throttled throttleCheck
+	for {
+		checkDBStaleness(maxDBAge)
+		checkErrorRate(errorRateThreshold)
+		throttled.check(rejectRateThreshold)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func checkDBStaleness(maxDBAge time.Duration) {
+	if !databaseLoaded() {
+		return
+	}
+	built := databaseBuildTime()
+	if age := time.Since(built); age > maxDBAge {
+		alerts.notify(alertKeyDBStale, fmt.Sprintf("GeoIP database is stale: built %s (%d days ago, threshold %d days).",
+			built.UTC().Format(time.RFC3339), int(age.Hours()/24), int(maxDBAge.Hours()/24)))
+		return
+	}
+	alerts.resolve(alertKeyDBStale)
+}
+
+func checkErrorRate(threshold float64) {
+	requests, errors := stats.recentTotals(alertErrorRateWindow)
+	if requests < alertErrorRateMinRequests {
+		return
+	}
+	if rate := float64(errors) / float64(requests); rate >= threshold {
+		alerts.notify(alertKeyErrorRate, fmt.Sprintf("Sustained 5xx rate: %.1f%% of %d requests failed in the last %s (threshold %.1f%%).",
+			rate*100, requests, alertErrorRateWindow, threshold*100))
+		return
+	}
+	alerts.resolve(alertKeyErrorRate)
+}
+
+// throttleCheck remembers the rejections counted at the previous check, so
+// each check looks at the requests of the last interval.
+type throttleCheck struct {
+	rejected uint64
+}
+
+// throttledTotal is the number of requests the rate limiter and the load
+// shedder have rejected since startup.
+func throttledTotal() uint64 {
+	var total uint64
+	if limiter != nil {
+		total += limiter.state().Rejected
+	}
+	if shedder != nil {
+		total += shedder.rejected.Load()
+	}
+	return total
+}
+
+// check alerts when the rate limiter and the load shedder rejected at least
+// threshold of the requests since the previous check: clients are being
+// turned away, and the limits or the capacity may need raising.
+func (c *throttleCheck) check(threshold float64) {
+	total := throttledTotal()
+	rejected := total - c.rejected
+	c.rejected = total
+	requests, _ := stats.recentTotals(alertCheckInterval)
+	if requests < alertErrorRateMinRequests {
+		return
+	}
+	if rate := float64(rejected) / float64(requests); rate >= threshold {
+		alerts.notify(alertKeyThrottling, fmt.Sprintf("Requests are being throttled: %d of %d requests (%.1f%%) were rejected by the rate limiter or load shedder in the last %s (threshold %.1f%%).",
+			rejected, requests, rate*100, alertCheckInterval, threshold*100))
+		return
+	}
+	alerts.resolve(alertKeyThrottling)
+}
+
+// alertDBUpdate reports the outcome of a database update attempt: a failed
+// download or verification alerts, and a successful one clears the alert.
+func alertDBUpdate(err error) {
+	if err != nil {
+		alerts.notify(alertKeyDBUpdate, fmt.Sprintf("GeoIP database update failed, still serving the loaded database: %v", err))
+		return
+	}
+	alerts.resolve(alertKeyDBUpdate)
+}
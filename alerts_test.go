@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useAlerter sends alerts to a test webhook for the rest of t and returns
+// the messages it receives.
+func useAlerter(t *testing.T) <-chan string {
+	t.Helper()
+	messages := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		json.NewDecoder(r.Body).Decode(&payload)
+		messages <- payload["text"]
+	}))
+	t.Cleanup(server.Close)
+	old := alerts
+	alerts = newAlerter(server.URL, time.Hour)
+	t.Cleanup(func() { alerts = old })
+	return messages
+}
+
+// receive waits for the next alert message.
+func receive(t *testing.T, messages <-chan string) string {
+	t.Helper()
+	select {
+	case m := <-messages:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("no alert was sent")
+		return ""
+	}
+}
+
+func TestAlertDBUpdateFailure(t *testing.T) {
+	messages := useAlerter(t)
+
+	alertDBUpdate(errors.New("downloaded database is invalid: bad magic"))
+	if m := receive(t, messages); !strings.Contains(m, "update failed") || !strings.Contains(m, "bad magic") {
+		t.Errorf("alert = %q, want the update failure", m)
+	}
+	alertDBUpdate(nil)
+	alertDBUpdate(errors.New("unexpected status 500"))
+	if m := receive(t, messages); !strings.Contains(m, "unexpected status 500") {
+		t.Errorf("alert after recovery = %q, want the new failure", m)
+	}
+}
+
+func TestAlertThrottling(t *testing.T) {
+	messages := useAlerter(t)
+	oldStats, oldLimiter := stats, limiter
+	stats = newStatsCollector()
+	limiter = newRateLimiter(1, 1)
+	t.Cleanup(func() { stats, limiter = oldStats, oldLimiter })
+
+	var c throttleCheck
+	for range 40 {
+		stats.recordRequest(http.StatusOK, time.Millisecond)
+		limiter.take("198.51.100.7")
+	}
+	c.check(0.5)
+	if m := receive(t, messages); !strings.Contains(m, "39 of 40 requests") {
+		t.Errorf("alert = %q, want 39 of 40 requests rejected", m)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log"
+	"net"
+
+	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// anonymousIPDB is the optional GeoIP2 Anonymous IP database
+// (GEOIP_ANONYMOUS_IP_DB_PATH).
+var anonymousIPDB = &supplementaryDB{
+	name:      "Anonymous IP",
+	types:     map[string]bool{"GeoIP2-Anonymous-IP": true},
+	want:      "a GeoIP2-Anonymous-IP database",
+	addFields: addAnonymousIPFields,
+}
+
+// addAnonymousIPFields sets the anonymity flags of ip. The database only
+// lists anonymous networks, so an IP without a record has every flag false.
+func addAnonymousIPFields(reader *maxminddb.Reader, ip net.IP, response map[string]any) error {
+	var record geoip2.AnonymousIP
+	if _, _, err := reader.LookupNetwork(ip, &record); err != nil {
+		return err
+	}
+	for field, flag := range anonymousIPFlags(record) {
+		response[field] = flag
+	}
+	return nil
+}
+
+// lookupAnonymousIP returns the anonymity flags of ip. ok is false when no
+// database is configured or the read failed, in which case the flags are
+// unknown.
+func lookupAnonymousIP(ip net.IP) (record geoip2.AnonymousIP, ok bool) {
+	reader := anonymousIPDB.reader.Load()
+	if reader == nil {
+		return record, false
+	}
+	if _, _, err := reader.LookupNetwork(ip, &record); err != nil {
+		log.Printf("Anonymous IP lookup for IP %s failed: %v", ip.String(), err)
+		return geoip2.AnonymousIP{}, false
+	}
+	return record, true
+}
+
+// anonymousIPFlags maps the response field names to record's flags.
+func anonymousIPFlags(record geoip2.AnonymousIP) map[string]bool {
+	return map[string]bool{
+		"is_anonymous":         record.IsAnonymous,
+		"is_anonymous_vpn":     record.IsAnonymousVPN,
+		"is_hosting_provider":  record.IsHostingProvider,
+		"is_public_proxy":      record.IsPublicProxy,
+		"is_residential_proxy": record.IsResidentialProxy,
+		"is_tor_exit_node":     record.IsTorExitNode,
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// apiVersions are the versions of the HTTP API, each served under
+// /{version}/. Unprefixed paths are aliases of the first, which has the
+// responses the API had before it was versioned; they stay that version when
+// later ones are added, so existing clients are not broken.
+//
+// A new version is added here, and handlers whose responses it changes
+// branch on apiVersion. Endpoints it does not change serve both versions.
+var apiVersions = []string{"v1"}
+
+// apiVersionHeader is the response header naming the version that served
+// a request.
+const apiVersionHeader = "API-Version"
+
+type apiVersionKey struct{}
+
+// requestVersion is the API version of a request and whether its path named it.
+type requestVersion struct {
+	version  string
+	prefixed bool
+}
+
+// apiVersion returns the API version r is served with.
+func apiVersion(r *http.Request) string {
+	if v, ok := r.Context().Value(apiVersionKey{}).(requestVersion); ok {
+		return v.version
+	}
+	return apiVersions[0]
+}
+
+// apiPathPrefix returns the version prefix of r's path, such as "/v1", or ""
+// for unprefixed paths, for building links to other endpoints that keep the
+// version the client chose.
+func apiPathPrefix(r *http.Request) string {
+	if v, ok := r.Context().Value(apiVersionKey{}).(requestVersion); ok && v.prefixed {
+		return "/" + v.version
+	}
+	return ""
+}
+
+// splitAPIVersion returns the version path names and the path without it,
+// or false when it names none of apiVersions.
+func splitAPIVersion(path string) (version, rest string, ok bool) {
+	version, rest, _ = strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if !slices.Contains(apiVersions, version) {
+		return "", path, false
+	}
+	return version, "/" + rest, true
+}
+
+// versionedAPI serves /{version}/... paths as the unprefixed ones with the
+// version in the request context, and names the version of every response
+// in apiVersionHeader.
+func versionedAPI(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version, rest, ok := splitAPIVersion(r.URL.Path)
+		if !ok {
+			w.Header().Set(apiVersionHeader, apiVersions[0])
+			next.ServeHTTP(w, r)
+			return
+		}
+		u := *r.URL
+		u.Path, u.RawPath = rest, ""
+		if r.URL.RawPath != "" {
+			if _, rawRest, ok := splitAPIVersion(r.URL.RawPath); ok {
+				u.RawPath = rawRest
+			}
+		}
+		r2 := r.WithContext(context.WithValue(r.Context(), apiVersionKey{}, requestVersion{version: version, prefixed: true}))
+		r2.URL = &u
+		w.Header().Set(apiVersionHeader, version)
+		next.ServeHTTP(w, r2)
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// asnDB is the optional autonomous system database (GEOIP_ASN_DB_PATH).
+var asnDB = &supplementaryDB{
+	name: "ASN",
+	types: map[string]bool{
+		"GeoLite2-ASN":         true,
+		"GeoIP2-ISP":           true,
+		"GeoIP2-Precision-ISP": true,
+	},
+	want:      "a GeoLite2-ASN or compatible database",
+	addFields: addASNFields,
+}
+
+func addASNFields(reader *maxminddb.Reader, ip net.IP, response map[string]any) error {
+	var record geoip2.ASN
+	_, found, err := lookupASNRecord(reader, ip, &record)
+	if err != nil || !found || record.AutonomousSystemNumber == 0 {
+		return err
+	}
+	response["autonomous_system_number"] = record.AutonomousSystemNumber
+	response["autonomous_system_organization"] = record.AutonomousSystemOrganization
+	return nil
+}
+
+// lookupASN returns the autonomous system record announcing ip from the ASN
+// database, or else the ISP database, which carries the same fields. A
+// failed read is logged and, like a missing record, reported as not found.
+func lookupASN(ip net.IP) (*geoip2.ASN, bool) {
+	record, _, found, err := lookupASNNetwork(ip)
+	if err != nil {
+		log.Printf("ASN lookup for IP %s failed: %v", ip.String(), err)
+	}
+	return record, found
+}
+
+// lookupASNNetwork is lookupASN that also returns the network of the record,
+// the prefix the autonomous system announces, and the error of a failed
+// read when no database had the record.
+func lookupASNNetwork(ip net.IP) (*geoip2.ASN, *net.IPNet, bool, error) {
+	var lastErr error
+	for _, d := range []*supplementaryDB{asnDB, ispDB} {
+		reader := d.reader.Load()
+		if reader == nil {
+			continue
+		}
+		var record geoip2.ASN
+		network, found, err := lookupASNRecord(reader, ip, &record)
+		if err != nil {
+			lastErr = fmt.Errorf("%s database: %w", d.name, err)
+			continue
+		}
+		if found && record.AutonomousSystemNumber != 0 {
+			return &record, network, true, nil
+		}
+	}
+	return nil, nil, false, lastErr
+}
+
+// asnDatabaseLoaded reports whether an ASN or ISP database is loaded.
+func asnDatabaseLoaded() bool {
+	return asnDB.reader.Load() != nil || ispDB.reader.Load() != nil
+}
+
+// asnHandler serves GET /asn/{ip}: the autonomous system announcing the IP
+// and its prefix, read from the ASN database alone, for network tooling that
+// has no use for the location.
+func asnHandler(w http.ResponseWriter, r *http.Request) {
+	if !asnDatabaseLoaded() {
+		writeJSONError(w, "ASN database not available", http.StatusInternalServerError, errCodeDBUnavailable)
+		return
+	}
+	ipStr := r.PathValue("ip")
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		writeJSONError(w, fmt.Sprintf("Invalid IP address format: %s", ipStr), http.StatusBadRequest, errCodeInvalidIP)
+		return
+	}
+
+	record, network, found, err := lookupASNNetwork(ip)
+	if err != nil {
+		log.Printf("ASN lookup for IP %s failed: %v", ip.String(), err)
+		writeJSONError(w, fmt.Sprintf("ASN lookup failed for IP: %s", ip.String()), http.StatusInternalServerError, errCodeDBError)
+		return
+	}
+	if !found {
+		writeJSONError(w, fmt.Sprintf("ASN data not found for IP: %s", ip.String()), http.StatusNotFound, errCodeNotFound)
+		return
+	}
+
+	response := map[string]any{
+		"ip":                             ip.String(),
+		"autonomous_system_number":       record.AutonomousSystemNumber,
+		"autonomous_system_organization": record.AutonomousSystemOrganization,
+		"network":                        network.String(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error encoding JSON response for IP %s: %v", ip.String(), err)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// batchLookupBytesPerIP bounds the request body of a batch lookup: a quoted
+// IPv6 address and its separator fit with room for whitespace.
+const batchLookupBytesPerIP = 64
+
+// batchLookupHandler serves POST /lookup: a JSON array of IPs, answered with
+// an array of their lookups in the same order. Items that cannot be looked up
+// carry their own error instead of failing the batch.
+func batchLookupHandler(w http.ResponseWriter, r *http.Request) {
+	if !databaseLoaded() {
+		writeJSONError(w, "GeoIP service not available", http.StatusInternalServerError, errCodeDBUnavailable)
+		return
+	}
+	limit := appConfig.BatchLookupMaxSize
+	body := http.MaxBytesReader(w, r.Body, int64(limit)*batchLookupBytesPerIP+1024)
+	var ips []string
+	dec := json.NewDecoder(body)
+	err := dec.Decode(&ips)
+	if err == nil {
+		// Anything after the array, such as a second array, is malformed.
+		if _, trailing := dec.Token(); trailing != io.EOF {
+			err = trailing
+			if err == nil {
+				err = errors.New("data after the array")
+			}
+		}
+	}
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		writeJSONError(w, fmt.Sprintf("Too many IPs: the maximum is %d", limit), http.StatusRequestEntityTooLarge, errCodePayloadTooLarge)
+		return
+	case err != nil:
+		writeJSONError(w, "Invalid batch request: the body must be a JSON array of IP address strings", http.StatusBadRequest, errCodeInvalidRequest)
+		return
+	case len(ips) == 0:
+		writeJSONError(w, "Invalid batch request: the array of IPs is empty", http.StatusBadRequest, errCodeInvalidRequest)
+		return
+	case len(ips) > limit:
+		writeJSONError(w, fmt.Sprintf("Too many IPs: %d (maximum %d)", len(ips), limit), http.StatusRequestEntityTooLarge, errCodePayloadTooLarge)
+		return
+	}
+
+	results := make([]map[string]any, len(ips))
+	for i, ipStr := range ips {
+		results[i], _ = lookupBatchItem(r.Context(), ipStr)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		log.Printf("Error encoding batch lookup response: %v", err)
+	}
+}
+
+// streamLookupMaxLineLength bounds a line of a streaming lookup; longer lines
+// get an error result without being read into memory.
+const streamLookupMaxLineLength = 256
+
+// streamLookupIdleTimeout is how long a streaming lookup waits for the next
+// line before closing the connection.
+const streamLookupIdleTimeout = 30 * time.Second
+
+// streamLookupHandler serves POST /lookup/stream: IPs one per line, answered
+// with one JSON result per line (NDJSON) in the same order, written as soon
+// as they are computed. Neither side holds more than a line at a time, so the
+// stream has no size limit. Results are flushed whenever the lines received so
+// far are answered.
+func streamLookupHandler(w http.ResponseWriter, r *http.Request) {
+	if !databaseLoaded() {
+		writeJSONError(w, "GeoIP service not available", http.StatusInternalServerError, errCodeDBUnavailable)
+		return
+	}
+	rc := streamingController(w)
+	in := bufio.NewReaderSize(r.Body, streamLookupMaxLineLength)
+	// Reading before the response is written sends clients waiting on
+	// Expect: 100-continue the go-ahead; otherwise the server closes the body.
+	_ = rc.SetReadDeadline(time.Now().Add(streamLookupIdleTimeout))
+	_, _ = in.Peek(1)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	out := bufio.NewWriter(w)
+	enc := json.NewEncoder(out)
+	for {
+		_ = rc.SetReadDeadline(time.Now().Add(streamLookupIdleTimeout))
+		err := streamLookupLine(r.Context(), in, enc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// The client went away or stalled; what it was sent stays valid.
+			log.Printf("Streaming lookup ended early: %v", err)
+			return
+		}
+		if in.Buffered() == 0 {
+			if err := out.Flush(); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+	if err := out.Flush(); err != nil {
+		log.Printf("Error writing streaming lookup response: %v", err)
+	}
+}
+
+// streamLookupLine reads the next line of IPs from in and writes its result
+// to enc, skipping blank lines. It returns io.EOF once in is exhausted.
+func streamLookupLine(ctx context.Context, in *bufio.Reader, enc *json.Encoder) error {
+	line, err := in.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		if err = discardLine(in); err != nil && err != io.EOF {
+			return err
+		}
+		if encErr := enc.Encode(map[string]any{"error": fmt.Sprintf("Line too long (maximum %d bytes)", streamLookupMaxLineLength), "error_code": errCodeInvalidRequest}); encErr != nil {
+			return encErr
+		}
+		return err
+	}
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if ipStr := string(bytes.TrimSpace(line)); ipStr != "" {
+		result, _ := lookupBatchItem(ctx, ipStr)
+		if encErr := enc.Encode(result); encErr != nil {
+			return encErr
+		}
+	}
+	return err
+}
+
+// streamingController prepares w for a response written while the request
+// body is still read: the stream outlives the server's timeouts, and HTTP/1.1
+// handlers may not read the body once they write without full duplex.
+func streamingController(w http.ResponseWriter) *http.ResponseController {
+	rc := http.NewResponseController(w)
+	if err := rc.EnableFullDuplex(); err != nil {
+		log.Printf("Streaming lookup: could not enable full duplex: %v", err)
+	}
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Streaming lookup: could not clear write deadline: %v", err)
+	}
+	return rc
+}
+
+// discardLine skips the rest of the current line of r.
+func discardLine(r *bufio.Reader) error {
+	for {
+		_, err := r.ReadSlice('\n')
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return err
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBatchLookup(t *testing.T) {
+	useFakeProvider(t, map[string]any{"country_code": "GB"})
+	useConfig(t, Config{BatchLookupMaxSize: 3})
+
+	req := httptest.NewRequest(http.MethodPost, "/lookup", strings.NewReader(` [ "81.2.69.142", "not-an-ip", " 8.8.8.8 " ]`+"\n"))
+	rec := httptest.NewRecorder()
+	batchLookupHandler(rec, req)
+	var results []map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("got %d %s", rec.Code, rec.Body)
+	}
+	want := []string{"", errCodeInvalidIP, ""}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for i, result := range results {
+		if code, _ := result["error_code"].(string); code != want[i] {
+			t.Errorf("result %d = %v, want the error code %q", i, result, want[i])
+		}
+	}
+}
+
+func TestBatchLookupRejectsMalformedRequests(t *testing.T) {
+	useFakeProvider(t, map[string]any{"country_code": "GB"})
+	useConfig(t, Config{BatchLookupMaxSize: 3})
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		// message is part of the error.
+		message string
+	}{
+		{"empty", "", http.StatusBadRequest, "must be a JSON array"},
+		{"not JSON", "8.8.8.8\n1.1.1.1\n", http.StatusBadRequest, "must be a JSON array"},
+		{"truncated", `["8.8.8.8", "1.1.`, http.StatusBadRequest, "must be a JSON array"},
+		{"an object", `{"ips": ["8.8.8.8"]}`, http.StatusBadRequest, "must be a JSON array"},
+		{"a number", `["8.8.8.8", 1]`, http.StatusBadRequest, "must be a JSON array"},
+		{"a nested array", `[["8.8.8.8"]]`, http.StatusBadRequest, "must be a JSON array"},
+		{"data after the array", `["8.8.8.8"]]`, http.StatusBadRequest, "must be a JSON array"},
+		{"a second array", `["8.8.8.8"] ["1.1.1.1"]`, http.StatusBadRequest, "must be a JSON array"},
+		{"null", "null", http.StatusBadRequest, "the array of IPs is empty"},
+		{"empty array", "[]", http.StatusBadRequest, "the array of IPs is empty"},
+		{"too many IPs", `["8.8.8.8", "1.1.1.1", "9.9.9.9", "8.8.4.4"]`, http.StatusRequestEntityTooLarge, "Too many IPs: 4 (maximum 3)"},
+		{"too large", `["` + strings.Repeat("1", 4096) + `"]`, http.StatusRequestEntityTooLarge, "Too many IPs: the maximum is 3"},
+		{"too large after the array", `["8.8.8.8"]` + strings.Repeat(" ", 4096), http.StatusRequestEntityTooLarge, "Too many IPs: the maximum is 3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/lookup", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			batchLookupHandler(rec, req)
+			if rec.Code != tt.status || !strings.Contains(rec.Body.String(), tt.message) {
+				t.Errorf("got %d %s, want %d with %q", rec.Code, rec.Body, tt.status, tt.message)
+			}
+		})
+	}
+}
+
+func TestStreamLookup(t *testing.T) {
+	useFakeProvider(t, map[string]any{"country_code": "GB"})
+
+	long := strings.Repeat("1", streamLookupMaxLineLength)
+	tests := []struct {
+		name string
+		body string
+		// want has the error code of each result, "" for a lookup.
+		want []string
+	}{
+		{"lines", "81.2.69.142\r\n\n  \nnot-an-ip\n 8.8.8.8 \n", []string{"", errCodeInvalidIP, ""}},
+		{"no final newline", "81.2.69.142\n8.8.8.8", []string{"", ""}},
+		{"empty", "", nil},
+		{"a line too long", "81.2.69.142\n" + long + "\n8.8.8.8\n", []string{"", errCodeInvalidRequest, ""}},
+		{"cut in a line too long", "81.2.69.142\n" + long, []string{"", errCodeInvalidRequest}},
+		{"a line of the maximum length", strings.Repeat(" ", streamLookupMaxLineLength-len("8.8.8.8\n")) + "8.8.8.8\n", []string{""}},
+		{"binary", "\x00\xff\x1f\n8.8.8.8\n", []string{errCodeInvalidIP, ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/lookup/stream", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			streamLookupHandler(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got %d %s", rec.Code, rec.Body)
+			}
+			var got []string
+			dec := json.NewDecoder(rec.Body)
+			for dec.More() {
+				var result map[string]any
+				if err := dec.Decode(&result); err != nil {
+					t.Fatal(err)
+				}
+				code, _ := result["error_code"].(string)
+				got = append(got, code)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got the error codes %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
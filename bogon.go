@@ -0,0 +1,98 @@
+package main
+
+import "net"
+
+// IP address classifications for special-purpose ranges (RFC 6890 and the
+// IANA special-purpose address registries).
+const (
+	ipTypePublic        = "public"
+	ipTypePrivate       = "private"
+	ipTypeLoopback      = "loopback"
+	ipTypeLinkLocal     = "link_local"
+	ipTypeCGNAT         = "cgnat"
+	ipTypeMulticast     = "multicast"
+	ipTypeDocumentation = "documentation"
+	ipTypeReserved      = "reserved"
+)
+
+type specialRange struct {
+	network *net.IPNet
+	ipType  string
+}
+
+// specialRanges lists non-public ranges, most specific first where they overlap.
+var specialRanges = func() []specialRange {
+	ranges := []struct{ cidr, ipType string }{
+		{"0.0.0.0/8", ipTypeReserved},
+		{"10.0.0.0/8", ipTypePrivate},
+		{"100.64.0.0/10", ipTypeCGNAT},
+		{"127.0.0.0/8", ipTypeLoopback},
+		{"169.254.0.0/16", ipTypeLinkLocal},
+		{"172.16.0.0/12", ipTypePrivate},
+		{"192.0.0.0/24", ipTypeReserved},
+		{"192.0.2.0/24", ipTypeDocumentation},
+		{"192.88.99.0/24", ipTypeReserved},
+		{"192.168.0.0/16", ipTypePrivate},
+		{"198.18.0.0/15", ipTypeReserved},
+		{"198.51.100.0/24", ipTypeDocumentation},
+		{"203.0.113.0/24", ipTypeDocumentation},
+		{"224.0.0.0/4", ipTypeMulticast},
+		{"240.0.0.0/4", ipTypeReserved},
+		{"::/128", ipTypeReserved},
+		{"::1/128", ipTypeLoopback},
+		{"100::/64", ipTypeReserved},
+		{"2001:db8::/32", ipTypeDocumentation},
+		{"fc00::/7", ipTypePrivate},
+		{"fe80::/10", ipTypeLinkLocal},
+		{"ff00::/8", ipTypeMulticast},
+	}
+	out := make([]specialRange, 0, len(ranges))
+	for _, r := range ranges {
+		_, network, err := net.ParseCIDR(r.cidr)
+		if err != nil {
+			panic(err)
+		}
+		out = append(out, specialRange{network: network, ipType: r.ipType})
+	}
+	return out
+}()
+
+// globalUnicastV6 is the only IPv6 block IANA allocates for global unicast.
+var _, globalUnicastV6, _ = net.ParseCIDR("2000::/3")
+
+// classifyIP returns the classification of ip: ipTypePublic for globally
+// routable addresses, otherwise the special-purpose range it belongs to.
+func classifyIP(ip net.IP) string {
+	for _, r := range specialRanges {
+		if r.network.Contains(ip) {
+			return r.ipType
+		}
+	}
+	if ip.To4() == nil && !globalUnicastV6.Contains(ip) {
+		return ipTypeReserved
+	}
+	return ipTypePublic
+}
+
+// missResponse is the 200 body returned for an IP without a database record
+// when MISS_BEHAVIOR is "null" or "bogon": the usual fields set to null and,
+// with classify, the IP's classification.
+func missResponse(ip net.IP, classify bool) map[string]any {
+	response := map[string]any{
+		"ip":           ip.String(),
+		"city":         nil,
+		"country_code": nil,
+		"country_name": nil,
+		"continent":    nil,
+		"latitude":     nil,
+		"longitude":    nil,
+		"time_zone":    nil,
+		"postal_code":  nil,
+	}
+	if classify {
+		ipType := classifyIP(ip)
+		response["ip_type"] = ipType
+		response["bogon"] = ipType != ipTypePublic
+	}
+	return response
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// dbCanaryLoggedDiffs caps the differences logged per canary so a database
+// with widespread changes does not flood the log; all are still counted.
+const dbCanaryLoggedDiffs = 100
+
+// dbCanary is a new database version serving a share of lookups next to
+// the current MaxMind database until it is promoted. Lookups it serves are
+// also read from the current database and the results compared.
+type dbCanary struct {
+	reader  *maxminddb.Reader
+	started time.Time
+	// threshold selects the IPs served by the canary: those whose hash
+	// modulo 10000 is below it.
+	threshold uint32
+
+	mu          sync.Mutex
+	lookups     uint64
+	diffs       uint64
+	diffsByKind map[string]uint64
+}
+
+// geoCanary is the database under canary rollout; nil when there is none.
+var geoCanary atomic.Pointer[dbCanary]
+
+// canaryMu serializes starting and promoting canaries.
+var canaryMu sync.Mutex
+
+// canaryPercent and canaryDuration configure rollouts; zero percent swaps
+// new databases in directly. They are set from the configuration in main.
+var (
+	canaryPercent  float64
+	canaryDuration time.Duration
+)
+
+// install makes the database at path serve lookups: directly when canary
+// rollouts are disabled or no database is loaded yet, otherwise as a canary
+// that is promoted after canaryDuration.
+func (p *maxMindProvider) install(path string) error {
+	if canaryPercent <= 0 || p.current() == nil {
+		if err := p.load(path); err != nil {
+			return err
+		}
+		log.Printf("GeoIP database switched to the build of %s.", databaseBuildTime().UTC().Format(time.RFC3339))
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	reader, err := openGeoDB(path)
+	if err != nil {
+		return err
+	}
+
+	canaryMu.Lock()
+	defer canaryMu.Unlock()
+	modTime := info.ModTime()
+	p.modTime.Store(&modTime)
+	canary := &dbCanary{
+		reader:      reader,
+		started:     time.Now(),
+		threshold:   uint32(math.Round(canaryPercent * 100)),
+		diffsByKind: make(map[string]uint64),
+	}
+	if old := geoCanary.Swap(canary); old != nil {
+		log.Printf("GeoIP database canary replaced by a newer version: %s", old.summary())
+		closeGeoDBLater(old.reader)
+	}
+	log.Printf("GeoIP database canary started: build %s serves %g%% of lookups for %s.",
+		time.Unix(int64(reader.Metadata.BuildEpoch), 0).UTC().Format(time.RFC3339), canaryPercent, canaryDuration)
+	time.AfterFunc(canaryDuration, func() { p.promote(canary) })
+	return nil
+}
+
+// promote makes canary the database serving all lookups, unless it has
+// been replaced in the meantime.
+func (p *maxMindProvider) promote(canary *dbCanary) {
+	canaryMu.Lock()
+	defer canaryMu.Unlock()
+	if geoCanary.Load() != canary {
+		return
+	}
+	p.swap(canary.reader)
+	geoCanary.Store(nil)
+	log.Printf("GeoIP database canary promoted: %s", canary.summary())
+}
+
+// selects reports whether the canary serves lookups for ip. The choice is a
+// hash of the IP, so an address consistently gets answers from one version.
+func (c *dbCanary) selects(ip net.IP) bool {
+	h := fnv.New32a()
+	h.Write(ip.To16())
+	return h.Sum32()%10000 < c.threshold
+}
+
+// compare looks ip up in the serving database and records how the canary's
+// answer differs from it.
+func (c *dbCanary) compare(ip net.IP, current *maxminddb.Reader, record *geoip2.City, found bool) {
+	var old geoip2.City
+	_, oldFound, err := lookupCityRecord(current, ip, &old)
+	if err != nil {
+		return // the comparison is best effort; the canary answer stands
+	}
+	var kinds []string
+	switch {
+	case found != oldFound:
+		kinds = append(kinds, "found")
+	case found:
+		kinds = cityDiff(&old, record)
+	}
+
+	c.mu.Lock()
+	c.lookups++
+	if len(kinds) > 0 {
+		c.diffs++
+		for _, kind := range kinds {
+			c.diffsByKind[kind]++
+		}
+	}
+	logDiff := len(kinds) > 0 && c.diffs <= dbCanaryLoggedDiffs
+	c.mu.Unlock()
+
+	if logDiff {
+		log.Printf("GeoIP database canary differs for %s: %v (old %s, new %s)",
+			ip.String(), kinds, describeCity(&old, oldFound), describeCity(record, found))
+	}
+}
+
+// cityDiff names the fields of the lookup response that differ between a
+// and b.
+func cityDiff(a, b *geoip2.City) []string {
+	var kinds []string
+	if a.Country.IsoCode != b.Country.IsoCode {
+		kinds = append(kinds, "country")
+	}
+	if !slices.Equal(subdivisionCodes(a), subdivisionCodes(b)) {
+		kinds = append(kinds, "subdivision")
+	}
+	if a.City.Names["en"] != b.City.Names["en"] {
+		kinds = append(kinds, "city")
+	}
+	if a.Postal.Code != b.Postal.Code {
+		kinds = append(kinds, "postal_code")
+	}
+	if a.Location.TimeZone != b.Location.TimeZone {
+		kinds = append(kinds, "time_zone")
+	}
+	if a.Location.Latitude != b.Location.Latitude || a.Location.Longitude != b.Location.Longitude {
+		kinds = append(kinds, "location")
+	}
+	return kinds
+}
+
+// subdivisionCodes lists the ISO codes of record's subdivisions, most
+// general first.
+func subdivisionCodes(record *geoip2.City) []string {
+	codes := make([]string, 0, len(record.Subdivisions))
+	for _, s := range record.Subdivisions {
+		codes = append(codes, s.IsoCode)
+	}
+	return codes
+}
+
+// describeCity summarizes record for diff log lines.
+func describeCity(record *geoip2.City, found bool) string {
+	if !found {
+		return "no record"
+	}
+	return record.Country.IsoCode + "/" + record.City.Names["en"]
+}
+
+// canaryState is the canary state reported by /stats.
+type canaryState struct {
+	Build       time.Time         `json:"build"`
+	Started     time.Time         `json:"started"`
+	PromotesAt  time.Time         `json:"promotes_at"`
+	Percent     float64           `json:"percent"`
+	Lookups     uint64            `json:"lookups"`
+	Differences uint64            `json:"differences"`
+	ByField     map[string]uint64 `json:"differences_by_field"`
+}
+
+func (c *dbCanary) state() canaryState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	byField := make(map[string]uint64, len(c.diffsByKind))
+	for kind, n := range c.diffsByKind {
+		byField[kind] = n
+	}
+	return canaryState{
+		Build:       time.Unix(int64(c.reader.Metadata.BuildEpoch), 0).UTC(),
+		Started:     c.started,
+		PromotesAt:  c.started.Add(canaryDuration),
+		Percent:     float64(c.threshold) / 100,
+		Lookups:     c.lookups,
+		Differences: c.diffs,
+		ByField:     byField,
+	}
+}
+
+// summary describes the canary's comparison results for log lines.
+func (c *dbCanary) summary() string {
+	s := c.state()
+	rate := 0.0
+	if s.Lookups > 0 {
+		rate = 100 * float64(s.Differences) / float64(s.Lookups)
+	}
+	return fmt.Sprintf("%d of %d compared lookups (%.2f%%) differed %v", s.Differences, s.Lookups, rate, s.ByField)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// CBOR major types (RFC 8949).
+const (
+	cborUnsigned = 0
+	cborNegative = 1
+	cborText     = 3
+	cborArray    = 4
+	cborMap      = 5
+	cborSimple   = 7
+)
+
+// cborAppendHead appends a CBOR data item head for the given major type and
+// argument, using the shortest encoding.
+func cborAppendHead(b []byte, major byte, arg uint64) []byte {
+	m := major << 5
+	switch {
+	case arg < 24:
+		return append(b, m|byte(arg))
+	case arg <= math.MaxUint8:
+		return append(b, m|24, byte(arg))
+	case arg <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, m|25), uint16(arg))
+	case arg <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, m|26), uint32(arg))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, m|27), arg)
+	}
+}
+
+// cborAppend appends the CBOR encoding of v. Supported types are those
+// produced by the lookup response builders: strings, bools, integers,
+// float64, nil, and maps/slices of them. Map keys are emitted in canonical
+// (length-first, then bytewise) order so encodings are deterministic.
+func cborAppend(b []byte, v any) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, cborSimple<<5|22), nil
+	case bool:
+		if v {
+			return append(b, cborSimple<<5|21), nil
+		}
+		return append(b, cborSimple<<5|20), nil
+	case string:
+		b = cborAppendHead(b, cborText, uint64(len(v)))
+		return append(b, v...), nil
+	case int:
+		return cborAppendInt(b, int64(v)), nil
+	case int64:
+		return cborAppendInt(b, v), nil
+	case uint:
+		return cborAppendHead(b, cborUnsigned, uint64(v)), nil
+	case uint16:
+		return cborAppendHead(b, cborUnsigned, uint64(v)), nil
+	case uint32:
+		return cborAppendHead(b, cborUnsigned, uint64(v)), nil
+	case uint64:
+		return cborAppendHead(b, cborUnsigned, v), nil
+	case float64:
+		// Use single precision when it round-trips exactly to keep payloads compact.
+		if f32 := float32(v); float64(f32) == v {
+			return binary.BigEndian.AppendUint32(append(b, cborSimple<<5|26), math.Float32bits(f32)), nil
+		}
+		return binary.BigEndian.AppendUint64(append(b, cborSimple<<5|27), math.Float64bits(v)), nil
+	case []string:
+		b = cborAppendHead(b, cborArray, uint64(len(v)))
+		for _, item := range v {
+			b = cborAppendHead(b, cborText, uint64(len(item)))
+			b = append(b, item...)
+		}
+		return b, nil
+	case []any:
+		b = cborAppendHead(b, cborArray, uint64(len(v)))
+		for _, item := range v {
+			var err error
+			if b, err = cborAppend(b, item); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case []map[string]any:
+		b = cborAppendHead(b, cborArray, uint64(len(v)))
+		for _, item := range v {
+			var err error
+			if b, err = cborAppend(b, item); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]string:
+		m := make(map[string]any, len(v))
+		for k, s := range v {
+			m[k] = s
+		}
+		return cborAppend(b, m)
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if len(keys[i]) != len(keys[j]) {
+				return len(keys[i]) < len(keys[j])
+			}
+			return keys[i] < keys[j]
+		})
+		b = cborAppendHead(b, cborMap, uint64(len(v)))
+		for _, k := range keys {
+			b = cborAppendHead(b, cborText, uint64(len(k)))
+			b = append(b, k...)
+			var err error
+			if b, err = cborAppend(b, v[k]); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("cbor: unsupported type %T", v)
+	}
+}
+
+func cborAppendInt(b []byte, v int64) []byte {
+	if v < 0 {
+		return cborAppendHead(b, cborNegative, uint64(-(v + 1)))
+	}
+	return cborAppendHead(b, cborUnsigned, uint64(v))
+}
+
+// cborMarshal returns the CBOR encoding of v.
+func cborMarshal(v any) ([]byte, error) {
+	return cborAppend(nil, v)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// cborDecode decodes the data item at the start of b, returning the rest.
+// Integers decode as int64, floats as float64, arrays as []any and maps as
+// map[string]any; it accepts what cborAppend writes and nothing more.
+func cborDecode(b []byte) (any, []byte, error) {
+	if len(b) == 0 {
+		return nil, nil, errors.New("cbor: unexpected end of input")
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+	b = b[1:]
+	var arg uint64
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info <= 27:
+		n := 1 << (info - 24)
+		if len(b) < n {
+			return nil, nil, errors.New("cbor: unexpected end of input")
+		}
+		for _, c := range b[:n] {
+			arg = arg<<8 | uint64(c)
+		}
+		b = b[n:]
+	default:
+		return nil, nil, fmt.Errorf("cbor: unsupported additional information %d", info)
+	}
+	switch major {
+	case cborUnsigned:
+		return int64(arg), b, nil
+	case cborNegative:
+		return -1 - int64(arg), b, nil
+	case cborText:
+		if uint64(len(b)) < arg {
+			return nil, nil, errors.New("cbor: unexpected end of input")
+		}
+		return string(b[:arg]), b[arg:], nil
+	case cborArray:
+		list := []any{}
+		for i := uint64(0); i < arg; i++ {
+			var item any
+			var err error
+			if item, b, err = cborDecode(b); err != nil {
+				return nil, nil, err
+			}
+			list = append(list, item)
+		}
+		return list, b, nil
+	case cborMap:
+		m := map[string]any{}
+		for i := uint64(0); i < arg; i++ {
+			k, rest, err := cborDecode(b)
+			if err != nil {
+				return nil, nil, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, nil, fmt.Errorf("cbor: map key %v is not a string", k)
+			}
+			if m[key], b, err = cborDecode(rest); err != nil {
+				return nil, nil, err
+			}
+		}
+		return m, b, nil
+	case cborSimple:
+		switch info {
+		case 20:
+			return false, b, nil
+		case 21:
+			return true, b, nil
+		case 22:
+			return nil, b, nil
+		case 26:
+			return float64(math.Float32frombits(uint32(arg))), b, nil
+		case 27:
+			return math.Float64frombits(arg), b, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("cbor: unsupported major type %d", major)
+}
+
+func TestCBORMarshalVectors(t *testing.T) {
+	// Encodings from RFC 8949 appendix A, except that floats use single
+	// precision at the smallest, where the RFC has half-precision examples.
+	tests := []struct {
+		v    any
+		want string
+	}{
+		{0, "00"},
+		{uint(23), "17"},
+		{24, "1818"},
+		{uint16(1000), "1903e8"},
+		{uint32(1000000), "1a000f4240"},
+		{uint64(1000000000000), "1b000000e8d4a51000"},
+		{uint64(math.MaxUint64), "1bffffffffffffffff"},
+		{-1, "20"},
+		{int64(-1000), "3903e7"},
+		{false, "f4"},
+		{true, "f5"},
+		{nil, "f6"},
+		{"", "60"},
+		{"IETF", "6449455446"},
+		{"ü", "62c3bc"},
+		{1.5, "fa3fc00000"},
+		{1.1, "fb3ff199999999999a"},
+		{[]any{}, "80"},
+		{[]any{1, []any{2, 3}, []string{"4"}}, "8301820203816134"},
+		{map[string]any{}, "a0"},
+		{map[string]any{"a": 1, "b": []any{2, 3}}, "a26161016162820203"},
+		// Keys are sorted by length, then bytewise.
+		{map[string]string{"bb": "x", "a": "y", "c": "z"}, "a3616161796163617a6262626178"},
+	}
+	for _, tt := range tests {
+		got, err := cborMarshal(tt.v)
+		if err != nil {
+			t.Errorf("cborMarshal(%#v): %v", tt.v, err)
+			continue
+		}
+		if hex.EncodeToString(got) != tt.want {
+			t.Errorf("cborMarshal(%#v) = %x, want %s", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestCBORRoundTrip(t *testing.T) {
+	response := map[string]any{
+		"ip":                       "8.8.8.8",
+		"city":                     "Mountain View",
+		"latitude":                 37.4223,
+		"longitude":                -122.085,
+		"accuracy_radius":          uint16(1000),
+		"autonomous_system_number": uint(15169),
+		"is_in_european_union":     false,
+		"postal_code":              nil,
+		"subdivisions":             []map[string]any{{"iso_code": "CA", "name": "California"}},
+		"names":                    map[string]string{"en": "United States", "de": "Vereinigte Staaten"},
+		"tags":                     []string{"anycast"},
+		"offset":                   -25200,
+		"geoname_id":               uint32(5375480),
+	}
+	want := map[string]any{
+		"ip":                       "8.8.8.8",
+		"city":                     "Mountain View",
+		"latitude":                 37.4223,
+		"longitude":                -122.085,
+		"accuracy_radius":          int64(1000),
+		"autonomous_system_number": int64(15169),
+		"is_in_european_union":     false,
+		"postal_code":              nil,
+		"subdivisions":             []any{map[string]any{"iso_code": "CA", "name": "California"}},
+		"names":                    map[string]any{"en": "United States", "de": "Vereinigte Staaten"},
+		"tags":                     []any{"anycast"},
+		"offset":                   int64(-25200),
+		"geoname_id":               int64(5375480),
+	}
+	encoded, err := cborMarshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, rest, err := cborDecode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 0 {
+		t.Errorf("%d bytes after the encoded map", len(rest))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decoded %#v, want %#v", got, want)
+	}
+
+	again, _ := cborMarshal(response)
+	if !bytes.Equal(encoded, again) {
+		t.Error("encodings of the same map differ")
+	}
+}
+
+func TestCBORMarshalLengthForms(t *testing.T) {
+	// The head of a string is one byte, and one, two or four more for
+	// lengths that do not fit in it.
+	tests := []struct{ n, head int }{{23, 1}, {24, 2}, {255, 2}, {256, 3}, {65535, 3}, {65536, 5}}
+	for _, tt := range tests {
+		s := strings.Repeat("x", tt.n)
+		encoded, err := cborMarshal(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if head := len(encoded) - tt.n; head != tt.head {
+			t.Errorf("string of %d bytes has a %d byte head, want %d", tt.n, head, tt.head)
+		}
+		if got, rest, err := cborDecode(encoded); err != nil || got != s || len(rest) != 0 {
+			t.Errorf("string of %d bytes does not decode back: %d bytes left, %v", tt.n, len(rest), err)
+		}
+	}
+}
+
+func TestCBORMarshalRejectsUnsupportedTypes(t *testing.T) {
+	for _, v := range []any{
+		struct{}{},
+		float32(1),
+		[]int{1},
+		map[int]any{1: "a"},
+		map[string]any{"nested": map[string]any{"bad": make(chan int)}},
+		[]any{"ok", func() {}},
+		[]map[string]any{{"bad": int8(1)}},
+	} {
+		if got, err := cborMarshal(v); err == nil {
+			t.Errorf("cborMarshal(%T) = %x, want an error", v, got)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// cloudProvider is a hosting provider of CLOUD_RANGES: the feed of its
+// published ranges and how to read it.
+type cloudProvider struct {
+	// url is the feed; empty for providers without a stable URL, which
+	// must be given one.
+	url   string
+	parse func(io.Reader) ([]netip.Prefix, error)
+}
+
+// cloudProviders are the providers CLOUD_RANGES can name, with their feeds.
+var cloudProviders = map[string]cloudProvider{
+	"aws":          {"https://ip-ranges.amazonaws.com/ip-ranges.json", jsonPrefixes("ip_prefix", "ipv6_prefix")},
+	"gcp":          {"https://www.gstatic.com/ipranges/cloud.json", jsonPrefixes("ipv4Prefix", "ipv6Prefix")},
+	"azure":        {"", jsonPrefixes("addressPrefixes")},
+	"cloudflare":   {"https://api.cloudflare.com/client/v4/ips", jsonPrefixes("ipv4_cidrs", "ipv6_cidrs")},
+	"digitalocean": {"https://digitalocean.com/geo/google.csv", parseThreatList},
+	"linode":       {"https://geoip.linode.com/", parseThreatList},
+	"oracle":       {"https://docs.oracle.com/en-us/iaas/tools/public_ip_ranges.json", jsonPrefixes("cidr")},
+}
+
+// cloudRanges holds the provider ranges of CLOUD_RANGES, in the lists and
+// tree of threat lists; nil when it is not set.
+var cloudRanges *threatIntel
+
+// jsonPrefixes returns a parser of JSON feeds collecting the networks under
+// any of keys, as strings or arrays of strings, wherever they are nested.
+func jsonPrefixes(keys ...string) func(io.Reader) ([]netip.Prefix, error) {
+	return func(r io.Reader) ([]netip.Prefix, error) {
+		var feed any
+		if err := json.NewDecoder(r).Decode(&feed); err != nil {
+			return nil, err
+		}
+		var networks []netip.Prefix
+		var walk func(v any) error
+		walk = func(v any) error {
+			switch v := v.(type) {
+			case []any:
+				for _, item := range v {
+					if err := walk(item); err != nil {
+						return err
+					}
+				}
+			case map[string]any:
+				for k, item := range v {
+					if !slices.Contains(keys, k) {
+						if err := walk(item); err != nil {
+							return err
+						}
+						continue
+					}
+					values, ok := item.([]any)
+					if !ok {
+						values = []any{item}
+					}
+					for _, value := range values {
+						s, _ := value.(string)
+						network, err := parseThreatNetwork(s)
+						if err != nil {
+							return fmt.Errorf("%s: %v", k, err)
+						}
+						networks = append(networks, network)
+					}
+				}
+			}
+			return nil
+		}
+		if err := walk(feed); err != nil {
+			return nil, err
+		}
+		if len(networks) == 0 {
+			return nil, fmt.Errorf("no networks in the feed")
+		}
+		return networks, nil
+	}
+}
+
+// parseCloudRanges parses CLOUD_RANGES: comma-separated providers, each a
+// name of cloudProviders, read from its feed or from the path or URL after
+// "=", or any other name with a path or URL of a plain list of networks.
+func parseCloudRanges(spec string) ([]*threatList, error) {
+	var lists []*threatList
+	names := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, source, _ := strings.Cut(entry, "=")
+		name, source = strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(source)
+		provider, known := cloudProviders[name]
+		switch {
+		case name == "":
+			return nil, fmt.Errorf("invalid entry %q", entry)
+		case source == "" && !known:
+			return nil, fmt.Errorf("unknown provider %q: name a path or URL with %s=source", name, name)
+		case source == "" && provider.url == "":
+			return nil, fmt.Errorf("provider %q has no stable feed URL: name one with %s=source", name, name)
+		case source == "":
+			source = provider.url
+		}
+		if names[name] {
+			return nil, fmt.Errorf("duplicate provider %q", name)
+		}
+		names[name] = true
+		lists = append(lists, &threatList{name: name, source: source, parse: provider.parse})
+	}
+	if len(lists) > maxThreatLists {
+		return nil, fmt.Errorf("%d providers, at most %d are supported", len(lists), maxThreatLists)
+	}
+	return lists, nil
+}
+
+// addCloudFields sets the is_datacenter field of response, and hosting to
+// the provider whose ranges have ip, the first in CLOUD_RANGES where
+// several do. Both are null until a feed has been read.
+func addCloudFields(ip net.IP, response map[string]any) {
+	if cloudRanges == nil {
+		return
+	}
+	if !cloudRanges.loaded.Load() {
+		response["is_datacenter"] = nil
+		response["hosting"] = nil
+		return
+	}
+	matched := cloudRanges.match(ip)
+	response["is_datacenter"] = matched != 0
+	response["hosting"] = nil
+	for i, list := range cloudRanges.lists {
+		if matched&(1<<i) != 0 {
+			response["hosting"] = map[string]any{"provider": list.name}
+			break
+		}
+	}
+}
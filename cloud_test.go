@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net"
+	"net/netip"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCloudFeeds(t *testing.T) {
+	tests := []struct {
+		provider string
+		feed     string
+		want     []string
+	}{
+		{"aws", `{"syncToken": "1", "prefixes": [{"ip_prefix": "3.5.140.0/22", "region": "ap-northeast-2", "service": "AMAZON"}],
+			"ipv6_prefixes": [{"ipv6_prefix": "2600:1f14::/35", "region": "us-west-2"}]}`, []string{"3.5.140.0/22", "2600:1f14::/35"}},
+		{"gcp", `{"prefixes": [{"ipv4Prefix": "34.1.208.0/20", "service": "Google Cloud"}, {"ipv6Prefix": "2600:1900:4000::/44"}]}`, []string{"34.1.208.0/20", "2600:1900:4000::/44"}},
+		{"azure", `{"changeNumber": 1, "values": [{"name": "AzureCloud", "properties": {"addressPrefixes": ["13.64.0.0/16", "2603:1000::/40"]}}]}`, []string{"13.64.0.0/16", "2603:1000::/40"}},
+		{"cloudflare", `{"result": {"ipv4_cidrs": ["173.245.48.0/20"], "ipv6_cidrs": ["2400:cb00::/32"], "etag": "x"}, "success": true, "errors": []}`, []string{"173.245.48.0/20", "2400:cb00::/32"}},
+		{"digitalocean", "5.101.96.0/21,NL,NL-NH,Amsterdam,1098 XH\n2a03:b0c0::/48,NL,NL-NH,Amsterdam,\n", []string{"5.101.96.0/21", "2a03:b0c0::/48"}},
+		{"linode", "# ip_prefix,alpha2code,region,city,postal_code\n45.33.0.0/17,US,US-CA,Fremont,\n", []string{"45.33.0.0/17"}},
+		{"oracle", `{"last_updated_timestamp": "x", "regions": [{"region": "us-phoenix-1", "cidrs": [{"cidr": "129.146.0.0/21", "tags": ["OCI"]}]}]}`, []string{"129.146.0.0/21"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			networks, err := cloudProviders[tt.provider].parse(strings.NewReader(tt.feed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, network := range networks {
+				got = append(got, network.String())
+			}
+			// Keys of a JSON object are walked in any order.
+			slices.Sort(got)
+			if want := slices.Sorted(slices.Values(tt.want)); !reflect.DeepEqual(got, want) {
+				t.Errorf("parsed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCloudFeedsRejectMalformedFeeds(t *testing.T) {
+	aws := cloudProviders["aws"].parse
+	cloudflare := cloudProviders["cloudflare"].parse
+	tests := []struct {
+		name string
+		feed string
+		// message is part of the error.
+		message string
+	}{
+		{"empty", "", "EOF"},
+		{"not JSON", "<html>Forbidden</html>", "invalid character"},
+		{"truncated", `{"prefixes": [{"ip_prefix": "3.5.140.0/22"}, {"ip_pre`, "unexpected EOF"},
+		{"no prefixes", `{"syncToken": "1", "prefixes": []}`, "no networks in the feed"},
+		{"another provider's feed", `{"prefixes": [{"ipv4Prefix": "34.1.208.0/20"}]}`, "no networks in the feed"},
+		{"an invalid network", `{"prefixes": [{"ip_prefix": "3.5.140.0/22"}, {"ip_prefix": "3.5.140.0/33"}]}`, `ip_prefix: invalid network "3.5.140.0/33"`},
+		{"a network of the wrong type", `{"prefixes": [{"ip_prefix": 3}]}`, `ip_prefix: invalid network ""`},
+		{"a null network", `{"prefixes": [{"ip_prefix": null}]}`, `ip_prefix: invalid network ""`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			networks, err := aws(strings.NewReader(tt.feed))
+			if err == nil || !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("parse = %v, %v, want an error containing %q", networks, err, tt.message)
+			}
+		})
+	}
+	// An API error of Cloudflare has no result.
+	if networks, err := cloudflare(strings.NewReader(`{"result": null, "success": false, "errors": [{"code": 10000}]}`)); err == nil {
+		t.Errorf("parse = %v, want an error", networks)
+	}
+}
+
+func TestParseCloudRanges(t *testing.T) {
+	lists, err := parseCloudRanges(" AWS , azure=/data/ServiceTags_Public.json,,hetzner=https://example.com/hetzner.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, list := range lists {
+		got = append(got, list.name+"="+list.source)
+	}
+	want := []string{"aws=https://ip-ranges.amazonaws.com/ip-ranges.json", "azure=/data/ServiceTags_Public.json", "hetzner=https://example.com/hetzner.txt"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parsed %v, want %v", got, want)
+	}
+	if lists[2].parse != nil {
+		t.Error("a provider of a plain list has a JSON parser")
+	}
+
+	tests := []struct {
+		spec    string
+		message string
+	}{
+		{"=/data/x.txt", "invalid entry"},
+		{"hetzner", `unknown provider "hetzner"`},
+		{"/data/x.txt", "unknown provider"},
+		{"azure", `provider "azure" has no stable feed URL`},
+		{"aws,AWS=/data/aws.json", `duplicate provider "aws"`},
+	}
+	for _, tt := range tests {
+		if lists, err := parseCloudRanges(tt.spec); err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("parseCloudRanges(%q) = %d lists, %v, want an error containing %q", tt.spec, len(lists), err, tt.message)
+		}
+	}
+}
+
+func TestAddCloudFields(t *testing.T) {
+	old := cloudRanges
+	t.Cleanup(func() { cloudRanges = old })
+	cloudRanges = newThreatIntel("Cloud ranges", []*threatList{{name: "aws"}, {name: "gcp"}})
+
+	response := map[string]any{}
+	addCloudFields(net.ParseIP("3.5.140.1"), response)
+	if want := map[string]any{"is_datacenter": nil, "hosting": nil}; !reflect.DeepEqual(response, want) {
+		t.Errorf("before a feed is read: %v, want %v", response, want)
+	}
+
+	tree := &threatTree{}
+	tree.insert(netip.MustParsePrefix("3.5.140.0/22"), 0)
+	tree.insert(netip.MustParsePrefix("3.5.0.0/16"), 1)
+	cloudRanges.tree.Store(tree)
+	cloudRanges.loaded.Store(true)
+	tests := []struct {
+		ip   string
+		want map[string]any
+	}{
+		// The first provider of CLOUD_RANGES wins.
+		{"3.5.140.1", map[string]any{"is_datacenter": true, "hosting": map[string]any{"provider": "aws"}}},
+		{"3.5.0.1", map[string]any{"is_datacenter": true, "hosting": map[string]any{"provider": "gcp"}}},
+		{"192.0.2.1", map[string]any{"is_datacenter": false, "hosting": nil}},
+	}
+	for _, tt := range tests {
+		response := map[string]any{}
+		addCloudFields(net.ParseIP(tt.ip), response)
+		if !reflect.DeepEqual(response, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.ip, response, tt.want)
+		}
+	}
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// This file implements a minimal CoAP (RFC 7252) server over UDP. It answers
+// GET /lookup/{ip} and GET /lookup (the sender's address) with a CBOR-encoded
+// lookup response, for constrained devices that cannot afford HTTP/TLS.
+//
+// UDP source addresses can be spoofed, so the server limits what it sends to
+// addresses that have not shown they receive its responses: requests are
+// rate limited per source, and responses larger than coapAmplificationFactor
+// times the request are replaced with an Echo (RFC 9175) challenge until the
+// client repeats the request with the Echo value.
+
+// CoAP message types.
+const (
+	coapConfirmable    = 0
+	coapNonConfirmable = 1
+	coapAcknowledgment = 2
+	coapReset          = 3
+)
+
+// CoAP codes, encoded as class<<5 | detail.
+const (
+	coapCodeEmpty            = 0x00
+	coapCodeGET              = 0x01
+	coapCodeContent          = 0x45 // 2.05
+	coapCodeBadRequest       = 0x80 // 4.00
+	coapCodeUnauthorized     = 0x81 // 4.01
+	coapCodeNotFound         = 0x84 // 4.04
+	coapCodeMethodNotAllowed = 0x85 // 4.05
+	coapCodeInternalError    = 0xA0 // 5.00
+)
+
+// CoAP option numbers and content formats used here.
+const (
+	coapOptionURIPath       = 11
+	coapOptionContentFormat = 12
+	coapOptionEcho          = 252
+	coapFormatLinkFormat    = 40
+	coapFormatCBOR          = 60
+)
+
+// coapMaxMessageSize bounds received datagrams; RFC 7252 recommends messages
+// fit in 1152 bytes, so anything larger is not a request we serve.
+const coapMaxMessageSize = 1280
+
+// coapAmplificationFactor bounds the size of responses to unverified
+// sources relative to their request, as RFC 9175 section 2.4 recommends.
+const coapAmplificationFactor = 3
+
+// coapEchoLifetime is how long an Echo value verifies its source.
+const coapEchoLifetime = 5 * time.Minute
+
+// coapMaxInFlight caps the requests answered at a time, so a slow lookup
+// does not hold up the others. Requests arriving while all are busy are
+// dropped; clients retransmit Confirmable requests.
+const coapMaxInFlight = 64
+
+var errCoAPMalformed = errors.New("coap: malformed message")
+
+type coapOption struct {
+	number int
+	value  []byte
+}
+
+type coapMessage struct {
+	msgType   byte
+	code      byte
+	messageID uint16
+	token     []byte
+	options   []coapOption
+	payload   []byte
+}
+
+func parseCoAPMessage(b []byte) (*coapMessage, error) {
+	if len(b) < 4 || b[0]>>6 != 1 {
+		return nil, errCoAPMalformed
+	}
+	m := &coapMessage{
+		msgType:   (b[0] >> 4) & 0x3,
+		code:      b[1],
+		messageID: binary.BigEndian.Uint16(b[2:4]),
+	}
+	tkl := int(b[0] & 0xF)
+	if tkl > 8 || len(b) < 4+tkl {
+		return nil, errCoAPMalformed
+	}
+	m.token = b[4 : 4+tkl]
+	b = b[4+tkl:]
+
+	number := 0
+	for len(b) > 0 {
+		if b[0] == 0xFF {
+			// A payload marker must be followed by a payload (RFC 7252 section 3).
+			if len(b) == 1 {
+				return nil, errCoAPMalformed
+			}
+			m.payload = b[1:]
+			break
+		}
+		delta, length := int(b[0]>>4), int(b[0]&0xF)
+		b = b[1:]
+		var err error
+		if delta, b, err = coapExtendedValue(delta, b); err != nil {
+			return nil, err
+		}
+		if length, b, err = coapExtendedValue(length, b); err != nil {
+			return nil, err
+		}
+		if len(b) < length {
+			return nil, errCoAPMalformed
+		}
+		number += delta
+		m.options = append(m.options, coapOption{number: number, value: b[:length]})
+		b = b[length:]
+	}
+	return m, nil
+}
+
+// coapExtendedValue decodes the extended option delta/length encoding.
+func coapExtendedValue(v int, b []byte) (int, []byte, error) {
+	switch v {
+	case 13:
+		if len(b) < 1 {
+			return 0, nil, errCoAPMalformed
+		}
+		return int(b[0]) + 13, b[1:], nil
+	case 14:
+		if len(b) < 2 {
+			return 0, nil, errCoAPMalformed
+		}
+		return int(binary.BigEndian.Uint16(b)) + 269, b[2:], nil
+	case 15:
+		return 0, nil, errCoAPMalformed
+	}
+	return v, b, nil
+}
+
+func (m *coapMessage) marshal() []byte {
+	b := []byte{1<<6 | m.msgType<<4 | byte(len(m.token)), m.code}
+	b = binary.BigEndian.AppendUint16(b, m.messageID)
+	b = append(b, m.token...)
+
+	sort.SliceStable(m.options, func(i, j int) bool { return m.options[i].number < m.options[j].number })
+	last := 0
+	for _, opt := range m.options {
+		b = coapAppendOptionHeader(b, opt.number-last, len(opt.value))
+		b = append(b, opt.value...)
+		last = opt.number
+	}
+	if len(m.payload) > 0 {
+		b = append(b, 0xFF)
+		b = append(b, m.payload...)
+	}
+	return b
+}
+
+func coapAppendOptionHeader(b []byte, delta, length int) []byte {
+	nibble := func(v int) (int, []byte) {
+		switch {
+		case v < 13:
+			return v, nil
+		case v < 269:
+			return 13, []byte{byte(v - 13)}
+		default:
+			return 14, binary.BigEndian.AppendUint16(nil, uint16(v-269))
+		}
+	}
+	d, dExt := nibble(delta)
+	l, lExt := nibble(length)
+	b = append(b, byte(d<<4|l))
+	b = append(b, dExt...)
+	return append(b, lExt...)
+}
+
+// coapUintOption encodes v as a minimal-length CoAP uint option value.
+func coapUintOption(number int, v uint32) coapOption {
+	value := binary.BigEndian.AppendUint32(nil, v)
+	for len(value) > 0 && value[0] == 0 {
+		value = value[1:]
+	}
+	return coapOption{number: number, value: value}
+}
+
+// coapServer answers CoAP lookup requests on a UDP socket.
+type coapServer struct {
+	conn   net.PacketConn
+	nextID atomic.Uint32
+	// limiter bounds the requests answered per source address; nil when
+	// COAP_RATE_LIMIT_RPS is 0.
+	limiter *rateLimiter
+	// echoKey authenticates the Echo values the server hands out.
+	echoKey []byte
+	// slots holds a token for each request being answered.
+	slots chan struct{}
+}
+
+func newCoAPServer(addr string, rateLimit float64) (*coapServer, error) {
+	conn, err := upgrades.listenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &coapServer{conn: conn, echoKey: make([]byte, 32), slots: make(chan struct{}, coapMaxInFlight)}
+	rand.Read(s.echoKey)
+	if rateLimit > 0 {
+		s.limiter = newRateLimiter(rateLimit, max(1, int(math.Ceil(rateLimit))))
+	}
+	return s, nil
+}
+
+// serve handles datagrams until the socket is closed. Each request is
+// answered in a goroutine of its own, at most coapMaxInFlight at a time.
+func (s *coapServer) serve() {
+	buf := make([]byte, coapMaxMessageSize)
+	for {
+		n, peer, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("CoAP: read error: %v", err)
+			continue
+		}
+		// The message refers to its datagram, and buf is read into again.
+		req, err := parseCoAPMessage(bytes.Clone(buf[:n]))
+		if err != nil {
+			// Malformed messages are silently ignored, as RFC 7252 section 4.2 allows.
+			continue
+		}
+		if s.limiter != nil && !s.limiter.take(coapPeerIP(peer)).allowed {
+			// Any answer would be sent to a source that may be spoofed.
+			continue
+		}
+		select {
+		case s.slots <- struct{}{}:
+		default:
+			continue
+		}
+		go func() {
+			defer func() { <-s.slots }()
+			s.respond(req, peer, n)
+		}()
+	}
+}
+
+// respond sends peer the response to req, a request of size bytes.
+func (s *coapServer) respond(req *coapMessage, peer net.Addr, size int) {
+	resp := s.handle(req, peer)
+	if resp == nil {
+		return
+	}
+	encoded := resp.marshal()
+	if now := time.Now(); len(encoded) > coapAmplificationFactor*size && !s.echoVerified(req, peer, now) {
+		encoded = s.echoChallenge(resp, peer, now).marshal()
+	}
+	if _, err := s.conn.WriteTo(encoded, peer); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("CoAP: write to %s failed: %v", peer, err)
+	}
+}
+
+func (s *coapServer) close() error {
+	return s.conn.Close()
+}
+
+// coapPeerIP returns the IP address of peer.
+func coapPeerIP(peer net.Addr) string {
+	if udpAddr, ok := peer.(*net.UDPAddr); ok {
+		return udpAddr.IP.String()
+	}
+	return peer.String()
+}
+
+// echoValue returns the Echo value handed to peer at now: the time, followed
+// by a MAC of it and the peer's address, so it needs no server state.
+func (s *coapServer) echoValue(peer net.Addr, now time.Time) []byte {
+	value := binary.BigEndian.AppendUint64(nil, uint64(now.Unix()))
+	mac := hmac.New(sha256.New, s.echoKey)
+	mac.Write(value)
+	mac.Write([]byte(coapPeerIP(peer)))
+	return mac.Sum(value)[:16]
+}
+
+// echoVerified reports whether req carries a fresh Echo value handed to peer.
+func (s *coapServer) echoVerified(req *coapMessage, peer net.Addr, now time.Time) bool {
+	for _, opt := range req.options {
+		if opt.number != coapOptionEcho || len(opt.value) != 16 {
+			continue
+		}
+		issued := time.Unix(int64(binary.BigEndian.Uint64(opt.value)), 0)
+		if now.Sub(issued) > coapEchoLifetime || issued.After(now) {
+			continue
+		}
+		if hmac.Equal(opt.value, s.echoValue(peer, issued)) {
+			return true
+		}
+	}
+	return false
+}
+
+// echoChallenge replaces resp, too large for an unverified peer, with a
+// 4.01 response carrying an Echo value to repeat the request with.
+func (s *coapServer) echoChallenge(resp *coapMessage, peer net.Addr, now time.Time) *coapMessage {
+	return &coapMessage{
+		msgType:   resp.msgType,
+		code:      coapCodeUnauthorized,
+		messageID: resp.messageID,
+		token:     resp.token,
+		options:   []coapOption{{number: coapOptionEcho, value: s.echoValue(peer, now)}},
+	}
+}
+
+// handle builds the response to req, or nil if none should be sent.
+func (s *coapServer) handle(req *coapMessage, peer net.Addr) *coapMessage {
+	switch req.msgType {
+	case coapAcknowledgment, coapReset:
+		return nil
+	}
+	if req.code == coapCodeEmpty {
+		// CoAP ping: an empty Confirmable message is answered with a Reset.
+		if req.msgType == coapConfirmable {
+			return &coapMessage{msgType: coapReset, messageID: req.messageID}
+		}
+		return nil
+	}
+
+	resp := &coapMessage{token: req.token}
+	if req.msgType == coapConfirmable {
+		// Piggybacked response.
+		resp.msgType, resp.messageID = coapAcknowledgment, req.messageID
+	} else {
+		resp.msgType, resp.messageID = coapNonConfirmable, uint16(s.nextID.Add(1))
+	}
+
+	if req.code != coapCodeGET {
+		resp.code, resp.payload = coapCodeMethodNotAllowed, []byte("Method not allowed")
+		return resp
+	}
+
+	var path []string
+	for _, opt := range req.options {
+		if opt.number == coapOptionURIPath {
+			path = append(path, string(opt.value))
+		}
+	}
+
+	switch {
+	case len(path) == 2 && path[0] == ".well-known" && path[1] == "core":
+		resp.code = coapCodeContent
+		resp.options = []coapOption{coapUintOption(coapOptionContentFormat, coapFormatLinkFormat)}
+		resp.payload = []byte(fmt.Sprintf(`</lookup>;rt="geo";ct=%d`, coapFormatCBOR))
+	case len(path) >= 1 && len(path) <= 2 && path[0] == "lookup":
+		ipStr := ""
+		if len(path) == 2 {
+			ipStr = path[1]
+		} else if _, ok := peer.(*net.UDPAddr); ok {
+			ipStr = coapPeerIP(peer)
+		}
+		s.lookup(resp, ipStr)
+	default:
+		resp.code, resp.payload = coapCodeNotFound, []byte("Not found")
+	}
+	return resp
+}
+
+func (s *coapServer) lookup(resp *coapMessage, ipStr string) {
+	record, err := lookupIPString(context.Background(), ipStr)
+	if errors.Is(err, errInvalidIP) {
+		resp.code, resp.payload = coapCodeBadRequest, []byte(fmt.Sprintf("Invalid IP address format: %s", ipStr))
+		return
+	}
+	if errors.Is(err, errNoRecord) {
+		var ok bool
+		if record, ok = missResult(net.ParseIP(strings.TrimSpace(ipStr))); !ok {
+			resp.code, resp.payload = coapCodeNotFound, []byte(fmt.Sprintf("GeoIP data not found for IP: %s", ipStr))
+			return
+		}
+		err = nil
+	}
+	if err != nil {
+		log.Printf("CoAP: GeoIP lookup for IP %s failed: %v", ipStr, err)
+		resp.code, resp.payload = coapCodeInternalError, []byte(fmt.Sprintf("GeoIP lookup failed for IP: %s", ipStr))
+		return
+	}
+	payload, err := cborMarshal(record)
+	if err != nil {
+		log.Printf("CoAP: error encoding CBOR response for IP %s: %v", ipStr, err)
+		resp.code, resp.payload = coapCodeInternalError, []byte("Could not encode response")
+		return
+	}
+	resp.code, resp.payload = coapCodeContent, payload
+	resp.options = []coapOption{coapUintOption(coapOptionContentFormat, coapFormatCBOR)}
+}
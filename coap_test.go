@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startCoAPServer serves CoAP on a loopback port for the rest of t and
+// returns a client socket connected to it.
+func startCoAPServer(t *testing.T, rateLimit float64) net.Conn {
+	t.Helper()
+	s, err := newCoAPServer("127.0.0.1:0", rateLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.serve()
+	t.Cleanup(func() { s.close() })
+	conn, err := net.Dial("udp", s.conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// coapExchange sends req on conn and returns the response, or nil when none
+// arrives.
+func coapExchange(t *testing.T, conn net.Conn, req *coapMessage) *coapMessage {
+	t.Helper()
+	if _, err := conn.Write(req.marshal()); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	buf := make([]byte, coapMaxMessageSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil
+	}
+	resp, err := parseCoAPMessage(buf[:n])
+	if err != nil {
+		t.Fatalf("parsing response: %v", err)
+	}
+	return resp
+}
+
+func coapLookupRequest(id uint16, ip string, options ...coapOption) *coapMessage {
+	return &coapMessage{
+		msgType:   coapConfirmable,
+		code:      coapCodeGET,
+		messageID: id,
+		token:     []byte{1, 2},
+		options: append([]coapOption{
+			{number: coapOptionURIPath, value: []byte("lookup")},
+			{number: coapOptionURIPath, value: []byte(ip)},
+		}, options...),
+	}
+}
+
+func TestCoAPEchoChallengeBeforeLargeResponses(t *testing.T) {
+	useFakeProvider(t, map[string]any{"country_code": "US", "country_name": "United States", "city": "Mountain View", "time_zone": "America/Los_Angeles"})
+	conn := startCoAPServer(t, 0)
+
+	req := coapLookupRequest(1, "192.0.2.1")
+	resp := coapExchange(t, conn, req)
+	if resp == nil || resp.code != coapCodeUnauthorized {
+		t.Fatalf("first response = %+v, want a 4.01 challenge", resp)
+	}
+	if size := len(resp.marshal()); size > coapAmplificationFactor*len(req.marshal()) {
+		t.Errorf("challenge is %d bytes for a %d byte request", size, len(req.marshal()))
+	}
+	var echo []byte
+	for _, opt := range resp.options {
+		if opt.number == coapOptionEcho {
+			echo = opt.value
+		}
+	}
+	if echo == nil {
+		t.Fatalf("challenge has no Echo option: %+v", resp.options)
+	}
+
+	resp = coapExchange(t, conn, coapLookupRequest(2, "192.0.2.1", coapOption{number: coapOptionEcho, value: echo}))
+	if resp == nil || resp.code != coapCodeContent || len(resp.payload) == 0 {
+		t.Fatalf("response with Echo = %+v, want 2.05 with the lookup", resp)
+	}
+
+	forged := append([]byte(nil), echo...)
+	forged[len(forged)-1] ^= 1
+	resp = coapExchange(t, conn, coapLookupRequest(3, "192.0.2.1", coapOption{number: coapOptionEcho, value: forged}))
+	if resp == nil || resp.code != coapCodeUnauthorized {
+		t.Errorf("response with a forged Echo = %+v, want a 4.01 challenge", resp)
+	}
+}
+
+func TestCoAPSmallResponsesNeedNoEcho(t *testing.T) {
+	conn := startCoAPServer(t, 0)
+	resp := coapExchange(t, conn, coapLookupRequest(1, "not-an-ip"))
+	if resp == nil || resp.code != coapCodeBadRequest {
+		t.Errorf("response = %+v, want 4.00 without a challenge", resp)
+	}
+}
+
+func TestCoAPRateLimitDropsRequests(t *testing.T) {
+	conn := startCoAPServer(t, 1)
+	if resp := coapExchange(t, conn, coapLookupRequest(1, "not-an-ip")); resp == nil {
+		t.Fatal("first request got no response")
+	}
+	if resp := coapExchange(t, conn, coapLookupRequest(2, "not-an-ip")); resp != nil {
+		t.Errorf("request over the limit got %+v, want no response", resp)
+	}
+}
+
+// blockingSource answers lookups once it is closed.
+type blockingSource chan struct{}
+
+func (s blockingSource) lookup(ctx context.Context, ip net.IP) (map[string]any, error) {
+	<-s
+	return nil, nil
+}
+
+func TestCoAPSlowLookupDoesNotBlockOthers(t *testing.T) {
+	useFakeProvider(t, nil)
+	blocked := make(blockingSource)
+	useLookupChain(t, lookupChain{{name: "database", source: blocked}})
+	conn := startCoAPServer(t, 0)
+
+	if _, err := conn.Write(coapLookupRequest(1, "192.0.2.1").marshal()); err != nil {
+		t.Fatal(err)
+	}
+	resp := coapExchange(t, conn, coapLookupRequest(2, "not-an-ip"))
+	if resp == nil || resp.messageID != 2 || resp.code != coapCodeBadRequest {
+		t.Errorf("response during a slow lookup = %+v, want the 4.00 of request 2", resp)
+	}
+
+	// The slow lookup is answered before the test's globals are restored.
+	close(blocked)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, coapMaxMessageSize)); err != nil {
+		t.Errorf("reading the response to the slow lookup: %v", err)
+	}
+}
+
+func TestCoAPMessageRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  *coapMessage
+		// wire is the encoding of msg from RFC 7252 section 3.
+		wire string
+	}{
+		{"empty", &coapMessage{msgType: coapConfirmable, messageID: 0x1234, token: []byte{}}, "40001234"},
+		{"token and payload", &coapMessage{msgType: coapNonConfirmable, code: coapCodeGET, messageID: 1, token: []byte{0xaa, 0xbb},
+			payload: []byte("hi")}, "52010001aabbff6869"},
+		{"options in number order", &coapMessage{msgType: coapConfirmable, code: coapCodeGET, messageID: 2, token: []byte{},
+			options: []coapOption{{number: coapOptionURIPath, value: []byte("a")}, {number: 12, value: []byte{}}, {number: coapOptionURIPath, value: []byte("b")}}},
+			"40010002b161016210"},
+		{"one-byte extended delta and length", &coapMessage{msgType: coapConfirmable, messageID: 3, token: []byte{},
+			options: []coapOption{{number: 13, value: []byte(strings.Repeat("x", 13))}}},
+			"40000003dd0000" + strings.Repeat("78", 13)},
+		{"two-byte extended delta and length", &coapMessage{msgType: coapConfirmable, messageID: 4, token: []byte{},
+			options: []coapOption{{number: 269, value: []byte(strings.Repeat("y", 269))}}},
+			"40000004ee00000000" + strings.Repeat("79", 269)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wire := tt.msg.marshal()
+			if got := hex.EncodeToString(wire); got != tt.wire {
+				t.Errorf("marshal = %s, want %s", got, tt.wire)
+			}
+			got, err := parseCoAPMessage(wire)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.msg) {
+				t.Errorf("parsed %+v, want %+v", got, tt.msg)
+			}
+		})
+	}
+}
+
+func TestParseCoAPMessageRejectsMalformedInput(t *testing.T) {
+	valid := coapLookupRequest(1, "8.8.8.8").marshal()
+	tests := []struct {
+		name string
+		msg  []byte
+	}{
+		{"empty", nil},
+		{"truncated header", []byte{0x40, 0x01, 0x00}},
+		{"version 0", []byte{0x00, 0x01, 0x00, 0x01}},
+		{"version 2", []byte{0x80, 0x01, 0x00, 0x01}},
+		{"token longer than 8 bytes", append([]byte{0x49, 0x01, 0x00, 0x01}, make([]byte, 9)...)},
+		{"truncated token", []byte{0x42, 0x01, 0x00, 0x01, 0xaa}},
+		{"reserved delta", []byte{0x40, 0x01, 0x00, 0x01, 0xf1, 0x00}},
+		{"reserved length", []byte{0x40, 0x01, 0x00, 0x01, 0x1f, 0x00}},
+		{"truncated extended delta", []byte{0x40, 0x01, 0x00, 0x01, 0xd0}},
+		{"truncated two-byte extended length", []byte{0x40, 0x01, 0x00, 0x01, 0x1e, 0x00}},
+		{"truncated option value", valid[:len(valid)-1]},
+		// A payload marker must be followed by a payload (RFC 7252 section 3).
+		{"payload marker without payload", append(valid, 0xFF)},
+	}
+	for _, tt := range tests {
+		if _, err := parseCoAPMessage(tt.msg); err != errCoAPMalformed {
+			t.Errorf("%s: parseCoAPMessage(%x) = %v, want errCoAPMalformed", tt.name, tt.msg, err)
+		}
+	}
+}
+
+func TestCoAPMissBehavior(t *testing.T) {
+	useFakeProvider(t, nil)
+	tests := []struct {
+		behavior string
+		code     byte
+		// want is the CBOR payload of a 2.05 response.
+		want map[string]any
+	}{
+		{missBehavior404, coapCodeNotFound, nil},
+		{missBehaviorNull, coapCodeContent, missResponse(net.ParseIP("81.2.69.142"), false)},
+		{missBehaviorBogon, coapCodeContent, missResponse(net.ParseIP("81.2.69.142"), true)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.behavior, func(t *testing.T) {
+			useConfig(t, Config{MissBehavior: tt.behavior})
+			resp := &coapMessage{}
+			(&coapServer{}).lookup(resp, "81.2.69.142")
+			if resp.code != tt.code {
+				t.Fatalf("code = %d.%02d, want %d.%02d", resp.code>>5, resp.code&0x1f, tt.code>>5, tt.code&0x1f)
+			}
+			if tt.want != nil {
+				if want, _ := cborMarshal(tt.want); !bytes.Equal(resp.payload, want) {
+					t.Errorf("payload = %x, want %x", resp.payload, want)
+				}
+			}
+		})
+	}
+}
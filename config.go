@@ -0,0 +1,1756 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// appConfig holds the configuration loaded at startup, for use by handlers.
+var appConfig Config
+
+// Config holds application configuration.
+type Config struct {
+	GeoIPDBPath string
+	// GeoIPDBURL is an https://, s3:// or gs:// URL the database is downloaded
+	// from to GeoIPDBPath at startup and every DBUpdateInterval. Empty
+	// disables it.
+	GeoIPDBURL string
+	// FallbackDBPaths are City-compatible databases consulted in order for
+	// the fields GeoIPDBPath has no value for.
+	FallbackDBPaths []string
+	// OverridesPath is an optional JSON or CSV file of networks whose lookup
+	// fields are answered from it instead of the databases.
+	OverridesPath string
+	// GeoNamesPath is an optional GeoNames dump whose city data is added to
+	// lookups by geoname ID.
+	GeoNamesPath string
+	// GeoIPProvider is the format of GeoIPDBPath: providerMaxMind or
+	// providerIP2Location.
+	GeoIPProvider string
+	// WebService is the MaxMind web service (city, country or insights)
+	// queried for IPs the databases have no record for; empty disables it.
+	WebService           string
+	WebServiceURL        string
+	WebServiceAccountID  string
+	WebServiceLicenseKey string
+	WebServiceTimeout    time.Duration
+	WebServiceCacheTTL   time.Duration
+	// RemoteLookupURL is an HTTP API, with {ip} where the IP goes, queried
+	// for IPs the databases have no record for; empty disables it.
+	RemoteLookupURL      string
+	RemoteLookupTimeout  time.Duration
+	RemoteLookupCacheTTL time.Duration
+	// DBCacheDir holds the databases unpacked from .mmdb.gz and .tar.gz files.
+	DBCacheDir               string
+	ListenAddr               string
+	AllowedCORSAccessOrigins []string
+	// ASNDBPath is the optional GeoLite2-ASN database adding autonomous system
+	// fields to lookups. Empty disables them.
+	ASNDBPath string
+	// ISPDBPath is the optional GeoIP2 ISP database adding ISP, organization
+	// and mobile carrier fields to lookups. Empty disables them.
+	ISPDBPath string
+	// ConnectionTypeDBPath is the optional GeoIP2 Connection Type database
+	// adding connection_type to lookups. Empty disables it.
+	ConnectionTypeDBPath string
+	// DomainDBPath is the optional GeoIP2 Domain database adding domain to
+	// lookups. Empty disables it.
+	DomainDBPath string
+	// AnonymousIPDBPath is the optional GeoIP2 Anonymous IP database adding
+	// VPN, proxy and Tor flags to lookups. Empty disables them.
+	AnonymousIPDBPath string
+	// CoordinatePrecision is the number of decimal places latitude/longitude are
+	// rounded to. A negative value leaves coordinates untouched.
+	CoordinatePrecision int
+	// FlagURLTemplate is the URL of a country's flag image, with {code} and
+	// {CODE} standing for its lower and upper case ISO code. Empty leaves
+	// flag_url out.
+	FlagURLTemplate string
+	// GDPRExcludeUK leaves the United Kingdom out of gdpr_applicable.
+	GDPRExcludeUK bool
+	// TwirpEnabled serves the iplookup.v1.IPLookup Twirp service under /twirp/.
+	TwirpEnabled bool
+	// GraphQLEnabled serves the GraphQL query API at /graphql.
+	GraphQLEnabled bool
+	// WebSocketEnabled serves lookups over WebSocket at /ws.
+	WebSocketEnabled bool
+	// CoAPListenAddr is the UDP address of the optional CoAP listener. Empty disables it.
+	CoAPListenAddr string
+	// CoAPRateLimit is the CoAP requests per second answered per source
+	// address; 0 disables the limit.
+	CoAPRateLimit float64
+	// WhoisEnabled serves /whois/{ip}, which queries RIR RDAP services.
+	WhoisEnabled bool
+	// RDAPTimeout bounds each outbound RDAP request.
+	RDAPTimeout time.Duration
+	// RDAPCacheTTL is how long RDAP answers are cached.
+	RDAPCacheTTL time.Duration
+	// RDAPRateLimit is the queries per second sent to each registry; 0
+	// leaves them unlimited.
+	RDAPRateLimit float64
+	// MinFraudEnabled lets lookups include the minFraud Insights risk scores
+	// of the IP with ?risk=1.
+	MinFraudEnabled bool
+	// MinFraudDefault includes the risk scores in lookups without a risk
+	// parameter.
+	MinFraudDefault    bool
+	MinFraudURL        string
+	MinFraudAccountID  string
+	MinFraudLicenseKey string
+	// MinFraudTimeout bounds each minFraud query.
+	MinFraudTimeout time.Duration
+	// MinFraudCacheTTL is how long risk scores are cached.
+	MinFraudCacheTTL time.Duration
+	// ThreatLists are the blocklists of THREAT_LISTS whose matches lookups
+	// report, refreshed every ThreatListsInterval.
+	ThreatLists         []*threatList
+	ThreatListsInterval time.Duration
+	// CloudRanges are the hosting provider ranges of CLOUD_RANGES that
+	// lookups report as is_datacenter and hosting, refreshed every
+	// CloudRangesInterval.
+	CloudRanges         []*threatList
+	CloudRangesInterval time.Duration
+	// DNSBLZones are the DNS blocklists lookups can check the IP against
+	// with ?dnsbl=1; empty disables the check.
+	DNSBLZones []string
+	// DNSBLDefault includes the listings in lookups without a dnsbl
+	// parameter.
+	DNSBLDefault bool
+	// DNSBLTimeout bounds each DNSBL query.
+	DNSBLTimeout time.Duration
+	// DNSBLCacheTTL is how long DNSBL answers are cached.
+	DNSBLCacheTTL time.Duration
+	// TorExitListEnabled adds is_tor_exit to lookups, from the exit list at
+	// TorExitListURL refreshed every TorExitListInterval.
+	TorExitListEnabled  bool
+	TorExitListURL      string
+	TorExitListInterval time.Duration
+	// RDNSEnabled lets lookups include the PTR hostname of the IP with ?rdns=1.
+	RDNSEnabled bool
+	// RDNSDefault includes the hostname in lookups without an rdns parameter.
+	RDNSDefault bool
+	// RDNSTimeout bounds each PTR lookup.
+	RDNSTimeout time.Duration
+	// RDNSCacheTTL is how long PTR answers are cached.
+	RDNSCacheTTL time.Duration
+	// HostnameLookupEnabled lets /lookup/ take a hostname, answered with the
+	// lookups of its addresses.
+	HostnameLookupEnabled bool
+	// HostnameLookupResolver is the host:port of the DNS server resolving
+	// hostname lookups. Empty uses the system resolver.
+	HostnameLookupResolver string
+	// HostnameLookupTimeout bounds the resolution of each hostname.
+	HostnameLookupTimeout time.Duration
+	// GrafanaEnabled serves the Grafana simple-JSON datasource under /grafana/.
+	GrafanaEnabled bool
+	// RESPListenAddr is the TCP address of the optional Redis protocol listener.
+	RESPListenAddr string
+	// RESPEncoding selects the value encoding for RESP GET replies ("json" or "msgpack").
+	RESPEncoding string
+	// MemcachedListenAddr is the TCP address of the optional memcached protocol listener.
+	MemcachedListenAddr string
+	// GRPCListenAddr is the TCP address of the optional gRPC listener.
+	GRPCListenAddr string
+	// AlertWebhookURL is a Slack or Discord incoming webhook for operational
+	// alerts. Empty disables alerting.
+	AlertWebhookURL string
+	// AlertCooldown suppresses repeats of the same alert within this period.
+	AlertCooldown time.Duration
+	// AlertDBMaxAge is the database age beyond which a staleness alert fires.
+	AlertDBMaxAge time.Duration
+	// MaxDBAge is the database age beyond which /healthz reports "degraded";
+	// zero disables the check. MaxDBAgeUnhealthy makes it answer 503 then.
+	MaxDBAge          time.Duration
+	MaxDBAgeUnhealthy bool
+	// AlertErrorRate is the fraction of 5xx responses (over 5 minutes) that
+	// triggers an alert.
+	AlertErrorRate float64
+	// AlertRejectRate is the fraction of requests rejected by the rate
+	// limiter or the load shedder (over a minute) that triggers an alert.
+	AlertRejectRate float64
+	// HeartbeatURL receives a periodic POST while the service is healthy. Empty
+	// disables heartbeats.
+	HeartbeatURL      string
+	HeartbeatInterval time.Duration
+	// ServiceRegistry selects a service discovery backend ("consul" or "etcd")
+	// to self-register with. Empty disables registration.
+	ServiceRegistry     string
+	ServiceRegistryAddr string
+	ServiceRegistryAuth string
+	ServiceInstance     serviceInstance
+	// MCPTransport enables the Model Context Protocol server: "stdio" serves MCP
+	// on stdin/stdout instead of HTTP, "sse" mounts it on the HTTP server.
+	MCPTransport string
+	// RateLimitRPS is the sustained requests per second allowed per client IP.
+	// Zero disables rate limiting.
+	RateLimitRPS float64
+	// RateLimitBurst is the number of requests a client may make at once.
+	RateLimitBurst int
+	// RateLimitTrustedProxies are the networks of reverse proxies whose
+	// X-Forwarded-For and X-Real-IP headers name the client to rate limit.
+	// Other requests are limited by the address they connect from.
+	RateLimitTrustedProxies []netip.Prefix
+	// MaxConcurrentRequests sheds load with 503 beyond this many in-flight
+	// requests. Zero disables load shedding.
+	MaxConcurrentRequests int
+	// AccessLogEnabled logs one line per HTTP request, including W3C trace IDs.
+	AccessLogEnabled bool
+	// MissBehavior selects the response for IPs without a database record:
+	// "404" (error), "null" (200 with null fields) or "bogon" (200 with null
+	// fields and an IP classification).
+	MissBehavior string
+	// SpecialRangeBehavior selects how non-public IPs are answered:
+	// "classify" (200 with their classification, without a lookup) or
+	// "lookup" (looked up like any other IP).
+	SpecialRangeBehavior string
+	// BatchLookupMaxSize caps the number of IPs of a POST /lookup; 0
+	// disables the endpoint.
+	BatchLookupMaxSize int
+	// StreamLookupEnabled serves POST /lookup/stream, which reads
+	// newline-delimited IPs and streams NDJSON results.
+	StreamLookupEnabled bool
+	// CSVLookupEnabled serves POST /lookup/csv, which appends geo columns to
+	// an uploaded CSV.
+	CSVLookupEnabled bool
+	// JobsDir is where bulk lookup jobs and their results are kept; empty
+	// disables the /jobs endpoints.
+	JobsDir string
+	// JobsWorkers is the number of jobs run at a time.
+	JobsWorkers int
+	// JobsMaxQueued caps the jobs waiting for a worker.
+	JobsMaxQueued int
+	// JobsMaxUploadBytes caps the size of a job's input.
+	JobsMaxUploadBytes int64
+	// JobsRetention is how long finished jobs and their results are kept.
+	JobsRetention time.Duration
+	// UIEnabled serves the embedded demo web UI under /ui/.
+	UIEnabled bool
+	// GroupcacheSelf is this instance's base URL (e.g. "http://10.0.0.5:8080")
+	// in the groupcache peer pool. Empty disables the shared cache.
+	GroupcacheSelf string
+	// GroupcacheSecret is the bearer token peers authenticate to each other with.
+	GroupcacheSecret string
+	// GroupcachePeers are the static peer base URLs.
+	GroupcachePeers []string
+	// GroupcachePeersDNS is a "name:port" resolved periodically to find peers.
+	GroupcachePeersDNS string
+	// GroupcacheSizeBytes bounds the memory used by this instance's cache.
+	GroupcacheSizeBytes int64
+	// DBUpdateURL is downloaded every DBUpdateInterval to update the database
+	// file. Empty disables automatic updates.
+	DBUpdateURL      string
+	DBUpdateInterval time.Duration
+	// MaxMindAccountID and MaxMindLicenseKey download MaxMindEditionID from
+	// MaxMindDownloadURL every DBUpdateInterval instead of DBUpdateURL.
+	MaxMindAccountID   string
+	MaxMindLicenseKey  string
+	MaxMindEditionID   string
+	MaxMindDownloadURL string
+	// DBUpdateLeaderElection selects how replicas sharing the database file
+	// elect the one that downloads ("file" or "kubernetes"). Empty means every
+	// instance downloads.
+	DBUpdateLeaderElection string
+	// DBUpdateLockFile is the lock file used by "file" leader election.
+	DBUpdateLockFile string
+	// DBUpdateLeaseName is the Lease used by "kubernetes" leader election.
+	DBUpdateLeaseName string
+	// DBWatchEnabled reloads the database when its file changes on disk, once
+	// its directory has been quiet for DBWatchDebounce.
+	DBWatchEnabled  bool
+	DBWatchDebounce time.Duration
+	// DBRefreshInterval checks the database file for changes on a timer and
+	// reloads it when it changed. Zero disables it.
+	DBRefreshInterval time.Duration
+	// AdminToken is the bearer token of the /admin endpoints, which are
+	// disabled when it is empty.
+	AdminToken string
+	// DBCanaryPercent is the share of lookups (0-100) served by a newly
+	// loaded database version, compared against the previous one, for
+	// DBCanaryDuration before it serves all lookups. Zero switches at once.
+	DBCanaryPercent  float64
+	DBCanaryDuration time.Duration
+	// SLOAvailabilityTarget is the fraction of requests that must not fail
+	// with a 5xx status. Zero disables the objective.
+	SLOAvailabilityTarget float64
+	// SLOLatencyTarget is the fraction of requests that must be served within
+	// SLOLatencyThreshold. Zero disables the objective.
+	SLOLatencyTarget    float64
+	SLOLatencyThreshold time.Duration
+	// SLOPeriod is the period the error budget is defined over.
+	SLOPeriod time.Duration
+	// HotUpgradeEnabled re-executes the binary on SIGUSR2, handing over the
+	// listening sockets (see upgrade.go).
+	HotUpgradeEnabled bool
+	// PIDFile is rewritten with the serving process's PID after startup and
+	// after every upgrade. Empty disables it.
+	PIDFile string
+	// GeoHeaders are the X-Geo-* headers set on lookup and /authz responses;
+	// nil when GEO_HEADERS_ENABLED is off.
+	GeoHeaders []geoHeader
+	// Policies are the compiled geofence policies served by /check and /authz,
+	// keyed by name. Empty disables those endpoints.
+	Policies map[string]*geoPolicy
+	// ResponseTemplates are the lookup formats of RESPONSE_TEMPLATES_FILE,
+	// keyed by name.
+	ResponseTemplates map[string]*responseFormat
+}
+
+// defaultGeoIPDir is the default directory to search for the GeoIP database.
+const defaultGeoIPDir = "/app/data"
+
+// defaultGeoIPFile is the default GeoIP database filename.
+const defaultGeoIPFile = "GeoLite2-City.mmdb"
+
+// defaultBatchLookupMaxSize is the default BATCH_LOOKUP_MAX_SIZE.
+const defaultBatchLookupMaxSize = 1000
+
+// MISS_BEHAVIOR values.
+const (
+	missBehavior404   = "404"
+	missBehaviorNull  = "null"
+	missBehaviorBogon = "bogon"
+)
+
+// SPECIAL_RANGE_BEHAVIOR values.
+const (
+	specialRangeClassify = "classify"
+	specialRangeLookup   = "lookup"
+)
+
+// maxCoordinatePrecision is the largest accepted COORDINATE_PRECISION value.
+// float64 cannot meaningfully represent more decimal places for coordinates.
+const maxCoordinatePrecision = 15
+
+// loadConfig reads the configuration from the environment, one feature at a
+// time. Invalid settings are logged and returned as the error.
+func loadConfig() (Config, error) {
+	cfg := Config{ListenAddr: os.Getenv("LISTEN_ADDR")}
+	if cfg.ListenAddr == "" {
+		cfg.ListenAddr = ":8080" // Default listen address
+	}
+	// Later settings are checked against earlier ones, so the order matters.
+	for _, load := range []func(*Config) error{
+		loadDatabaseConfig,
+		loadResponseConfig,
+		loadEndpointConfig,
+		loadListenerConfig,
+		loadWhoisConfig,
+		loadRDNSConfig,
+		loadHostnameLookupConfig,
+		loadIPListConfig,
+		loadDNSBLConfig,
+		loadTorExitListConfig,
+		loadObservabilityConfig,
+		loadServiceRegistryConfig,
+		loadRateLimitConfig,
+		loadJobsConfig,
+		loadGroupcacheConfig,
+		loadDBUpdateConfig,
+		loadWebServiceConfig,
+		loadMinFraudConfig,
+		loadRemoteLookupConfig,
+		loadAdminConfig,
+		checkProviderConfig,
+		loadHotUpgradeConfig,
+		loadPolicyConfig,
+	} {
+		if err := load(&cfg); err != nil {
+			return Config{}, err
+		}
+	}
+	return cfg, nil
+}
+
+// loadDatabaseConfig reads the primary database, its source and the
+// supplementary databases.
+func loadDatabaseConfig(cfg *Config) error {
+	dbPath := os.Getenv("GEOIP_DB_PATH")
+
+	geoIPProvider := strings.ToLower(strings.TrimSpace(os.Getenv("GEOIP_PROVIDER")))
+	switch geoIPProvider {
+	case "":
+		geoIPProvider = providerMaxMind
+	case providerMaxMind:
+	case providerIP2Location:
+		// The default path and the update sources are MaxMind databases.
+		if dbPath == "" {
+			errMsg := "GEOIP_DB_PATH must be set when GEOIP_PROVIDER is ip2location."
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+		log.Println("Serving lookups from an IP2Location BIN database (GEOIP_PROVIDER=ip2location).")
+	default:
+		errMsg := fmt.Sprintf("Invalid GEOIP_PROVIDER '%s': must be 'maxmind' or 'ip2location'.", geoIPProvider)
+		log.Println(errMsg)
+		return errors.New(errMsg)
+	}
+
+	dbURL := strings.TrimSpace(os.Getenv("GEOIP_DB_URL"))
+	dbURLVar := "GEOIP_DB_URL"
+	if strings.HasPrefix(dbPath, "http://") || strings.HasPrefix(dbPath, "https://") {
+		if dbURL != "" {
+			errMsg := "GEOIP_DB_URL cannot be set when GEOIP_DB_PATH is a URL."
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+		dbURL, dbURLVar, dbPath = dbPath, "GEOIP_DB_PATH", ""
+	}
+	if dbURL != "" {
+		u, err := url.Parse(dbURL)
+		valid := err == nil && u.Host != ""
+		switch {
+		case !valid:
+		case u.Scheme == "http" || u.Scheme == "https":
+		case u.Scheme == "s3" || u.Scheme == "gs":
+			valid = strings.Trim(u.Path, "/") != ""
+		default:
+			valid = false
+		}
+		if !valid {
+			errMsg := fmt.Sprintf("Invalid %s '%s': must be an https://, s3://bucket/key or gs://bucket/key URL.", dbURLVar, dbURL)
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+		if dbPath == "" {
+			// The download is memory-mapped like any database, so it needs a file.
+			name := path.Base(u.Path)
+			if name == "." || name == "/" {
+				name = "GeoIP.mmdb"
+			}
+			dbPath = filepath.Join(os.TempDir(), "ip-lookup-"+name)
+		}
+		log.Printf("Using GeoIP database from %s: %s (local copy: %s)", dbURLVar, dbURL, dbPath)
+	} else if dbPath == "" {
+		// GEOIP_DB_PATH environment variable is not set.
+		// Attempt to use a default path, which aligns with the geoipupdate service volume mount.
+		potentialDefaultPath := filepath.Join(defaultGeoIPDir, defaultGeoIPFile)
+		log.Printf("GEOIP_DB_PATH not set. Checking default location: %s", potentialDefaultPath)
+
+		if _, err := os.Stat(potentialDefaultPath); err == nil {
+			// Default file exists
+			log.Printf("Using GeoIP database found at default location: %s", potentialDefaultPath)
+			dbPath = potentialDefaultPath
+		} else if os.IsNotExist(err) && len(embeddedGeoDB) > 0 {
+			// Serve the embedded database until one is written to the default path.
+			log.Printf("No GeoIP database found at %s; using the embedded database.", potentialDefaultPath)
+			dbPath = potentialDefaultPath
+		} else if os.IsNotExist(err) {
+			// Default file does not exist
+			errMsg := fmt.Sprintf("GEOIP_DB_PATH environment variable is not set, and the default database '%s' was not found in '%s'. Please ensure the database file is available or set GEOIP_DB_PATH.", defaultGeoIPFile, defaultGeoIPDir)
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		} else {
+			// Some other error occurred when checking for the default file (e.g., permission issues)
+			errMsg := fmt.Sprintf("Error checking for default GeoIP database at '%s': %v. Please ensure the path is accessible or set GEOIP_DB_PATH.", potentialDefaultPath, err)
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+	} else {
+		log.Printf("Using GeoIP database path from GEOIP_DB_PATH: %s", dbPath)
+	}
+
+	var fallbackDBPaths []string
+	for _, p := range strings.Split(os.Getenv("GEOIP_FALLBACK_DB_PATHS"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			fallbackDBPaths = append(fallbackDBPaths, p)
+		}
+	}
+	if len(fallbackDBPaths) > 0 {
+		log.Printf("Using fallback GeoIP databases from GEOIP_FALLBACK_DB_PATHS: %s", strings.Join(fallbackDBPaths, ", "))
+	}
+	geoNamesPath := strings.TrimSpace(os.Getenv("GEONAMES_PATH"))
+	if geoNamesPath != "" {
+		log.Printf("Using GeoNames city data from GEONAMES_PATH: %s", geoNamesPath)
+	}
+	overridesPath := strings.TrimSpace(os.Getenv("GEOIP_OVERRIDES_PATH"))
+	if overridesPath != "" {
+		log.Printf("Using IP overrides from GEOIP_OVERRIDES_PATH: %s", overridesPath)
+	}
+	dbCacheDir := strings.TrimSpace(os.Getenv("DB_CACHE_DIR"))
+	if dbCacheDir == "" {
+		dbCacheDir = filepath.Join(os.TempDir(), "ip-lookup-cache")
+	}
+	asnDBPath := strings.TrimSpace(os.Getenv("GEOIP_ASN_DB_PATH"))
+	if asnDBPath != "" {
+		log.Printf("Using ASN database path from GEOIP_ASN_DB_PATH: %s", asnDBPath)
+	}
+	ispDBPath := strings.TrimSpace(os.Getenv("GEOIP_ISP_DB_PATH"))
+	if ispDBPath != "" {
+		log.Printf("Using ISP database path from GEOIP_ISP_DB_PATH: %s", ispDBPath)
+	}
+	connectionTypeDBPath := strings.TrimSpace(os.Getenv("GEOIP_CONNECTION_TYPE_DB_PATH"))
+	if connectionTypeDBPath != "" {
+		log.Printf("Using Connection Type database path from GEOIP_CONNECTION_TYPE_DB_PATH: %s", connectionTypeDBPath)
+	}
+	domainDBPath := strings.TrimSpace(os.Getenv("GEOIP_DOMAIN_DB_PATH"))
+	if domainDBPath != "" {
+		log.Printf("Using Domain database path from GEOIP_DOMAIN_DB_PATH: %s", domainDBPath)
+	}
+	anonymousIPDBPath := strings.TrimSpace(os.Getenv("GEOIP_ANONYMOUS_IP_DB_PATH"))
+	if anonymousIPDBPath != "" {
+		log.Printf("Using Anonymous IP database path from GEOIP_ANONYMOUS_IP_DB_PATH: %s", anonymousIPDBPath)
+	}
+
+	cfg.GeoIPProvider = geoIPProvider
+	cfg.GeoIPDBPath = dbPath
+	cfg.GeoIPDBURL = dbURL
+	cfg.FallbackDBPaths = fallbackDBPaths
+	cfg.GeoNamesPath = geoNamesPath
+	cfg.OverridesPath = overridesPath
+	cfg.DBCacheDir = dbCacheDir
+	cfg.ASNDBPath = asnDBPath
+	cfg.ISPDBPath = ispDBPath
+	cfg.ConnectionTypeDBPath = connectionTypeDBPath
+	cfg.DomainDBPath = domainDBPath
+	cfg.AnonymousIPDBPath = anonymousIPDBPath
+	return nil
+}
+
+// loadResponseConfig reads the settings shaping lookup responses.
+func loadResponseConfig(cfg *Config) error {
+	allowedOriginsEnv := os.Getenv("ALLOWED_CORS_ORIGINS")
+	var allowedOriginsList []string
+	if allowedOriginsEnv != "" {
+		allowedOriginsList = strings.Split(allowedOriginsEnv, ",")
+		for i, origin := range allowedOriginsList {
+			allowedOriginsList[i] = strings.TrimSpace(origin)
+		}
+		log.Printf("Allowed CORS origins: %v", allowedOriginsList)
+	} else {
+		log.Println("ALLOWED_CORS_ORIGINS not set. CORS headers will not be added.")
+	}
+
+	coordinatePrecision := -1 // Default: return coordinates as stored in the database
+	if precisionEnv := os.Getenv("COORDINATE_PRECISION"); precisionEnv != "" {
+		precision, err := strconv.Atoi(strings.TrimSpace(precisionEnv))
+		if err != nil || precision < 0 || precision > maxCoordinatePrecision {
+			errMsg := fmt.Sprintf("Invalid COORDINATE_PRECISION '%s': must be an integer between 0 and %d.", precisionEnv, maxCoordinatePrecision)
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+		coordinatePrecision = precision
+		log.Printf("Rounding coordinates to %d decimal places.", coordinatePrecision)
+	}
+
+	flagURLTemplate := strings.TrimSpace(os.Getenv("FLAG_URL_TEMPLATE"))
+	if flagURLTemplate != "" {
+		if !strings.Contains(flagURLTemplate, "{code}") && !strings.Contains(flagURLTemplate, "{CODE}") {
+			errMsg := fmt.Sprintf("Invalid FLAG_URL_TEMPLATE '%s': must contain {code} or {CODE}.", flagURLTemplate)
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+		log.Printf("Adding flag_url to lookups from FLAG_URL_TEMPLATE: %s", flagURLTemplate)
+	}
+
+	gdprExcludeUK, err := parseBoolEnv("GDPR_EXCLUDE_UK")
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if gdprExcludeUK {
+		log.Println("GDPR_EXCLUDE_UK set: gdpr_applicable is false for the United Kingdom.")
+	}
+
+	missBehavior := strings.ToLower(strings.TrimSpace(os.Getenv("MISS_BEHAVIOR")))
+	switch missBehavior {
+	case "":
+		missBehavior = missBehavior404
+	case missBehavior404, missBehaviorNull, missBehaviorBogon:
+	default:
+		errMsg := fmt.Sprintf("Invalid MISS_BEHAVIOR '%s': must be '404', 'null' or 'bogon'.", missBehavior)
+		log.Println(errMsg)
+		return errors.New(errMsg)
+	}
+	if missBehavior != missBehavior404 {
+		log.Printf("Database misses answered with 200 (%s).", missBehavior)
+	}
+
+	specialRangeBehavior := strings.ToLower(strings.TrimSpace(os.Getenv("SPECIAL_RANGE_BEHAVIOR")))
+	switch specialRangeBehavior {
+	case "":
+		specialRangeBehavior = specialRangeClassify
+	case specialRangeClassify, specialRangeLookup:
+	default:
+		errMsg := fmt.Sprintf("Invalid SPECIAL_RANGE_BEHAVIOR '%s': must be 'classify' or 'lookup'.", specialRangeBehavior)
+		log.Println(errMsg)
+		return errors.New(errMsg)
+	}
+	if specialRangeBehavior == specialRangeLookup {
+		log.Println("Private and reserved IPs are looked up in the databases (SPECIAL_RANGE_BEHAVIOR=lookup).")
+	}
+
+	var responseTemplates map[string]*responseFormat
+	if templatesFile := strings.TrimSpace(os.Getenv("RESPONSE_TEMPLATES_FILE")); templatesFile != "" {
+		responseTemplates, err = loadResponseTemplates(templatesFile)
+		if err != nil {
+			errMsg := fmt.Sprintf("Invalid response template: %v", err)
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+		log.Printf("Response templates enabled: %d parsed.", len(responseTemplates))
+	}
+
+	cfg.AllowedCORSAccessOrigins = allowedOriginsList
+	cfg.CoordinatePrecision = coordinatePrecision
+	cfg.FlagURLTemplate = flagURLTemplate
+	cfg.GDPRExcludeUK = gdprExcludeUK
+	cfg.MissBehavior = missBehavior
+	cfg.SpecialRangeBehavior = specialRangeBehavior
+	cfg.ResponseTemplates = responseTemplates
+	return nil
+}
+
+// loadEndpointConfig reads which optional HTTP endpoints and MCP
+// transports are served.
+func loadEndpointConfig(cfg *Config) error {
+	mcpTransport := strings.ToLower(strings.TrimSpace(os.Getenv("MCP_TRANSPORT")))
+	switch mcpTransport {
+	case "":
+	case "stdio", "sse":
+		log.Printf("MCP server enabled with %s transport.", mcpTransport)
+	default:
+		errMsg := fmt.Sprintf("Invalid MCP_TRANSPORT '%s': must be 'stdio' or 'sse'.", mcpTransport)
+		log.Println(errMsg)
+		return errors.New(errMsg)
+	}
+
+	twirpEnabled, err := parseBoolEnv("TWIRP_ENABLED")
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if twirpEnabled {
+		log.Printf("Twirp service enabled at %s", twirpPathPrefix)
+	}
+
+	graphQLEnabled, err := parseBoolEnv("GRAPHQL_ENABLED")
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if graphQLEnabled {
+		log.Println("GraphQL API enabled at /graphql")
+	}
+
+	webSocketEnabled, err := parseBoolEnv("WEBSOCKET_ENABLED")
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if webSocketEnabled {
+		log.Println("WebSocket lookups enabled at /ws")
+	}
+
+	grafanaEnabled, err := parseBoolEnv("GRAFANA_DATASOURCE_ENABLED")
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if grafanaEnabled {
+		log.Printf("Grafana JSON datasource enabled at %s", grafanaPathPrefix)
+	}
+
+	batchLookupMaxSize, err := parseNonNegativeIntEnv("BATCH_LOOKUP_MAX_SIZE", defaultBatchLookupMaxSize)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	streamLookupEnabled, err := parseBoolEnv("STREAM_LOOKUP_ENABLED")
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if streamLookupEnabled {
+		log.Println("Streaming lookups enabled at POST /lookup/stream")
+	}
+
+	csvLookupEnabled, err := parseBoolEnv("CSV_LOOKUP_ENABLED")
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if csvLookupEnabled {
+		log.Println("CSV lookups enabled at POST /lookup/csv")
+	}
+
+	uiEnabled, err := parseBoolEnv("UI_ENABLED")
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if uiEnabled {
+		log.Printf("Demo web UI enabled at %s", uiPathPrefix)
+	}
+
+	cfg.MCPTransport = mcpTransport
+	cfg.TwirpEnabled = twirpEnabled
+	cfg.GraphQLEnabled = graphQLEnabled
+	cfg.WebSocketEnabled = webSocketEnabled
+	cfg.GrafanaEnabled = grafanaEnabled
+	cfg.BatchLookupMaxSize = batchLookupMaxSize
+	cfg.StreamLookupEnabled = streamLookupEnabled
+	cfg.CSVLookupEnabled = csvLookupEnabled
+	cfg.UIEnabled = uiEnabled
+	return nil
+}
+
+// loadListenerConfig reads the listeners of the non-HTTP protocols.
+func loadListenerConfig(cfg *Config) error {
+	coapListenAddr := strings.TrimSpace(os.Getenv("COAP_LISTEN_ADDR"))
+	coapRateLimit := 10.0
+	if rateEnv := strings.TrimSpace(os.Getenv("COAP_RATE_LIMIT_RPS")); rateEnv != "" {
+		rate, err := strconv.ParseFloat(rateEnv, 64)
+		if err != nil || rate < 0 || math.IsInf(rate, 0) {
+			errMsg := fmt.Sprintf("Invalid COAP_RATE_LIMIT_RPS '%s': must be a non-negative number.", rateEnv)
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+		coapRateLimit = rate
+	}
+	if coapListenAddr != "" {
+		log.Printf("CoAP listener enabled on udp %s (%g requests/s per source).", coapListenAddr, coapRateLimit)
+	}
+
+	respListenAddr := strings.TrimSpace(os.Getenv("RESP_LISTEN_ADDR"))
+	respEncoding := strings.ToLower(strings.TrimSpace(os.Getenv("RESP_ENCODING")))
+	if respEncoding == "" {
+		respEncoding = "json"
+	}
+	if _, ok := respEncoders[respEncoding]; !ok {
+		errMsg := fmt.Sprintf("Invalid RESP_ENCODING '%s': must be 'json' or 'msgpack'.", respEncoding)
+		log.Println(errMsg)
+		return errors.New(errMsg)
+	}
+	if respListenAddr != "" {
+		log.Printf("Redis protocol listener enabled on %s (%s values).", respListenAddr, respEncoding)
+	}
+
+	memcachedListenAddr := strings.TrimSpace(os.Getenv("MEMCACHED_LISTEN_ADDR"))
+	if memcachedListenAddr != "" {
+		log.Printf("Memcached protocol listener enabled on %s.", memcachedListenAddr)
+	}
+
+	grpcListenAddr := strings.TrimSpace(os.Getenv("GRPC_LISTEN_ADDR"))
+	if grpcListenAddr != "" {
+		log.Printf("gRPC listener enabled on %s.", grpcListenAddr)
+	}
+
+	cfg.CoAPListenAddr = coapListenAddr
+	cfg.CoAPRateLimit = coapRateLimit
+	cfg.RESPListenAddr = respListenAddr
+	cfg.RESPEncoding = respEncoding
+	cfg.MemcachedListenAddr = memcachedListenAddr
+	cfg.GRPCListenAddr = grpcListenAddr
+	return nil
+}
+
+// loadWhoisConfig reads the WHOIS/RDAP settings.
+func loadWhoisConfig(cfg *Config) error {
+	whoisEnabled, err := parseBoolEnv("WHOIS_ENABLED")
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	rdapTimeout, err := parseDurationEnv("RDAP_TIMEOUT", 5*time.Second)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	rdapCacheTTL, err := parseDurationEnv("RDAP_CACHE_TTL", 24*time.Hour)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	rdapRateLimit := 5.0
+	if rateEnv := strings.TrimSpace(os.Getenv("RDAP_RATE_LIMIT_RPS")); rateEnv != "" {
+		rdapRateLimit, err = strconv.ParseFloat(rateEnv, 64)
+		if err != nil || rdapRateLimit < 0 || math.IsInf(rdapRateLimit, 0) {
+			errMsg := fmt.Sprintf("Invalid RDAP_RATE_LIMIT_RPS '%s': must be a non-negative number.", rateEnv)
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+	}
+	if whoisEnabled {
+		log.Printf("WHOIS/RDAP endpoint enabled (timeout %s, cache TTL %s, %g queries/s per registry).", rdapTimeout, rdapCacheTTL, rdapRateLimit)
+	}
+
+	cfg.WhoisEnabled = whoisEnabled
+	cfg.RDAPTimeout = rdapTimeout
+	cfg.RDAPCacheTTL = rdapCacheTTL
+	cfg.RDAPRateLimit = rdapRateLimit
+	return nil
+}
+
+// loadRDNSConfig reads the reverse DNS enrichment settings.
+func loadRDNSConfig(cfg *Config) error {
+	rdnsEnabled, err := parseBoolEnv("RDNS_ENABLED")
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	rdnsDefault, err := parseBoolEnv("RDNS_DEFAULT")
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	rdnsTimeout, err := parseDurationEnv("RDNS_TIMEOUT", 500*time.Millisecond)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	rdnsCacheTTL, err := parseDurationEnv("RDNS_CACHE_TTL", time.Hour)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if rdnsDefault && !rdnsEnabled {
+		errMsg := "RDNS_ENABLED must be set when RDNS_DEFAULT is."
+		log.Println(errMsg)
+		return errors.New(errMsg)
+	}
+	if rdnsEnabled {
+		log.Printf("Reverse DNS enrichment enabled (default %t, timeout %s, cache TTL %s).", rdnsDefault, rdnsTimeout, rdnsCacheTTL)
+	}
+
+	cfg.RDNSEnabled = rdnsEnabled
+	cfg.RDNSDefault = rdnsDefault
+	cfg.RDNSTimeout = rdnsTimeout
+	cfg.RDNSCacheTTL = rdnsCacheTTL
+	return nil
+}
+
+// loadHostnameLookupConfig reads the hostname lookup settings.
+func loadHostnameLookupConfig(cfg *Config) error {
+	hostnameLookupEnabled, err := parseBoolEnv("HOSTNAME_LOOKUP_ENABLED")
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	hostnameLookupTimeout, err := parseDurationEnv("HOSTNAME_LOOKUP_TIMEOUT", 2*time.Second)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	hostnameLookupResolver := strings.TrimSpace(os.Getenv("HOSTNAME_LOOKUP_RESOLVER"))
+	if hostnameLookupResolver != "" {
+		if _, _, err := net.SplitHostPort(hostnameLookupResolver); err != nil {
+			// A bare address uses the DNS port.
+			hostnameLookupResolver = net.JoinHostPort(hostnameLookupResolver, "53")
+		}
+		if host, _, _ := net.SplitHostPort(hostnameLookupResolver); host == "" {
+			errMsg := fmt.Sprintf("Invalid HOSTNAME_LOOKUP_RESOLVER '%s': must be a host or host:port.", os.Getenv("HOSTNAME_LOOKUP_RESOLVER"))
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+	}
+	if hostnameLookupEnabled {
+		if hostnameLookupTimeout <= 0 {
+			errMsg := "HOSTNAME_LOOKUP_TIMEOUT must be positive."
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+		resolver := hostnameLookupResolver
+		if resolver == "" {
+			resolver = "the system resolver"
+		}
+		log.Printf("Hostname lookups enabled (resolver %s, timeout %s).", resolver, hostnameLookupTimeout)
+	}
+
+	cfg.HostnameLookupEnabled = hostnameLookupEnabled
+	cfg.HostnameLookupResolver = hostnameLookupResolver
+	cfg.HostnameLookupTimeout = hostnameLookupTimeout
+	return nil
+}
+
+// loadIPListConfig reads the threat and cloud range lists.
+func loadIPListConfig(cfg *Config) error {
+	threatLists, err := parseThreatLists(os.Getenv("THREAT_LISTS"))
+	if err != nil {
+		errMsg := fmt.Sprintf("Invalid THREAT_LISTS: %v", err)
+		log.Println(errMsg)
+		return errors.New(errMsg)
+	}
+	threatListsInterval, err := parseDurationEnv("THREAT_LISTS_REFRESH_INTERVAL", time.Hour)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if len(threatLists) > 0 {
+		if threatListsInterval <= 0 {
+			errMsg := "THREAT_LISTS_REFRESH_INTERVAL must be positive."
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+		names := make([]string, len(threatLists))
+		for i, list := range threatLists {
+			names[i] = list.name
+		}
+		log.Printf("Threat lists enabled: %s (refresh every %s).", strings.Join(names, ", "), threatListsInterval)
+	}
+
+	cloudRanges, err := parseCloudRanges(os.Getenv("CLOUD_RANGES"))
+	if err != nil {
+		errMsg := fmt.Sprintf("Invalid CLOUD_RANGES: %v", err)
+		log.Println(errMsg)
+		return errors.New(errMsg)
+	}
+	cloudRangesInterval, err := parseDurationEnv("CLOUD_RANGES_REFRESH_INTERVAL", 24*time.Hour)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if len(cloudRanges) > 0 {
+		if cloudRangesInterval <= 0 {
+			errMsg := "CLOUD_RANGES_REFRESH_INTERVAL must be positive."
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+		names := make([]string, len(cloudRanges))
+		for i, list := range cloudRanges {
+			names[i] = list.name
+		}
+		log.Printf("Cloud range detection enabled: %s (refresh every %s).", strings.Join(names, ", "), cloudRangesInterval)
+	}
+
+	cfg.ThreatLists = threatLists
+	cfg.ThreatListsInterval = threatListsInterval
+	cfg.CloudRanges = cloudRanges
+	cfg.CloudRangesInterval = cloudRangesInterval
+	return nil
+}
+
+// loadDNSBLConfig reads the DNSBL settings.
+func loadDNSBLConfig(cfg *Config) error {
+	var dnsblZones []string
+	for _, zone := range strings.Split(os.Getenv("DNSBL_ZONES"), ",") {
+		if zone = strings.Trim(strings.TrimSpace(zone), "."); zone != "" {
+			dnsblZones = append(dnsblZones, strings.ToLower(zone))
+		}
+	}
+	dnsblDefault, err := parseBoolEnv("DNSBL_DEFAULT")
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	dnsblTimeout, err := parseDurationEnv("DNSBL_TIMEOUT", 300*time.Millisecond)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	dnsblCacheTTL, err := parseDurationEnv("DNSBL_CACHE_TTL", 10*time.Minute)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if dnsblDefault && len(dnsblZones) == 0 {
+		errMsg := "DNSBL_ZONES must be set when DNSBL_DEFAULT is."
+		log.Println(errMsg)
+		return errors.New(errMsg)
+	}
+	if len(dnsblZones) > 0 {
+		log.Printf("DNSBL checks enabled against %s (default %t, timeout %s, cache TTL %s).", strings.Join(dnsblZones, ", "), dnsblDefault, dnsblTimeout, dnsblCacheTTL)
+	}
+
+	cfg.DNSBLZones = dnsblZones
+	cfg.DNSBLDefault = dnsblDefault
+	cfg.DNSBLTimeout = dnsblTimeout
+	cfg.DNSBLCacheTTL = dnsblCacheTTL
+	return nil
+}
+
+// loadTorExitListConfig reads the Tor exit list settings.
+func loadTorExitListConfig(cfg *Config) error {
+	torExitListEnabled, err := parseBoolEnv("TOR_EXIT_LIST_ENABLED")
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	torExitListURL := strings.TrimSpace(os.Getenv("TOR_EXIT_LIST_URL"))
+	if torExitListURL == "" {
+		torExitListURL = defaultTorExitListURL
+	}
+	torExitListInterval, err := parseDurationEnv("TOR_EXIT_LIST_REFRESH_INTERVAL", time.Hour)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if torExitListEnabled {
+		if u, err := url.Parse(torExitListURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errMsg := "Invalid TOR_EXIT_LIST_URL: must be an http:// or https:// URL."
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+		if torExitListInterval <= 0 {
+			errMsg := "TOR_EXIT_LIST_REFRESH_INTERVAL must be positive."
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+		log.Printf("Tor exit node detection enabled (refresh every %s).", torExitListInterval)
+	}
+
+	cfg.TorExitListEnabled = torExitListEnabled
+	cfg.TorExitListURL = torExitListURL
+	cfg.TorExitListInterval = torExitListInterval
+	return nil
+}
+
+// loadObservabilityConfig reads the access log, alert, heartbeat and SLO
+// settings.
+func loadObservabilityConfig(cfg *Config) error {
+	accessLogEnabled, err := parseBoolEnv("ACCESS_LOG_ENABLED")
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	alertWebhookURL := strings.TrimSpace(os.Getenv("ALERT_WEBHOOK_URL"))
+	alertCooldown, err := parseDurationEnv("ALERT_COOLDOWN", time.Hour)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	maxDBAgeDays, err := parseNonNegativeIntEnv("MAX_DB_AGE_DAYS", 0)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	maxDBAgeUnhealthy, err := parseBoolEnv("MAX_DB_AGE_UNHEALTHY")
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if maxDBAgeUnhealthy && maxDBAgeDays == 0 {
+		errMsg := "MAX_DB_AGE_DAYS must be set when MAX_DB_AGE_UNHEALTHY is."
+		log.Println(errMsg)
+		return errors.New(errMsg)
+	}
+	if maxDBAgeDays > 0 {
+		log.Printf("Health checks report a database older than %d days as degraded.", maxDBAgeDays)
+	}
+	alertDBMaxAge, err := parseDurationEnv("ALERT_DB_MAX_AGE", 30*24*time.Hour)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	alertErrorRate := 0.05
+	if rateEnv := strings.TrimSpace(os.Getenv("ALERT_ERROR_RATE")); rateEnv != "" {
+		rate, err := strconv.ParseFloat(rateEnv, 64)
+		if err != nil || rate <= 0 || rate > 1 {
+			errMsg := fmt.Sprintf("Invalid ALERT_ERROR_RATE '%s': must be a fraction between 0 and 1.", rateEnv)
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+		alertErrorRate = rate
+	}
+	alertRejectRate := 0.1
+	if rateEnv := strings.TrimSpace(os.Getenv("ALERT_REJECT_RATE")); rateEnv != "" {
+		rate, err := strconv.ParseFloat(rateEnv, 64)
+		if err != nil || rate <= 0 || rate > 1 {
+			errMsg := fmt.Sprintf("Invalid ALERT_REJECT_RATE '%s': must be a fraction between 0 and 1.", rateEnv)
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+		alertRejectRate = rate
+	}
+	if alertWebhookURL != "" {
+		log.Printf("Operational alerts enabled (cooldown %s).", alertCooldown)
+	}
+
+	heartbeatURL := strings.TrimSpace(os.Getenv("HEARTBEAT_URL"))
+	heartbeatInterval, err := parseDurationEnv("HEARTBEAT_INTERVAL", time.Minute)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if heartbeatURL != "" {
+		log.Printf("Heartbeat pings enabled every %s.", heartbeatInterval)
+	}
+
+	sloAvailabilityTarget, err := parseFractionEnv("SLO_AVAILABILITY_TARGET")
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	sloLatencyTarget, err := parseFractionEnv("SLO_LATENCY_TARGET")
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	sloLatencyThreshold, err := parseDurationEnv("SLO_LATENCY_THRESHOLD", 250*time.Millisecond)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	sloPeriod, err := parseDurationEnv("SLO_PERIOD", 30*24*time.Hour)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if sloAvailabilityTarget > 0 || sloLatencyTarget > 0 {
+		log.Printf("SLO tracking enabled (availability %g, latency %g within %s, over %s).",
+			sloAvailabilityTarget, sloLatencyTarget, sloLatencyThreshold, sloPeriod)
+	}
+
+	cfg.AccessLogEnabled = accessLogEnabled
+	cfg.AlertWebhookURL = alertWebhookURL
+	cfg.AlertCooldown = alertCooldown
+	cfg.MaxDBAge = time.Duration(maxDBAgeDays) * 24 * time.Hour
+	cfg.MaxDBAgeUnhealthy = maxDBAgeUnhealthy
+	cfg.AlertDBMaxAge = alertDBMaxAge
+	cfg.AlertErrorRate = alertErrorRate
+	cfg.AlertRejectRate = alertRejectRate
+	cfg.HeartbeatURL = heartbeatURL
+	cfg.HeartbeatInterval = heartbeatInterval
+	cfg.SLOAvailabilityTarget = sloAvailabilityTarget
+	cfg.SLOLatencyTarget = sloLatencyTarget
+	cfg.SLOLatencyThreshold = sloLatencyThreshold
+	cfg.SLOPeriod = sloPeriod
+	return nil
+}
+
+// loadServiceRegistryConfig reads the service registration settings.
+func loadServiceRegistryConfig(cfg *Config) error {
+	serviceRegistry := strings.ToLower(strings.TrimSpace(os.Getenv("SERVICE_REGISTRY")))
+	var instance serviceInstance
+	switch serviceRegistry {
+	case "":
+	case "consul", "etcd":
+		var err error
+		instance, err = loadServiceInstance(cfg.ListenAddr)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		log.Printf("Service registration enabled with %s as %s (%s:%d).", serviceRegistry, instance.ID, instance.Address, instance.Port)
+	default:
+		errMsg := fmt.Sprintf("Invalid SERVICE_REGISTRY '%s': must be 'consul' or 'etcd'.", serviceRegistry)
+		log.Println(errMsg)
+		return errors.New(errMsg)
+	}
+
+	cfg.ServiceRegistry = serviceRegistry
+	cfg.ServiceRegistryAddr = strings.TrimSpace(os.Getenv("SERVICE_REGISTRY_ADDR"))
+	cfg.ServiceRegistryAuth = os.Getenv("SERVICE_REGISTRY_TOKEN")
+	cfg.ServiceInstance = instance
+	return nil
+}
+
+// loadRateLimitConfig reads the per-client rate limit and load shedding
+// settings.
+func loadRateLimitConfig(cfg *Config) error {
+	var rateLimitRPS float64
+	if rpsEnv := strings.TrimSpace(os.Getenv("RATE_LIMIT_RPS")); rpsEnv != "" {
+		rps, err := strconv.ParseFloat(rpsEnv, 64)
+		if err != nil || rps < 0 || math.IsInf(rps, 0) {
+			errMsg := fmt.Sprintf("Invalid RATE_LIMIT_RPS '%s': must be a non-negative number.", rpsEnv)
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+		rateLimitRPS = rps
+	}
+	rateLimitBurst, err := parseNonNegativeIntEnv("RATE_LIMIT_BURST", max(1, int(math.Ceil(rateLimitRPS))))
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if rateLimitRPS > 0 {
+		if rateLimitBurst == 0 {
+			errMsg := "Invalid RATE_LIMIT_BURST '0': must be at least 1 when rate limiting is enabled."
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+		log.Printf("Rate limiting enabled: %g requests/s per client, burst %d.", rateLimitRPS, rateLimitBurst)
+	}
+	var rateLimitTrustedProxies []netip.Prefix
+	for _, proxy := range strings.Split(os.Getenv("RATE_LIMIT_TRUSTED_PROXIES"), ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		network, err := parseThreatNetwork(proxy)
+		if err != nil {
+			errMsg := fmt.Sprintf("Invalid RATE_LIMIT_TRUSTED_PROXIES entry '%s': must be an IP address or CIDR network.", proxy)
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+		rateLimitTrustedProxies = append(rateLimitTrustedProxies, network)
+	}
+	maxConcurrentRequests, err := parseNonNegativeIntEnv("MAX_CONCURRENT_REQUESTS", 0)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if maxConcurrentRequests > 0 {
+		log.Printf("Load shedding enabled above %d concurrent requests.", maxConcurrentRequests)
+	}
+
+	cfg.RateLimitRPS = rateLimitRPS
+	cfg.RateLimitBurst = rateLimitBurst
+	cfg.RateLimitTrustedProxies = rateLimitTrustedProxies
+	cfg.MaxConcurrentRequests = maxConcurrentRequests
+	return nil
+}
+
+// loadJobsConfig reads the bulk lookup job settings.
+func loadJobsConfig(cfg *Config) error {
+	jobsDir := strings.TrimSpace(os.Getenv("JOBS_DIR"))
+	jobsWorkers, err := parseNonNegativeIntEnv("JOBS_WORKERS", 2)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	jobsMaxQueued, err := parseNonNegativeIntEnv("JOBS_MAX_QUEUED", 100)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	jobsMaxUploadMB, err := parseNonNegativeIntEnv("JOBS_MAX_UPLOAD_SIZE_MB", 1024)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	jobsRetention, err := parseDurationEnv("JOBS_RETENTION", 24*time.Hour)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if jobsDir != "" {
+		if jobsWorkers == 0 || jobsMaxQueued == 0 || jobsMaxUploadMB == 0 || jobsRetention <= 0 {
+			errMsg := "JOBS_WORKERS, JOBS_MAX_QUEUED, JOBS_MAX_UPLOAD_SIZE_MB and JOBS_RETENTION must be positive."
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+		log.Printf("Bulk lookup jobs enabled in %s (%d workers, up to %d queued, results kept %s).", jobsDir, jobsWorkers, jobsMaxQueued, jobsRetention)
+	}
+
+	cfg.JobsDir = jobsDir
+	cfg.JobsWorkers = jobsWorkers
+	cfg.JobsMaxQueued = jobsMaxQueued
+	cfg.JobsMaxUploadBytes = int64(jobsMaxUploadMB) << 20
+	cfg.JobsRetention = jobsRetention
+	return nil
+}
+
+// loadGroupcacheConfig reads the shared cache settings.
+func loadGroupcacheConfig(cfg *Config) error {
+	groupcacheSelf := strings.TrimSuffix(strings.TrimSpace(os.Getenv("GROUPCACHE_SELF")), "/")
+	var groupcachePeers []string
+	for _, peer := range strings.Split(os.Getenv("GROUPCACHE_PEERS"), ",") {
+		if peer = strings.TrimSuffix(strings.TrimSpace(peer), "/"); peer != "" {
+			groupcachePeers = append(groupcachePeers, peer)
+		}
+	}
+	groupcachePeersDNS := strings.TrimSpace(os.Getenv("GROUPCACHE_PEERS_DNS"))
+	groupcacheSizeMB, err := parseNonNegativeIntEnv("GROUPCACHE_SIZE_MB", 64)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if groupcacheSelf == "" && (len(groupcachePeers) > 0 || groupcachePeersDNS != "") {
+		errMsg := "GROUPCACHE_SELF must be set when GROUPCACHE_PEERS or GROUPCACHE_PEERS_DNS is."
+		log.Println(errMsg)
+		return errors.New(errMsg)
+	}
+	groupcacheSecret := strings.TrimSpace(os.Getenv("GROUPCACHE_SECRET"))
+	if groupcacheSelf != "" && groupcacheSecret == "" {
+		errMsg := "GROUPCACHE_SECRET must be set when GROUPCACHE_SELF is."
+		log.Println(errMsg)
+		return errors.New(errMsg)
+	}
+	if groupcacheSelf != "" {
+		log.Printf("Groupcache shared cache enabled as %s (%d MB).", groupcacheSelf, groupcacheSizeMB)
+	}
+
+	cfg.GroupcacheSelf = groupcacheSelf
+	cfg.GroupcacheSecret = groupcacheSecret
+	cfg.GroupcachePeers = groupcachePeers
+	cfg.GroupcachePeersDNS = groupcachePeersDNS
+	cfg.GroupcacheSizeBytes = int64(groupcacheSizeMB) << 20
+	return nil
+}
+
+// loadDBUpdateConfig reads the database update and reload settings.
+// It runs after loadDatabaseConfig, whose GEOIP_DB_URL is an update source.
+func loadDBUpdateConfig(cfg *Config) error {
+	dbUpdateURL := strings.TrimSpace(os.Getenv("DB_UPDATE_URL"))
+	if dbUpdateURL != "" {
+		if u, err := url.Parse(dbUpdateURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errMsg := "Invalid DB_UPDATE_URL: must be an http:// or https:// URL."
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+	}
+	maxMindAccountID := strings.TrimSpace(os.Getenv("MAXMIND_ACCOUNT_ID"))
+	maxMindLicenseKey := strings.TrimSpace(os.Getenv("MAXMIND_LICENSE_KEY"))
+	if (maxMindAccountID == "") != (maxMindLicenseKey == "") {
+		errMsg := "MAXMIND_ACCOUNT_ID and MAXMIND_LICENSE_KEY must be set together."
+		log.Println(errMsg)
+		return errors.New(errMsg)
+	}
+	sources := 0
+	for _, set := range []bool{cfg.GeoIPDBURL != "", dbUpdateURL != "", maxMindAccountID != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		errMsg := "Only one of GEOIP_DB_URL, DB_UPDATE_URL and MAXMIND_ACCOUNT_ID can be set: choose one update source."
+		log.Println(errMsg)
+		return errors.New(errMsg)
+	}
+	maxMindEditionID := strings.TrimSpace(os.Getenv("MAXMIND_EDITION_ID"))
+	if maxMindEditionID == "" {
+		maxMindEditionID = "GeoLite2-City"
+	}
+	maxMindDownloadURL := strings.TrimSpace(os.Getenv("MAXMIND_DOWNLOAD_URL"))
+	if maxMindDownloadURL == "" {
+		maxMindDownloadURL = defaultMaxMindDownloadURL
+	} else if u, err := url.Parse(maxMindDownloadURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errMsg := "Invalid MAXMIND_DOWNLOAD_URL: must be an http:// or https:// URL."
+		log.Println(errMsg)
+		return errors.New(errMsg)
+	}
+
+	dbUpdateInterval, err := parseDurationEnv("DB_UPDATE_INTERVAL", 24*time.Hour)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	dbUpdateLeaderElection := strings.ToLower(strings.TrimSpace(os.Getenv("DB_UPDATE_LEADER_ELECTION")))
+	switch dbUpdateLeaderElection {
+	case "", "file", "kubernetes":
+	default:
+		errMsg := fmt.Sprintf("Invalid DB_UPDATE_LEADER_ELECTION '%s': must be 'file' or 'kubernetes'.", dbUpdateLeaderElection)
+		log.Println(errMsg)
+		return errors.New(errMsg)
+	}
+	dbUpdateLockFile := strings.TrimSpace(os.Getenv("DB_UPDATE_LOCK_FILE"))
+	if dbUpdateLockFile == "" {
+		dbUpdateLockFile = cfg.GeoIPDBPath + ".lock"
+	}
+	dbUpdateLeaseName := strings.TrimSpace(os.Getenv("DB_UPDATE_LEASE_NAME"))
+	if dbUpdateLeaseName == "" {
+		dbUpdateLeaseName = "ip-lookup-db-updater"
+	}
+	if sources > 0 {
+		election := "every instance downloads"
+		if dbUpdateLeaderElection != "" {
+			election = dbUpdateLeaderElection + " leader election"
+		}
+		log.Printf("Database updates enabled every %s (%s).", dbUpdateInterval, election)
+		if maxMindAccountID != "" {
+			log.Printf("Database updates download %s from MaxMind.", maxMindEditionID)
+		}
+	}
+
+	dbWatchEnabled, err := parseBoolEnv("DB_WATCH_ENABLED")
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	dbWatchDebounce, err := parseDurationEnv("DB_WATCH_DEBOUNCE", 2*time.Second)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if dbWatchEnabled {
+		log.Printf("Database file watching enabled (debounce %s).", dbWatchDebounce)
+	}
+	dbRefreshInterval, err := parseDurationEnv("GEOIP_DB_REFRESH_INTERVAL", 0)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if dbRefreshInterval > 0 {
+		log.Printf("Database file refresh enabled every %s.", dbRefreshInterval)
+	}
+
+	cfg.DBUpdateURL = dbUpdateURL
+	cfg.MaxMindAccountID = maxMindAccountID
+	cfg.MaxMindLicenseKey = maxMindLicenseKey
+	cfg.MaxMindEditionID = maxMindEditionID
+	cfg.MaxMindDownloadURL = maxMindDownloadURL
+	cfg.DBUpdateInterval = dbUpdateInterval
+	cfg.DBUpdateLeaderElection = dbUpdateLeaderElection
+	cfg.DBUpdateLockFile = dbUpdateLockFile
+	cfg.DBUpdateLeaseName = dbUpdateLeaseName
+	cfg.DBWatchEnabled = dbWatchEnabled
+	cfg.DBWatchDebounce = dbWatchDebounce
+	cfg.DBRefreshInterval = dbRefreshInterval
+	return nil
+}
+
+// loadWebServiceConfig reads the MaxMind web service fallback settings.
+// Its credentials default to those of loadDBUpdateConfig.
+func loadWebServiceConfig(cfg *Config) error {
+	webServiceName := strings.ToLower(strings.TrimSpace(os.Getenv("MAXMIND_WEB_SERVICE")))
+	webServiceAccountID := strings.TrimSpace(os.Getenv("MAXMIND_WEB_SERVICE_ACCOUNT_ID"))
+	webServiceLicenseKey := strings.TrimSpace(os.Getenv("MAXMIND_WEB_SERVICE_LICENSE_KEY"))
+	if webServiceAccountID == "" && webServiceLicenseKey == "" {
+		webServiceAccountID, webServiceLicenseKey = cfg.MaxMindAccountID, cfg.MaxMindLicenseKey
+	}
+	webServiceURL := strings.TrimSpace(os.Getenv("MAXMIND_WEB_SERVICE_URL"))
+	if webServiceURL == "" {
+		webServiceURL = defaultMaxMindWebServiceURL
+	}
+	webServiceTimeout, err := parseDurationEnv("MAXMIND_WEB_SERVICE_TIMEOUT", 2*time.Second)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	webServiceCacheTTL, err := parseDurationEnv("MAXMIND_WEB_SERVICE_CACHE_TTL", 24*time.Hour)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	switch webServiceName {
+	case "":
+	case "city", "country", "insights":
+		if webServiceAccountID == "" || webServiceLicenseKey == "" {
+			errMsg := "MAXMIND_WEB_SERVICE_ACCOUNT_ID and MAXMIND_WEB_SERVICE_LICENSE_KEY (or MAXMIND_ACCOUNT_ID and MAXMIND_LICENSE_KEY) must be set when MAXMIND_WEB_SERVICE is."
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+		if u, err := url.Parse(webServiceURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errMsg := "Invalid MAXMIND_WEB_SERVICE_URL: must be an http:// or https:// URL."
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+		log.Printf("MaxMind %s web service fallback enabled for IPs the databases have no record for (timeout %s, cache TTL %s).", webServiceName, webServiceTimeout, webServiceCacheTTL)
+	default:
+		errMsg := fmt.Sprintf("Invalid MAXMIND_WEB_SERVICE '%s': must be 'city', 'country' or 'insights'.", webServiceName)
+		log.Println(errMsg)
+		return errors.New(errMsg)
+	}
+
+	cfg.WebService = webServiceName
+	cfg.WebServiceURL = webServiceURL
+	cfg.WebServiceAccountID = webServiceAccountID
+	cfg.WebServiceLicenseKey = webServiceLicenseKey
+	cfg.WebServiceTimeout = webServiceTimeout
+	cfg.WebServiceCacheTTL = webServiceCacheTTL
+	return nil
+}
+
+// loadMinFraudConfig reads the minFraud settings. Its credentials default
+// to those of loadDBUpdateConfig.
+func loadMinFraudConfig(cfg *Config) error {
+	minFraudEnabled, err := parseBoolEnv("MINFRAUD_ENABLED")
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	minFraudDefault, err := parseBoolEnv("MINFRAUD_DEFAULT")
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	minFraudAccountID := strings.TrimSpace(os.Getenv("MINFRAUD_ACCOUNT_ID"))
+	minFraudLicenseKey := strings.TrimSpace(os.Getenv("MINFRAUD_LICENSE_KEY"))
+	if minFraudAccountID == "" && minFraudLicenseKey == "" {
+		minFraudAccountID, minFraudLicenseKey = cfg.MaxMindAccountID, cfg.MaxMindLicenseKey
+	}
+	minFraudURL := strings.TrimSpace(os.Getenv("MINFRAUD_URL"))
+	if minFraudURL == "" {
+		minFraudURL = defaultMinFraudURL
+	}
+	minFraudTimeout, err := parseDurationEnv("MINFRAUD_TIMEOUT", time.Second)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	minFraudCacheTTL, err := parseDurationEnv("MINFRAUD_CACHE_TTL", time.Hour)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if minFraudDefault && !minFraudEnabled {
+		errMsg := "MINFRAUD_ENABLED must be set when MINFRAUD_DEFAULT is."
+		log.Println(errMsg)
+		return errors.New(errMsg)
+	}
+	if minFraudEnabled {
+		if minFraudAccountID == "" || minFraudLicenseKey == "" {
+			errMsg := "MINFRAUD_ACCOUNT_ID and MINFRAUD_LICENSE_KEY (or MAXMIND_ACCOUNT_ID and MAXMIND_LICENSE_KEY) must be set when MINFRAUD_ENABLED is."
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+		if u, err := url.Parse(minFraudURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errMsg := "Invalid MINFRAUD_URL: must be an http:// or https:// URL."
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+		log.Printf("minFraud Insights risk scores enabled for lookups (default %t, timeout %s, cache TTL %s).", minFraudDefault, minFraudTimeout, minFraudCacheTTL)
+	}
+
+	cfg.MinFraudEnabled = minFraudEnabled
+	cfg.MinFraudDefault = minFraudDefault
+	cfg.MinFraudURL = minFraudURL
+	cfg.MinFraudAccountID = minFraudAccountID
+	cfg.MinFraudLicenseKey = minFraudLicenseKey
+	cfg.MinFraudTimeout = minFraudTimeout
+	cfg.MinFraudCacheTTL = minFraudCacheTTL
+	return nil
+}
+
+// loadRemoteLookupConfig reads the remote lookup API settings.
+func loadRemoteLookupConfig(cfg *Config) error {
+	remoteLookupURL := strings.TrimSpace(os.Getenv("REMOTE_LOOKUP_URL"))
+	remoteLookupTimeout, err := parseDurationEnv("REMOTE_LOOKUP_TIMEOUT", time.Second)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	remoteLookupCacheTTL, err := parseDurationEnv("REMOTE_LOOKUP_CACHE_TTL", time.Hour)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if remoteLookupURL != "" {
+		if u, err := url.Parse(remoteLookupURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || !strings.Contains(remoteLookupURL, "{ip}") {
+			errMsg := "Invalid REMOTE_LOOKUP_URL: must be an http:// or https:// URL with {ip} where the IP goes."
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+		log.Printf("Remote lookup API %s enabled for IPs the databases have no record for (timeout %s, cache TTL %s).", remoteLookupURL, remoteLookupTimeout, remoteLookupCacheTTL)
+	}
+
+	cfg.RemoteLookupURL = remoteLookupURL
+	cfg.RemoteLookupTimeout = remoteLookupTimeout
+	cfg.RemoteLookupCacheTTL = remoteLookupCacheTTL
+	return nil
+}
+
+// loadAdminConfig reads the admin endpoint and database canary settings.
+func loadAdminConfig(cfg *Config) error {
+	adminToken := strings.TrimSpace(os.Getenv("ADMIN_TOKEN"))
+	if adminToken != "" {
+		if len(adminToken) < 16 {
+			errMsg := "Invalid ADMIN_TOKEN: must be at least 16 characters."
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+		log.Println("Admin endpoints enabled (POST /admin/db).")
+	}
+
+	var dbCanaryPercent float64
+	if percentEnv := strings.TrimSpace(os.Getenv("DB_CANARY_PERCENT")); percentEnv != "" {
+		percent, err := strconv.ParseFloat(percentEnv, 64)
+		if err != nil || percent < 0 || percent > 100 {
+			errMsg := fmt.Sprintf("Invalid DB_CANARY_PERCENT '%s': must be a percentage between 0 and 100.", percentEnv)
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+		dbCanaryPercent = percent
+	}
+	dbCanaryDuration, err := parseDurationEnv("DB_CANARY_DURATION", time.Hour)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if dbCanaryPercent > 0 {
+		log.Printf("Database canary rollouts enabled: new versions serve %g%% of lookups for %s first.", dbCanaryPercent, dbCanaryDuration)
+	}
+
+	cfg.AdminToken = adminToken
+	cfg.DBCanaryPercent = dbCanaryPercent
+	cfg.DBCanaryDuration = dbCanaryDuration
+	return nil
+}
+
+// loadHotUpgradeConfig reads the hot upgrade settings.
+func loadHotUpgradeConfig(cfg *Config) error {
+	hotUpgradeEnabled, err := parseBoolEnv("HOT_UPGRADE_ENABLED")
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if hotUpgradeEnabled {
+		if upgradeSignal == nil {
+			errMsg := "HOT_UPGRADE_ENABLED is not supported on this platform."
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+		log.Println("Hot upgrades enabled: send SIGUSR2 to start a new binary without dropping connections.")
+	}
+
+	cfg.HotUpgradeEnabled = hotUpgradeEnabled
+	cfg.PIDFile = strings.TrimSpace(os.Getenv("PID_FILE"))
+	return nil
+}
+
+// loadPolicyConfig reads the geofence policies and geo response headers.
+func loadPolicyConfig(cfg *Config) error {
+	policies, err := loadPolicies(strings.TrimSpace(os.Getenv("POLICY")), strings.TrimSpace(os.Getenv("POLICIES_FILE")))
+	if err != nil {
+		errMsg := fmt.Sprintf("Invalid geofence policy: %v", err)
+		log.Println(errMsg)
+		return errors.New(errMsg)
+	}
+	if len(policies) > 0 {
+		log.Printf("Geofence policies enabled: %d compiled.", len(policies))
+	}
+
+	geoHeadersEnabled, err := parseBoolEnv("GEO_HEADERS_ENABLED")
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	var geoHeaders []geoHeader
+	if geoHeadersEnabled {
+		headerNames := os.Getenv("GEO_HEADER_NAMES")
+		geoHeaders, err = parseGeoHeaderNames(headerNames)
+		if err != nil {
+			errMsg := fmt.Sprintf("Invalid GEO_HEADER_NAMES '%s': %v.", headerNames, err)
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+		log.Printf("Geo response headers enabled: %d headers.", len(geoHeaders))
+	}
+
+	cfg.Policies = policies
+	cfg.GeoHeaders = geoHeaders
+	return nil
+}
+
+// checkProviderConfig rejects the settings a GEOIP_PROVIDER other than
+// MaxMind does not support.
+func checkProviderConfig(cfg *Config) error {
+	if cfg.GeoIPProvider == providerMaxMind {
+		return nil
+	}
+	dbURLVar := "GEOIP_DB_URL"
+	if strings.TrimSpace(os.Getenv(dbURLVar)) == "" {
+		dbURLVar = "GEOIP_DB_PATH"
+	}
+	// These replace or compare the database as a MaxMind file.
+	for _, setting := range []struct {
+		name string
+		set  bool
+	}{
+		{dbURLVar, cfg.GeoIPDBURL != ""},
+		{"DB_UPDATE_URL", cfg.DBUpdateURL != ""},
+		{"MAXMIND_ACCOUNT_ID", cfg.MaxMindAccountID != ""},
+		{"DB_WATCH_ENABLED", cfg.DBWatchEnabled},
+		{"GEOIP_DB_REFRESH_INTERVAL", cfg.DBRefreshInterval > 0},
+		{"ADMIN_TOKEN", cfg.AdminToken != ""},
+		{"DB_CANARY_PERCENT", cfg.DBCanaryPercent > 0},
+	} {
+		if setting.set {
+			errMsg := fmt.Sprintf("%s is not supported with GEOIP_PROVIDER=%s: it requires a MaxMind database.", setting.name, cfg.GeoIPProvider)
+			log.Println(errMsg)
+			return errors.New(errMsg)
+		}
+	}
+	return nil
+}
+
+// loadServiceInstance builds the advertised service instance from the
+// SERVICE_* environment variables, defaulting to the hostname and listen port.
+func loadServiceInstance(listenAddr string) (serviceInstance, error) {
+	instance := serviceInstance{
+		Name:    strings.TrimSpace(os.Getenv("SERVICE_NAME")),
+		ID:      strings.TrimSpace(os.Getenv("SERVICE_ID")),
+		Address: strings.TrimSpace(os.Getenv("SERVICE_ADDRESS")),
+	}
+	if instance.Name == "" {
+		instance.Name = "ip-lookup"
+	}
+	if instance.Address == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return serviceInstance{}, fmt.Errorf("SERVICE_ADDRESS not set and hostname unavailable: %v", err)
+		}
+		instance.Address = hostname
+	}
+
+	port, err := listenPort(listenAddr)
+	if err != nil {
+		return serviceInstance{}, fmt.Errorf("Cannot derive service port from LISTEN_ADDR '%s': %v", listenAddr, err)
+	}
+	instance.Port = port
+	if portEnv := strings.TrimSpace(os.Getenv("SERVICE_PORT")); portEnv != "" {
+		if instance.Port, err = strconv.Atoi(portEnv); err != nil || instance.Port <= 0 || instance.Port > 65535 {
+			return serviceInstance{}, fmt.Errorf("Invalid SERVICE_PORT '%s': must be a port number.", portEnv)
+		}
+	}
+
+	if instance.ID == "" {
+		instance.ID = fmt.Sprintf("%s-%s-%d", instance.Name, instance.Address, instance.Port)
+	}
+	if tagsEnv := os.Getenv("SERVICE_TAGS"); tagsEnv != "" {
+		for _, tag := range strings.Split(tagsEnv, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				instance.Tags = append(instance.Tags, tag)
+			}
+		}
+	}
+	return instance, nil
+}
+
+// parseDurationEnv reads a positive duration (e.g. "5s", "24h") from an
+// environment variable, returning def when it is unset.
+func parseDurationEnv(name string, def time.Duration) (time.Duration, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return def, nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed <= 0 {
+		return 0, fmt.Errorf("Invalid %s '%s': must be a positive duration such as '5s' or '24h'.", name, value)
+	}
+	return parsed, nil
+}
+
+// parseFractionEnv reads a fraction strictly between 0 and 1 (such as 0.999)
+// from an environment variable, returning 0 when it is unset.
+func parseFractionEnv(name string) (float64, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return 0, nil
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || parsed <= 0 || parsed >= 1 {
+		return 0, fmt.Errorf("Invalid %s '%s': must be a fraction between 0 and 1 such as '0.999'.", name, value)
+	}
+	return parsed, nil
+}
+
+// parseNonNegativeIntEnv reads a non-negative integer from an environment
+// variable, returning def when it is unset.
+func parseNonNegativeIntEnv(name string, def int) (int, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return def, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		return 0, fmt.Errorf("Invalid %s '%s': must be a non-negative integer.", name, value)
+	}
+	return parsed, nil
+}
+
+// parseBoolEnv reads a boolean environment variable, treating unset as false.
+func parseBoolEnv(name string) (bool, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return false, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("Invalid %s '%s': must be a boolean (true/false).", name, value)
+	}
+	return parsed, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("GEOIP_DB_PATH", "/data/GeoLite2-City.mmdb")
+	t.Setenv("RATE_LIMIT_RPS", "2.5")
+	t.Setenv("MAXMIND_ACCOUNT_ID", "42")
+	t.Setenv("MAXMIND_LICENSE_KEY", "key")
+	t.Setenv("MINFRAUD_ENABLED", "true")
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ListenAddr != ":8080" || cfg.GeoIPProvider != providerMaxMind {
+		t.Errorf("ListenAddr, GeoIPProvider = %q, %q, want the defaults", cfg.ListenAddr, cfg.GeoIPProvider)
+	}
+	if cfg.RateLimitRPS != 2.5 || cfg.RateLimitBurst != 3 {
+		t.Errorf("RateLimitRPS, RateLimitBurst = %g, %d, want 2.5, 3", cfg.RateLimitRPS, cfg.RateLimitBurst)
+	}
+	if cfg.DBUpdateLockFile != "/data/GeoLite2-City.mmdb.lock" || cfg.DBUpdateInterval != 24*time.Hour {
+		t.Errorf("DBUpdateLockFile, DBUpdateInterval = %q, %s", cfg.DBUpdateLockFile, cfg.DBUpdateInterval)
+	}
+	if cfg.MinFraudAccountID != "42" || cfg.WebServiceAccountID != "42" {
+		t.Errorf("MinFraudAccountID, WebServiceAccountID = %q, %q, want MAXMIND_ACCOUNT_ID", cfg.MinFraudAccountID, cfg.WebServiceAccountID)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		env  map[string]string
+		want string
+	}{
+		{map[string]string{"COORDINATE_PRECISION": "16"}, "Invalid COORDINATE_PRECISION"},
+		{map[string]string{"RATE_LIMIT_RPS": "fast"}, "Invalid RATE_LIMIT_RPS"},
+		{map[string]string{"RATE_LIMIT_TRUSTED_PROXIES": "10.0.0.0/33"}, "Invalid RATE_LIMIT_TRUSTED_PROXIES"},
+		{map[string]string{"COAP_RATE_LIMIT_RPS": "-1"}, "Invalid COAP_RATE_LIMIT_RPS"},
+		{map[string]string{"GROUPCACHE_SELF": "http://10.0.0.5:8080"}, "GROUPCACHE_SECRET must be set"},
+		{map[string]string{"GEOIP_DB_URL": "https://example.com/db.mmdb", "DB_UPDATE_URL": "https://example.com/db.mmdb"}, "Only one of GEOIP_DB_URL"},
+		{map[string]string{"GEOIP_PROVIDER": "ip2location", "ADMIN_TOKEN": "0123456789abcdef"}, "ADMIN_TOKEN is not supported"},
+		{map[string]string{"GEOIP_PROVIDER": "ip2location", "GEOIP_DB_PATH": "https://example.com/db.bin"}, "GEOIP_DB_PATH is not supported"},
+		{map[string]string{"MINFRAUD_DEFAULT": "true"}, "MINFRAUD_ENABLED must be set"},
+	}
+	for _, tt := range tests {
+		t.Setenv("GEOIP_DB_PATH", "/data/db.mmdb")
+		for k, v := range tt.env {
+			t.Setenv(k, v)
+		}
+		_, err := loadConfig()
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("loadConfig with %v: err = %v, want %q", tt.env, err, tt.want)
+		}
+		for k := range tt.env {
+			t.Setenv(k, "")
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net"
+
+	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// connectionTypeDB is the optional GeoIP2 Connection Type database
+// (GEOIP_CONNECTION_TYPE_DB_PATH).
+var connectionTypeDB = &supplementaryDB{
+	name:      "Connection Type",
+	types:     map[string]bool{"GeoIP2-Connection-Type": true},
+	want:      "a GeoIP2-Connection-Type database",
+	addFields: addConnectionTypeFields,
+}
+
+// addConnectionTypeFields sets connection_type: "Cable/DSL", "Cellular",
+// "Corporate" or "Satellite".
+func addConnectionTypeFields(reader *maxminddb.Reader, ip net.IP, response map[string]any) error {
+	var record geoip2.ConnectionType
+	_, found, err := reader.LookupNetwork(ip, &record)
+	if err != nil || !found || record.ConnectionType == "" {
+		return err
+	}
+	response["connection_type"] = record.ConnectionType
+	return nil
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// csvLookupColumns are the columns a CSV lookup can append, by the lookup
+// field each is read from.
+var csvLookupColumns = map[string]string{
+	"country_code": "country_code",
+	"city":         "city",
+	"lat":          "latitude",
+	"lon":          "longitude",
+	"asn":          "autonomous_system_number",
+}
+
+// defaultCSVLookupColumns are the appended columns without ?columns=.
+var defaultCSVLookupColumns = []string{"country_code", "city", "lat", "lon", "asn"}
+
+// csvLookupOptions are the query parameters of a CSV lookup.
+type csvLookupOptions struct {
+	header bool
+	// column is the IP column: a header name, or a 1-based number when
+	// columnName is empty.
+	column     int
+	columnName string
+	columns    []string
+	inputComma rune
+	comma      rune
+}
+
+func parseCSVLookupOptions(query url.Values) (csvLookupOptions, error) {
+	opts := csvLookupOptions{header: true, column: 1, columns: defaultCSVLookupColumns, inputComma: ','}
+	if value := strings.TrimSpace(query.Get("header")); value != "" {
+		header, err := strconv.ParseBool(value)
+		if err != nil {
+			return opts, fmt.Errorf("Invalid header parameter '%s': must be a boolean such as 1 or 0", value)
+		}
+		opts.header = header
+	}
+	if value := strings.TrimSpace(query.Get("column")); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			if n < 1 {
+				return opts, fmt.Errorf("Invalid column parameter '%s': column numbers start at 1", value)
+			}
+			opts.column = n
+		} else if !opts.header {
+			return opts, fmt.Errorf("Invalid column parameter '%s': without a header row the column must be a number", value)
+		} else {
+			opts.columnName = value
+		}
+	} else if opts.header {
+		opts.columnName = "ip"
+	}
+	if value := strings.TrimSpace(query.Get("columns")); value != "" {
+		opts.columns = nil
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if _, ok := csvLookupColumns[name]; !ok {
+				return opts, fmt.Errorf("Invalid columns parameter: unknown column '%s' (must be country_code, city, lat, lon or asn)", name)
+			}
+			opts.columns = append(opts.columns, name)
+		}
+	}
+	var err error
+	if opts.inputComma, err = parseCSVDelimiter("input_delimiter", query.Get("input_delimiter"), ','); err != nil {
+		return opts, err
+	}
+	if opts.comma, err = parseCSVDelimiter("delimiter", query.Get("delimiter"), opts.inputComma); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// parseCSVDelimiter parses a delimiter parameter: a single character, or
+// "tab".
+func parseCSVDelimiter(param, value string, def rune) (rune, error) {
+	if value == "" {
+		return def, nil
+	}
+	if strings.EqualFold(value, "tab") {
+		return '\t', nil
+	}
+	comma, size := utf8.DecodeRuneInString(value)
+	if size != len(value) || comma == utf8.RuneError || comma == '"' || comma == '\r' || comma == '\n' {
+		return 0, fmt.Errorf("Invalid %s parameter '%s': must be a single character or 'tab'", param, value)
+	}
+	return comma, nil
+}
+
+// csvLookup appends the geo columns of opts to the rows of a CSV.
+type csvLookup struct {
+	opts   csvLookupOptions
+	reader *csv.Reader
+	writer *csv.Writer
+	// column is the IP column. Short rows are padded to width, so the
+	// appended cells line up.
+	column int
+	width  int
+	// pending is the header row, written before the first row.
+	pending []string
+}
+
+func newCSVLookup(in io.Reader, out io.Writer, opts csvLookupOptions) *csvLookup {
+	reader := csv.NewReader(in)
+	reader.Comma = opts.inputComma
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.ReuseRecord = true
+	writer := csv.NewWriter(out)
+	writer.Comma = opts.comma
+	return &csvLookup{opts: opts, reader: reader, writer: writer, column: opts.column - 1, width: opts.column}
+}
+
+// readHeader reads the header row and finds the IP column in it. It returns
+// io.EOF for an empty CSV.
+func (c *csvLookup) readHeader() error {
+	record, err := c.reader.Read()
+	if err != nil {
+		return err
+	}
+	if c.opts.columnName != "" {
+		c.column = -1
+		for i, name := range record {
+			if strings.EqualFold(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")), c.opts.columnName) {
+				c.column = i
+				break
+			}
+		}
+		if c.column < 0 {
+			return fmt.Errorf("no '%s' column in the header row; select the IP column with ?column=", c.opts.columnName)
+		}
+	}
+	c.width = max(c.column+1, len(record))
+	c.pending = append(record, c.opts.columns...)
+	return nil
+}
+
+// row reads the next row and writes it with the geo columns of its IP
+// appended, empty when the IP cannot be looked up. It returns io.EOF at the
+// end of the CSV, and a *csv.ParseError when the rest of the input cannot be
+// split into rows reliably.
+func (c *csvLookup) row(ctx context.Context) error {
+	if err := c.writePending(); err != nil {
+		return err
+	}
+	record, err := c.reader.Read()
+	if err != nil {
+		return err
+	}
+	var response map[string]any
+	if c.column < len(record) {
+		if result, ok := lookupBatchItem(ctx, record[c.column]); ok {
+			response = result
+		}
+	}
+	for len(record) < c.width {
+		record = append(record, "")
+	}
+	for _, name := range c.opts.columns {
+		record = append(record, csvLookupCell(response[csvLookupColumns[name]]))
+	}
+	return c.writer.Write(record)
+}
+
+func (c *csvLookup) writePending() error {
+	if c.pending == nil {
+		return nil
+	}
+	record := c.pending
+	c.pending = nil
+	return c.writer.Write(record)
+}
+
+// flush writes the buffered rows.
+func (c *csvLookup) flush() error {
+	if err := c.writePending(); err != nil {
+		return err
+	}
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+// csvLookupHandler serves POST /lookup/csv: a CSV with a column of IPs,
+// answered with the same CSV and the geo columns of each row's IP appended.
+// Rows are streamed like those of a streaming lookup.
+func csvLookupHandler(w http.ResponseWriter, r *http.Request) {
+	if !databaseLoaded() {
+		writeJSONError(w, "GeoIP service not available", http.StatusInternalServerError, errCodeDBUnavailable)
+		return
+	}
+	opts, err := parseCSVLookupOptions(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest, errCodeInvalidRequest)
+		return
+	}
+
+	rc := streamingController(w)
+	_ = rc.SetReadDeadline(time.Now().Add(streamLookupIdleTimeout))
+	in := bufio.NewReader(r.Body)
+	out := bufio.NewWriter(w)
+	lookup := newCSVLookup(in, out, opts)
+	// The header row is read before the response is written, so a missing IP
+	// column is still a 400. Reading also sends clients waiting on Expect:
+	// 100-continue the go-ahead.
+	if opts.header {
+		if err := lookup.readHeader(); err != nil && err != io.EOF {
+			writeJSONError(w, fmt.Sprintf("Invalid CSV: %v", err), http.StatusBadRequest, errCodeInvalidRequest)
+			return
+		}
+	} else {
+		_, _ = in.Peek(1)
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	for {
+		_ = rc.SetReadDeadline(time.Now().Add(streamLookupIdleTimeout))
+		err := lookup.row(r.Context())
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			log.Printf("CSV lookup stopped at invalid input: %v", err)
+			break
+		}
+		if err != nil {
+			log.Printf("CSV lookup ended early: %v", err)
+			return
+		}
+		if in.Buffered() == 0 {
+			if err := lookup.flush(); err != nil {
+				return
+			}
+			if err := out.Flush(); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+	if err := lookup.flush(); err != nil {
+		log.Printf("Error writing CSV lookup response: %v", err)
+		return
+	}
+	if err := out.Flush(); err != nil {
+		log.Printf("Error writing CSV lookup response: %v", err)
+	}
+}
+
+// csvLookupCell formats a lookup field as a CSV cell; missing and null fields
+// are empty.
+func csvLookupCell(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
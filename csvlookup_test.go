@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// serveCSVLookup posts body to csvLookupHandler with query and returns the
+// status and body of the response.
+func serveCSVLookup(t *testing.T, query, body string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/lookup/csv?"+query, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	csvLookupHandler(rec, req)
+	return rec.Code, rec.Body.String()
+}
+
+func TestCSVLookup(t *testing.T) {
+	useFakeProvider(t, map[string]any{"country_code": "GB", "city": "London", "latitude": 51.5142})
+
+	tests := []struct {
+		name  string
+		query string
+		body  string
+		want  string
+	}{
+		{"header", "columns=country_code,lat", "name,ip\nhome,81.2.69.142\n",
+			"name,ip,country_code,lat\nhome,81.2.69.142,GB,51.5142\n"},
+		{"header with a BOM and spaces", "columns=city&column=IP", "\ufeffname, Ip \nhome,81.2.69.142\n",
+			"\ufeffname,\" Ip \",city\nhome,81.2.69.142,London\n"},
+		{"no header", "header=0&column=2&columns=city", "home,81.2.69.142\r\nwork,8.8.8.8\r\n",
+			"home,81.2.69.142,London\nwork,8.8.8.8,London\n"},
+		{"invalid IPs", "columns=city", "ip\nnot-an-ip\n\"\"\n8.8.8.8\n",
+			"ip,city\nnot-an-ip,\n,\n8.8.8.8,London\n"},
+		{"short rows are padded", "header=0&column=3&columns=city", "a\na,b,81.2.69.142\n",
+			"a,,,\na,b,81.2.69.142,London\n"},
+		{"long rows", "columns=city", "ip\n81.2.69.142,extra,cells\n",
+			"ip,city\n81.2.69.142,extra,cells,London\n"},
+		{"quoted cells", "columns=city", "ip,note\n\"81.2.69.142\",\"a, \"\"b\"\"\"\n",
+			"ip,note,city\n81.2.69.142,\"a, \"\"b\"\"\",London\n"},
+		{"a stray quote", "columns=city", "ip,note\n81.2.69.142,a\"b\n",
+			"ip,note,city\n81.2.69.142,\"a\"\"b\",London\n"},
+		{"delimiters", "input_delimiter=tab&delimiter=%3B&columns=city,lat", "ip\tnote\n81.2.69.142\ta;b\n",
+			"ip;note;city;lat\n81.2.69.142;\"a;b\";London;51.5142\n"},
+		{"empty", "", "", ""},
+		{"only a header", "columns=city", "ip\n", "ip,city\n"},
+		{"no final newline", "columns=city", "ip\n81.2.69.142", "ip,city\n81.2.69.142,London\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := serveCSVLookup(t, tt.query, tt.body)
+			if code != http.StatusOK || body != tt.want {
+				t.Errorf("got %d %q, want 200 %q", code, body, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVLookupRejectsMalformedRequests(t *testing.T) {
+	useFakeProvider(t, map[string]any{"country_code": "GB"})
+
+	tests := []struct {
+		name    string
+		query   string
+		body    string
+		message string
+	}{
+		{"no IP column", "", "name,address\nhome,81.2.69.142\n", "no 'ip' column in the header row"},
+		{"no named column", "column=addr", "ip\n81.2.69.142\n", "no 'addr' column in the header row"},
+		{"header of an unclosed quote", "", "\"ip\n81.2.69.142\n", "no 'ip' column"},
+		{"invalid header parameter", "header=maybe", "ip\n", "Invalid header parameter 'maybe'"},
+		{"column 0", "column=0", "ip\n", "column numbers start at 1"},
+		{"named column without a header", "header=false&column=ip", "81.2.69.142\n", "without a header row the column must be a number"},
+		{"unknown column", "columns=city,region", "ip\n", "unknown column 'region'"},
+		{"empty column", "columns=city,,lat", "ip\n", "unknown column ''"},
+		{"delimiter of two characters", "delimiter=%3B%3B", "ip\n", "Invalid delimiter parameter ';;'"},
+		{"quote delimiter", "input_delimiter=" + url.QueryEscape(`"`), "ip\n", "Invalid input_delimiter parameter"},
+		{"newline delimiter", "delimiter=%0A", "ip\n", "Invalid delimiter parameter"},
+		{"invalid UTF-8 delimiter", "delimiter=%FF", "ip\n", "Invalid delimiter parameter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := serveCSVLookup(t, tt.query, tt.body)
+			if code != http.StatusBadRequest || !strings.Contains(body, tt.message) {
+				t.Errorf("got %d %s, want 400 with %q", code, body, tt.message)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// dbCacheMaxAge is how long an unpacked database that no open call used
+// stays in the cache directory.
+const dbCacheMaxAge = 24 * time.Hour
+
+// gzipMagic starts every gzip stream, so .mmdb.gz and .tar.gz files are
+// recognized whatever they are named.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// openMMDB opens the MaxMind database at path. A gzip-compressed database or
+// a tar.gz archive holding one, as MaxMind distributes them, is unpacked into
+// appConfig.DBCacheDir first.
+func openMMDB(path string) (*maxminddb.Reader, error) {
+	unpacked, err := unpackedDBPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return maxminddb.Open(unpacked)
+}
+
+// unpackedDBPath returns path itself for a plain database and the cached
+// copy of the contained database for an archive. The copy is named after
+// the archive's SHA-256, so an archive is unpacked once however many files
+// (an update's temporary file, then GEOIP_DB_PATH) hold it.
+func unpackedDBPath(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	magic := make([]byte, len(gzipMagic))
+	if _, err := io.ReadFull(f, magic); err != nil || !bytes.Equal(magic, gzipMagic) {
+		return path, nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	dir := appConfig.DBCacheDir
+	cached := filepath.Join(dir, hex.EncodeToString(hash.Sum(nil))[:32]+".mmdb")
+	if _, err := os.Stat(cached); err == nil {
+		// Mark the copy as used so pruneDBCache keeps it.
+		now := time.Now()
+		os.Chtimes(cached, now, now)
+		return cached, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(dir, ".unpack-*.mmdb")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	err = unpackMMDB(f, tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	os.Chmod(tmp.Name(), 0o644)
+	if err := os.Rename(tmp.Name(), cached); err != nil {
+		return "", err
+	}
+	log.Printf("Unpacked %s to %s.", path, cached)
+	pruneDBCache(dir)
+	return cached, nil
+}
+
+// unpackMMDB copies the database in a gzip stream, either compressed on its
+// own or in a tar archive, to dst.
+func unpackMMDB(r io.Reader, dst io.Writer) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	br := bufio.NewReaderSize(gz, 1024)
+	// Every tar header carries the "ustar" magic at offset 257.
+	if header, _ := br.Peek(262); len(header) == 262 && string(header[257:262]) == "ustar" {
+		err = copyTarMMDB(br, dst)
+	} else {
+		_, err = io.Copy(dst, br)
+	}
+	if err != nil {
+		return err
+	}
+	// Read to the end so the gzip checksum is verified.
+	_, err = io.Copy(io.Discard, br)
+	return err
+}
+
+// pruneDBCache removes unpacked databases no open call used for
+// dbCacheMaxAge. Readers still serving one keep their mapping.
+func pruneDBCache(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".mmdb") || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) > dbCacheMaxAge {
+			os.Remove(filepath.Join(dir, entry.Name()))
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import "strings"
+
+// dbipDatabaseTypes map the DB-IP editions whose type names no MaxMind type
+// to the MaxMind type their records follow.
+var dbipDatabaseTypes = map[string]string{
+	"DBIP-City-Lite":    "GeoLite2-City",
+	"DBIP-Country-Lite": "GeoLite2-Country",
+	"DBIP-Country":      "GeoIP2-Country",
+	"DBIP-ASN-Lite":     "GeoLite2-ASN",
+}
+
+// dbipCompatTypes expand the short names DB-IP uses in "(compat=...)".
+var dbipCompatTypes = map[string]string{
+	"City":       "GeoIP2-City",
+	"Country":    "GeoIP2-Country",
+	"Enterprise": "GeoIP2-Enterprise",
+}
+
+// dbipCompatibleType returns the MaxMind database type a DB-IP database type
+// is laid out like. DB-IP names the type in the database type itself, e.g.
+// "DBIP-Location (compat=City)" or "DBIP-ASN-Lite (compat=GeoLite2-ASN)",
+// except for the Lite editions in dbipDatabaseTypes.
+func dbipCompatibleType(dbType string) (string, bool) {
+	if !strings.HasPrefix(dbType, "DBIP-") {
+		return "", false
+	}
+	if name, compat, ok := strings.Cut(dbType, " (compat="); ok {
+		compat = strings.TrimSuffix(compat, ")")
+		if expanded, ok := dbipCompatTypes[compat]; ok {
+			compat = expanded
+		}
+		if compat != "" {
+			return compat, true
+		}
+		dbType = name
+	}
+	compat, ok := dbipDatabaseTypes[dbType]
+	return compat, ok
+}
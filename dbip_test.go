@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestDBIPCompatibleType(t *testing.T) {
+	tests := []struct {
+		dbType string
+		want   string
+		ok     bool
+	}{
+		{"DBIP-City-Lite", "GeoLite2-City", true},
+		{"DBIP-Country-Lite", "GeoLite2-Country", true},
+		{"DBIP-Country", "GeoIP2-Country", true},
+		{"DBIP-ASN-Lite", "GeoLite2-ASN", true},
+		{"DBIP-Location (compat=City)", "GeoIP2-City", true},
+		{"DBIP-Location-ISP (compat=Enterprise)", "GeoIP2-Enterprise", true},
+		{"DBIP-ASN (compat=GeoLite2-ASN)", "GeoLite2-ASN", true},
+		{"DBIP-City-Lite (compat=)", "GeoLite2-City", true},
+		{"DBIP-Unknown", "", false},
+		{"GeoLite2-City", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := dbipCompatibleType(tt.dbType)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("dbipCompatibleType(%q) = %q, %v, want %q, %v", tt.dbType, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// The fixtures in testdata are built from the .json file of the same name
+// with testdata/mkmmdb.py.
+
+func TestDBIPCityFields(t *testing.T) {
+	useConfig(t, Config{CoordinatePrecision: -1})
+	city := map[string]any{
+		"ip":                   "8.8.8.8",
+		"network":              "8.8.8.0/24",
+		"city":                 "Mountain View",
+		"country_code":         "US",
+		"country_name":         "United States",
+		"continent":            "North America",
+		"is_in_european_union": false,
+		"latitude":             37.4223,
+		"longitude":            -122.085,
+		"subdivision_name":     "California",
+	}
+	tests := []struct {
+		path string
+		want map[string]any
+		// absent are fields the database type must not produce.
+		absent []string
+	}{
+		{"testdata/dbip-city-lite.mmdb", city, nil},
+		{"testdata/dbip-location.mmdb", city, nil},
+		{"testdata/dbip-country-lite.mmdb", map[string]any{
+			"ip":           "8.8.8.8",
+			"network":      "8.8.8.0/24",
+			"country_code": "US",
+			"country_name": "United States",
+			"continent":    "North America",
+		}, []string{"city", "latitude", "longitude", "subdivision_name"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			p := &maxMindProvider{path: tt.path}
+			if err := p.load(tt.path); err != nil {
+				t.Fatalf("load: %v", err)
+			}
+			t.Cleanup(func() { p.Close() })
+
+			response, err := p.lookup(context.Background(), net.ParseIP("8.8.8.8"))
+			if err != nil || response == nil {
+				t.Fatalf("lookup = %v, %v", response, err)
+			}
+			for k, want := range tt.want {
+				if got := response[k]; !reflect.DeepEqual(got, want) {
+					t.Errorf("%s = %#v, want %#v", k, got, want)
+				}
+			}
+			for _, k := range tt.absent {
+				if got, ok := response[k]; ok {
+					t.Errorf("%s = %#v, want no such field", k, got)
+				}
+			}
+
+			if response, err := p.lookup(context.Background(), net.ParseIP("1.1.1.1")); err != nil || response != nil {
+				t.Errorf("lookup of an IP outside the database = %v, %v, want no record", response, err)
+			}
+		})
+	}
+}
+
+func TestDBIPASNFields(t *testing.T) {
+	tests := []struct {
+		path   string
+		ip     string
+		number uint
+		org    string
+	}{
+		{"testdata/dbip-asn.mmdb", "8.8.8.8", 15169, "GOOGLE"},
+		{"testdata/dbip-asn-lite.mmdb", "8.8.8.8", 15169, "GOOGLE"},
+		{"testdata/dbip-asn-lite.mmdb", "10.1.2.3", 64512, "PRIVATE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path+"/"+tt.ip, func(t *testing.T) {
+			d := &supplementaryDB{name: asnDB.name, types: asnDB.types, want: asnDB.want, addFields: asnDB.addFields, path: tt.path}
+			if err := d.load(); err != nil {
+				t.Fatalf("load: %v", err)
+			}
+			t.Cleanup(func() { d.reader.Load().Close() })
+
+			response := map[string]any{}
+			if err := d.addFields(d.reader.Load(), net.ParseIP(tt.ip), response); err != nil {
+				t.Fatal(err)
+			}
+			if got := response["autonomous_system_number"]; got != tt.number {
+				t.Errorf("autonomous_system_number = %#v, want %d", got, tt.number)
+			}
+			if got := response["autonomous_system_organization"]; got != tt.org {
+				t.Errorf("autonomous_system_organization = %#v, want %q", got, tt.org)
+			}
+		})
+	}
+}
+
+func TestDBIPDatabaseTypeChecks(t *testing.T) {
+	if _, err := openGeoDB("testdata/dbip-asn.mmdb"); err == nil {
+		t.Error("openGeoDB accepted a DB-IP ASN database as the City database")
+	}
+	d := &supplementaryDB{name: asnDB.name, types: asnDB.types, want: asnDB.want, path: "testdata/dbip-city-lite.mmdb"}
+	if err := d.load(); err == nil {
+		d.reader.Load().Close()
+		t.Error("the ASN database accepted a DB-IP City database")
+	}
+}
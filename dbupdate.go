@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// dbUpdateCheckInterval is how often the updater renews leadership and
+// checks whether another instance replaced the database file.
+const dbUpdateCheckInterval = 30 * time.Second
+
+// dbUpdater keeps the database at path current. The leader (every instance
+// when there is no elector) downloads new versions from source every
+// interval and installs them atomically; all instances reload the file when
+// it changes, so replicas sharing a volume download each update once.
+type dbUpdater struct {
+	path     string
+	source   dbSource
+	interval time.Duration
+	elector  leaderElector
+	client   *http.Client
+
+	leader        bool
+	elected       bool
+	lastDownload  time.Time
+	failedModTime time.Time
+}
+
+// dbSource is where the updater downloads the database from.
+type dbSource interface {
+	// fetch writes the database to dst unless it has not changed since
+	// modTime (zero when unknown), in which case it returns errNotModified.
+	// It returns the version's modification time when the source has one.
+	fetch(ctx context.Context, client *http.Client, modTime time.Time, dst io.Writer) (time.Time, error)
+}
+
+// errNotModified is returned by a dbSource with no newer database.
+var errNotModified = errors.New("not modified")
+
+// urlSource is an HTTP(S) URL serving the .mmdb file (DB_UPDATE_URL, or
+// GEOIP_DB_URL). Requests are conditional on the ETag of the last download
+// and the loaded file's modification time, so an unchanged database is not
+// downloaded again.
+type urlSource struct {
+	url  string
+	etag string
+}
+
+func (s *urlSource) fetch(ctx context.Context, client *http.Client, modTime time.Time, dst io.Writer) (time.Time, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if s.etag != "" {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+	if !modTime.IsZero() {
+		req.Header.Set("If-Modified-Since", modTime.UTC().Format(http.TimeFormat))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return time.Time{}, errNotModified
+	}
+	if resp.StatusCode != http.StatusOK {
+		return time.Time{}, fmt.Errorf("server returned %s", resp.Status)
+	}
+	if _, err := io.Copy(dst, resp.Body); err != nil {
+		return time.Time{}, err
+	}
+	s.etag = resp.Header.Get("ETag")
+	lastModified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return lastModified, nil
+}
+
+// updateSource returns the configured source of database updates, or nil
+// when updates are disabled.
+func updateSource(cfg Config) dbSource {
+	switch {
+	case cfg.MaxMindAccountID != "":
+		return maxMindSource{
+			baseURL:    cfg.MaxMindDownloadURL,
+			accountID:  cfg.MaxMindAccountID,
+			licenseKey: cfg.MaxMindLicenseKey,
+			edition:    cfg.MaxMindEditionID,
+		}
+	case cfg.GeoIPDBURL != "":
+		u, _ := url.Parse(cfg.GeoIPDBURL)
+		key := strings.TrimPrefix(u.Path, "/")
+		switch u.Scheme {
+		case "gs":
+			return newGCSSource(u.Host, key)
+		case "s3":
+			return newS3Source(u.Host, key)
+		}
+		return &urlSource{url: cfg.GeoIPDBURL}
+	case cfg.DBUpdateURL != "":
+		return &urlSource{url: cfg.DBUpdateURL}
+	}
+	return nil
+}
+
+func newDBUpdater(path string, source dbSource, interval time.Duration, elector leaderElector) *dbUpdater {
+	return &dbUpdater{
+		path:     path,
+		source:   source,
+		interval: interval,
+		elector:  elector,
+		client:   &http.Client{Timeout: 10 * time.Minute},
+	}
+}
+
+// run updates the database until ctx is cancelled, then gives up leadership.
+func (u *dbUpdater) run(ctx context.Context) {
+	ticker := time.NewTicker(dbUpdateCheckInterval)
+	defer ticker.Stop()
+	for {
+		u.check(ctx)
+		select {
+		case <-ctx.Done():
+			if u.elector != nil && u.leader {
+				releaseCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				if err := u.elector.release(releaseCtx); err != nil {
+					log.Printf("Database updater: could not release leadership: %v", err)
+				}
+				cancel()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (u *dbUpdater) check(ctx context.Context) {
+	leader := true
+	if u.elector != nil {
+		var err error
+		if leader, err = u.elector.acquire(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Database updater: leader election failed: %v", err)
+		}
+		if leader != u.leader || !u.elected {
+			if leader {
+				log.Println("Database updater: this instance is now the leader and downloads updates.")
+			} else {
+				log.Println("Database updater: following; another instance downloads updates.")
+			}
+		}
+	}
+	u.leader, u.elected = leader, true
+
+	// Allow for ticker jitter so an interval that is a multiple of the check
+	// interval is not skipped by a few microseconds.
+	if leader && time.Since(u.lastDownload) >= u.interval-time.Second {
+		u.lastDownload = time.Now()
+		err := u.download(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Database updater: download failed: %v", err)
+		}
+		if ctx.Err() == nil {
+			alertDBUpdate(err)
+		}
+	}
+	u.reloadIfChanged()
+}
+
+// download fetches the database and installs it if it is newer than the
+// loaded one.
+func (u *dbUpdater) download(ctx context.Context) error {
+	built, err := u.save(ctx)
+	if err != nil || built == 0 {
+		return err
+	}
+	log.Printf("Downloaded GeoIP database built %s.", time.Unix(int64(built), 0).UTC().Format(time.RFC3339))
+	return maxMindDB().install(u.path)
+}
+
+// save fetches the database and, if it is newer than the loaded one (or none
+// is loaded yet), replaces the file at path with it, returning its build
+// epoch; zero means there was no newer version. The file is written next to
+// path and renamed into place, so instances reading the shared file never see
+// a partial download.
+func (u *dbUpdater) save(ctx context.Context) (uint, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(u.path), ".download-*.mmdb")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	// CreateTemp makes the file private; other instances must be able to read it.
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	var modTime time.Time
+	if loaded := maxMindDB().modTime.Load(); loaded != nil {
+		modTime = *loaded
+	}
+	lastModified, err := u.source.fetch(ctx, u.client, modTime, tmp)
+	if err != nil {
+		tmp.Close()
+		if errors.Is(err, errNotModified) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+
+	candidate, err := openGeoDB(tmp.Name())
+	if err != nil {
+		return 0, fmt.Errorf("downloaded database is invalid: %v", err)
+	}
+	built := candidate.Metadata.BuildEpoch
+	candidate.Close()
+	if current := maxMindDB().current(); current != nil && built <= current.Metadata.BuildEpoch {
+		return 0, nil
+	}
+	if !lastModified.IsZero() {
+		os.Chtimes(tmp.Name(), lastModified, lastModified)
+	}
+	if err := os.Rename(tmp.Name(), u.path); err != nil {
+		return 0, err
+	}
+	return built, nil
+}
+
+// reloadIfChanged loads the database file again when its modification time
+// differs from the loaded one, e.g. after the leader replaced it.
+func (u *dbUpdater) reloadIfChanged() {
+	if err := maxMindDB().reloadIfChanged(u.path, &u.failedModTime); err != nil {
+		log.Printf("Database updater: keeping the loaded database: %v", err)
+	}
+}
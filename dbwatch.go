@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// dbWatcher reloads the database when its file is replaced on disk, e.g. by
+// a geoipupdate sidecar. It watches the file's directory rather than the
+// file, since replacing a file by rename (or a Kubernetes volume's symlink
+// swap) leaves a watch on the old inode behind.
+type dbWatcher struct {
+	path     string
+	debounce time.Duration
+	watcher  *fsnotify.Watcher
+
+	failedModTime time.Time
+}
+
+func newDBWatcher(path string, debounce time.Duration) (*dbWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	return &dbWatcher{path: path, debounce: debounce, watcher: watcher}, nil
+}
+
+// run reloads the database after changes in its directory until ctx is
+// cancelled. A reload waits until the directory has been quiet for the
+// debounce period, so a file still being written is not loaded.
+func (d *dbWatcher) run(ctx context.Context) {
+	defer d.watcher.Close()
+	timer := time.NewTimer(d.debounce)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-d.watcher.Events:
+			if !ok {
+				return
+			}
+			timer.Reset(d.debounce)
+		case err, ok := <-d.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Database watcher: %v", err)
+		case <-timer.C:
+			if err := maxMindDB().reloadIfChanged(d.path, &d.failedModTime); err != nil {
+				log.Printf("Database watcher: keeping the loaded database: %v", err)
+			}
+		}
+	}
+}
+
+// runDBRefresh reloads the database every interval if its file changed,
+// until ctx is cancelled. It is the fallback for volumes that deliver no
+// change notifications to the watcher, such as NFS or some CSI drivers.
+func runDBRefresh(ctx context.Context, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var failedModTime time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := maxMindDB().reloadIfChanged(path, &failedModTime); err != nil {
+				log.Printf("Database refresh: keeping the loaded database: %v", err)
+			}
+		}
+	}
+}
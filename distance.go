@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+)
+
+// earthRadiusKm is the mean radius of the Earth.
+const earthRadiusKm = 6371.0088
+
+// kmPerMile converts kilometres to international miles.
+const kmPerMile = 1.609344
+
+// haversineKm returns the great-circle distance in kilometres between two
+// points given in degrees.
+func haversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// lookupCoordinates returns the coordinates /lookup/ returns for ip. When
+// there are none, or the lookup fails, it writes the error response and
+// returns false.
+func lookupCoordinates(w http.ResponseWriter, r *http.Request, ip net.IP) (lat, lon float64, ok bool) {
+	response, err := lookupIP(r.Context(), ip)
+	if errors.Is(err, errNoRecord) || err == nil && !hasCoordinates(response) {
+		writeJSONError(w, fmt.Sprintf("GeoIP coordinates not found for IP: %s", ip.String()), http.StatusNotFound, errCodeNotFound)
+		return 0, 0, false
+	}
+	if err != nil {
+		log.Printf("GeoIP lookup for IP %s failed: %v", ip.String(), err)
+		writeJSONError(w, fmt.Sprintf("GeoIP lookup failed for IP: %s", ip.String()), http.StatusInternalServerError, errCodeDBError)
+		return 0, 0, false
+	}
+	lat, _ = response["latitude"].(float64)
+	lon, _ = response["longitude"].(float64)
+	return lat, lon, true
+}
+
+// distanceHandler serves GET /distance/{ip1}/{ip2}: the great-circle
+// distance between the locations of two IPs, with the coordinates of each,
+// for checks such as impossible travel between two logins.
+func distanceHandler(w http.ResponseWriter, r *http.Request) {
+	if !databaseLoaded() {
+		writeJSONError(w, "GeoIP service not available", http.StatusInternalServerError, errCodeDBUnavailable)
+		return
+	}
+	var ips [2]net.IP
+	for i, name := range []string{"ip1", "ip2"} {
+		ipStr := r.PathValue(name)
+		if ips[i] = net.ParseIP(ipStr); ips[i] == nil {
+			writeJSONError(w, fmt.Sprintf("Invalid IP address format: %s", ipStr), http.StatusBadRequest, errCodeInvalidIP)
+			return
+		}
+	}
+
+	var points [2]map[string]any
+	for i, ip := range ips {
+		lat, lon, ok := lookupCoordinates(w, r, ip)
+		if !ok {
+			return
+		}
+		points[i] = map[string]any{"ip": ip.String(), "latitude": lat, "longitude": lon}
+	}
+
+	lat1, lon1 := points[0]["latitude"].(float64), points[0]["longitude"].(float64)
+	lat2, lon2 := points[1]["latitude"].(float64), points[1]["longitude"].(float64)
+	km := haversineKm(lat1, lon1, lat2, lon2)
+	response := map[string]any{
+		"from":           points[0],
+		"to":             points[1],
+		"distance_km":    math.Round(km*100) / 100,
+		"distance_miles": math.Round(km/kmPerMile*100) / 100,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error encoding JSON response for IPs %s and %s: %v", ips[0].String(), ips[1].String(), err)
+	}
+}
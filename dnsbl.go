@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dnsblCacheSize bounds the number of cached DNSBL answers.
+const dnsblCacheSize = 10000
+
+// dnsblChecker queries DNS blocklists for IPs, all lists in parallel and each
+// with a strict timeout, caching the answers briefly.
+type dnsblChecker struct {
+	resolver *net.Resolver
+	zones    []string
+	timeout  time.Duration
+	// alwaysOn makes lookups without a dnsbl parameter include the listings.
+	alwaysOn bool
+	// cache holds the zones listing each IP, in zones order.
+	cache *ttlCache[[]string]
+}
+
+// dnsbl is the process-wide DNSBL checker; nil when DNSBL_ZONES is not set.
+var dnsbl *dnsblChecker
+
+func newDNSBLChecker(zones []string, timeout, cacheTTL time.Duration, alwaysOn bool) *dnsblChecker {
+	return &dnsblChecker{
+		resolver: net.DefaultResolver,
+		zones:    zones,
+		timeout:  timeout,
+		alwaysOn: alwaysOn,
+		cache:    newTTLCache[[]string](dnsblCacheSize, cacheTTL),
+	}
+}
+
+// listings returns the zones listing ip. Zones that do not answer within the
+// timeout are left out, and the answer is then not cached so the next lookup
+// asks them again.
+func (c *dnsblChecker) listings(ctx context.Context, ip net.IP) []string {
+	key := ip.String()
+	if zones, ok := c.cache.get(key); ok {
+		return zones
+	}
+	addr, _ := netip.AddrFromSlice(ip)
+	name := dnsblName(addr.Unmap())
+	listed := make([]bool, len(c.zones))
+	failed := make([]bool, len(c.zones))
+	var wg sync.WaitGroup
+	for i, zone := range c.zones {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var err error
+			listed[i], err = c.query(ctx, name+"."+zone)
+			if err != nil {
+				log.Printf("DNSBL %s query for IP %s failed: %v", zone, key, err)
+				failed[i] = true
+			}
+		}()
+	}
+	wg.Wait()
+	zones := []string{}
+	complete := true
+	for i, zone := range c.zones {
+		if listed[i] {
+			zones = append(zones, zone)
+		}
+		complete = complete && !failed[i]
+	}
+	if complete {
+		c.cache.set(key, zones)
+	}
+	return zones
+}
+
+// query reports whether name, an IP in a DNSBL zone, has a listing address.
+func (c *dnsblChecker) query(ctx context.Context, name string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	addrs, err := c.resolver.LookupHost(ctx, name)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, a := range addrs {
+		addr, err := netip.ParseAddr(a)
+		if err != nil || !addr.Is4() {
+			continue
+		}
+		octets := addr.As4()
+		if octets[0] != 127 {
+			continue
+		}
+		if octets[1] == 255 && octets[2] == 255 {
+			// 127.255.255.x is an error, e.g. Spamhaus refusing queries from
+			// public resolvers.
+			return false, fmt.Errorf("the list answered %s", a)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// dnsblName returns addr as DNSBLs expect it before the zone: the octets of
+// an IPv4 address or the nibbles of an IPv6 address, in reverse order.
+func dnsblName(addr netip.Addr) string {
+	var labels []string
+	if addr.Is4() {
+		for _, b := range addr.As4() {
+			labels = append(labels, strconv.Itoa(int(b)))
+		}
+	} else {
+		for _, b := range addr.As16() {
+			labels = append(labels, strconv.FormatUint(uint64(b>>4), 16), strconv.FormatUint(uint64(b&0xf), 16))
+		}
+	}
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return strings.Join(labels, ".")
+}
+
+// wanted reports whether the request asks for the DNSBL listings: ?dnsbl=1
+// does, ?dnsbl=0 does not, and without the parameter DNSBL_DEFAULT decides.
+func (c *dnsblChecker) wanted(r *http.Request) (bool, error) {
+	value := strings.TrimSpace(r.URL.Query().Get("dnsbl"))
+	if value == "" {
+		return c.alwaysOn, nil
+	}
+	wanted, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("Invalid dnsbl parameter '%s': must be a boolean such as 1 or 0", value)
+	}
+	return wanted, nil
+}
+
+// wantsDNSBL reports whether the lookup response for r should carry the
+// DNSBL listings. Invalid dnsbl values are rejected before this is consulted.
+func wantsDNSBL(r *http.Request) bool {
+	if dnsbl == nil {
+		return false
+	}
+	wanted, err := dnsbl.wanted(r)
+	return err == nil && wanted
+}
+
+// addDNSBLListings sets the dnsbl_listings field of response to the zones
+// listing ip, if the request asks for it.
+func addDNSBLListings(r *http.Request, ip net.IP, response map[string]any) {
+	if !wantsDNSBL(r) {
+		return
+	}
+	response["dnsbl_listings"] = dnsbl.listings(r.Context(), ip)
+}
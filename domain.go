@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net"
+
+	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// domainDB is the optional GeoIP2 Domain database (GEOIP_DOMAIN_DB_PATH).
+var domainDB = &supplementaryDB{
+	name:      "Domain",
+	types:     map[string]bool{"GeoIP2-Domain": true},
+	want:      "a GeoIP2-Domain database",
+	addFields: addDomainFields,
+}
+
+// addDomainFields sets domain, the second-level domain associated with ip.
+func addDomainFields(reader *maxminddb.Reader, ip net.IP, response map[string]any) error {
+	var record geoip2.Domain
+	_, found, err := reader.LookupNetwork(ip, &record)
+	if err != nil || !found || record.Domain == "" {
+		return err
+	}
+	response["domain"] = record.Domain
+	return nil
+}
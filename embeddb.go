@@ -0,0 +1,11 @@
+//go:build embeddb
+
+package main
+
+import _ "embed"
+
+// embeddedGeoDB is the database served when no external one is found. Build
+// with `make embed` to copy it into embedded/ first.
+//
+//go:embed embedded/GeoIP.mmdb
+var embeddedGeoDB []byte
@@ -0,0 +1,7 @@
+//go:build !embeddb
+
+package main
+
+// embeddedGeoDB is empty without the embeddb build tag: an external database
+// is required.
+var embeddedGeoDB []byte
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// enterpriseDatabaseTypes are the accepted database types with Enterprise
+// records: City data plus confidence scores, user types and ISP data.
+var enterpriseDatabaseTypes = map[string]bool{
+	"GeoIP2-Enterprise": true,
+}
+
+// isEnterpriseDatabase reports whether reader is an Enterprise database.
+func isEnterpriseDatabase(reader *maxminddb.Reader) bool {
+	return readerIsType(enterpriseDatabaseTypes, reader)
+}
+
+// enterpriseRecord holds the Enterprise fields that geoip2.City leaves out.
+// Decoding only these keeps the second read of the record cheap.
+type enterpriseRecord struct {
+	City struct {
+		Confidence uint8 `maxminddb:"confidence"`
+	} `maxminddb:"city"`
+	Country struct {
+		Confidence uint8 `maxminddb:"confidence"`
+	} `maxminddb:"country"`
+	Postal struct {
+		Confidence uint8 `maxminddb:"confidence"`
+	} `maxminddb:"postal"`
+	Subdivisions []struct {
+		Confidence uint8 `maxminddb:"confidence"`
+	} `maxminddb:"subdivisions"`
+	Traits struct {
+		AutonomousSystemOrganization string  `maxminddb:"autonomous_system_organization"`
+		ConnectionType               string  `maxminddb:"connection_type"`
+		Domain                       string  `maxminddb:"domain"`
+		ISP                          string  `maxminddb:"isp"`
+		MobileCountryCode            string  `maxminddb:"mobile_country_code"`
+		MobileNetworkCode            string  `maxminddb:"mobile_network_code"`
+		Organization                 string  `maxminddb:"organization"`
+		UserType                     string  `maxminddb:"user_type"`
+		AutonomousSystemNumber       uint    `maxminddb:"autonomous_system_number"`
+		StaticIPScore                float64 `maxminddb:"static_ip_score"`
+		IsLegitimateProxy            bool    `maxminddb:"is_legitimate_proxy"`
+	} `maxminddb:"traits"`
+}
+
+// addEnterpriseFields sets the confidence scores (0-100), user type and ISP
+// fields of ip that the Enterprise database has. A failed read only costs
+// these fields, as the City fields were already read.
+func addEnterpriseFields(reader *maxminddb.Reader, ip net.IP, response map[string]any) error {
+	var record enterpriseRecord
+	_, found, err := reader.LookupNetwork(ip, &record)
+	if err != nil || !found {
+		return err
+	}
+	for field, confidence := range map[string]uint8{
+		"country_confidence": record.Country.Confidence,
+		"city_confidence":    record.City.Confidence,
+		"postal_confidence":  record.Postal.Confidence,
+	} {
+		if confidence > 0 {
+			response[field] = confidence
+		}
+	}
+	if len(record.Subdivisions) > 0 && record.Subdivisions[0].Confidence > 0 {
+		response["subdivision_confidence"] = record.Subdivisions[0].Confidence
+	}
+
+	t := record.Traits
+	for field, value := range map[string]string{
+		"user_type":                      t.UserType,
+		"isp":                            t.ISP,
+		"organization":                   t.Organization,
+		"autonomous_system_organization": t.AutonomousSystemOrganization,
+		"connection_type":                t.ConnectionType,
+		"domain":                         t.Domain,
+		"mobile_country_code":            t.MobileCountryCode,
+		"mobile_network_code":            t.MobileNetworkCode,
+	} {
+		if value != "" {
+			response[field] = value
+		}
+	}
+	if t.AutonomousSystemNumber != 0 {
+		response["autonomous_system_number"] = t.AutonomousSystemNumber
+	}
+	if t.StaticIPScore > 0 {
+		response["static_ip_score"] = t.StaticIPScore
+	}
+	response["is_legitimate_proxy"] = t.IsLegitimateProxy
+	return nil
+}
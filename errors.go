@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// errorDocsURL documents every machine-readable error code.
+const errorDocsURL = "https://github.com/ali-issa/ip-lookup#error-codes"
+
+// errorTypeBaseURL prefixes the problem type URI of each error code, which
+// links to the code in the table at errorDocsURL.
+const errorTypeBaseURL = "https://github.com/ali-issa/ip-lookup#error-"
+
+// Stable machine-readable error codes. Clients should branch on these rather
+// than on the human-readable message, which may change.
+const (
+	errCodeInvalidIP           = "INVALID_IP"
+	errCodeIPUndetermined      = "IP_UNDETERMINED"
+	errCodeNotFound            = "NOT_FOUND"
+	errCodeDBUnavailable       = "DB_UNAVAILABLE"
+	errCodeDBError             = "DB_ERROR"
+	errCodeInvalidRequest      = "INVALID_REQUEST"
+	errCodeRouteNotFound       = "ROUTE_NOT_FOUND"
+	errCodeMethodNotAllowed    = "METHOD_NOT_ALLOWED"
+	errCodeURITooLong          = "URI_TOO_LONG"
+	errCodeRateLimited         = "RATE_LIMITED"
+	errCodeOverloaded          = "OVERLOADED"
+	errCodeUpstreamUnavailable = "UPSTREAM_UNAVAILABLE"
+	errCodeUnknownPolicy       = "UNKNOWN_POLICY"
+	errCodePolicyError         = "POLICY_ERROR"
+	errCodeAccessDenied        = "ACCESS_DENIED"
+	errCodeUnauthorized        = "UNAUTHORIZED"
+	errCodePayloadTooLarge     = "PAYLOAD_TOO_LARGE"
+	errCodeInvalidDatabase     = "INVALID_DATABASE"
+	errCodeInternal            = "INTERNAL_ERROR"
+	errCodeJobNotReady         = "JOB_NOT_READY"
+	errCodeJobFailed           = "JOB_FAILED"
+)
+
+// errorTitles are the problem titles of the error codes: a summary that is
+// the same for every occurrence, unlike the message.
+var errorTitles = map[string]string{
+	errCodeInvalidIP:           "Invalid IP address",
+	errCodeIPUndetermined:      "Client IP address undetermined",
+	errCodeNotFound:            "Not found",
+	errCodeDBUnavailable:       "Database unavailable",
+	errCodeDBError:             "Database read failed",
+	errCodeInvalidRequest:      "Invalid request",
+	errCodeRouteNotFound:       "Route not found",
+	errCodeMethodNotAllowed:    "Method not allowed",
+	errCodeURITooLong:          "Request URI too long",
+	errCodeRateLimited:         "Rate limit exceeded",
+	errCodeOverloaded:          "Server overloaded",
+	errCodeUpstreamUnavailable: "Upstream unavailable",
+	errCodeUnknownPolicy:       "Unknown policy",
+	errCodePolicyError:         "Policy evaluation failed",
+	errCodeAccessDenied:        "Access denied",
+	errCodeUnauthorized:        "Unauthorized",
+	errCodePayloadTooLarge:     "Payload too large",
+	errCodeInvalidDatabase:     "Invalid database",
+	errCodeInternal:            "Internal error",
+	errCodeJobNotReady:         "Job not ready",
+	errCodeJobFailed:           "Job failed",
+}
+
+// problemType returns the problem type URI of errorCode, such as
+// https://github.com/ali-issa/ip-lookup#error-invalid-ip for INVALID_IP.
+func problemType(errorCode string) string {
+	return errorTypeBaseURL + strings.ReplaceAll(strings.ToLower(errorCode), "_", "-")
+}
+
+// AppError is an error response, written as an RFC 7807 problem document
+// (application/problem+json). Handlers set Message, Code, ErrorCode and
+// Details, and writeAppError fills in the rest. The message, code and
+// docs_url members repeat detail, status and the documentation link under
+// the names error bodies had before they were problem documents.
+type AppError struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail"`
+	Instance  string `json:"instance,omitempty"`
+	ErrorCode string `json:"error_code"`
+	Details   any    `json:"details,omitempty"`
+	Message   string `json:"message"`
+	Code      int    `json:"code"`
+	DocsURL   string `json:"docs_url"`
+	TraceID   string `json:"trace_id,omitempty"`
+	SpanID    string `json:"span_id,omitempty"`
+}
+
+func writeJSONError(w http.ResponseWriter, message string, code int, errorCode string) {
+	writeAppError(w, AppError{Message: message, Code: code, ErrorCode: errorCode})
+}
+
+// writeAppError writes appErr as a problem document with its HTTP status,
+// filling in the problem members, the docs URL and the request's path and
+// trace IDs.
+func writeAppError(w http.ResponseWriter, appErr AppError) {
+	appErr.Type = problemType(appErr.ErrorCode)
+	if appErr.Title = errorTitles[appErr.ErrorCode]; appErr.Title == "" {
+		appErr.Title = http.StatusText(appErr.Code)
+	}
+	appErr.Status, appErr.Detail = appErr.Code, appErr.Message
+	if appErr.DocsURL == "" {
+		appErr.DocsURL = errorDocsURL
+	}
+	if tw, ok := tracedWriterFrom(w); ok {
+		appErr.Instance = tw.path
+		appErr.TraceID, appErr.SpanID = tw.trace.TraceID, tw.trace.SpanID
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(appErr.Code)
+	json.NewEncoder(w).Encode(appErr)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"log"
+	"net"
+	"path/filepath"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// fallbackDBs are the City-compatible databases of GEOIP_FALLBACK_DB_PATHS,
+// consulted in order after GEOIP_DB_PATH. They fill the fields the databases
+// before them have no value for, e.g. a GeoLite2-City behind a commercial
+// database with partial coverage.
+var fallbackDBs []*supplementaryDB
+
+func newFallbackDB(path string) *supplementaryDB {
+	return &supplementaryDB{
+		name:  "Fallback " + filepath.Base(path),
+		types: cityDatabaseTypes,
+		want:  "a City, Country or Enterprise database",
+		path:  path,
+	}
+}
+
+// mergeFallbackFields fills the empty fields of response (nil when the
+// primary database has no record for ip) from the fallback databases. A
+// failed read is logged and skips that database.
+func mergeFallbackFields(ip net.IP, response map[string]any) map[string]any {
+	for _, d := range fallbackDBs {
+		if response != nil && complete(response) {
+			break
+		}
+		reader := d.reader.Load()
+		if reader == nil {
+			continue
+		}
+		var record geoip2.City
+		start := time.Now()
+		_, found, err := lookupCityRecord(reader, ip, &record)
+		stageDecode.since(start)
+		if err != nil {
+			log.Printf("%s lookup for IP %s failed: %v", d.name, ip.String(), err)
+			continue
+		}
+		if !found {
+			continue
+		}
+		fields := cityResponse(ip, &record, reader)
+		if response == nil {
+			response = fields
+			continue
+		}
+		mergeEmptyFields(response, fields)
+	}
+	return response
+}
+
+// complete reports whether every field of response has a value.
+func complete(response map[string]any) bool {
+	if _, ok := response["city"]; !ok {
+		// Built from a Country database.
+		return false
+	}
+	for field, value := range response {
+		if emptyField(field, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeEmptyFields sets the fields of dst that are empty to their values in
+// src. The coordinates are taken as a pair, where dst has none.
+func mergeEmptyFields(dst, src map[string]any) {
+	for field, value := range src {
+		if field == "latitude" || field == "longitude" {
+			continue
+		}
+		if emptyField(field, dst[field]) && !emptyField(field, value) {
+			dst[field] = value
+		}
+	}
+	if !hasCoordinates(dst) && hasCoordinates(src) {
+		dst["latitude"], dst["longitude"] = src["latitude"], src["longitude"]
+	}
+}
+
+func emptyField(field string, value any) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case float64:
+		// A record without a location reads as 0, 0.
+		return (field == "latitude" || field == "longitude") && v == 0
+	}
+	return false
+}
+
+func hasCoordinates(response map[string]any) bool {
+	return !emptyField("latitude", response["latitude"]) || !emptyField("longitude", response["longitude"])
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// parseFields parses the fields parameter of a lookup: a comma-separated
+// list of the response fields to return. It returns nil when r does not ask
+// for a subset.
+func parseFields(r *http.Request) ([]string, error) {
+	value := r.URL.Query().Get("fields")
+	if value == "" {
+		return nil, nil
+	}
+	var fields []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			fields = append(fields, name)
+		}
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("Invalid fields parameter '%s': must be a comma-separated list of field names", value)
+	}
+	return fields, nil
+}
+
+// selectFields returns the fields of response r asks for, or response itself
+// when it does not ask for a subset. Requested fields the response lacks are
+// left out. Invalid fields values are rejected before this is consulted.
+func selectFields(r *http.Request, response map[string]any) map[string]any {
+	fields, err := parseFields(r)
+	if err != nil || fields == nil {
+		return response
+	}
+	selected := make(map[string]any, len(fields))
+	for _, name := range fields {
+		if value, ok := response[name]; ok {
+			selected[name] = value
+		}
+	}
+	return selected
+}
@@ -0,0 +1,35 @@
+package main
+
+import "strings"
+
+// flagEmoji returns the flag emoji of an ISO country code, the pair of
+// regional indicator symbols of its letters, or "" when code is not two
+// letters.
+func flagEmoji(code string) string {
+	if len(code) != 2 {
+		return ""
+	}
+	var flag []rune
+	for _, c := range strings.ToUpper(code) {
+		if c < 'A' || c > 'Z' {
+			return ""
+		}
+		flag = append(flag, 0x1F1E6+c-'A')
+	}
+	return string(flag)
+}
+
+// addFlagFields sets the flag_emoji field of response, and flag_url when
+// FLAG_URL_TEMPLATE is set, from its country code. Responses without a
+// country get neither.
+func addFlagFields(response map[string]any) {
+	code, _ := response["country_code"].(string)
+	emoji := flagEmoji(code)
+	if emoji == "" {
+		return
+	}
+	response["flag_emoji"] = emoji
+	if template := appConfig.FlagURLTemplate; template != "" {
+		response["flag_url"] = strings.NewReplacer("{code}", strings.ToLower(code), "{CODE}", strings.ToUpper(code)).Replace(template)
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// responseFormat is an encoding of lookup responses, selected with ?format=
+// or the Accept header.
+type responseFormat struct {
+	name string
+	// mediaTypes are the Accept media types selecting the format.
+	mediaTypes  []string
+	contentType string
+	encode      func(response map[string]any) ([]byte, error)
+}
+
+var jsonFormat = &responseFormat{
+	name:        "json",
+	mediaTypes:  []string{"application/json"},
+	contentType: "application/json",
+	encode:      encodeJSONResponse,
+}
+
+// responseFormats are the lookup response formats, in the order Accept
+// header ties are broken in.
+var responseFormats = []*responseFormat{
+	jsonFormat,
+	{name: "xml", mediaTypes: []string{"application/xml", "text/xml"}, contentType: "application/xml; charset=utf-8", encode: encodeXMLResponse},
+	{name: "yaml", mediaTypes: []string{"application/yaml", "application/x-yaml", "text/yaml"}, contentType: "application/yaml; charset=utf-8", encode: encodeYAMLResponse},
+	{name: "csv", mediaTypes: []string{"text/csv"}, contentType: "text/csv; charset=utf-8", encode: encodeCSVResponse},
+	{name: "msgpack", mediaTypes: []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"}, contentType: "application/msgpack", encode: encodeMsgpackResponse},
+	{name: "cbor", mediaTypes: []string{"application/cbor"}, contentType: "application/cbor", encode: encodeCBORResponse},
+	{name: "geojson", mediaTypes: []string{"application/geo+json"}, contentType: "application/geo+json", encode: encodeGeoJSONResponse},
+	{name: "protobuf", mediaTypes: []string{"application/x-protobuf", "application/protobuf", "application/vnd.google.protobuf"}, contentType: "application/x-protobuf", encode: encodeProtobufResponse},
+}
+
+// templateFormats are the formats of RESPONSE_TEMPLATES_FILE, by name, set
+// from the configuration in configureServices. They are selected with ?format= only.
+var templateFormats map[string]*responseFormat
+
+// loadResponseTemplates parses the JSON file of named text/template
+// templates at path into formats rendering lookup responses as text.
+func loadResponseTemplates(path string) (map[string]*responseFormat, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading templates file: %v", err)
+	}
+	var sources map[string]string
+	if err := json.Unmarshal(data, &sources); err != nil {
+		return nil, fmt.Errorf("parsing templates file %s: %v", path, err)
+	}
+	formats := make(map[string]*responseFormat, len(sources))
+	for name, source := range sources {
+		if strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("template names must not be empty")
+		}
+		for _, format := range responseFormats {
+			if strings.EqualFold(format.name, name) {
+				return nil, fmt.Errorf("template %q has the name of a built-in format", name)
+			}
+		}
+		tmpl, err := template.New(name).Parse(source)
+		if err != nil {
+			return nil, err
+		}
+		formats[name] = &responseFormat{
+			name:        name,
+			contentType: "text/plain; charset=utf-8",
+			encode: func(response map[string]any) ([]byte, error) {
+				// Nulls render as empty text rather than "<no value>".
+				data := make(map[string]any, len(response))
+				for k, v := range response {
+					if v == nil {
+						v = ""
+					}
+					data[k] = v
+				}
+				var buf bytes.Buffer
+				err := tmpl.Execute(&buf, data)
+				return buf.Bytes(), err
+			},
+		}
+	}
+	return formats, nil
+}
+
+// negotiateFormat returns the format of the lookup response r asks for: the
+// one named by the format parameter, or else the one its Accept header
+// prefers. JSON is the default, including for browsers, whose Accept headers
+// list text/html and prefer XML over anything else this serves.
+func negotiateFormat(r *http.Request) (*responseFormat, error) {
+	if name := strings.TrimSpace(r.URL.Query().Get("format")); name != "" {
+		if format, ok := templateFormats[name]; ok {
+			return format, nil
+		}
+		names := make([]string, 0, len(responseFormats)+len(templateFormats))
+		for _, format := range responseFormats {
+			if strings.EqualFold(format.name, name) {
+				return format, nil
+			}
+			names = append(names, format.name)
+		}
+		templates := make([]string, 0, len(templateFormats))
+		for template := range templateFormats {
+			templates = append(templates, template)
+		}
+		sort.Strings(templates)
+		names = append(names, templates...)
+		return nil, fmt.Errorf("Invalid format parameter '%s': must be one of %s", name, strings.Join(names, ", "))
+	}
+
+	best, bestQ := jsonFormat, 0.0
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(accepted)
+		if err != nil {
+			continue
+		}
+		if mediaType == "text/html" {
+			return jsonFormat, nil
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		if q <= bestQ {
+			continue
+		}
+		if mediaType == "*/*" || mediaType == "application/*" {
+			best, bestQ = jsonFormat, q
+			continue
+		}
+		for _, format := range responseFormats {
+			for _, t := range format.mediaTypes {
+				if t == mediaType {
+					best, bestQ = format, q
+				}
+			}
+		}
+	}
+	return best, nil
+}
+
+// encodeJSONResponse encodes response as json.Encoder does, ending in a
+// newline.
+func encodeJSONResponse(response map[string]any) ([]byte, error) {
+	var buf bytes.Buffer
+	err := json.NewEncoder(&buf).Encode(response)
+	return buf.Bytes(), err
+}
+
+// encodeGeoJSONResponse encodes response as a GeoJSON Feature with a Point
+// at its coordinates and its other fields as properties, for map libraries.
+// The geometry is null when the response has no coordinates.
+func encodeGeoJSONResponse(response map[string]any) ([]byte, error) {
+	var geometry any
+	lat, hasLat := response["latitude"].(float64)
+	lon, hasLon := response["longitude"].(float64)
+	if hasLat && hasLon {
+		geometry = map[string]any{"type": "Point", "coordinates": []float64{lon, lat}}
+	}
+	properties := make(map[string]any, len(response))
+	for k, v := range response {
+		if k != "latitude" && k != "longitude" {
+			properties[k] = v
+		}
+	}
+	return encodeJSONResponse(map[string]any{"type": "Feature", "geometry": geometry, "properties": properties})
+}
+
+// encodeMsgpackResponse encodes response as a MessagePack map, as the Redis
+// protocol does with RESP_ENCODING=msgpack.
+func encodeMsgpackResponse(response map[string]any) ([]byte, error) {
+	return msgpackMarshal(response)
+}
+
+// encodeCBORResponse encodes response as a CBOR map, as CoAP responses are.
+func encodeCBORResponse(response map[string]any) ([]byte, error) {
+	return cborMarshal(response)
+}
+
+// encodeProtobufResponse encodes response as an iplookup.v1.LookupResponse,
+// which has the database fields of a lookup only.
+func encodeProtobufResponse(response map[string]any) ([]byte, error) {
+	return proto.Marshal(lookupResponseMessage(response))
+}
+
+// plainResponse returns response as it decodes from JSON, so the text
+// formats only deal with JSON's value types. Numbers are json.Number, so
+// they are written as the JSON response writes them.
+func plainResponse(response map[string]any) (map[string]any, error) {
+	encoded, err := json.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var plain map[string]any
+	err = decoder.Decode(&plain)
+	return plain, err
+}
+
+// sortedKeys returns the keys of m in the order JSON responses list them.
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// xmlName matches the field names that can be XML element names as they are.
+var xmlName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// encodeXMLResponse encodes response as a <lookup> element with a child
+// element per field. Arrays have an <item> element per value, and nulls are
+// empty elements. Field names that are not XML names are written as
+// <field name="...">.
+func encodeXMLResponse(response map[string]any) ([]byte, error) {
+	plain, err := plainResponse(response)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	writeXMLElement(&buf, "lookup", plain)
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+func writeXMLElement(buf *bytes.Buffer, name string, value any) {
+	end := name
+	if !xmlName.MatchString(name) {
+		buf.WriteString(`<field name="`)
+		xml.EscapeText(buf, []byte(name))
+		buf.WriteString(`"`)
+		end = "field"
+	} else {
+		buf.WriteString("<" + name)
+	}
+	switch v := value.(type) {
+	case nil:
+		buf.WriteString("/>")
+		return
+	case map[string]any:
+		buf.WriteString(">")
+		for _, k := range sortedKeys(v) {
+			writeXMLElement(buf, k, v[k])
+		}
+	case []any:
+		buf.WriteString(">")
+		for _, item := range v {
+			writeXMLElement(buf, "item", item)
+		}
+	default:
+		buf.WriteString(">")
+		xml.EscapeText(buf, []byte(fmt.Sprint(v)))
+	}
+	buf.WriteString("</" + end + ">")
+}
+
+// yamlKey matches the field names that need no quotes as YAML keys.
+var yamlKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// encodeYAMLResponse encodes response as a YAML mapping. Values are written
+// in JSON syntax, which YAML parses as the same values.
+func encodeYAMLResponse(response map[string]any) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, k := range sortedKeys(response) {
+		value, err := json.Marshal(response[k])
+		if err != nil {
+			return nil, err
+		}
+		if yamlKey.MatchString(k) {
+			buf.WriteString(k)
+		} else {
+			key, _ := json.Marshal(k)
+			buf.Write(key)
+		}
+		buf.WriteString(": ")
+		buf.Write(value)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeCSVResponse encodes response as a CSV of a header row with the
+// field names and a row with their values. Nulls are empty cells, and arrays
+// and objects are written as JSON.
+func encodeCSVResponse(response map[string]any) ([]byte, error) {
+	plain, err := plainResponse(response)
+	if err != nil {
+		return nil, err
+	}
+	keys := sortedKeys(plain)
+	row := make([]string, len(keys))
+	for i, k := range keys {
+		switch v := plain[k].(type) {
+		case nil:
+		case map[string]any, []any:
+			encoded, _ := json.Marshal(v)
+			row[i] = string(encoded)
+		default:
+			row[i] = fmt.Sprint(v)
+		}
+	}
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write(keys)
+	writer.Write(row)
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// gcsReadScope is the OAuth scope requested for downloads.
+const gcsReadScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+// gcsSource downloads the database from a Cloud Storage object (GEOIP_DB_URL
+// gs://bucket/key) through the XML API, with an access token from the
+// application default credentials. Without credentials the object is
+// requested anonymously, for public buckets.
+type gcsSource struct {
+	bucket string
+	key    string
+	// endpoint is http(s)://host of the API; STORAGE_EMULATOR_HOST overrides it.
+	endpoint string
+}
+
+func newGCSSource(bucket, key string) gcsSource {
+	endpoint := "https://storage.googleapis.com"
+	if host := strings.TrimRight(os.Getenv("STORAGE_EMULATOR_HOST"), "/"); host != "" {
+		endpoint = host
+		if !strings.Contains(host, "://") {
+			endpoint = "http://" + host
+		}
+	}
+	return gcsSource{bucket: bucket, key: key, endpoint: endpoint}
+}
+
+func (s gcsSource) fetch(ctx context.Context, client *http.Client, modTime time.Time, dst io.Writer) (time.Time, error) {
+	token, err := gcpAccessToken(ctx, client)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Google Cloud credentials: %v", err)
+	}
+	objectURL := s.endpoint + "/" + s.bucket + "/" + objectKeyEscape(s.key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, objectURL, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if !modTime.IsZero() {
+		req.Header.Set("If-Modified-Since", modTime.UTC().Format(http.TimeFormat))
+	}
+	return fetchObject(client, req, dst)
+}
+
+// gcpCredentialsFile is the subset of an application default credentials
+// file used: a service account key or a gcloud user login.
+type gcpCredentialsFile struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// gcpAccessToken finds an access token the way the Google client libraries
+// do: GOOGLE_APPLICATION_CREDENTIALS, gcloud's application default
+// credentials, then the metadata server (GCE, GKE, Cloud Run). It returns ""
+// when none is available.
+func gcpAccessToken(ctx context.Context, client *http.Client) (string, error) {
+	path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if path == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			adc := filepath.Join(home, ".config", "gcloud", "application_default_credentials.json")
+			if _, err := os.Stat(adc); err == nil {
+				path = adc
+			}
+		}
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		var file gcpCredentialsFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return "", fmt.Errorf("%s: %v", path, err)
+		}
+		if file.TokenURI == "" {
+			file.TokenURI = "https://oauth2.googleapis.com/token"
+		}
+		switch file.Type {
+		case "service_account":
+			assertion, err := gcpServiceAccountJWT(file, time.Now())
+			if err != nil {
+				return "", fmt.Errorf("%s: %v", path, err)
+			}
+			return gcpToken(ctx, client, file.TokenURI, url.Values{
+				"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+				"assertion":  {assertion},
+			})
+		case "authorized_user":
+			return gcpToken(ctx, client, file.TokenURI, url.Values{
+				"grant_type":    {"refresh_token"},
+				"client_id":     {file.ClientID},
+				"client_secret": {file.ClientSecret},
+				"refresh_token": {file.RefreshToken},
+			})
+		default:
+			return "", fmt.Errorf("%s: unsupported credentials type %q", path, file.Type)
+		}
+	}
+
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = "metadata.google.internal"
+	}
+	metadataCtx, cancel := context.WithTimeout(ctx, cloudMetadataTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(metadataCtx, http.MethodGet,
+		"http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	var doc struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := getJSON(client, req, &doc); err != nil {
+		// Not on Google Cloud: fall back to anonymous requests.
+		return "", nil
+	}
+	return doc.AccessToken, nil
+}
+
+// gcpToken exchanges a grant for an access token at tokenURI.
+func gcpToken(ctx context.Context, client *http.Client, tokenURI string, form url.Values) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var doc struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := getJSON(client, req, &doc); err != nil {
+		return "", fmt.Errorf("token request: %v", err)
+	}
+	if doc.AccessToken == "" {
+		return "", errors.New("token request: no access token in the response")
+	}
+	return doc.AccessToken, nil
+}
+
+// gcpServiceAccountJWT returns the signed RS256 assertion a service account
+// exchanges for an access token.
+func gcpServiceAccountJWT(file gcpCredentialsFile, now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(file.PrivateKey))
+	if block == nil {
+		return "", errors.New("private_key is not PEM")
+	}
+	var key *rsa.PrivateKey
+	if parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		var ok bool
+		if key, ok = parsed.(*rsa.PrivateKey); !ok {
+			return "", errors.New("private_key is not an RSA key")
+		}
+	} else if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+		return "", fmt.Errorf("private_key: %v", err)
+	}
+
+	encode := func(v any) string {
+		data, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	unsigned := encode(map[string]string{"alg": "RS256", "typ": "JWT"}) + "." + encode(map[string]any{
+		"iss":   file.ClientEmail,
+		"scope": gcsReadScope,
+		"aud":   file.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
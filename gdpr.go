@@ -0,0 +1,31 @@
+package main
+
+// euMemberStates are the ISO codes of the EU member states, for responses
+// from sources that do not carry IsInEuropeanUnion.
+var euMemberStates = map[string]bool{
+	"AT": true, "BE": true, "BG": true, "CY": true, "CZ": true, "DE": true, "DK": true,
+	"EE": true, "ES": true, "FI": true, "FR": true, "GR": true, "HR": true, "HU": true,
+	"IE": true, "IT": true, "LT": true, "LU": true, "LV": true, "MT": true, "NL": true,
+	"PL": true, "PT": true, "RO": true, "SE": true, "SI": true, "SK": true,
+}
+
+// eeaStates are the EEA members outside the EU, where the GDPR applies too.
+var eeaStates = map[string]bool{"IS": true, "LI": true, "NO": true}
+
+// addGDPRFields sets the gdpr_applicable field of response from its country:
+// true in the EU and EEA, and in the United Kingdom, whose UK GDPR mirrors
+// it, unless GDPR_EXCLUDE_UK is set. Responses from sources without the
+// database's is_in_european_union get it from the country too. Responses
+// without a country get neither.
+func addGDPRFields(response map[string]any) {
+	code, _ := response["country_code"].(string)
+	if code == "" {
+		return
+	}
+	eu, ok := response["is_in_european_union"].(bool)
+	if !ok {
+		eu = euMemberStates[code]
+		response["is_in_european_union"] = eu
+	}
+	response["gdpr_applicable"] = eu || eeaStates[code] || code == "GB" && !appConfig.GDPRExcludeUK
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// dbReadAttempts is how many times a failing database read is tried before
+// the lookup is reported as an error.
+const dbReadAttempts = 3
+
+// dbReadRetryBackoff is the delay before the first retry; it doubles per attempt.
+const dbReadRetryBackoff = 5 * time.Millisecond
+
+// dbUnhealthyThreshold is the number of consecutive failed lookups after
+// which the database is reported unhealthy by /healthz.
+const dbUnhealthyThreshold = 5
+
+// dbProbeIP is looked up by /healthz to detect recovery while unhealthy.
+var dbProbeIP = net.IPv4(8, 8, 8, 8)
+
+// errDBRead is returned (wrapped) when the database could not be read even
+// after retrying; it is distinct from an IP having no record.
+var errDBRead = errors.New("GeoIP database read failed")
+
+// cityDatabaseTypes are the database types that contain City-compatible
+// records, as accepted by geoip2.Reader.City.
+var cityDatabaseTypes = map[string]bool{
+	"GeoLite2-City":             true,
+	"GeoIP2-City":               true,
+	"GeoIP2-City-Africa":        true,
+	"GeoIP2-City-Asia-Pacific":  true,
+	"GeoIP2-City-Europe":        true,
+	"GeoIP2-City-North-America": true,
+	"GeoIP2-City-South-America": true,
+	"GeoIP2-Precision-City":     true,
+	"GeoLite2-Country":          true,
+	"GeoIP2-Country":            true,
+	"GeoIP2-Enterprise":         true,
+}
+
+// countryDatabaseTypes are the accepted database types that have no
+// City-level data. Lookups against them answer with the country and
+// continent fields only, rather than empty cities and 0,0 coordinates.
+var countryDatabaseTypes = map[string]bool{
+	"GeoLite2-Country": true,
+	"GeoIP2-Country":   true,
+}
+
+// compatibleDatabaseType returns the MaxMind database type a DB-IP or
+// ipinfo.io database stands in for.
+func compatibleDatabaseType(dbType string) (string, bool) {
+	if compat, ok := dbipCompatibleType(dbType); ok {
+		return compat, true
+	}
+	return ipinfoCompatibleType(dbType)
+}
+
+// isDatabaseType reports whether a database of type dbType has the records
+// of one of types, directly or as a compatible third-party database.
+func isDatabaseType(types map[string]bool, dbType string) bool {
+	if types[dbType] {
+		return true
+	}
+	compat, ok := compatibleDatabaseType(dbType)
+	return ok && types[compat]
+}
+
+// readerIsType is isDatabaseType for an open database.
+func readerIsType(types map[string]bool, reader *maxminddb.Reader) bool {
+	return isDatabaseType(types, reader.Metadata.DatabaseType)
+}
+
+// isCountryDatabase reports whether reader is a Country-only database.
+func isCountryDatabase(reader *maxminddb.Reader) bool {
+	return readerIsType(countryDatabaseTypes, reader)
+}
+
+// dbCloseGrace is how long a replaced database stays open so lookups that
+// started on it can finish.
+const dbCloseGrace = 30 * time.Second
+
+// maxMindProvider serves lookups from a MaxMind-format database: MaxMind's
+// own, DB-IP's or ipinfo.io's. The database is swapped atomically when a new
+// version is loaded, so callers should load it once per lookup.
+type maxMindProvider struct {
+	// path is the file SIGHUP reloads.
+	path   string
+	reader atomic.Pointer[maxminddb.Reader]
+	// modTime is the modification time of the file reader was loaded from,
+	// used to notice when another process replaces it.
+	modTime atomic.Pointer[time.Time]
+}
+
+// current returns the database serving lookups; nil until one is loaded.
+func (p *maxMindProvider) current() *maxminddb.Reader {
+	return p.reader.Load()
+}
+
+// lookup answers ip from the database, or from the canary when it serves ip.
+func (p *maxMindProvider) lookup(ctx context.Context, ip net.IP) (map[string]any, error) {
+	record, db, network, found, err := p.lookupCityFrom(ip)
+	if err != nil || !found {
+		return nil, err
+	}
+	response := cityResponse(ip, record, db)
+	response["network"] = network.String()
+	return response, nil
+}
+
+func (p *maxMindProvider) loaded() bool {
+	return p.current() != nil
+}
+
+func (p *maxMindProvider) databaseType() string {
+	return p.current().Metadata.DatabaseType
+}
+
+func (p *maxMindProvider) buildTime() time.Time {
+	return time.Unix(int64(p.current().Metadata.BuildEpoch), 0)
+}
+
+func (p *maxMindProvider) metadata() map[string]any {
+	return dbMetadata(p.current())
+}
+
+func (p *maxMindProvider) reload() error {
+	return p.install(p.path)
+}
+
+func (p *maxMindProvider) Close() error {
+	if reader := p.current(); reader != nil {
+		return reader.Close()
+	}
+	return nil
+}
+
+// swap makes reader the database serving lookups; the database it replaces
+// is closed after dbCloseGrace.
+func (p *maxMindProvider) swap(reader *maxminddb.Reader) {
+	if old := p.reader.Swap(reader); old != nil {
+		closeGeoDBLater(old)
+	}
+}
+
+// openGeoDB opens the MaxMind database at path and checks that it supports
+// City lookups.
+func openGeoDB(path string) (*maxminddb.Reader, error) {
+	reader, err := openMMDB(path)
+	if err != nil {
+		return nil, err
+	}
+	return checkGeoDBType(reader)
+}
+
+// checkGeoDBType returns reader if it supports City lookups, and closes it
+// otherwise.
+func checkGeoDBType(reader *maxminddb.Reader) (*maxminddb.Reader, error) {
+	if dbType := reader.Metadata.DatabaseType; !isDatabaseType(cityDatabaseTypes, dbType) {
+		reader.Close()
+		return nil, fmt.Errorf("unsupported database type %q: a City, Country or Enterprise database is required", dbType)
+	}
+	return reader, nil
+}
+
+// loadEmbedded serves lookups from the database compiled into the binary
+// with the embeddb build tag. It has no file, so modTime stays unset and the
+// first database written to GEOIP_DB_PATH replaces it.
+func (p *maxMindProvider) loadEmbedded() error {
+	if len(embeddedGeoDB) == 0 {
+		return errors.New("no database is embedded in this binary")
+	}
+	reader, err := maxminddb.FromBytes(embeddedGeoDB)
+	if err != nil {
+		return fmt.Errorf("embedded database: %v", err)
+	}
+	if reader, err = checkGeoDBType(reader); err != nil {
+		return fmt.Errorf("embedded database: %v", err)
+	}
+	p.swap(reader)
+	return nil
+}
+
+// load opens the database at path and makes it the one serving lookups.
+// The database it replaces is closed after dbCloseGrace.
+func (p *maxMindProvider) load(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	reader, err := openGeoDB(path)
+	if err != nil {
+		return err
+	}
+	modTime := info.ModTime()
+	p.modTime.Store(&modTime)
+	p.swap(reader)
+	return nil
+}
+
+// reloadIfChanged installs the database at path when its modification time
+// differs from the loaded one's. A version that fails to load is recorded
+// in failedModTime and not retried until the file changes again.
+func (p *maxMindProvider) reloadIfChanged(path string, failedModTime *time.Time) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	modTime := info.ModTime()
+	if loaded := p.modTime.Load(); loaded != nil && modTime.Equal(*loaded) || modTime.Equal(*failedModTime) {
+		return nil
+	}
+	log.Printf("GeoIP database file %s changed, loading it.", path)
+	if err := p.install(path); err != nil {
+		*failedModTime = modTime
+		return fmt.Errorf("loading %s failed: %v", path, err)
+	}
+	return nil
+}
+
+// closeGeoDBLater closes a replaced database once lookups that started on it
+// have had dbCloseGrace to finish.
+func closeGeoDBLater(reader *maxminddb.Reader) {
+	time.AfterFunc(dbCloseGrace, func() {
+		if err := reader.Close(); err != nil {
+			log.Printf("Error closing replaced GeoIP database: %v", err)
+		}
+	})
+}
+
+// dbHealthTracker flips the database to unhealthy after repeated read
+// failures and back on the next successful read.
+type dbHealthTracker struct {
+	mu                  sync.Mutex
+	consecutiveFailures int
+	unhealthySince      time.Time
+	lastError           string
+}
+
+// dbHealth tracks the health of the MaxMind database.
+var dbHealth dbHealthTracker
+
+func (h *dbHealthTracker) recordSuccess() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.unhealthySince.IsZero() {
+		log.Printf("GeoIP database reads recovered after %s.", time.Since(h.unhealthySince).Round(time.Second))
+	}
+	h.consecutiveFailures = 0
+	h.unhealthySince = time.Time{}
+}
+
+func (h *dbHealthTracker) recordFailure(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.consecutiveFailures++
+	h.lastError = err.Error()
+	if h.consecutiveFailures >= dbUnhealthyThreshold && h.unhealthySince.IsZero() {
+		h.unhealthySince = time.Now()
+		log.Printf("GeoIP database marked unhealthy after %d consecutive read failures: %v", h.consecutiveFailures, err)
+	}
+}
+
+// healthy reports whether the database is healthy and, if not, since when
+// and the last read error.
+func (h *dbHealthTracker) healthy() (bool, time.Time, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.unhealthySince.IsZero(), h.unhealthySince, h.lastError
+}
+
+// lookupCity reads the City record for ip, retrying transient read errors.
+// found is false when the database has no record for ip. Persistent failures
+// wrap errDBRead and count towards marking the database unhealthy.
+func (p *maxMindProvider) lookupCity(ip net.IP) (record *geoip2.City, found bool, err error) {
+	record, _, _, found, err = p.lookupCityFrom(ip)
+	return record, found, err
+}
+
+// lookupCityFrom is lookupCity that also returns the database read, which
+// is not the current one while a canary serves ip, and the network of the
+// record. found is false while no database is loaded.
+func (p *maxMindProvider) lookupCityFrom(ip net.IP) (record *geoip2.City, db *maxminddb.Reader, network *net.IPNet, found bool, err error) {
+	db = p.current()
+	if db == nil {
+		return nil, nil, nil, false, nil
+	}
+	canary := geoCanary.Load()
+	if canary != nil && !canary.selects(ip) {
+		canary = nil
+	}
+	if canary != nil {
+		db = canary.reader
+	}
+	backoff := dbReadRetryBackoff
+	for attempt := 1; ; attempt++ {
+		var city geoip2.City
+		start := time.Now()
+		network, found, err = lookupCityRecord(db, ip, &city)
+		stageDecode.since(start)
+		if err == nil {
+			dbHealth.recordSuccess()
+			if !found {
+				stats.recordMiss()
+			}
+			if canary != nil {
+				canary.compare(ip, p.current(), &city, found)
+			}
+			return &city, db, network, found, nil
+		}
+		stats.recordDBReadError()
+		if attempt == dbReadAttempts {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	dbHealth.recordFailure(err)
+	return nil, db, nil, false, fmt.Errorf("%w: %v", errDBRead, err)
+}
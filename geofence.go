@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxGeofenceBodySize caps the JSON body of POST /geofence.
+const maxGeofenceBodySize = 1 << 20
+
+// maxGeofenceVertices caps the vertices of a polygon fence.
+const maxGeofenceVertices = 10000
+
+// maxGeofenceRadiusKm is about half the Earth's circumference, which
+// reaches every point.
+const maxGeofenceRadiusKm = 20016
+
+// geofenceRequest is the JSON body of POST /geofence.
+type geofenceRequest struct {
+	IP string `json:"ip"`
+	// Polygon is the ring of the fence as [longitude, latitude] pairs, the
+	// order of GeoJSON. Closing the ring is optional.
+	Polygon [][]float64 `json:"polygon"`
+}
+
+// geofenceIP returns the IP of a geofence query, or the caller's when ipStr
+// is empty. It writes the error response and returns nil when there is none.
+func geofenceIP(w http.ResponseWriter, r *http.Request, ipStr string) net.IP {
+	if ipStr = strings.TrimSpace(ipStr); ipStr == "" {
+		start := time.Now()
+		ipStr = clientIP(r)
+		stageClientIP.since(start)
+	}
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		writeJSONError(w, fmt.Sprintf("Invalid IP address format: %s", ipStr), http.StatusBadRequest, errCodeInvalidIP)
+	}
+	return ip
+}
+
+// parseGeofenceFloat parses the query parameter name, a finite number in
+// [lo, hi].
+func parseGeofenceFloat(r *http.Request, name string, lo, hi float64) (float64, error) {
+	value := strings.TrimSpace(r.URL.Query().Get(name))
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(f) || f < lo || f > hi {
+		return 0, fmt.Errorf("Invalid %s parameter '%s': must be a number from %g to %g", name, value, lo, hi)
+	}
+	return f, nil
+}
+
+// geofenceHandler serves GET /geofence?ip=&lat=&lon=&radius_km=: whether the
+// location of the IP lies within radius_km of the center, and its distance
+// from it. Without ip, the caller's IP is checked.
+func geofenceHandler(w http.ResponseWriter, r *http.Request) {
+	if !databaseLoaded() {
+		writeJSONError(w, "GeoIP service not available", http.StatusInternalServerError, errCodeDBUnavailable)
+		return
+	}
+	centerLat, err := parseGeofenceFloat(r, "lat", -90, 90)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest, errCodeInvalidRequest)
+		return
+	}
+	centerLon, err := parseGeofenceFloat(r, "lon", -180, 180)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest, errCodeInvalidRequest)
+		return
+	}
+	radius, err := parseGeofenceFloat(r, "radius_km", 0, maxGeofenceRadiusKm)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest, errCodeInvalidRequest)
+		return
+	}
+	ip := geofenceIP(w, r, r.URL.Query().Get("ip"))
+	if ip == nil {
+		return
+	}
+
+	lat, lon, ok := lookupCoordinates(w, r, ip)
+	if !ok {
+		return
+	}
+	km := haversineKm(lat, lon, centerLat, centerLon)
+	writeGeofenceResponse(w, ip, map[string]any{
+		"ip":          ip.String(),
+		"latitude":    lat,
+		"longitude":   lon,
+		"inside":      km <= radius,
+		"distance_km": math.Round(km*100) / 100,
+	})
+}
+
+// geofencePolygonHandler serves POST /geofence: whether the location of the
+// IP of the body lies within its polygon. Without ip, the caller's IP is
+// checked.
+func geofencePolygonHandler(w http.ResponseWriter, r *http.Request) {
+	if !databaseLoaded() {
+		writeJSONError(w, "GeoIP service not available", http.StatusInternalServerError, errCodeDBUnavailable)
+		return
+	}
+	var req geofenceRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxGeofenceBodySize)).Decode(&req)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeJSONError(w, fmt.Sprintf("Geofence request too large: the maximum is %d bytes", maxGeofenceBodySize), http.StatusRequestEntityTooLarge, errCodePayloadTooLarge)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, `Invalid geofence request: the body must be a JSON object such as {"ip": "8.8.8.8", "polygon": [[lon, lat], ...]}`, http.StatusBadRequest, errCodeInvalidRequest)
+		return
+	}
+	if err := validatePolygon(req.Polygon); err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest, errCodeInvalidRequest)
+		return
+	}
+	ip := geofenceIP(w, r, req.IP)
+	if ip == nil {
+		return
+	}
+
+	lat, lon, ok := lookupCoordinates(w, r, ip)
+	if !ok {
+		return
+	}
+	writeGeofenceResponse(w, ip, map[string]any{
+		"ip":        ip.String(),
+		"latitude":  lat,
+		"longitude": lon,
+		"inside":    polygonContains(req.Polygon, lon, lat),
+	})
+}
+
+// validatePolygon checks that polygon is a ring of at least three
+// [longitude, latitude] pairs within range.
+func validatePolygon(polygon [][]float64) error {
+	points := len(polygon)
+	// A closing point repeats the first, leaving a line for 3 points.
+	if points > 1 && slices.Equal(polygon[0], polygon[points-1]) {
+		points--
+	}
+	if points < 3 {
+		return errors.New("Invalid polygon: it must have at least 3 [longitude, latitude] points, not counting a closing one")
+	}
+	if len(polygon) > maxGeofenceVertices {
+		return fmt.Errorf("Invalid polygon: it has %d points (maximum %d)", len(polygon), maxGeofenceVertices)
+	}
+	for i, point := range polygon {
+		if len(point) != 2 || point[0] < -180 || point[0] > 180 || point[1] < -90 || point[1] > 90 {
+			return fmt.Errorf("Invalid polygon point %d: must be a [longitude, latitude] pair within range", i)
+		}
+	}
+	return nil
+}
+
+// polygonContains reports whether the point lies inside polygon, by ray
+// casting on longitude and latitude as plane coordinates. Edges are
+// straight in that plane, which is close to the great-circle distance for
+// fences of a few hundred kilometres; polygons crossing the antimeridian
+// are not supported.
+func polygonContains(polygon [][]float64, lon, lat float64) bool {
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		xi, yi := polygon[i][0], polygon[i][1]
+		xj, yj := polygon[j][0], polygon[j][1]
+		if (yi > lat) != (yj > lat) && lon < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+func writeGeofenceResponse(w http.ResponseWriter, ip net.IP, response map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error encoding JSON response for IP %s: %v", ip.String(), err)
+	}
+}
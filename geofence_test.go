@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// serveGeofence sends req to the geofence handlers and decodes the response.
+func serveGeofence(t *testing.T, req *http.Request) (int, map[string]any) {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /geofence", geofenceHandler)
+	mux.HandleFunc("POST /geofence", geofencePolygonHandler)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	var body map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("%s %s: decoding %q: %v", req.Method, req.URL, rec.Body.String(), err)
+	}
+	return rec.Code, body
+}
+
+// berlinSquare is a fence of about 22 km around central Berlin.
+const berlinSquare = `[[13.25, 52.45], [13.55, 52.45], [13.55, 52.6], [13.25, 52.6]]`
+
+func TestGeofence(t *testing.T) {
+	useFakeProvider(t, map[string]any{"country_code": "DE", "latitude": 52.52, "longitude": 13.405})
+
+	tests := []struct {
+		name   string
+		req    *http.Request
+		inside bool
+	}{
+		{"within the radius", httptest.NewRequest(http.MethodGet, "/geofence?ip=81.2.69.142&lat=52.5&lon=13.4&radius_km=5", nil), true},
+		{"outside the radius", httptest.NewRequest(http.MethodGet, "/geofence?ip=81.2.69.142&lat=48.85&lon=2.35&radius_km=800", nil), false},
+		{"a radius reaching everywhere", httptest.NewRequest(http.MethodGet, "/geofence?ip=81.2.69.142&lat=-52.5&lon=-166.6&radius_km=20016", nil), true},
+		{"within the polygon", httptest.NewRequest(http.MethodPost, "/geofence", strings.NewReader(`{"ip": "81.2.69.142", "polygon": `+berlinSquare+`}`)), true},
+		{"within a closed polygon", httptest.NewRequest(http.MethodPost, "/geofence", strings.NewReader(`{"ip": "81.2.69.142", "polygon": [[13.25, 52.45], [13.55, 52.45], [13.55, 52.6], [13.25, 52.45]]}`)), true},
+		{"outside the polygon", httptest.NewRequest(http.MethodPost, "/geofence", strings.NewReader(`{"ip": "81.2.69.142", "polygon": [[2.2, 48.8], [2.5, 48.8], [2.5, 48.9], [2.2, 48.9]]}`)), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := serveGeofence(t, tt.req)
+			if code != http.StatusOK || body["inside"] != tt.inside || body["ip"] != "81.2.69.142" {
+				t.Errorf("got %d %v, want 200 with inside %v", code, body, tt.inside)
+			}
+		})
+	}
+}
+
+func TestGeofenceRejectsMalformedRequests(t *testing.T) {
+	useFakeProvider(t, map[string]any{"country_code": "DE", "latitude": 52.52, "longitude": 13.405})
+
+	get := func(query string) *http.Request {
+		return httptest.NewRequest(http.MethodGet, "/geofence?"+query, nil)
+	}
+	post := func(body string) *http.Request {
+		return httptest.NewRequest(http.MethodPost, "/geofence", strings.NewReader(body))
+	}
+	tests := []struct {
+		name    string
+		req     *http.Request
+		code    int
+		message string
+	}{
+		{"no parameters", get(""), http.StatusBadRequest, "Invalid lat parameter ''"},
+		{"latitude out of range", get("lat=90.1&lon=0&radius_km=1"), http.StatusBadRequest, "Invalid lat parameter '90.1'"},
+		{"longitude not a number", get("lat=0&lon=east&radius_km=1"), http.StatusBadRequest, "Invalid lon parameter 'east'"},
+		{"NaN longitude", get("lat=0&lon=NaN&radius_km=1"), http.StatusBadRequest, "Invalid lon parameter 'NaN'"},
+		{"infinite radius", get("lat=0&lon=0&radius_km=Inf"), http.StatusBadRequest, "Invalid radius_km parameter 'Inf'"},
+		{"negative radius", get("lat=0&lon=0&radius_km=-1"), http.StatusBadRequest, "Invalid radius_km parameter '-1'"},
+		{"hex radius", get("lat=0&lon=0&radius_km=0x10"), http.StatusBadRequest, "Invalid radius_km parameter '0x10'"},
+		{"invalid IP", get("ip=8.8.8&lat=0&lon=0&radius_km=1"), http.StatusBadRequest, "Invalid IP address format: 8.8.8"},
+		{"empty body", post(""), http.StatusBadRequest, "Invalid geofence request"},
+		{"truncated body", post(`{"ip": "8.8.8.8", "polygon": [[13.25, 52.45], [13.5`), http.StatusBadRequest, "Invalid geofence request"},
+		{"body not an object", post(`[[13.25, 52.45]]`), http.StatusBadRequest, "Invalid geofence request"},
+		{"polygon of strings", post(`{"polygon": [["13.25", "52.45"]]}`), http.StatusBadRequest, "Invalid geofence request"},
+		{"too large", post(`{"ip": "` + strings.Repeat(" ", maxGeofenceBodySize) + `"}`), http.StatusRequestEntityTooLarge, "Geofence request too large"},
+		{"no polygon", post(`{"ip": "8.8.8.8"}`), http.StatusBadRequest, "at least 3"},
+		{"two points", post(`{"polygon": [[0, 0], [1, 1]]}`), http.StatusBadRequest, "at least 3"},
+		{"two points and a closing one", post(`{"polygon": [[0, 0], [1, 1], [0, 0]]}`), http.StatusBadRequest, "at least 3"},
+		{"too many points", post(`{"polygon": [` + strings.Repeat("[0, 0], ", maxGeofenceVertices) + `[1, 1]]}`), http.StatusBadRequest, "maximum 10000"},
+		{"a point of one coordinate", post(`{"polygon": [[0, 0], [1], [1, 1]]}`), http.StatusBadRequest, "Invalid polygon point 1"},
+		{"a point of three coordinates", post(`{"polygon": [[0, 0], [1, 0, 0], [1, 1]]}`), http.StatusBadRequest, "Invalid polygon point 1"},
+		{"swapped coordinates", post(`{"polygon": [[0, 0], [1, 1], [50, 100]]}`), http.StatusBadRequest, "Invalid polygon point 2"},
+		{"invalid IP in the body", post(`{"ip": "x", "polygon": ` + berlinSquare + `}`), http.StatusBadRequest, "Invalid IP address format: x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := serveGeofence(t, tt.req)
+			if message, _ := body["message"].(string); code != tt.code || !strings.Contains(message, tt.message) {
+				t.Errorf("got %d %v, want %d with %q", code, body, tt.code, tt.message)
+			}
+		})
+	}
+}
+
+func TestPolygonContains(t *testing.T) {
+	// A concave U, open at the top between longitudes 1 and 2.
+	u := [][]float64{{0, 0}, {3, 0}, {3, 3}, {2, 3}, {2, 1}, {1, 1}, {1, 3}, {0, 3}}
+	tests := []struct {
+		lon, lat float64
+		want     bool
+	}{
+		{0.5, 2, true},
+		{2.5, 2, true},
+		{1.5, 0.5, true},
+		{1.5, 2, false},
+		{-1, 1, false},
+		{4, 1, false},
+		{1.5, 3.5, false},
+	}
+	for _, tt := range tests {
+		if got := polygonContains(u, tt.lon, tt.lat); got != tt.want {
+			t.Errorf("polygonContains(U, %g, %g) = %v, want %v", tt.lon, tt.lat, got, tt.want)
+		}
+	}
+}
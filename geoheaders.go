@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// geoHeader maps a lookup response field to the response header carrying it.
+type geoHeader struct {
+	key   string // the name used in GEO_HEADER_NAMES
+	field string // the /lookup response field
+	name  string // the header name
+}
+
+// defaultGeoHeaders are the headers emitted in header mode, in order. asn is
+// only present when an ASN database is configured.
+var defaultGeoHeaders = []geoHeader{
+	{"country", "country_code", "X-Geo-Country"},
+	{"country_name", "country_name", "X-Geo-Country-Name"},
+	{"continent", "continent", "X-Geo-Continent"},
+	{"region", "subdivision_name", "X-Geo-Region"},
+	{"city", "city", "X-Geo-City"},
+	{"postal_code", "postal_code", "X-Geo-Postal-Code"},
+	{"latitude", "latitude", "X-Geo-Latitude"},
+	{"longitude", "longitude", "X-Geo-Longitude"},
+	{"time_zone", "time_zone", "X-Geo-Time-Zone"},
+	{"asn", "autonomous_system_number", "X-Geo-ASN"},
+}
+
+// geoHeaders are the headers set on lookup and /authz responses; nil when
+// GEO_HEADERS_ENABLED is off.
+var geoHeaders []geoHeader
+
+// parseGeoHeaderNames applies GEO_HEADER_NAMES overrides ("key=Header-Name"
+// pairs, comma-separated) to the default headers. An empty name drops the
+// header.
+func parseGeoHeaderNames(spec string) ([]geoHeader, error) {
+	headers := make([]geoHeader, len(defaultGeoHeaders))
+	copy(headers, defaultGeoHeaders)
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, name, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not a key=Header-Name pair", pair)
+		}
+		key, name = strings.TrimSpace(key), strings.TrimSpace(name)
+		if name != "" && !validHeaderName(name) {
+			return nil, fmt.Errorf("%q is not a valid header name", name)
+		}
+		found := false
+		for i := range headers {
+			if headers[i].key == key {
+				headers[i].name = name
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown field %q", key)
+		}
+	}
+	kept := headers[:0]
+	for _, h := range headers {
+		if h.name != "" {
+			kept = append(kept, h)
+		}
+	}
+	return kept, nil
+}
+
+// validHeaderName reports whether name is an HTTP token (RFC 9110).
+func validHeaderName(name string) bool {
+	for _, c := range name {
+		if c > '~' || c <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// setGeoHeaders copies the fields of a lookup response into the configured
+// headers, skipping fields that are missing or empty.
+func setGeoHeaders(w http.ResponseWriter, response map[string]any) {
+	for _, h := range geoHeaders {
+		var value string
+		switch v := response[h.field].(type) {
+		case string:
+			value = v
+		case float64:
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		case nil:
+		default:
+			value = fmt.Sprint(v)
+		}
+		if value != "" {
+			// Assigned directly to keep the configured spelling, e.g. X-Geo-ASN.
+			w.Header()[h.name] = []string{value}
+		}
+	}
+}
+
+// setGeoHeadersFor looks ip up for its geo headers. It is used where the
+// response body is not a lookup, such as /authz; a failed lookup only costs
+// the headers.
+func setGeoHeadersFor(ctx context.Context, w http.ResponseWriter, ip net.IP) {
+	if geoHeaders == nil {
+		return
+	}
+	response, err := lookupRecord(ctx, ip)
+	if err != nil {
+		if !errors.Is(err, errNoRecord) {
+			log.Printf("GeoIP lookup for headers of IP %s failed: %v", ip.String(), err)
+		}
+		return
+	}
+	setGeoHeaders(w, response)
+}
+
+// geoHeadersHandler serves /authz/headers for reverse proxies that only want
+// the client IP's location as headers: it always answers 204 No Content, with
+// whichever headers could be determined, so it never blocks a request.
+func geoHeadersHandler(w http.ResponseWriter, r *http.Request) {
+	if ip := net.ParseIP(clientIP(r)); ip != nil && databaseLoaded() {
+		setGeoHeadersFor(r.Context(), w, ip)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
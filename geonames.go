@@ -0,0 +1,144 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// GeoNames dump columns (https://download.geonames.org/export/dump/readme.txt).
+const (
+	geoNamesColumnID           = 0
+	geoNamesColumnASCIIName    = 2
+	geoNamesColumnFeatureClass = 6
+	geoNamesColumnPopulation   = 14
+	geoNamesColumns            = 19
+)
+
+// geoNamesPlace is the GeoNames data added to lookups of a city.
+type geoNamesPlace struct {
+	asciiName    string
+	featureClass string
+	population   uint64
+}
+
+// geoNames holds the places of GEONAMES_PATH by geoname ID; nil when it is
+// not set.
+var geoNames atomic.Pointer[map[uint]geoNamesPlace]
+
+// geoNamesDigest is a hash of the loaded GeoNames dump, which keys the shared
+// cache with the database builds: it is the same on peers loading the same
+// dump, whenever they loaded it.
+var geoNamesDigest atomic.Pointer[string]
+
+// loadGeoNames reads the GeoNames dump at path, a tab-separated file such as
+// cities1000.txt or the .zip GeoNames distributes it in, and swaps it in.
+func loadGeoNames(path string) error {
+	var r io.Reader
+	if strings.EqualFold(filepath.Ext(path), ".zip") {
+		archive, err := zip.OpenReader(path)
+		if err != nil {
+			return err
+		}
+		defer archive.Close()
+		f, err := openGeoNamesEntry(&archive.Reader)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	h := fnv.New64a()
+	places, err := parseGeoNames(io.TeeReader(r, h))
+	if err != nil {
+		return err
+	}
+	digest := strconv.FormatUint(h.Sum64(), 36)
+	geoNames.Store(&places)
+	geoNamesDigest.Store(&digest)
+	log.Printf("Loaded %d GeoNames places from %s.", len(places), path)
+	return nil
+}
+
+// openGeoNamesEntry opens the dump in a GeoNames .zip, which also holds a
+// readme.
+func openGeoNamesEntry(archive *zip.Reader) (io.ReadCloser, error) {
+	for _, entry := range archive.File {
+		if strings.EqualFold(filepath.Ext(entry.Name), ".txt") && !strings.EqualFold(filepath.Base(entry.Name), "readme.txt") {
+			return entry.Open()
+		}
+	}
+	return nil, fmt.Errorf("no .txt file in the archive")
+}
+
+func parseGeoNames(r io.Reader) (map[uint]geoNamesPlace, error) {
+	places := make(map[uint]geoNamesPlace)
+	scanner := bufio.NewScanner(r)
+	// The alternate names make some lines long.
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		columns := strings.Split(text, "\t")
+		if len(columns) < geoNamesColumns {
+			return nil, fmt.Errorf("line %d: %d columns, want %d", line, len(columns), geoNamesColumns)
+		}
+		id, err := strconv.ParseUint(columns[geoNamesColumnID], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid geoname ID %q", line, columns[geoNamesColumnID])
+		}
+		var population uint64
+		if value := columns[geoNamesColumnPopulation]; value != "" {
+			if population, err = strconv.ParseUint(value, 10, 64); err != nil {
+				return nil, fmt.Errorf("line %d: invalid population %q", line, value)
+			}
+		}
+		places[uint(id)] = geoNamesPlace{
+			asciiName:    columns[geoNamesColumnASCIIName],
+			featureClass: columns[geoNamesColumnFeatureClass],
+			population:   population,
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	// An empty dump is a failed download rather than a world without cities.
+	if len(places) == 0 {
+		return nil, fmt.Errorf("no places in the dump")
+	}
+	return places, nil
+}
+
+// addGeoNamesFields adds the GeoNames data of the city with geoNameID to
+// response, if GeoNames is loaded and has the city.
+func addGeoNamesFields(geoNameID uint, response map[string]any) {
+	places := geoNames.Load()
+	if places == nil || geoNameID == 0 {
+		return
+	}
+	place, ok := (*places)[geoNameID]
+	if !ok {
+		return
+	}
+	response["city_geoname_id"] = geoNameID
+	response["city_ascii_name"] = place.asciiName
+	response["city_feature_class"] = place.featureClass
+	response["city_population"] = place.population
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// geoNamesLine is a line of a GeoNames dump with the given columns set.
+func geoNamesLine(id, asciiName, featureClass, population string) string {
+	columns := make([]string, geoNamesColumns)
+	columns[geoNamesColumnID] = id
+	columns[1] = asciiName
+	columns[geoNamesColumnASCIIName] = asciiName
+	columns[geoNamesColumnFeatureClass] = featureClass
+	columns[geoNamesColumnPopulation] = population
+	return strings.Join(columns, "\t") + "\n"
+}
+
+// geoNamesTestDump has Mountain View and a place without a population.
+var geoNamesTestDump = "# cities1000\n\n" +
+	geoNamesLine("5375480", "Mountain View", "P", "82376") +
+	geoNamesLine("2643743", "London", "P", "")
+
+// useGeoNames restores the loaded GeoNames dump when t ends.
+func useGeoNames(t *testing.T) {
+	t.Helper()
+	places, digest := geoNames.Load(), geoNamesDigest.Load()
+	t.Cleanup(func() {
+		geoNames.Store(places)
+		geoNamesDigest.Store(digest)
+	})
+}
+
+func TestParseGeoNames(t *testing.T) {
+	places, err := parseGeoNames(strings.NewReader(geoNamesTestDump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[uint]geoNamesPlace{
+		5375480: {asciiName: "Mountain View", featureClass: "P", population: 82376},
+		2643743: {asciiName: "London", featureClass: "P"},
+	}
+	if !reflect.DeepEqual(places, want) {
+		t.Errorf("parsed %+v, want %+v", places, want)
+	}
+}
+
+func TestParseGeoNamesRejectsMalformedDumps(t *testing.T) {
+	valid := geoNamesLine("5375480", "Mountain View", "P", "82376")
+	tests := []struct {
+		name string
+		dump string
+		// message is part of the error.
+		message string
+	}{
+		{"empty", "", "no places"},
+		{"only comments", "# cities1000\n\n", "no places"},
+		{"too few columns", valid + "2643743\tLondon\tLondon\n", "line 2: 3 columns, want 19"},
+		{"cut short", valid + valid[:len(valid)/2], "line 2:"},
+		{"comma-separated", strings.ReplaceAll(valid, "\t", ","), "line 1: 1 columns"},
+		{"ID not a number", geoNamesLine("Q5375480", "Mountain View", "P", "82376"), `invalid geoname ID "Q5375480"`},
+		{"ID over 32 bits", geoNamesLine("4294967296", "Mountain View", "P", "82376"), "invalid geoname ID"},
+		{"negative population", geoNamesLine("5375480", "Mountain View", "P", "-1"), `invalid population "-1"`},
+		{"fractional population", geoNamesLine("5375480", "Mountain View", "P", "8.2e4"), "invalid population"},
+		{"line too long", geoNamesLine("5375480", strings.Repeat("x", 1<<20), "P", "82376"), "token too long"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			places, err := parseGeoNames(strings.NewReader(tt.dump))
+			if err == nil || !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("parseGeoNames = %d places, %v, want an error containing %q", len(places), err, tt.message)
+			}
+		})
+	}
+}
+
+// zipOf returns a .zip archive of files, by name.
+func zipOf(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestLoadGeoNames(t *testing.T) {
+	archive := zipOf(t, map[string]string{"readme.txt": "GeoNames dump", "cities1000.txt": geoNamesTestDump})
+	// Flipping a byte of the compressed dump breaks its deflate stream.
+	corrupt := bytes.Clone(archive)
+	corrupt[bytes.Index(corrupt, []byte("cities1000.txt"))+len("cities1000.txt")+2] ^= 0xff
+
+	tests := []struct {
+		name    string
+		file    string
+		content []byte
+		// message is part of the error; the dump loads when it is empty.
+		message string
+	}{
+		{"dump", "cities1000.txt", []byte(geoNamesTestDump), ""},
+		{"archive", "cities1000.zip", archive, ""},
+		{"archive of only a readme", "cities1000.zip", zipOf(t, map[string]string{"readme.txt": geoNamesTestDump}), "no .txt file in the archive"},
+		{"truncated archive", "cities1000.zip", archive[:len(archive)-10], "zip"},
+		{"archive not a zip", "cities1000.ZIP", []byte(geoNamesTestDump), "zip: not a valid zip file"},
+		{"corrupt archive", "cities1000.zip", corrupt, "flate: corrupt input"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useGeoNames(t)
+			geoNames.Store(nil)
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, tt.content, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			err := loadGeoNames(path)
+			if tt.message == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if places := geoNames.Load(); places == nil || len(*places) != 2 {
+					t.Errorf("loaded %v, want 2 places", places)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("loadGeoNames = %v, want an error containing %q", err, tt.message)
+			}
+			if geoNames.Load() != nil {
+				t.Error("a rejected dump was loaded")
+			}
+		})
+	}
+}
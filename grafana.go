@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// This file implements the Grafana simple-JSON datasource contract (also
+// understood by the Infinity plugin's backend mode) on top of the in-process
+// stats, so dashboards can be built without Prometheus.
+
+// grafanaPathPrefix is where the datasource endpoints are mounted.
+const grafanaPathPrefix = "/grafana/"
+
+// Metrics offered to Grafana via /search.
+const (
+	grafanaTargetRequests         = "requests"
+	grafanaTargetErrors           = "errors"
+	grafanaTargetLookups          = "lookups"
+	grafanaTargetLatencyAvg       = "latency_avg_ms"
+	grafanaTargetLatencyMax       = "latency_max_ms"
+	grafanaTargetLookupsByCountry = "lookups_by_country"
+)
+
+var grafanaTargets = []string{
+	grafanaTargetRequests,
+	grafanaTargetErrors,
+	grafanaTargetLookups,
+	grafanaTargetLatencyAvg,
+	grafanaTargetLatencyMax,
+	grafanaTargetLookupsByCountry,
+}
+
+// maxGrafanaRequestSize caps the size of a datasource query body.
+const maxGrafanaRequestSize = 64 << 10
+
+type grafanaQuery struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	IntervalMs int64 `json:"intervalMs"`
+	Targets    []struct {
+		Target string `json:"target"`
+		Type   string `json:"type"`
+	} `json:"targets"`
+}
+
+type grafanaTimeSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"` // [value, unix milliseconds]
+}
+
+type grafanaColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+type grafanaTable struct {
+	Type    string          `json:"type"`
+	Columns []grafanaColumn `json:"columns"`
+	Rows    [][]any         `json:"rows"`
+}
+
+func writeGrafanaJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Grafana: error encoding response: %v", err)
+	}
+}
+
+// grafanaTestHandler answers Grafana's "Save & test" connection check.
+func grafanaTestHandler(w http.ResponseWriter, r *http.Request) {
+	writeGrafanaJSON(w, map[string]string{"status": "ok"})
+}
+
+// grafanaSearchHandler lists the available metric targets.
+func grafanaSearchHandler(w http.ResponseWriter, r *http.Request) {
+	writeGrafanaJSON(w, grafanaTargets)
+}
+
+// grafanaAnnotationsHandler returns no annotations; it exists because
+// Grafana probes the endpoint.
+func grafanaAnnotationsHandler(w http.ResponseWriter, r *http.Request) {
+	writeGrafanaJSON(w, []any{})
+}
+
+// grafanaQueryHandler returns time series or table data for the requested targets.
+func grafanaQueryHandler(w http.ResponseWriter, r *http.Request) {
+	var q grafanaQuery
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxGrafanaRequestSize)).Decode(&q); err != nil {
+		writeJSONError(w, "Invalid query body", http.StatusBadRequest, errCodeInvalidRequest)
+		return
+	}
+	if q.Range.To.IsZero() {
+		q.Range.To = time.Now()
+	}
+	if q.Range.From.IsZero() {
+		q.Range.From = q.Range.To.Add(-time.Hour)
+	}
+
+	// Group one-minute buckets into Grafana's requested interval.
+	perPoint := max(int(q.IntervalMs/int64(time.Minute/time.Millisecond)), 1)
+	buckets := stats.series(q.Range.From, q.Range.To)
+
+	results := make([]any, 0, len(q.Targets))
+	for _, t := range q.Targets {
+		if t.Target == grafanaTargetLookupsByCountry {
+			table := grafanaTable{
+				Type:    "table",
+				Columns: []grafanaColumn{{Text: "Country", Type: "string"}, {Text: "Lookups", Type: "number"}},
+				Rows:    [][]any{},
+			}
+			for _, c := range stats.countries() {
+				table.Rows = append(table.Rows, []any{c.Country, c.Lookups})
+			}
+			results = append(results, table)
+			continue
+		}
+
+		series := grafanaTimeSeries{Target: t.Target, Datapoints: [][2]float64{}}
+		for i := 0; i < len(buckets); i += perPoint {
+			group := buckets[i:min(i+perPoint, len(buckets))]
+			value, ok := grafanaAggregate(t.Target, group)
+			if !ok {
+				break
+			}
+			series.Datapoints = append(series.Datapoints, [2]float64{value, float64(group[0].minute * 60 * 1000)})
+		}
+		results = append(results, series)
+	}
+	writeGrafanaJSON(w, results)
+}
+
+// grafanaAggregate folds a group of buckets into one datapoint for target.
+// It reports false for unknown targets.
+func grafanaAggregate(target string, group []statsBucket) (float64, bool) {
+	var requests, errors, lookups uint64
+	var latencySum, latencyMax time.Duration
+	for _, b := range group {
+		requests += b.requests
+		errors += b.errors
+		lookups += b.lookups
+		latencySum += b.latencySum
+		latencyMax = max(latencyMax, b.latencyMax)
+	}
+
+	switch target {
+	case grafanaTargetRequests:
+		return float64(requests), true
+	case grafanaTargetErrors:
+		return float64(errors), true
+	case grafanaTargetLookups:
+		return float64(lookups), true
+	case grafanaTargetLatencyAvg:
+		if requests == 0 {
+			return 0, true
+		}
+		return float64(latencySum) / float64(requests) / float64(time.Millisecond), true
+	case grafanaTargetLatencyMax:
+		return float64(latencyMax) / float64(time.Millisecond), true
+	}
+	return 0, false
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/errcode"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+
+	"github.com/ali-issa/ip-lookup/graph"
+)
+
+// This file serves the GraphQL API at /graphql with gqlgen. The schema is
+// graph/schema.graphqls; `make graphql` regenerates graph/generated.go from
+// it, and graphQLResolver resolves its queries.
+
+// maxGraphQLRequestSize caps the size of a GraphQL request body.
+const maxGraphQLRequestSize = 64 << 10
+
+// graphQLLocationFields maps the fields of the Location type to the lookup
+// fields they read.
+var graphQLLocationFields = map[string]string{
+	"ip":                           "ip",
+	"city":                         "city",
+	"countryCode":                  "country_code",
+	"countryName":                  "country_name",
+	"continent":                    "continent",
+	"latitude":                     "latitude",
+	"longitude":                    "longitude",
+	"timeZone":                     "time_zone",
+	"postalCode":                   "postal_code",
+	"subdivisionName":              "subdivision_name",
+	"accuracyRadius":               "accuracy_radius",
+	"metroCode":                    "metro_code",
+	"network":                      "network",
+	"flagEmoji":                    "flag_emoji",
+	"flagUrl":                      "flag_url",
+	"isInEuropeanUnion":            "is_in_european_union",
+	"gdprApplicable":               "gdpr_applicable",
+	"autonomousSystemNumber":       "autonomous_system_number",
+	"autonomousSystemOrganization": "autonomous_system_organization",
+	"mobileCountryCode":            "mobile_country_code",
+	"mobileNetworkCode":            "mobile_network_code",
+	"isTorExit":                    "is_tor_exit",
+}
+
+// graphQLIntFields are the Location fields of type Int; gqlgen reads them
+// as int, and the other numbers, of type Float, as float64.
+var graphQLIntFields = map[string]bool{
+	"accuracyRadius":         true,
+	"metroCode":              true,
+	"autonomousSystemNumber": true,
+}
+
+func init() {
+	// Queries over the batch limit are rejected like invalid ones.
+	errcode.RegisterErrorType(errCodeInvalidRequest, errcode.KindProtocol)
+}
+
+// graphQLServer executes the queries of graphQLHandler.
+var graphQLServer = newGraphQLServer()
+
+func newGraphQLServer() *handler.Server {
+	srv := handler.New(graph.NewExecutableSchema(graph.Config{Resolvers: graphQLResolver{}}))
+	srv.AddTransport(transport.GET{})
+	srv.AddTransport(transport.POST{})
+	srv.AddTransport(transport.GRAPHQL{})
+	srv.Use(extension.Introspection{})
+	srv.Use(graphQLBatchLimit{})
+	srv.SetErrorPresenter(presentGraphQLError)
+	return srv
+}
+
+// graphQLRequestKey is the context key of the HTTP request of a query.
+type graphQLRequestKey struct{}
+
+// graphQLHandler serves GET and POST /graphql. Queries that cannot be parsed
+// or validated are answered with errors and no data; failed lookups are
+// reported in errors next to the data of the others.
+func graphQLHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxGraphQLRequestSize+1))
+		if err != nil {
+			writeGraphQLError(w, http.StatusBadRequest, "Failed to read request body", errCodeInvalidRequest)
+			return
+		}
+		if len(body) > maxGraphQLRequestSize {
+			writeGraphQLError(w, http.StatusBadRequest, fmt.Sprintf("Request body exceeds %d bytes", maxGraphQLRequestSize), errCodeInvalidRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	if !databaseLoaded() {
+		writeGraphQLError(w, http.StatusInternalServerError, "GeoIP service not available", errCodeDBUnavailable)
+		return
+	}
+	graphQLServer.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), graphQLRequestKey{}, r)))
+}
+
+// writeGraphQLError answers a request that is not executed with a GraphQL
+// error of code.
+func writeGraphQLError(w http.ResponseWriter, status int, message, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	response := graphql.Response{Errors: gqlerror.List{{Message: message, Extensions: map[string]any{"code": code}}}}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error encoding GraphQL response: %v", err)
+	}
+}
+
+// presentGraphQLError gives the errors of requests and queries that cannot
+// be parsed or validated the INVALID_REQUEST code of the rest of the API.
+func presentGraphQLError(ctx context.Context, err error) *gqlerror.Error {
+	gqlErr := graphql.DefaultErrorPresenter(ctx, err)
+	switch code, ok := gqlErr.Extensions["code"]; {
+	case code == errcode.ParseFailed, code == errcode.ValidationFailed:
+		gqlErr.Extensions["code"] = errCodeInvalidRequest
+	case !ok && len(gqlErr.Path) == 0:
+		// Errors of the request itself come from the transport uncoded.
+		errcode.Set(gqlErr, errCodeInvalidRequest)
+	}
+	return gqlErr
+}
+
+// graphQLBatchLimit rejects queries looking up more IPs, across their
+// fields, than BATCH_LOOKUP_MAX_SIZE allows a batch lookup.
+type graphQLBatchLimit struct{}
+
+func (graphQLBatchLimit) ExtensionName() string { return "BatchLimit" }
+
+func (graphQLBatchLimit) Validate(graphql.ExecutableSchema) error { return nil }
+
+func (graphQLBatchLimit) MutateOperationContext(ctx context.Context, op *graphql.OperationContext) *gqlerror.Error {
+	lookups := 0
+	for _, f := range graphql.CollectFields(op, op.Operation.SelectionSet, []string{"Query"}) {
+		switch f.Name {
+		case "lookup":
+			lookups++
+		case "lookups":
+			if appConfig.BatchLookupMaxSize == 0 {
+				return graphQLInvalidRequest(f.Position, "Field lookups is disabled: batch lookups are off")
+			}
+			switch ips := f.ArgumentMap(op.Variables)["ips"].(type) {
+			case []any:
+				lookups += len(ips)
+			case string:
+				// A single string is a list of one, by input coercion.
+				lookups++
+			}
+		}
+	}
+	// With BATCH_LOOKUP_MAX_SIZE 0, which disables batches, a query looks
+	// up one IP.
+	if limit := max(appConfig.BatchLookupMaxSize, 1); lookups > limit {
+		return graphQLInvalidRequest(nil, "The query looks up %d IPs (maximum %d)", lookups, limit)
+	}
+	return nil
+}
+
+// graphQLInvalidRequest returns an INVALID_REQUEST error at pos, or of the
+// whole query when pos is nil.
+func graphQLInvalidRequest(pos *ast.Position, format string, args ...any) *gqlerror.Error {
+	err := &gqlerror.Error{Message: fmt.Sprintf(format, args...), Extensions: map[string]any{"code": errCodeInvalidRequest}}
+	if pos != nil {
+		err.Locations = []gqlerror.Location{{Line: pos.Line, Column: pos.Column}}
+	}
+	return err
+}
+
+// graphQLResolver resolves the fields of Query.
+type graphQLResolver struct{}
+
+func (r graphQLResolver) Query() graph.QueryResolver { return r }
+
+// Lookup resolves lookup(ip), looking up the caller's IP without ip.
+func (graphQLResolver) Lookup(ctx context.Context, ip *string) (map[string]any, error) {
+	ipStr := ""
+	if ip != nil {
+		ipStr = *ip
+	}
+	if ipStr == "" {
+		req, _ := ctx.Value(graphQLRequestKey{}).(*http.Request)
+		if req != nil {
+			ipStr = clientIP(req)
+		}
+		if ipStr == "" {
+			addGraphQLError(ctx, graphql.GetPath(ctx), "Could not determine IP address from request", errCodeIPUndetermined)
+			return nil, nil
+		}
+	}
+	return resolveGraphQLLocation(ctx, ipStr, graphql.GetPath(ctx)), nil
+}
+
+// Lookups resolves lookups(ips). Each IP that cannot be looked up is null,
+// with an error of its own.
+func (graphQLResolver) Lookups(ctx context.Context, ips []string) ([]map[string]any, error) {
+	path := graphql.GetPath(ctx)
+	locations := make([]map[string]any, len(ips))
+	for i, ip := range ips {
+		locations[i] = resolveGraphQLLocation(ctx, ip, append(path[:len(path):len(path)], ast.PathIndex(i)))
+	}
+	return locations, nil
+}
+
+// resolveGraphQLLocation looks up ipStr as a Location, or adds the error of
+// the lookup at path and returns nil.
+func resolveGraphQLLocation(ctx context.Context, ipStr string, path ast.Path) map[string]any {
+	result, ok := lookupBatchItem(ctx, ipStr)
+	if !ok {
+		message, _ := result["error"].(string)
+		code, _ := result["error_code"].(string)
+		addGraphQLError(ctx, path, message, code)
+		return nil
+	}
+	location := make(map[string]any, len(graphQLLocationFields))
+	for name, field := range graphQLLocationFields {
+		location[name] = graphQLScalar(result[field], graphQLIntFields[name])
+	}
+	return location
+}
+
+// addGraphQLError adds an error of code at path, located at the field being
+// resolved.
+func addGraphQLError(ctx context.Context, path ast.Path, message, code string) {
+	pos := graphql.GetFieldContext(ctx).Field.Position
+	graphql.AddError(ctx, &gqlerror.Error{
+		Message:    message,
+		Locations:  []gqlerror.Location{{Line: pos.Line, Column: pos.Column}},
+		Path:       path,
+		Extensions: map[string]any{"code": code},
+	})
+}
+
+// graphQLScalar converts a number of a lookup result, of whichever Go type
+// its source decoded it as, to the int or float64 gqlgen reads. Other values
+// are returned as they are.
+func graphQLScalar(v any, integer bool) any {
+	rv := reflect.ValueOf(v)
+	var f float64
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		f = float64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		f = float64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		f = rv.Float()
+	default:
+		return v
+	}
+	if integer {
+		return int(f)
+	}
+	return f
+}
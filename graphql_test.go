@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// serveGraphQL posts body to graphQLHandler as contentType and returns the
+// status and body of the response.
+func serveGraphQL(t *testing.T, contentType, body string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	graphQLHandler(rec, req)
+	return rec.Code, strings.TrimSpace(rec.Body.String())
+}
+
+// graphQLBody encodes a JSON GraphQL request.
+func graphQLBody(query string, variables map[string]any) string {
+	b, _ := json.Marshal(map[string]any{"query": query, "variables": variables})
+	return string(b)
+}
+
+func TestGraphQLQuery(t *testing.T) {
+	useFakeProvider(t, map[string]any{"country_code": "GB", "city": "London", "latitude": 51.5142, "accuracy_radius": uint16(5)})
+	useConfig(t, Config{BatchLookupMaxSize: defaultBatchLookupMaxSize})
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        string
+	}{
+		{
+			"fields in selection order",
+			"application/graphql",
+			`{ lookup(ip: "81.2.69.142") { latitude countryCode ip accuracyRadius } }`,
+			`{"data":{"lookup":{"latitude":51.5142,"countryCode":"GB","ip":"81.2.69.142","accuracyRadius":5}}}`,
+		},
+		{
+			"aliases, typenames and comments",
+			"application/graphql",
+			"query Q {\n  __typename\n  home: lookup(ip: \"81.2.69.142\") { __typename town: city } # a comment\n}",
+			`{"data":{"__typename":"Query","home":{"__typename":"Location","town":"London"}}}`,
+		},
+		{
+			"variables and lists",
+			"application/json",
+			graphQLBody(`query ($ips: [String!]!, $ip: String) { lookups(ips: $ips) { ip } one: lookup(ip: $ip) { ip } }`,
+				map[string]any{"ips": []string{"81.2.69.142", "8.8.8.8"}, "ip": "8.8.8.8"}),
+			`{"data":{"lookups":[{"ip":"81.2.69.142"},{"ip":"8.8.8.8"}],"one":{"ip":"8.8.8.8"}}}`,
+		},
+		{
+			"a string for a list",
+			"application/graphql",
+			`{ lookups(ips: "81.2.69.142") { city } }`,
+			`{"data":{"lookups":[{"city":"London"}]}}`,
+		},
+		{
+			"fragments and directives",
+			"application/graphql",
+			`{ lookup(ip: "81.2.69.142") { ...F ip @skip(if: true) } } fragment F on Location { city }`,
+			`{"data":{"lookup":{"city":"London"}}}`,
+		},
+		{
+			"introspection",
+			"application/graphql",
+			`{ __type(name: "Location") { fields(includeDeprecated: false) { name } } }`,
+			`{"data":{"__type":{"fields":[{"name":"ip"},{"name":"city"},{"name":"countryCode"},{"name":"countryName"},{"name":"continent"},{"name":"latitude"},{"name":"longitude"},{"name":"timeZone"},{"name":"postalCode"},{"name":"subdivisionName"},{"name":"accuracyRadius"},{"name":"metroCode"},{"name":"network"},{"name":"flagEmoji"},{"name":"flagUrl"},{"name":"isInEuropeanUnion"},{"name":"gdprApplicable"},{"name":"autonomousSystemNumber"},{"name":"autonomousSystemOrganization"},{"name":"mobileCountryCode"},{"name":"mobileNetworkCode"},{"name":"isTorExit"}]}}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := serveGraphQL(t, tt.contentType, tt.body)
+			if code != http.StatusOK || body != tt.want {
+				t.Errorf("got %d %s, want 200 %s", code, body, tt.want)
+			}
+		})
+	}
+}
+
+func TestGraphQLLookupErrors(t *testing.T) {
+	useFakeProvider(t, map[string]any{"country_code": "GB"})
+	useConfig(t, Config{BatchLookupMaxSize: defaultBatchLookupMaxSize})
+
+	code, body := serveGraphQL(t, "application/graphql", `{ lookups(ips: ["81.2.69.142", "x"]) { ip } one: lookup(ip: "y") { ip } }`)
+	var response struct {
+		Errors []json.RawMessage `json:"errors"`
+		Data   json.RawMessage   `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(body), &response); err != nil {
+		t.Fatalf("decoding %s: %v", body, err)
+	}
+	if want := `{"lookups":[{"ip":"81.2.69.142"},null],"one":null}`; code != http.StatusOK || string(response.Data) != want {
+		t.Errorf("got %d with data %s, want 200 with %s", code, response.Data, want)
+	}
+	// The fields are resolved concurrently, so their errors come in any order.
+	errs := make([]string, len(response.Errors))
+	for i, e := range response.Errors {
+		errs[i] = string(e)
+	}
+	sort.Strings(errs)
+	want := []string{
+		`{"message":"Invalid IP address format: x","path":["lookups",1],"locations":[{"line":1,"column":3}],"extensions":{"code":"INVALID_IP"}}`,
+		`{"message":"Invalid IP address format: y","path":["one"],"locations":[{"line":1,"column":45}],"extensions":{"code":"INVALID_IP"}}`,
+	}
+	if !reflect.DeepEqual(errs, want) {
+		t.Errorf("errors = %s, want %s", errs, want)
+	}
+}
+
+// graphQLResponse is a decoded GraphQL response.
+type graphQLResponse struct {
+	Errors []struct {
+		Message    string            `json:"message"`
+		Locations  []map[string]int  `json:"locations"`
+		Extensions map[string]string `json:"extensions"`
+	} `json:"errors"`
+	Data any `json:"data"`
+}
+
+func TestGraphQLMalformedRequests(t *testing.T) {
+	useFakeProvider(t, map[string]any{"country_code": "GB"})
+	useConfig(t, Config{BatchLookupMaxSize: 2})
+
+	tests := []struct {
+		name  string
+		query string
+		// message is part of the error message.
+		message string
+		line    int
+		column  int
+	}{
+		{"empty document", "# nothing", "no operation provided", 0, 0},
+		{"unterminated string", `{ lookup(ip: "8.8.8.8) { ip } }`, "Unexpected <Invalid>", 1, 32},
+		{"unclosed selection", `{ lookup { ip }`, "Expected Name, found <EOF>", 1, 16},
+		{"mutation", `mutation { lookup { ip } }`, `Schema does not support operation type "mutation"`, 1, 1},
+		{"several operations", `query A { lookup { ip } } query B { lookup { ip } }`, "not found", 0, 0},
+		{"unknown query field", `{ location { ip } }`, `Cannot query field "location" on type "Query"`, 1, 3},
+		{"unknown location field", `{ lookup { ip asn } }`, `Cannot query field "asn" on type "Location"`, 1, 15},
+		{"no subfields", `{ lookup }`, `Field "lookup" of type "Location" must have a selection of subfields`, 1, 3},
+		{"unknown argument", `{ lookup(addr: "8.8.8.8") { ip } }`, `Unknown argument "addr"`, 1, 3},
+		{"ip of the wrong type", `{ lookup(ip: 8) { ip } }`, "String cannot represent a non string value", 1, 14},
+		{"missing ips", `{ lookups { ip } }`, `argument "ips" of type "[String!]!" is required`, 1, 3},
+		{"clashing names", `{ a: lookup { ip } a: lookups(ips: []) { ip } }`, `Fields "a" conflict`, 1, 20},
+		{"undefined variable", `{ lookup(ip: $ip) { ip } }`, `Variable "$ip" is not defined`, 1, 14},
+		{"missing required variable", `query ($ip: String!) { lookup(ip: $ip) { ip } }`, "must be defined", 0, 0},
+		{"too many IPs", `{ lookups(ips: ["8.8.8.8", "8.8.4.4"]) { ip } one: lookup(ip: "1.1.1.1") { ip } }`, "The query looks up 3 IPs (maximum 2)", 0, 0},
+		{"too many IPs in fragments", `{ ...Q a: lookup(ip: "1.1.1.1") { ip } } fragment Q on Query { b: lookup(ip: "8.8.8.8") { ip } c: lookup(ip: "8.8.4.4") { ip } }`, "The query looks up 3 IPs (maximum 2)", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := serveGraphQL(t, "application/graphql", tt.query)
+			var response graphQLResponse
+			if err := json.Unmarshal([]byte(body), &response); err != nil {
+				t.Fatalf("decoding %s: %v", body, err)
+			}
+			if code != http.StatusUnprocessableEntity || response.Data != nil || len(response.Errors) != 1 {
+				t.Fatalf("got %d %s, want 422 with one error and no data", code, body)
+			}
+			e := response.Errors[0]
+			if !strings.Contains(e.Message, tt.message) {
+				t.Errorf("message = %q, want it to contain %q", e.Message, tt.message)
+			}
+			if e.Extensions["code"] != errCodeInvalidRequest {
+				t.Errorf("code = %q, want %s", e.Extensions["code"], errCodeInvalidRequest)
+			}
+			var want []map[string]int
+			if tt.line != 0 {
+				want = []map[string]int{{"line": tt.line, "column": tt.column}}
+			}
+			if len(e.Locations) != len(want) || len(want) > 0 && (e.Locations[0]["line"] != tt.line || e.Locations[0]["column"] != tt.column) {
+				t.Errorf("locations = %v, want %v", e.Locations, want)
+			}
+		})
+	}
+}
+
+func TestGraphQLBatchesDisabled(t *testing.T) {
+	useFakeProvider(t, map[string]any{"country_code": "GB"})
+	useConfig(t, Config{BatchLookupMaxSize: 0})
+
+	tests := []struct {
+		query string
+		code  int
+	}{
+		{`{ lookup(ip: "81.2.69.142") { countryCode } }`, http.StatusOK},
+		{`{ lookups(ips: ["81.2.69.142"]) { countryCode } }`, http.StatusUnprocessableEntity},
+		{`{ a: lookup(ip: "81.2.69.142") { ip } b: lookup(ip: "8.8.8.8") { ip } }`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		if code, body := serveGraphQL(t, "application/graphql", tt.query); code != tt.code {
+			t.Errorf("%s: got %d %s, want %d", tt.query, code, body, tt.code)
+		}
+	}
+}
+
+func TestGraphQLMalformedBodies(t *testing.T) {
+	useFakeProvider(t, map[string]any{"country_code": "GB"})
+
+	post := func(body string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		return req
+	}
+	tests := []struct {
+		name    string
+		req     *http.Request
+		code    int
+		message string
+	}{
+		{"not JSON", post("{"), http.StatusBadRequest, "json request body could not be decoded"},
+		{"no query", post(`{"query": " "}`), http.StatusUnprocessableEntity, "no operation provided"},
+		{"too large", post(strings.Repeat(" ", maxGraphQLRequestSize+1)), http.StatusBadRequest, "Request body exceeds"},
+		{"bad variables parameter", httptest.NewRequest(http.MethodGet, "/graphql?query="+url.QueryEscape("{ lookup { ip } }")+"&variables=x", nil), http.StatusBadRequest, "variables could not be decoded"},
+		{"unknown operation", httptest.NewRequest(http.MethodGet, "/graphql?query="+url.QueryEscape("query A { lookup { ip } }")+"&operationName=B", nil), http.StatusUnprocessableEntity, "operation B not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			graphQLHandler(rec, tt.req)
+			if rec.Code != tt.code || !strings.Contains(rec.Body.String(), tt.message) {
+				t.Errorf("got %d %s, want %d with %q", rec.Code, rec.Body.String(), tt.code, tt.message)
+			}
+		})
+	}
+}
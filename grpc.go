@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+
+	iplookupv1 "github.com/ali-issa/ip-lookup/proto/iplookup/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	_ "google.golang.org/grpc/encoding/gzip" // accept gzip-compressed messages
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// This file serves the IPLookup service of proto/iplookup/v1/lookup.proto
+// over gRPC with grpc-go and the service code generated by protoc-gen-go-grpc.
+
+// maxGRPCMessageSize caps a request message, matching the default limit of
+// gRPC clients and servers.
+const maxGRPCMessageSize = 4 << 20
+
+// grpcErrorCodeTrailer is the trailer carrying the service's machine-readable
+// error code of a failed call, so clients can share handling with the REST API.
+const grpcErrorCodeTrailer = "error-code"
+
+// grpcError returns the status of a failed call and sets its error-code trailer.
+func grpcError(ctx context.Context, code codes.Code, errorCode, msg string) error {
+	grpc.SetTrailer(ctx, metadata.Pairs(grpcErrorCodeTrailer, errorCode))
+	return status.Error(code, msg)
+}
+
+// newGRPCServer returns the server of GRPC_LISTEN_ADDR. gRPC clients connect
+// over cleartext HTTP/2 with prior knowledge.
+func newGRPCServer() *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.MaxRecvMsgSize(maxGRPCMessageSize),
+		grpc.UnknownServiceHandler(func(_ any, stream grpc.ServerStream) error {
+			method, _ := grpc.MethodFromServerStream(stream)
+			return grpcError(stream.Context(), codes.Unimplemented, errCodeRouteNotFound, fmt.Sprintf("unknown method %s", method))
+		}),
+	)
+	iplookupv1.RegisterIPLookupServer(srv, grpcService{})
+	return srv
+}
+
+// serveGRPC serves gRPC on ln until the server is stopped.
+func serveGRPC(srv *grpc.Server, ln net.Listener) {
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		log.Fatalf("Could not serve gRPC on %s: %v", ln.Addr(), err)
+	}
+}
+
+// shutdownGRPC stops srv once its calls have finished, or cancels them when
+// ctx is done first.
+func shutdownGRPC(ctx context.Context, srv *grpc.Server) error {
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		srv.Stop()
+		return ctx.Err()
+	}
+}
+
+// grpcService implements iplookupv1.IPLookupServer.
+type grpcService struct {
+	iplookupv1.UnimplementedIPLookupServer
+}
+
+func (grpcService) Lookup(ctx context.Context, req *iplookupv1.LookupRequest) (*iplookupv1.LookupResponse, error) {
+	if req.GetIp() == "" {
+		return nil, grpcError(ctx, codes.InvalidArgument, errCodeInvalidRequest, "ip is required")
+	}
+	if !databaseLoaded() {
+		return nil, grpcError(ctx, codes.Unavailable, errCodeDBUnavailable, "GeoIP service not available")
+	}
+	record, err := lookupIPString(ctx, req.GetIp())
+	if errors.Is(err, errInvalidIP) {
+		return nil, grpcError(ctx, codes.InvalidArgument, errCodeInvalidIP, fmt.Sprintf("Invalid IP address format: %s", req.GetIp()))
+	}
+	if errors.Is(err, errNoRecord) {
+		if response, ok := missResult(net.ParseIP(strings.TrimSpace(req.GetIp()))); ok {
+			return lookupResponseMessage(response), nil
+		}
+		return nil, grpcError(ctx, codes.NotFound, errCodeNotFound, fmt.Sprintf("GeoIP data not found for IP: %s", req.GetIp()))
+	}
+	if err != nil && ctx.Err() != nil {
+		return nil, grpcError(ctx, status.FromContextError(ctx.Err()).Code(), errCodeInternal, ctx.Err().Error())
+	}
+	if err != nil {
+		log.Printf("gRPC: GeoIP lookup for IP %s failed: %v", req.GetIp(), err)
+		return nil, grpcError(ctx, codes.Internal, errCodeDBError, fmt.Sprintf("GeoIP lookup failed for IP: %s", req.GetIp()))
+	}
+	return lookupResponseMessage(record), nil
+}
+
+func (grpcService) BatchLookup(ctx context.Context, req *iplookupv1.BatchLookupRequest) (*iplookupv1.BatchLookupResponse, error) {
+	// BATCH_LOOKUP_MAX_SIZE caps batches as it does POST /lookup, and 0
+	// disables them.
+	limit := appConfig.BatchLookupMaxSize
+	if limit == 0 {
+		return nil, grpcError(ctx, codes.Unimplemented, errCodeRouteNotFound, "BatchLookup is disabled")
+	}
+	if len(req.GetIps()) > limit {
+		return nil, grpcError(ctx, codes.InvalidArgument, errCodeInvalidRequest, fmt.Sprintf("too many IPs: %d (maximum %d)", len(req.GetIps()), limit))
+	}
+	if !databaseLoaded() {
+		return nil, grpcError(ctx, codes.Unavailable, errCodeDBUnavailable, "GeoIP service not available")
+	}
+	batch := &iplookupv1.BatchLookupResponse{Results: make([]*iplookupv1.BatchLookupResult, 0, len(req.GetIps()))}
+	for _, ipStr := range req.GetIps() {
+		batch.Results = append(batch.Results, batchLookupResultFor(ctx, ipStr))
+	}
+	return batch, nil
+}
+
+// StreamBatchLookup answers each LookupRequest the client streams with a
+// BatchLookupResult, in order and as soon as it is looked up, until the
+// client closes its side.
+func (grpcService) StreamBatchLookup(stream grpc.BidiStreamingServer[iplookupv1.LookupRequest, iplookupv1.BatchLookupResult]) error {
+	ctx := stream.Context()
+	if !databaseLoaded() {
+		return grpcError(ctx, codes.Unavailable, errCodeDBUnavailable, "GeoIP service not available")
+	}
+	// Response headers go out before the first request message arrives, so
+	// clients waiting for them do not block.
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(batchLookupResultFor(ctx, req.GetIp())); err != nil {
+			return err
+		}
+	}
+}
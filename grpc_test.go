@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	iplookupv1 "github.com/ali-issa/ip-lookup/proto/iplookup/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// startGRPCServer serves newGRPCServer on a loopback port and returns a
+// client connection to it.
+func startGRPCServer(t *testing.T) *grpc.ClientConn {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newGRPCServer()
+	go serveGRPC(srv, ln)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.NewClient(ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGRPCLookup(t *testing.T) {
+	useFakeProvider(t, map[string]any{"city": "London", "country_code": "GB", "latitude": 51.5142, "accuracy_radius": uint(10)})
+	client := iplookupv1.NewIPLookupClient(startGRPCServer(t))
+
+	resp, err := client.Lookup(context.Background(), &iplookupv1.LookupRequest{Ip: "81.2.69.142"}, grpc.UseCompressor(gzip.Name))
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	if resp.GetIp() != "81.2.69.142" || resp.GetCity() != "London" || resp.GetCountryCode() != "GB" || resp.GetLatitude() != 51.5142 || resp.GetAccuracyRadius() != 10 {
+		t.Errorf("Lookup = %v", resp)
+	}
+}
+
+func TestGRPCErrorCodeTrailer(t *testing.T) {
+	useFakeProvider(t, nil)
+	conn := startGRPCServer(t)
+	client := iplookupv1.NewIPLookupClient(conn)
+
+	tests := []struct {
+		name      string
+		call      func(trailer *metadata.MD) error
+		code      codes.Code
+		errorCode string
+	}{
+		{"missing ip", func(md *metadata.MD) error {
+			_, err := client.Lookup(context.Background(), &iplookupv1.LookupRequest{}, grpc.Trailer(md))
+			return err
+		}, codes.InvalidArgument, errCodeInvalidRequest},
+		{"invalid ip", func(md *metadata.MD) error {
+			_, err := client.Lookup(context.Background(), &iplookupv1.LookupRequest{Ip: "x"}, grpc.Trailer(md))
+			return err
+		}, codes.InvalidArgument, errCodeInvalidIP},
+		{"no record", func(md *metadata.MD) error {
+			_, err := client.Lookup(context.Background(), &iplookupv1.LookupRequest{Ip: "8.8.8.8"}, grpc.Trailer(md))
+			return err
+		}, codes.NotFound, errCodeNotFound},
+		{"unknown method", func(md *metadata.MD) error {
+			return conn.Invoke(context.Background(), "/iplookup.v1.IPLookup/Missing", &iplookupv1.LookupRequest{}, &iplookupv1.LookupResponse{}, grpc.Trailer(md))
+		}, codes.Unimplemented, errCodeRouteNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var trailer metadata.MD
+			err := tt.call(&trailer)
+			if got := status.Code(err); got != tt.code {
+				t.Errorf("code = %v, want %v (%v)", got, tt.code, err)
+			}
+			if got := trailer.Get(grpcErrorCodeTrailer); len(got) != 1 || got[0] != tt.errorCode {
+				t.Errorf("error-code trailer = %v, want %s", got, tt.errorCode)
+			}
+		})
+	}
+}
+
+func TestGRPCStreamBatchLookup(t *testing.T) {
+	useFakeProvider(t, map[string]any{"country_code": "GB"})
+	client := iplookupv1.NewIPLookupClient(startGRPCServer(t))
+
+	stream, err := client.StreamBatchLookup(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range []string{"81.2.69.142", "x"} {
+		if err := stream.Send(&iplookupv1.LookupRequest{Ip: ip}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stream.CloseSend()
+
+	var results []*iplookupv1.BatchLookupResult
+	for {
+		result, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, result)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+	if results[0].GetRecord().GetCountryCode() != "GB" {
+		t.Errorf("results[0] = %v, want a GB record", results[0])
+	}
+	if results[1].GetRecord() != nil || results[1].GetError() == "" {
+		t.Errorf("results[1] = %v, want an error", results[1])
+	}
+}
+
+func TestGRPCBatchLookupSize(t *testing.T) {
+	useFakeProvider(t, map[string]any{"country_code": "GB"})
+	client := iplookupv1.NewIPLookupClient(startGRPCServer(t))
+
+	tests := []struct {
+		maxSize int
+		ips     int
+		code    codes.Code
+	}{
+		{2, 2, codes.OK},
+		{2, 3, codes.InvalidArgument},
+		{0, 1, codes.Unimplemented},
+	}
+	for _, tt := range tests {
+		useConfig(t, Config{BatchLookupMaxSize: tt.maxSize})
+		resp, err := client.BatchLookup(context.Background(), &iplookupv1.BatchLookupRequest{Ips: make([]string, tt.ips)})
+		if got := status.Code(err); got != tt.code {
+			t.Errorf("BATCH_LOOKUP_MAX_SIZE=%d, %d IPs: code = %v, want %v (%v)", tt.maxSize, tt.ips, got, tt.code, err)
+		}
+		if err == nil && len(resp.GetResults()) != tt.ips {
+			t.Errorf("BATCH_LOOKUP_MAX_SIZE=%d: %d results, want %d", tt.maxSize, len(resp.GetResults()), tt.ips)
+		}
+	}
+}
+
+func TestGRPCLookupMissBehavior(t *testing.T) {
+	useFakeProvider(t, nil)
+	useConfig(t, Config{MissBehavior: missBehaviorNull})
+	client := iplookupv1.NewIPLookupClient(startGRPCServer(t))
+
+	resp, err := client.Lookup(context.Background(), &iplookupv1.LookupRequest{Ip: "81.2.69.142"})
+	if err != nil || resp.GetIp() != "81.2.69.142" || resp.GetCountryCode() != "" {
+		t.Errorf("Lookup of an IP without a record = %v, %v, want the empty record", resp, err)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// runHeartbeat pings url every interval until ctx is cancelled. Pings are only
+// sent while the service is healthy, so an external dead man's switch
+// (healthchecks.io, Cronitor, Uptime Kuma push monitors, ...) notices when
+// the process hangs, dies, or loses its database.
+func runHeartbeat(ctx context.Context, url string, interval time.Duration) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := sendHeartbeat(ctx, client, url); err != nil && ctx.Err() == nil {
+			log.Printf("Heartbeat ping failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func sendHeartbeat(ctx context.Context, client *http.Client, url string) error {
+	if !databaseLoaded() {
+		return fmt.Errorf("skipping ping: GeoIP database not loaded")
+	}
+	built := databaseBuildTime()
+	body, err := json.Marshal(map[string]any{
+		"status":         "ok",
+		"database_type":  databaseType(),
+		"db_build_epoch": built.Unix(),
+		"db_age_seconds": int64(time.Since(built).Seconds()),
+		"uptime_seconds": int64(time.Since(stats.started).Seconds()),
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("monitor returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// stageBuckets are the upper bounds, in seconds, of the lookup stage
+// histograms. Local stages take microseconds; the cache stage can include a
+// fetch from a groupcache peer.
+var stageBuckets = []float64{
+	0.000001, 0.0000025, 0.000005, 0.00001, 0.000025, 0.00005, 0.0001, 0.00025, 0.0005,
+	0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1,
+}
+
+// histogram is a Prometheus-style latency histogram with fixed buckets,
+// updated with atomics so observing is cheap on the request path.
+type histogram struct {
+	bounds []float64
+	// counts[i] counts observations <= bounds[i] and > bounds[i-1]; the last
+	// element counts those above every bound.
+	counts []atomic.Uint64
+	sumNs  atomic.Uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]atomic.Uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	seconds := d.Seconds()
+	i := 0
+	for i < len(h.bounds) && seconds > h.bounds[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.sumNs.Add(uint64(max(d, 0)))
+}
+
+// since observes the time elapsed since start.
+func (h *histogram) since(start time.Time) {
+	h.observe(time.Since(start))
+}
+
+// write emits the histogram's cumulative buckets, sum and count as samples of
+// name, with labels added to each.
+func (h *histogram) write(m *metricsWriter, name string, labels ...string) {
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i].Load()
+		m.sample(name+"_bucket", float64(cumulative), append(labels, "le", strconv.FormatFloat(bound, 'g', -1, 64))...)
+	}
+	cumulative += h.counts[len(h.bounds)].Load()
+	m.sample(name+"_bucket", float64(cumulative), append(labels, "le", "+Inf")...)
+	m.sample(name+"_sum", time.Duration(h.sumNs.Load()).Seconds(), labels...)
+	m.sample(name+"_count", float64(cumulative), labels...)
+}
+
+// Lookup stages timed separately, so a latency regression can be pinned to
+// one of them.
+var (
+	// stageClientIP resolves the caller's IP from proxy headers for /lookup/.
+	stageClientIP = newHistogram(stageBuckets)
+	// stageCache is the shared cache check, including any peer fetch.
+	stageCache = newHistogram(stageBuckets)
+	// stageDecode is each read of a record from the mmdb database.
+	stageDecode = newHistogram(stageBuckets)
+	// stageEncode is the JSON encoding of lookup responses.
+	stageEncode = newHistogram(stageBuckets)
+)
+
+var lookupStages = []struct {
+	name string
+	h    *histogram
+}{
+	{"client_ip", stageClientIP},
+	{"cache", stageCache},
+	{"decode", stageDecode},
+	{"encode", stageEncode},
+}
+
+func writeStageMetrics(m *metricsWriter) {
+	m.family("ip_lookup_stage_duration_seconds", "histogram", "Duration of the individual lookup stages.")
+	for _, s := range lookupStages {
+		s.h.write(m, "ip_lookup_stage_duration_seconds", "stage", s.name)
+	}
+}
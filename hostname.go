@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxHostnameAddrs caps the addresses of a hostname that are looked up.
+const maxHostnameAddrs = 100
+
+// hostnameResolver resolves the hostnames looked up in place of an IP.
+type hostnameResolver struct {
+	resolver *net.Resolver
+	timeout  time.Duration
+}
+
+// hostnames is the resolver of hostname lookups; nil when
+// HOSTNAME_LOOKUP_ENABLED is off.
+var hostnames *hostnameResolver
+
+// newHostnameResolver returns a resolver querying the DNS server at addr,
+// or the system resolver when addr is empty.
+func newHostnameResolver(addr string, timeout time.Duration) *hostnameResolver {
+	resolver := net.DefaultResolver
+	if addr != "" {
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		}
+	}
+	return &hostnameResolver{resolver: resolver, timeout: timeout}
+}
+
+// resolve returns the A and AAAA addresses of host, at most
+// maxHostnameAddrs of them.
+func (h *hostnameResolver) resolve(ctx context.Context, host string) ([]net.IP, error) {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	addrs, err := h.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, 0, min(len(addrs), maxHostnameAddrs))
+	for _, addr := range addrs {
+		if len(ips) == maxHostnameAddrs {
+			break
+		}
+		ips = append(ips, addr.IP)
+	}
+	return ips, nil
+}
+
+// validHostname reports whether s is a fully qualified hostname: dot-separated
+// labels of letters, digits and hyphens, with a top-level label that is not
+// all digits. Single-label names are rejected, so lookups cannot probe the
+// search domains of the resolver.
+func validHostname(s string) bool {
+	s = strings.TrimSuffix(s, ".")
+	if len(s) > 253 || !strings.Contains(s, ".") {
+		return false
+	}
+	labels := strings.Split(s, ".")
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return strings.Trim(labels[len(labels)-1], "0123456789") != ""
+}
+
+// writeHostnameLookup answers /lookup/{hostname}: the hostname and a result
+// for each of its addresses, shaped like the items of a batch lookup.
+func writeHostnameLookup(w http.ResponseWriter, r *http.Request, host string) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	ips, err := hostnames.resolve(r.Context(), host)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		writeJSONError(w, fmt.Sprintf("Hostname has no A or AAAA records: %s", host), http.StatusNotFound, errCodeNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Resolving hostname %s failed: %v", host, err)
+		writeJSONError(w, fmt.Sprintf("Could not resolve hostname: %s", host), http.StatusBadGateway, errCodeUpstreamUnavailable)
+		return
+	}
+	results := make([]map[string]any, len(ips))
+	for i, ip := range ips {
+		results[i], _ = lookupBatchItem(r.Context(), ip.String())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]any{"hostname": host, "results": results}); err != nil {
+		log.Printf("Error encoding JSON response for hostname %s: %v", host, err)
+	}
+}
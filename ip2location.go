@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ip2locationHeaderSize is the length of a BIN file's header.
+const ip2locationHeaderSize = 64
+
+// ip2locationColumns give, for each IP2Location database type (DB1 to DB26),
+// the 1-based column of a field in a row; 0 when the type lacks the field.
+// Column 1 is the start of the range.
+var ip2locationColumns = map[string][27]uint8{
+	"country":   {0, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2},
+	"region":    {0, 0, 0, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3},
+	"city":      {0, 0, 0, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4},
+	"isp":       {0, 0, 3, 0, 5, 0, 7, 5, 7, 0, 8, 0, 9, 0, 9, 0, 9, 0, 9, 7, 9, 0, 9, 7, 9, 9, 9},
+	"latitude":  {0, 0, 0, 0, 0, 5, 5, 0, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5},
+	"longitude": {0, 0, 0, 0, 0, 6, 6, 0, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6},
+	"domain":    {0, 0, 0, 0, 0, 0, 0, 6, 8, 0, 9, 0, 10, 0, 10, 0, 10, 0, 10, 8, 10, 0, 10, 8, 10, 10, 10},
+	"zipcode":   {0, 0, 0, 0, 0, 0, 0, 0, 0, 7, 7, 7, 7, 0, 7, 7, 7, 0, 7, 0, 7, 7, 7, 0, 7, 7, 7},
+	"timezone":  {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 8, 8, 7, 8, 8, 8, 7, 8, 0, 8, 8, 8, 0, 8, 8, 8},
+	"mcc":       {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 9, 16, 0, 16, 9, 16, 16, 16},
+	"mnc":       {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 10, 17, 0, 17, 10, 17, 17, 17},
+}
+
+// ip2locationStringFields map the string columns to lookup fields. The
+// country column also points at the country name, 3 bytes after the code.
+var ip2locationStringFields = map[string]string{
+	"region":   "subdivision_name",
+	"city":     "city",
+	"zipcode":  "postal_code",
+	"timezone": "utc_offset",
+	"isp":      "isp",
+	"domain":   "domain",
+	"mcc":      "mobile_country_code",
+	"mnc":      "mobile_network_code",
+}
+
+// ip2locationFile is an open IP2Location BIN database. Rows are sorted by the
+// start of their range; each range ends where the next row's starts.
+type ip2locationFile struct {
+	f         *os.File
+	dbType    uint8
+	columns   uint8
+	built     time.Time
+	ipv4      ip2locationTable
+	ipv6      ip2locationTable
+	packageID string
+}
+
+// ip2locationTable is the IPv4 or IPv6 section of a BIN file. Offsets are
+// 1-based, as stored in the header.
+type ip2locationTable struct {
+	count     uint32
+	base      uint32
+	indexBase uint32
+	// addrSize is the width of the range start: 4 or 16 bytes.
+	addrSize int
+	rowSize  int
+}
+
+// openIP2Location opens and checks the BIN database at path.
+func openIP2Location(path string) (*ip2locationFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, ip2locationHeaderSize)
+	if _, err := f.ReadAt(header, 0); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: reading the header: %v", path, err)
+	}
+	db := &ip2locationFile{
+		f:       f,
+		dbType:  header[0],
+		columns: header[1],
+		built:   time.Date(2000+int(header[2]), time.Month(header[3]), int(header[4]), 0, 0, 0, 0, time.UTC),
+	}
+	u32 := func(offset int) uint32 { return binary.LittleEndian.Uint32(header[offset:]) }
+	db.ipv4 = ip2locationTable{count: u32(5), base: u32(9), indexBase: u32(21), addrSize: 4}
+	db.ipv6 = ip2locationTable{count: u32(13), base: u32(17), indexBase: u32(25), addrSize: 16}
+	db.ipv4.rowSize = int(db.columns) * 4
+	db.ipv6.rowSize = 16 + (int(db.columns)-1)*4
+	db.packageID = fmt.Sprintf("DB%d", db.dbType)
+	if db.dbType < 1 || db.dbType > 26 || db.columns < 2 || header[3] < 1 || header[3] > 12 || db.ipv4.count == 0 && db.ipv6.count == 0 {
+		f.Close()
+		// MMDB and CSV files fail here; the check catches the common mistakes.
+		return nil, fmt.Errorf("%s is not an IP2Location BIN database", path)
+	}
+	return db, nil
+}
+
+func (db *ip2locationFile) Close() error {
+	return db.f.Close()
+}
+
+// lookup returns the lookup fields of ip, or nil when no range holds it.
+func (db *ip2locationFile) lookup(ip net.IP) (map[string]any, error) {
+	table, addr := db.ipv6, ip.To16()
+	if ip4 := ip.To4(); ip4 != nil {
+		table, addr = db.ipv4, ip4
+	}
+	row, err := db.findRow(table, addr)
+	if err != nil || row == nil {
+		return nil, err
+	}
+
+	col := func(field string) (int, bool) {
+		n := int(ip2locationColumns[field][db.dbType])
+		// The range start is column 1, so column n is at addrSize + (n-2)*4.
+		return table.addrSize + (n-2)*4, n > 1 && n <= int(db.columns)
+	}
+	offset, _ := col("country")
+	pointer := binary.LittleEndian.Uint32(row[offset:])
+	countryCode, err := db.readString(pointer)
+	if err != nil {
+		return nil, err
+	}
+	// Reserved and unallocated ranges have "-" for every field.
+	if countryCode == "-" || countryCode == "" {
+		return nil, nil
+	}
+	countryName, err := db.readString(pointer + 3)
+	if err != nil {
+		return nil, err
+	}
+	response := map[string]any{
+		"ip":           ip.String(),
+		"country_code": countryCode,
+		"country_name": countryName,
+	}
+	for field, name := range ip2locationStringFields {
+		offset, ok := col(field)
+		if !ok {
+			continue
+		}
+		value, err := db.readString(binary.LittleEndian.Uint32(row[offset:]))
+		if err != nil {
+			return nil, err
+		}
+		if value != "" && value != "-" {
+			response[name] = value
+		}
+	}
+	latOffset, hasLat := col("latitude")
+	lonOffset, hasLon := col("longitude")
+	if hasLat && hasLon {
+		lat := math.Float32frombits(binary.LittleEndian.Uint32(row[latOffset:]))
+		lon := math.Float32frombits(binary.LittleEndian.Uint32(row[lonOffset:]))
+		response["latitude"] = roundCoordinate(float32Decimal(lat), appConfig.CoordinatePrecision)
+		response["longitude"] = roundCoordinate(float32Decimal(lon), appConfig.CoordinatePrecision)
+	}
+	return response, nil
+}
+
+// findRow binary searches table for the row whose range holds addr, using
+// the index of the first 16 address bits to narrow the search when the file
+// has one. It returns the row's bytes.
+func (db *ip2locationFile) findRow(table ip2locationTable, addr net.IP) ([]byte, error) {
+	if table.count == 0 {
+		return nil, nil
+	}
+	low, high := uint32(0), table.count-1
+	if table.indexBase > 0 {
+		entry := make([]byte, 8)
+		prefix := uint32(addr[0])<<8 | uint32(addr[1])
+		if _, err := db.f.ReadAt(entry, int64(table.indexBase-1)+int64(prefix)*8); err != nil {
+			return nil, fmt.Errorf("reading the IP2Location index: %v", err)
+		}
+		low = binary.LittleEndian.Uint32(entry)
+		high = min(binary.LittleEndian.Uint32(entry[4:]), table.count-1)
+	}
+	// A row and the start of the next one, which ends its range.
+	buf := make([]byte, table.rowSize+table.addrSize)
+	for low <= high {
+		mid := low + (high-low)/2
+		n, err := db.f.ReadAt(buf, int64(table.base-1)+int64(mid)*int64(table.rowSize))
+		if n < table.rowSize {
+			return nil, fmt.Errorf("reading IP2Location row %d: %v", mid, err)
+		}
+		// The last row's range runs to the end of the address space.
+		for i := n; i < len(buf); i++ {
+			buf[i] = 0xff
+		}
+		switch {
+		case compareIP2LocationAddr(addr, buf[:table.addrSize]) < 0:
+			if mid == 0 {
+				return nil, nil
+			}
+			high = mid - 1
+		case compareIP2LocationAddr(addr, buf[table.rowSize:]) >= 0:
+			low = mid + 1
+		default:
+			return buf[:table.rowSize], nil
+		}
+	}
+	return nil, nil
+}
+
+// compareIP2LocationAddr compares a big-endian address with a little-endian
+// one from a row, of the same width.
+func compareIP2LocationAddr(addr net.IP, stored []byte) int {
+	for i := range addr {
+		a, b := addr[i], stored[len(addr)-1-i]
+		if a != b {
+			if a < b {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// float32Decimal returns the shortest decimal that reads back as f. Converting
+// f itself adds the float32 noise, which rounding to the 6 decimals
+// IP2Location publishes does not remove: 51.5142 would be 51.514198.
+func float32Decimal(f float32) float64 {
+	v, _ := strconv.ParseFloat(strconv.FormatFloat(float64(f), 'g', -1, 32), 64)
+	return v
+}
+
+// readString reads the length-prefixed string at the 0-based offset pointer.
+func (db *ip2locationFile) readString(pointer uint32) (string, error) {
+	// Strings are at most 255 bytes; read them in one call.
+	buf := make([]byte, 256)
+	n, err := db.f.ReadAt(buf, int64(pointer))
+	if n == 0 {
+		return "", fmt.Errorf("reading an IP2Location string: %v", err)
+	}
+	length := int(buf[0])
+	if length > n-1 {
+		return "", errors.New("reading an IP2Location string: truncated file")
+	}
+	return string(buf[1 : 1+length]), nil
+}
+
+// ip2locationProvider serves lookups from the BIN database at path, which
+// SIGHUP reopens.
+type ip2locationProvider struct {
+	path string
+	db   atomic.Pointer[ip2locationFile]
+}
+
+func newIP2LocationProvider(path string) (*ip2locationProvider, error) {
+	p := &ip2locationProvider{path: path}
+	if err := p.reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *ip2locationProvider) lookup(ctx context.Context, ip net.IP) (map[string]any, error) {
+	return p.db.Load().lookup(ip)
+}
+
+func (p *ip2locationProvider) loaded() bool {
+	return p.db.Load() != nil
+}
+
+func (p *ip2locationProvider) databaseType() string {
+	return "IP2Location-" + p.db.Load().packageID
+}
+
+func (p *ip2locationProvider) buildTime() time.Time {
+	return p.db.Load().built
+}
+
+func (p *ip2locationProvider) metadata() map[string]any {
+	db := p.db.Load()
+	ipVersion := 4
+	if db.ipv6.count > 0 {
+		ipVersion = 6
+	}
+	return map[string]any{
+		"database_type": p.databaseType(),
+		"build_time":    db.built.Format(time.RFC3339),
+		"age_days":      int(time.Since(db.built).Hours() / 24),
+		"ip_version":    ipVersion,
+		"ipv4_ranges":   db.ipv4.count,
+		"ipv6_ranges":   db.ipv6.count,
+	}
+}
+
+// reload opens p.path and swaps it in; the replaced file is closed after
+// dbCloseGrace.
+func (p *ip2locationProvider) reload() error {
+	db, err := openIP2Location(p.path)
+	if err != nil {
+		return err
+	}
+	if old := p.db.Swap(db); old != nil {
+		time.AfterFunc(dbCloseGrace, func() { old.Close() })
+	}
+	log.Printf("Loaded IP2Location %s database built %s from %s.", db.packageID, db.built.Format(time.DateOnly), p.path)
+	return nil
+}
+
+func (p *ip2locationProvider) Close() error {
+	return p.db.Load().Close()
+}
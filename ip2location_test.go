@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// ip2locationTestRow is an IPv4 row of a DB5 file: country, region, city,
+// latitude and longitude.
+type ip2locationTestRow struct {
+	start               string
+	country, name       string
+	region, city        string
+	latitude, longitude float32
+}
+
+// ip2locationDB5RowSize is the size of an IPv4 row of a DB5 file.
+const ip2locationDB5RowSize = 6 * 4
+
+// buildIP2LocationDB5 encodes rows as a DB5 BIN file without an index. The
+// rows start right after the header and the strings right after the rows.
+func buildIP2LocationDB5(rows []ip2locationTestRow) []byte {
+	header := make([]byte, ip2locationHeaderSize)
+	header[0], header[1] = 5, 6
+	header[2], header[3], header[4] = 24, 6, 1
+	binary.LittleEndian.PutUint32(header[5:], uint32(len(rows)))
+	binary.LittleEndian.PutUint32(header[9:], ip2locationHeaderSize+1)
+
+	table := make([]byte, 0, len(rows)*ip2locationDB5RowSize)
+	var strs []byte
+	stringsBase := uint32(ip2locationHeaderSize + len(rows)*ip2locationDB5RowSize)
+	addString := func(s string) uint32 {
+		pointer := stringsBase + uint32(len(strs))
+		strs = append(strs, byte(len(s)))
+		strs = append(strs, s...)
+		return pointer
+	}
+	for _, row := range rows {
+		start := net.ParseIP(row.start).To4()
+		table = binary.LittleEndian.AppendUint32(table, binary.BigEndian.Uint32(start))
+		// The country name follows the code, which is padded to 2 bytes.
+		country := addString(row.country + strings.Repeat("-", 2-len(row.country)))
+		addString(row.name)
+		table = binary.LittleEndian.AppendUint32(table, country)
+		table = binary.LittleEndian.AppendUint32(table, addString(row.region))
+		table = binary.LittleEndian.AppendUint32(table, addString(row.city))
+		table = binary.LittleEndian.AppendUint32(table, math.Float32bits(row.latitude))
+		table = binary.LittleEndian.AppendUint32(table, math.Float32bits(row.longitude))
+	}
+	return append(append(header, table...), strs...)
+}
+
+var ip2locationTestRows = []ip2locationTestRow{
+	{start: "1.0.0.0", country: "-", name: "-", region: "-", city: "-"},
+	{start: "81.2.69.0", country: "GB", name: "United Kingdom", region: "England", city: "London", latitude: 51.5142, longitude: -0.0931},
+	{start: "81.2.70.0", country: "-", name: "-", region: "-", city: "-"},
+}
+
+// writeIP2LocationFile writes b to a file in a temporary directory and
+// returns its path.
+func writeIP2LocationFile(t *testing.T, b []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "IP2LOCATION-DB5.BIN")
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestIP2LocationLookup(t *testing.T) {
+	useConfig(t, Config{CoordinatePrecision: -1})
+	db, err := openIP2Location(writeIP2LocationFile(t, buildIP2LocationDB5(ip2locationTestRows)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if db.packageID != "DB5" || db.built.Format("2006-01-02") != "2024-06-01" {
+		t.Errorf("opened %s built %s, want DB5 built 2024-06-01", db.packageID, db.built)
+	}
+
+	london := map[string]any{
+		"ip":               "81.2.69.142",
+		"country_code":     "GB",
+		"country_name":     "United Kingdom",
+		"subdivision_name": "England",
+		"city":             "London",
+		"latitude":         51.5142,
+		"longitude":        -0.0931,
+	}
+	if got, err := db.lookup(net.ParseIP("81.2.69.142")); err != nil || !reflect.DeepEqual(got, london) {
+		t.Errorf("lookup(81.2.69.142) = %v, %v, want %v", got, err, london)
+	}
+
+	tests := []struct {
+		ip string
+		// country is the country code of the range holding ip; "" when none.
+		country string
+	}{
+		{"81.2.69.0", "GB"},
+		{"81.2.69.255", "GB"},
+		// Ranges of "-" are unallocated, and the first one starts at 1.0.0.0.
+		{"81.2.70.1", ""},
+		{"255.255.255.255", ""},
+		{"0.0.0.1", ""},
+		// The file has no IPv6 ranges.
+		{"2001:db8::1", ""},
+	}
+	for _, tt := range tests {
+		got, err := db.lookup(net.ParseIP(tt.ip))
+		switch {
+		case err != nil:
+			t.Errorf("lookup(%s): %v", tt.ip, err)
+		case tt.country == "" && got != nil:
+			t.Errorf("lookup(%s) = %v, want no result", tt.ip, got)
+		case tt.country != "" && (got == nil || got["country_code"] != tt.country):
+			t.Errorf("lookup(%s) = %v, want country %s", tt.ip, got, tt.country)
+		}
+	}
+}
+
+func TestOpenIP2LocationRejectsMalformedFiles(t *testing.T) {
+	valid := buildIP2LocationDB5(ip2locationTestRows)
+	with := func(offset int, b ...byte) []byte {
+		file := append([]byte(nil), valid...)
+		copy(file[offset:], b)
+		return file
+	}
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"empty", nil},
+		{"truncated header", valid[:ip2locationHeaderSize-1]},
+		{"an MMDB file", append(make([]byte, ip2locationHeaderSize), "\xab\xcd\xefMaxMind.com"...)},
+		{"a CSV file", []byte(strings.Repeat(`"16777216","16777471","AU","Australia"`+"\n", 4))},
+		{"database type 0", with(0, 0)},
+		{"database type 27", with(0, 27)},
+		{"one column", with(1, 1)},
+		{"month 0", with(3, 0)},
+		{"month 13", with(3, 13)},
+		{"no ranges", with(5, 0, 0, 0, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if db, err := openIP2Location(writeIP2LocationFile(t, tt.file)); err == nil {
+				db.Close()
+				t.Error("openIP2Location accepted the file")
+			}
+		})
+	}
+}
+
+func TestIP2LocationLookupOfTruncatedFiles(t *testing.T) {
+	valid := buildIP2LocationDB5(ip2locationTestRows)
+	// The offset of the GB row and of its city pointer.
+	row := ip2locationHeaderSize + ip2locationDB5RowSize
+	city := row + 4 + (4-2)*4
+	with := func(offset int, v uint32) []byte {
+		file := append([]byte(nil), valid...)
+		binary.LittleEndian.PutUint32(file[offset:], v)
+		return file
+	}
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"rows cut short", valid[:row+ip2locationDB5RowSize/2]},
+		{"no strings", valid[:ip2locationHeaderSize+len(ip2locationTestRows)*ip2locationDB5RowSize]},
+		{"string past the end", with(city, math.MaxInt32)},
+		// The city's length byte is the last byte of the file.
+		{"string longer than the file", append(with(city, uint32(len(valid))), 200)},
+		{"rows past the end", with(9, math.MaxInt32)},
+		{"rows before the start", with(9, 0)},
+		{"index past the end", with(21, math.MaxInt32)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := openIP2Location(writeIP2LocationFile(t, tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			if got, err := db.lookup(net.ParseIP("81.2.69.142")); err == nil {
+				t.Errorf("lookup = %v, want an error", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// ipinfoCompatibleType returns the MaxMind database type an ipinfo.io
+// database stands in for. ipinfo types name the file, e.g. "ipinfo
+// standard_location.mmdb", "ipinfo country_asn.mmdb" or "ipinfo asn.mmdb".
+func ipinfoCompatibleType(dbType string) (string, bool) {
+	lower := strings.ToLower(dbType)
+	if !strings.HasPrefix(lower, "ipinfo") {
+		return "", false
+	}
+	switch {
+	case strings.Contains(lower, "country"), strings.Contains(lower, "lite"):
+		return "GeoLite2-Country", true
+	case strings.Contains(lower, "asn"):
+		return "GeoLite2-ASN", true
+	default:
+		// Location, Core, Plus, ...: the fields an edition lacks stay empty.
+		return "GeoLite2-City", true
+	}
+}
+
+// isIPinfoDatabase reports whether reader is an ipinfo.io database, whose
+// records are flat rather than laid out like MaxMind's.
+func isIPinfoDatabase(reader *maxminddb.Reader) bool {
+	_, ok := ipinfoCompatibleType(reader.Metadata.DatabaseType)
+	return ok
+}
+
+// ipinfoRecord holds the fields of every ipinfo.io edition. The Lite edition
+// has country (the name) and country_code; older editions have country (the
+// code) and country_name, and the same for the continent.
+type ipinfoRecord struct {
+	Country       string `maxminddb:"country"`
+	CountryCode   string `maxminddb:"country_code"`
+	CountryName   string `maxminddb:"country_name"`
+	Continent     string `maxminddb:"continent"`
+	ContinentCode string `maxminddb:"continent_code"`
+	ContinentName string `maxminddb:"continent_name"`
+	City          string `maxminddb:"city"`
+	Region        string `maxminddb:"region"`
+	RegionCode    string `maxminddb:"region_code"`
+	// Coordinates are strings in some editions and numbers in others.
+	Lat        any    `maxminddb:"lat"`
+	Lng        any    `maxminddb:"lng"`
+	PostalCode string `maxminddb:"postal_code"`
+	Timezone   string `maxminddb:"timezone"`
+	ASN        string `maxminddb:"asn"`
+	ASName     string `maxminddb:"as_name"`
+	// Name is the organization in the ASN edition.
+	Name string `maxminddb:"name"`
+}
+
+// lookupCityRecord reads the City record for ip from reader into record,
+// adapting ipinfo.io records to the MaxMind layout.
+func lookupCityRecord(reader *maxminddb.Reader, ip net.IP, record *geoip2.City) (network *net.IPNet, found bool, err error) {
+	if !isIPinfoDatabase(reader) {
+		return reader.LookupNetwork(ip, record)
+	}
+	var info ipinfoRecord
+	if network, found, err = reader.LookupNetwork(ip, &info); err != nil || !found {
+		return network, found, err
+	}
+	info.cityRecord(record)
+	return network, true, nil
+}
+
+// cityRecord fills record with the fields of r.
+func (r *ipinfoRecord) cityRecord(record *geoip2.City) {
+	countryCode, countryName := r.Country, r.CountryName
+	if r.CountryCode != "" {
+		countryCode, countryName = r.CountryCode, r.Country
+	}
+	continentCode, continentName := r.Continent, r.ContinentName
+	if r.ContinentCode != "" {
+		continentCode, continentName = r.ContinentCode, r.Continent
+	}
+	record.Country.IsoCode = countryCode
+	record.Country.Names = englishName(countryName)
+	// ipinfo.io records have no EU flag.
+	record.Country.IsInEuropeanUnion = euMemberStates[countryCode]
+	record.Continent.Code = continentCode
+	record.Continent.Names = englishName(continentName)
+	record.City.Names = englishName(r.City)
+	if r.Region != "" {
+		record.Subdivisions = slices.Grow(record.Subdivisions, 1)[:1]
+		record.Subdivisions[0].IsoCode = r.RegionCode
+		record.Subdivisions[0].Names = englishName(r.Region)
+	}
+	record.Location.Latitude = ipinfoCoordinate(r.Lat)
+	record.Location.Longitude = ipinfoCoordinate(r.Lng)
+	record.Location.TimeZone = r.Timezone
+	record.Postal.Code = r.PostalCode
+}
+
+// asnRecord returns the autonomous system of r; the number is 0 when r has
+// none.
+func (r *ipinfoRecord) asnRecord() geoip2.ASN {
+	number, _ := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(r.ASN), "AS"), 10, 32)
+	organization := r.ASName
+	if organization == "" {
+		organization = r.Name
+	}
+	return geoip2.ASN{AutonomousSystemNumber: uint(number), AutonomousSystemOrganization: organization}
+}
+
+// lookupASNRecord reads the ASN record for ip from reader into record,
+// adapting ipinfo.io records to the MaxMind layout, and returns the network
+// of the record.
+func lookupASNRecord(reader *maxminddb.Reader, ip net.IP, record *geoip2.ASN) (network *net.IPNet, found bool, err error) {
+	if !isIPinfoDatabase(reader) {
+		return reader.LookupNetwork(ip, record)
+	}
+	var info ipinfoRecord
+	if network, found, err = reader.LookupNetwork(ip, &info); err != nil || !found {
+		return network, found, err
+	}
+	*record = info.asnRecord()
+	return network, true, nil
+}
+
+// addIPinfoFields adds the autonomous system fields the ipinfo.io Lite and
+// country_asn editions carry next to the location. Supplementary databases
+// added later take precedence.
+func addIPinfoFields(reader *maxminddb.Reader, ip net.IP, response map[string]any) error {
+	var info ipinfoRecord
+	if _, found, err := reader.LookupNetwork(ip, &info); err != nil || !found {
+		return err
+	}
+	asn := info.asnRecord()
+	if asn.AutonomousSystemNumber == 0 {
+		return nil
+	}
+	response["autonomous_system_number"] = asn.AutonomousSystemNumber
+	response["autonomous_system_organization"] = asn.AutonomousSystemOrganization
+	return nil
+}
+
+// englishName returns the names map of a record with only an English name,
+// or nil when name is empty.
+func englishName(name string) map[string]string {
+	if name == "" {
+		return nil
+	}
+	return map[string]string{"en": name}
+}
+
+// ipinfoCoordinate returns a latitude or longitude stored as a string or a
+// number, or 0 when it is missing or malformed.
+func ipinfoCoordinate(v any) float64 {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case float32:
+		return float64(v)
+	case string:
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	}
+	return 0
+}
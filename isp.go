@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net"
+
+	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// ispDB is the optional GeoIP2 ISP database (GEOIP_ISP_DB_PATH).
+var ispDB = &supplementaryDB{
+	name: "ISP",
+	types: map[string]bool{
+		"GeoIP2-ISP":           true,
+		"GeoIP2-Precision-ISP": true,
+	},
+	want:      "a GeoIP2-ISP database",
+	addFields: addISPFields,
+}
+
+// addISPFields sets the ISP, organization and mobile carrier fields of ip
+// that the database has, and its autonomous system.
+func addISPFields(reader *maxminddb.Reader, ip net.IP, response map[string]any) error {
+	var record geoip2.ISP
+	_, found, err := reader.LookupNetwork(ip, &record)
+	if err != nil || !found {
+		return err
+	}
+	for field, value := range map[string]string{
+		"isp":                            record.ISP,
+		"organization":                   record.Organization,
+		"mobile_country_code":            record.MobileCountryCode,
+		"mobile_network_code":            record.MobileNetworkCode,
+		"autonomous_system_organization": record.AutonomousSystemOrganization,
+	} {
+		if value != "" {
+			response[field] = value
+		}
+	}
+	if record.AutonomousSystemNumber != 0 {
+		response["autonomous_system_number"] = record.AutonomousSystemNumber
+	}
+	return nil
+}
@@ -0,0 +1,527 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Job statuses.
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+)
+
+// Job formats: newline-delimited IPs answered with NDJSON, as by POST
+// /lookup/stream, or a CSV answered with geo columns appended, as by POST
+// /lookup/csv.
+const (
+	jobFormatNDJSON = "ndjson"
+	jobFormatCSV    = "csv"
+)
+
+// job is a bulk lookup submitted to POST /jobs. Its exported fields are
+// persisted next to its input and result, and guarded by the mutex of
+// jobQueue.
+type job struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	Format     string     `json:"format"`
+	Query      string     `json:"query,omitempty"`
+	InputSize  int64      `json:"input_size"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	// read is the number of input bytes processed while the job runs.
+	read atomic.Int64
+}
+
+// jobQueue runs the jobs of JOBS_DIR on a fixed number of workers. Every
+// job is kept on disk, so jobs queued or running when the process stops are
+// run again from the start when it restarts.
+type jobQueue struct {
+	dir            string
+	maxUploadBytes int64
+	retention      time.Duration
+	mu             sync.Mutex
+	jobs           map[string]*job
+	pending        chan *job
+}
+
+// jobs is the process-wide job queue; nil when JOBS_DIR is not set.
+var jobs *jobQueue
+
+// newJobQueue opens dir, creating it if needed, and queues again the jobs
+// that had not finished.
+func newJobQueue(dir string, maxQueued int, maxUploadBytes int64, retention time.Duration) (*jobQueue, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	q := &jobQueue{dir: dir, maxUploadBytes: maxUploadBytes, retention: retention, jobs: make(map[string]*job)}
+	var unfinished []*job
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		j := &job{}
+		if err := json.Unmarshal(data, j); err != nil {
+			log.Printf("Skipping unreadable job file %s: %v", path, err)
+			continue
+		}
+		if err := checkJobFile(j, path); err != nil {
+			log.Printf("Skipping invalid job file %s: %v", path, err)
+			continue
+		}
+		q.jobs[j.ID] = j
+		if j.Status == jobQueued || j.Status == jobRunning {
+			j.Status, j.StartedAt = jobQueued, nil
+			unfinished = append(unfinished, j)
+		}
+	}
+	sort.Slice(unfinished, func(a, b int) bool { return unfinished[a].CreatedAt.Before(unfinished[b].CreatedAt) })
+	q.pending = make(chan *job, maxQueued+len(unfinished))
+	for _, j := range unfinished {
+		q.pending <- j
+	}
+	if len(unfinished) > 0 {
+		log.Printf("Resuming %d unfinished jobs from %s.", len(unfinished), dir)
+	}
+	return q, nil
+}
+
+// checkJobFile checks the metadata j read from path, whose ID names the
+// files of the job and so must be that of path.
+func checkJobFile(j *job, path string) error {
+	if id := strings.TrimSuffix(filepath.Base(path), ".json"); j.ID != id {
+		return fmt.Errorf("the job ID %q is not that of the file, %q", j.ID, id)
+	}
+	switch j.Status {
+	case jobQueued, jobRunning:
+	case jobSucceeded, jobFailed:
+		// Without it, the job would never expire.
+		if j.FinishedAt == nil {
+			return fmt.Errorf("the job is %s but has no finished_at", j.Status)
+		}
+	default:
+		return fmt.Errorf("unknown job status %q", j.Status)
+	}
+	if j.Format != jobFormatNDJSON && j.Format != jobFormatCSV {
+		return fmt.Errorf("unknown job format %q", j.Format)
+	}
+	return nil
+}
+
+func (q *jobQueue) path(id, ext string) string {
+	return filepath.Join(q.dir, id+ext)
+}
+
+// save writes the metadata of j. The caller holds q.mu.
+func (q *jobQueue) save(j *job) error {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	tmp := q.path(j.ID, ".json.tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path(j.ID, ".json"))
+}
+
+// run starts workers that run queued jobs until ctx is done, and removes
+// expired jobs. A job interrupted by ctx stays running on disk, so it is run
+// again after a restart.
+func (q *jobQueue) run(ctx context.Context, workers int) {
+	for range workers {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case j := <-q.pending:
+					q.runJob(ctx, j)
+				}
+			}
+		}()
+	}
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			q.removeExpired()
+		}
+	}
+}
+
+func (q *jobQueue) runJob(ctx context.Context, j *job) {
+	q.mu.Lock()
+	now := time.Now()
+	j.Status, j.StartedAt = jobRunning, &now
+	if err := q.save(j); err != nil {
+		log.Printf("Could not save job %s: %v", j.ID, err)
+	}
+	q.mu.Unlock()
+
+	err := q.process(ctx, j)
+	if ctx.Err() != nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now = time.Now()
+	j.FinishedAt = &now
+	if err != nil {
+		j.Status, j.Error = jobFailed, err.Error()
+		log.Printf("Job %s failed: %v", j.ID, err)
+	} else {
+		j.Status = jobSucceeded
+	}
+	if err := q.save(j); err != nil {
+		log.Printf("Could not save job %s: %v", j.ID, err)
+		return
+	}
+	// A finished job is not run again, so its input is no longer needed.
+	if err := os.Remove(q.path(j.ID, ".input")); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Could not remove the input of job %s: %v", j.ID, err)
+	}
+}
+
+// process looks up the input of j and writes the result next to it.
+func (q *jobQueue) process(ctx context.Context, j *job) error {
+	if !databaseLoaded() {
+		return errors.New("GeoIP service not available")
+	}
+	f, err := os.Open(q.path(j.ID, ".input"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tmp := q.path(j.ID, ".result.tmp")
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	defer out.Close()
+	w := bufio.NewWriter(out)
+	j.read.Store(0)
+	in := bufio.NewReaderSize(&countingReader{r: f, n: &j.read}, streamLookupMaxLineLength)
+
+	switch j.Format {
+	case jobFormatCSV:
+		query, _ := url.ParseQuery(j.Query)
+		opts, err := parseCSVLookupOptions(query)
+		if err != nil {
+			return err
+		}
+		lookup := newCSVLookup(in, w, opts)
+		if opts.header {
+			if err := lookup.readHeader(); err != nil && err != io.EOF {
+				return fmt.Errorf("invalid CSV: %v", err)
+			}
+		}
+		for ctx.Err() == nil {
+			err := lookup.row(ctx)
+			if err == io.EOF {
+				break
+			}
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				return fmt.Errorf("invalid CSV: %v", err)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if err := lookup.flush(); err != nil {
+			return err
+		}
+	default:
+		enc := json.NewEncoder(w)
+		for ctx.Err() == nil {
+			err := streamLookupLine(ctx, in, enc)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path(j.ID, ".result"))
+}
+
+// removeExpired deletes the jobs that finished more than the retention ago.
+func (q *jobQueue) removeExpired() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for id, j := range q.jobs {
+		if j.FinishedAt == nil || time.Since(*j.FinishedAt) < q.retention {
+			continue
+		}
+		for _, ext := range []string{".json", ".input", ".result"} {
+			if err := os.Remove(q.path(id, ext)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Printf("Could not remove expired job %s: %v", id, err)
+			}
+		}
+		delete(q.jobs, id)
+	}
+}
+
+// countingReader counts the bytes read from r into n.
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// deadlineReader extends the read deadline of a request before every read,
+// so a long upload is only cut off when it stalls.
+type deadlineReader struct {
+	r  io.Reader
+	rc *http.ResponseController
+}
+
+func (d *deadlineReader) Read(p []byte) (int, error) {
+	_ = d.rc.SetReadDeadline(time.Now().Add(streamLookupIdleTimeout))
+	return d.r.Read(p)
+}
+
+// status reports j for GET /jobs/{id}, with links under the API path prefix
+// of the request. The caller holds q.mu.
+func (q *jobQueue) status(j *job, prefix string) map[string]any {
+	status := map[string]any{
+		"id":         j.ID,
+		"status":     j.Status,
+		"format":     j.Format,
+		"input_size": j.InputSize,
+		"created_at": j.CreatedAt.UTC().Format(time.RFC3339),
+	}
+	progress := 0.0
+	switch {
+	case j.Status == jobSucceeded:
+		progress = 1
+	case j.Status == jobRunning && j.InputSize > 0:
+		progress = math.Min(float64(j.read.Load())/float64(j.InputSize), 1)
+	}
+	status["progress"] = math.Floor(progress*1000) / 1000
+	if j.StartedAt != nil {
+		status["started_at"] = j.StartedAt.UTC().Format(time.RFC3339)
+	}
+	if j.FinishedAt != nil {
+		status["finished_at"] = j.FinishedAt.UTC().Format(time.RFC3339)
+		status["expires_at"] = j.FinishedAt.Add(q.retention).UTC().Format(time.RFC3339)
+	}
+	if j.Status == jobSucceeded {
+		status["result_url"] = prefix + "/jobs/" + j.ID + "/result"
+	}
+	if j.Error != "" {
+		status["error"] = j.Error
+	}
+	return status
+}
+
+func writeJobStatus(w http.ResponseWriter, code int, status map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		log.Printf("Error encoding job status response: %v", err)
+	}
+}
+
+// submitJobHandler serves POST /jobs: the body is stored as the input of a
+// new job, which is answered with 202 and the job's status.
+func submitJobHandler(w http.ResponseWriter, r *http.Request) {
+	if !databaseLoaded() {
+		writeJSONError(w, "GeoIP service not available", http.StatusInternalServerError, errCodeDBUnavailable)
+		return
+	}
+	query := r.URL.Query()
+	format := strings.ToLower(strings.TrimSpace(query.Get("format")))
+	switch format {
+	case "":
+		format = jobFormatNDJSON
+	case jobFormatNDJSON:
+	case jobFormatCSV:
+		if _, err := parseCSVLookupOptions(query); err != nil {
+			writeJSONError(w, err.Error(), http.StatusBadRequest, errCodeInvalidRequest)
+			return
+		}
+	default:
+		writeJSONError(w, fmt.Sprintf("Invalid format parameter '%s': must be 'ndjson' or 'csv'", format), http.StatusBadRequest, errCodeInvalidRequest)
+		return
+	}
+	if len(jobs.pending) == cap(jobs.pending) {
+		w.Header().Set("Retry-After", "60")
+		writeJSONError(w, "Too many queued jobs", http.StatusServiceUnavailable, errCodeOverloaded)
+		return
+	}
+
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		log.Printf("Could not generate job ID: %v", err)
+		writeJSONError(w, "Could not create job", http.StatusInternalServerError, errCodeInternal)
+		return
+	}
+	j := &job{ID: hex.EncodeToString(idBytes), Status: jobQueued, Format: format, CreatedAt: time.Now()}
+	if format == jobFormatCSV {
+		j.Query = r.URL.RawQuery
+	}
+	size, err := jobs.storeInput(w, r, j.ID)
+	if err != nil {
+		os.Remove(jobs.path(j.ID, ".input"))
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeJSONError(w, fmt.Sprintf("Job input too large: the maximum is %d MB", jobs.maxUploadBytes>>20), http.StatusRequestEntityTooLarge, errCodePayloadTooLarge)
+			return
+		}
+		log.Printf("Could not store the input of job %s: %v", j.ID, err)
+		writeJSONError(w, "Could not store job input", http.StatusInternalServerError, errCodeInternal)
+		return
+	}
+	j.InputSize = size
+
+	jobs.mu.Lock()
+	if err := jobs.save(j); err != nil {
+		jobs.mu.Unlock()
+		os.Remove(jobs.path(j.ID, ".input"))
+		log.Printf("Could not save job %s: %v", j.ID, err)
+		writeJSONError(w, "Could not create job", http.StatusInternalServerError, errCodeInternal)
+		return
+	}
+	select {
+	case jobs.pending <- j:
+	default:
+		// Other jobs filled the queue during the upload.
+		os.Remove(jobs.path(j.ID, ".json"))
+		jobs.mu.Unlock()
+		os.Remove(jobs.path(j.ID, ".input"))
+		w.Header().Set("Retry-After", "60")
+		writeJSONError(w, "Too many queued jobs", http.StatusServiceUnavailable, errCodeOverloaded)
+		return
+	}
+	jobs.jobs[j.ID] = j
+	status := jobs.status(j, apiPathPrefix(r))
+	jobs.mu.Unlock()
+
+	w.Header().Set("Location", apiPathPrefix(r)+"/jobs/"+j.ID)
+	writeJobStatus(w, http.StatusAccepted, status)
+}
+
+// storeInput writes the body of r to the input file of job id, returning its
+// size.
+func (q *jobQueue) storeInput(w http.ResponseWriter, r *http.Request, id string) (int64, error) {
+	f, err := os.Create(q.path(id, ".input"))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	body := &deadlineReader{r: http.MaxBytesReader(w, r.Body, q.maxUploadBytes), rc: http.NewResponseController(w)}
+	size, err := io.Copy(f, body)
+	if err != nil {
+		return 0, err
+	}
+	return size, f.Close()
+}
+
+// jobStatusHandler serves GET /jobs/{id}.
+func jobStatusHandler(w http.ResponseWriter, r *http.Request) {
+	jobs.mu.Lock()
+	j, ok := jobs.jobs[r.PathValue("id")]
+	var status map[string]any
+	if ok {
+		status = jobs.status(j, apiPathPrefix(r))
+	}
+	jobs.mu.Unlock()
+	if !ok {
+		writeJSONError(w, "Job not found", http.StatusNotFound, errCodeNotFound)
+		return
+	}
+	writeJobStatus(w, http.StatusOK, status)
+}
+
+// jobResultHandler serves GET /jobs/{id}/result: the output of a job that
+// succeeded, with range requests supported for resumable downloads.
+func jobResultHandler(w http.ResponseWriter, r *http.Request) {
+	jobs.mu.Lock()
+	j, ok := jobs.jobs[r.PathValue("id")]
+	var status, format, jobErr string
+	var finished time.Time
+	if ok {
+		status, format, jobErr = j.Status, j.Format, j.Error
+		if j.FinishedAt != nil {
+			finished = *j.FinishedAt
+		}
+	}
+	jobs.mu.Unlock()
+	switch {
+	case !ok:
+		writeJSONError(w, "Job not found", http.StatusNotFound, errCodeNotFound)
+		return
+	case status == jobFailed:
+		writeJSONError(w, fmt.Sprintf("Job failed: %s", jobErr), http.StatusConflict, errCodeJobFailed)
+		return
+	case status != jobSucceeded:
+		writeJSONError(w, fmt.Sprintf("Job is %s; poll /jobs/%s until it succeeds", status, j.ID), http.StatusConflict, errCodeJobNotReady)
+		return
+	}
+	f, err := os.Open(jobs.path(j.ID, ".result"))
+	if err != nil {
+		log.Printf("Could not open the result of job %s: %v", j.ID, err)
+		writeJSONError(w, "Job result not available", http.StatusInternalServerError, errCodeInternal)
+		return
+	}
+	defer f.Close()
+	// Large results take longer than the server's WriteTimeout to download.
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Could not clear write deadline for job result: %v", err)
+	}
+	if format == jobFormatCSV {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	http.ServeContent(w, r, "", finished, f)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewJobQueueSkipsMalformedJobFiles(t *testing.T) {
+	dir := t.TempDir()
+	const finished = `"created_at": "2024-06-01T10:00:00Z", "finished_at": "2024-06-01T10:05:00Z"`
+	files := map[string]string{
+		"queued.json":    `{"id": "queued", "status": "queued", "format": "ndjson", "created_at": "2024-06-01T10:02:00Z"}`,
+		"running.json":   `{"id": "running", "status": "running", "format": "csv", "created_at": "2024-06-01T10:01:00Z", "started_at": "2024-06-01T10:03:00Z"}`,
+		"succeeded.json": `{"id": "succeeded", "status": "succeeded", "format": "csv", ` + finished + `}`,
+		"failed.json":    `{"id": "failed", "status": "failed", "format": "ndjson", "error": "invalid CSV", ` + finished + `}`,
+		// A write interrupted before the rename leaves a temporary file.
+		"partial.json.tmp": `{"id": "partial", "status": "queued", "format": "ndjson"}`,
+
+		"empty.json":            ``,
+		"truncated.json":        `{"id": "truncated", "status": "que`,
+		"array.json":            `[{"id": "array"}]`,
+		"wrong-types.json":      `{"id": "wrong-types", "status": "queued", "format": "ndjson", "input_size": "10"}`,
+		"no-id.json":            `{"status": "queued", "format": "ndjson"}`,
+		"other-id.json":         `{"id": "queued", "status": "failed", "format": "ndjson", ` + finished + `}`,
+		"escape.json":           `{"id": "../escape", "status": "queued", "format": "ndjson"}`,
+		"unknown-status.json":   `{"id": "unknown-status", "status": "cancelled", "format": "ndjson", ` + finished + `}`,
+		"unknown-format.json":   `{"id": "unknown-format", "status": "queued", "format": "xml"}`,
+		"no-finished-time.json": `{"id": "no-finished-time", "status": "succeeded", "format": "csv"}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	q, err := newJobQueue(dir, 10, 1<<20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for id := range q.jobs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	if want := []string{"failed", "queued", "running", "succeeded"}; strings.Join(ids, ",") != strings.Join(want, ",") {
+		t.Errorf("loaded jobs %v, want %v", ids, want)
+	}
+	// Unfinished jobs are queued again in the order they were created.
+	var pending []string
+	for len(q.pending) > 0 {
+		j := <-q.pending
+		pending = append(pending, j.ID+" "+j.Status)
+	}
+	if want := "running queued,queued queued"; strings.Join(pending, ",") != want {
+		t.Errorf("pending jobs %q, want %q", strings.Join(pending, ","), want)
+	}
+}
+
+func TestJobQueueRunsMalformedInput(t *testing.T) {
+	useFakeProvider(t, map[string]any{"country_code": "GB"})
+
+	tests := []struct {
+		name   string
+		format string
+		query  string
+		// input is the content of the input file; none when "-".
+		input  string
+		status string
+		// error is part of the job's error.
+		error string
+		// lines is the number of lines of the result.
+		lines int
+	}{
+		{"NDJSON", jobFormatNDJSON, "", "81.2.69.142\n\n  \nnot-an-ip\r\n8.8.8.8\n", jobSucceeded, "", 3},
+		{"NDJSON without a final newline", jobFormatNDJSON, "", "81.2.69.142\n8.8.8.8", jobSucceeded, "", 2},
+		{"NDJSON line too long", jobFormatNDJSON, "", "81.2.69.142\n" + strings.Repeat("1", streamLookupMaxLineLength+1) + "\n8.8.8.8\n", jobSucceeded, "", 3},
+		{"NDJSON cut in a long line", jobFormatNDJSON, "", "81.2.69.142\n" + strings.Repeat("1", streamLookupMaxLineLength+1), jobSucceeded, "", 2},
+		{"empty NDJSON", jobFormatNDJSON, "", "", jobSucceeded, "", 0},
+		{"binary NDJSON", jobFormatNDJSON, "", "\x00\xff\xfe\n", jobSucceeded, "", 1},
+		{"CSV", jobFormatCSV, "", "ip,name\n81.2.69.142,a\nnot-an-ip,b\n", jobSucceeded, "", 3},
+		{"CSV of ragged rows", jobFormatCSV, "", "name,ip\na\nb,81.2.69.142,extra\n", jobSucceeded, "", 3},
+		// The quoted cell runs to the end of the input.
+		{"CSV with an unclosed quote", jobFormatCSV, "", "ip,name\n81.2.69.142,\"a\nb\n", jobSucceeded, "", 4},
+		{"empty CSV", jobFormatCSV, "", "", jobSucceeded, "", 0},
+		{"CSV without the IP column", jobFormatCSV, "", "address,name\n81.2.69.142,a\n", jobFailed, "invalid CSV: no 'ip' column", 0},
+		{"CSV of an invalid query", jobFormatCSV, "columns=asn,region", "ip\n81.2.69.142\n", jobFailed, "unknown column 'region'", 0},
+		{"missing input", jobFormatNDJSON, "", "-", jobFailed, "no such file", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := newJobQueue(t.TempDir(), 10, 1<<20, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			j := &job{ID: "job", Status: jobQueued, Format: tt.format, Query: tt.query, CreatedAt: time.Now()}
+			q.jobs[j.ID] = j
+			if tt.input != "-" {
+				if err := os.WriteFile(q.path(j.ID, ".input"), []byte(tt.input), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			q.runJob(context.Background(), j)
+			if j.Status != tt.status || !strings.Contains(j.Error, tt.error) || tt.error == "" && j.Error != "" {
+				t.Fatalf("job is %s with error %q, want %s with %q", j.Status, j.Error, tt.status, tt.error)
+			}
+			if _, err := os.Stat(q.path(j.ID, ".input")); !os.IsNotExist(err) {
+				t.Errorf("the input of a finished job was kept: %v", err)
+			}
+			result, err := os.ReadFile(q.path(j.ID, ".result"))
+			if tt.status == jobFailed {
+				if err == nil {
+					t.Errorf("a failed job has the result %q", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lines := strings.Count(string(result), "\n"); lines != tt.lines {
+				t.Errorf("the result has %d lines, want %d:\n%s", lines, tt.lines, result)
+			}
+		})
+	}
+}
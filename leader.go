@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// leaderElector decides which of several instances sharing a database file
+// downloads updates for it.
+type leaderElector interface {
+	// acquire tries to become or stay the leader and reports whether this
+	// instance leads. It is called every dbUpdateCheckInterval.
+	acquire(ctx context.Context) (bool, error)
+	// release gives up leadership so another instance can take over at once.
+	release(ctx context.Context) error
+}
+
+// newLeaderElector returns the elector for kind ("file" or "kubernetes").
+func newLeaderElector(kind, lockFile, leaseName string) (leaderElector, error) {
+	switch kind {
+	case "file":
+		return &fileLockElector{path: lockFile}, nil
+	case "kubernetes":
+		return newKubeLeaseElector(leaseName)
+	}
+	return nil, fmt.Errorf("unsupported leader election %q", kind)
+}
+
+// errLockHeld is returned by tryLockFile when another process holds the lock.
+var errLockHeld = errors.New("lock held by another process")
+
+// fileLockElector elects the instance holding an exclusive lock on a file on
+// the shared volume. The lock is kept for the life of the process and
+// released by the kernel if it dies, letting another instance take over.
+type fileLockElector struct {
+	path string
+	file *os.File
+}
+
+func (e *fileLockElector) acquire(ctx context.Context) (bool, error) {
+	if e.file != nil {
+		return true, nil
+	}
+	f, err := os.OpenFile(e.path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return false, err
+	}
+	if err := tryLockFile(f); err != nil {
+		f.Close()
+		if errors.Is(err, errLockHeld) {
+			return false, nil
+		}
+		return false, fmt.Errorf("locking %s: %v", e.path, err)
+	}
+	// Record the holder to help operators; the lock itself is what counts.
+	f.Truncate(0)
+	f.WriteAt([]byte(electionIdentity()+"\n"), 0)
+	e.file = f
+	return true, nil
+}
+
+func (e *fileLockElector) release(ctx context.Context) error {
+	if e.file == nil {
+		return nil
+	}
+	err := e.file.Close() // closing the descriptor releases the lock
+	e.file = nil
+	return err
+}
+
+// electionIdentity names this instance in leases and lock files.
+func electionIdentity() string {
+	if name := strings.TrimSpace(os.Getenv("POD_NAME")); name != "" {
+		return name
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// kubeServiceAccountDir holds the credentials Kubernetes mounts into pods.
+const kubeServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubeLeaseDuration is how long a lease stays valid without renewal. It spans
+// several check intervals so a slow renewal does not hand over leadership.
+const kubeLeaseDuration = 3 * dbUpdateCheckInterval
+
+// kubeMicroTimeFormat is the Kubernetes MicroTime wire format.
+const kubeMicroTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// kubeLeaseElector elects the holder of a coordination.k8s.io/v1 Lease,
+// talking to the API server with the pod's service account.
+type kubeLeaseElector struct {
+	leaseURL      string
+	collectionURL string
+	namespace     string
+	name          string
+	identity      string
+	client        *http.Client
+}
+
+// kubeLease is the subset of a Lease the elector reads and writes. Metadata
+// is passed through unchanged so updates keep its resourceVersion and labels.
+type kubeLease struct {
+	APIVersion string          `json:"apiVersion"`
+	Kind       string          `json:"kind"`
+	Metadata   json.RawMessage `json:"metadata"`
+	Spec       struct {
+		HolderIdentity       string `json:"holderIdentity,omitempty"`
+		LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+		AcquireTime          string `json:"acquireTime,omitempty"`
+		RenewTime            string `json:"renewTime,omitempty"`
+		LeaseTransitions     int    `json:"leaseTransitions,omitempty"`
+	} `json:"spec"`
+}
+
+func newKubeLeaseElector(name string) (*kubeLeaseElector, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("kubernetes leader election requires running in a pod (KUBERNETES_SERVICE_HOST is not set)")
+	}
+	namespace := strings.TrimSpace(os.Getenv("POD_NAMESPACE"))
+	if namespace == "" {
+		ns, err := os.ReadFile(kubeServiceAccountDir + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("determining namespace: %v", err)
+		}
+		namespace = strings.TrimSpace(string(ns))
+	}
+	caCert, err := os.ReadFile(kubeServiceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("reading cluster CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, errors.New("cluster CA certificate is invalid")
+	}
+	collectionURL := fmt.Sprintf("https://%s/apis/coordination.k8s.io/v1/namespaces/%s/leases", net.JoinHostPort(host, port), namespace)
+	return &kubeLeaseElector{
+		leaseURL:      collectionURL + "/" + name,
+		collectionURL: collectionURL,
+		namespace:     namespace,
+		name:          name,
+		identity:      electionIdentity(),
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+	}, nil
+}
+
+// call sends a JSON request to the API server and decodes a successful
+// response into out. It returns the HTTP status so callers can handle 404
+// and 409 (conflict), which are expected during elections.
+func (k *kubeLeaseElector) call(ctx context.Context, method, url string, in, out any) (int, error) {
+	var body io.Reader
+	if in != nil {
+		encoded, err := json.Marshal(in)
+		if err != nil {
+			return 0, err
+		}
+		body = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return 0, err
+	}
+	// Read the token on every call: Kubernetes rotates it.
+	token, err := os.ReadFile(kubeServiceAccountDir + "/token")
+	if err != nil {
+		return 0, fmt.Errorf("reading service account token: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusConflict:
+		return resp.StatusCode, nil
+	case resp.StatusCode >= 300:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return resp.StatusCode, fmt.Errorf("%s %s: %s: %s", method, url, resp.Status, strings.TrimSpace(string(msg)))
+	case out != nil:
+		return resp.StatusCode, json.NewDecoder(resp.Body).Decode(out)
+	}
+	return resp.StatusCode, nil
+}
+
+func (k *kubeLeaseElector) acquire(ctx context.Context) (bool, error) {
+	var lease kubeLease
+	status, err := k.call(ctx, http.MethodGet, k.leaseURL, nil, &lease)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now().UTC().Format(kubeMicroTimeFormat)
+	if status == http.StatusNotFound {
+		lease = kubeLease{APIVersion: "coordination.k8s.io/v1", Kind: "Lease"}
+		lease.Metadata, _ = json.Marshal(map[string]string{"name": k.name, "namespace": k.namespace})
+		lease.Spec.HolderIdentity = k.identity
+		lease.Spec.LeaseDurationSeconds = int(kubeLeaseDuration.Seconds())
+		lease.Spec.AcquireTime, lease.Spec.RenewTime = now, now
+		status, err = k.call(ctx, http.MethodPost, k.collectionURL, lease, nil)
+		return err == nil && status != http.StatusConflict, err
+	}
+
+	if holder := lease.Spec.HolderIdentity; holder != k.identity {
+		if holder != "" && !k.expired(lease) {
+			return false, nil
+		}
+		lease.Spec.HolderIdentity = k.identity
+		lease.Spec.AcquireTime = now
+		lease.Spec.LeaseTransitions++
+	}
+	lease.Spec.LeaseDurationSeconds = int(kubeLeaseDuration.Seconds())
+	lease.Spec.RenewTime = now
+	// The resourceVersion in the metadata makes this a compare-and-swap: if
+	// another instance updated the lease first, the API server answers 409.
+	status, err = k.call(ctx, http.MethodPut, k.leaseURL, lease, nil)
+	return err == nil && status != http.StatusConflict && status != http.StatusNotFound, err
+}
+
+// expired reports whether the lease holder failed to renew it in time.
+func (k *kubeLeaseElector) expired(lease kubeLease) bool {
+	renewed, err := time.Parse(time.RFC3339Nano, lease.Spec.RenewTime)
+	if err != nil {
+		return true
+	}
+	duration := time.Duration(lease.Spec.LeaseDurationSeconds) * time.Second
+	return time.Since(renewed) > duration
+}
+
+func (k *kubeLeaseElector) release(ctx context.Context) error {
+	var lease kubeLease
+	status, err := k.call(ctx, http.MethodGet, k.leaseURL, nil, &lease)
+	if err != nil || status == http.StatusNotFound || lease.Spec.HolderIdentity != k.identity {
+		return err
+	}
+	lease.Spec.HolderIdentity = ""
+	_, err = k.call(ctx, http.MethodPut, k.leaseURL, lease, nil)
+	return err
+}
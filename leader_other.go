@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// tryLockFile is not implemented on this platform; use Kubernetes leader
+// election instead.
+func tryLockFile(f *os.File) error {
+	return errors.New("file locks are not supported on this platform")
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// localizedNameFields are the lookup fields ?lang= localizes, with the
+// entity ?all_names= lists their names under and the names of the City
+// record each is read from.
+var localizedNameFields = []struct {
+	field  string
+	entity string
+	names  func(*geoip2.City) map[string]string
+}{
+	{"city", "city", func(c *geoip2.City) map[string]string { return c.City.Names }},
+	{"country_name", "country", func(c *geoip2.City) map[string]string { return c.Country.Names }},
+	{"continent", "continent", func(c *geoip2.City) map[string]string { return c.Continent.Names }},
+	{"subdivision_name", "", func(c *geoip2.City) map[string]string {
+		if len(c.Subdivisions) == 0 {
+			return nil
+		}
+		return c.Subdivisions[0].Names
+	}},
+}
+
+// lookupLanguages are the languages of the names in MaxMind databases.
+var lookupLanguages = []string{"de", "en", "es", "fr", "ja", "pt-BR", "ru", "zh-CN"}
+
+// parseLang parses the lang parameter of a lookup, one of lookupLanguages
+// matched case-insensitively, returned as MaxMind spells it. It returns ""
+// when r does not ask for a language.
+func parseLang(r *http.Request) (string, error) {
+	value := strings.TrimSpace(r.URL.Query().Get("lang"))
+	if value == "" {
+		return "", nil
+	}
+	for _, lang := range lookupLanguages {
+		if strings.EqualFold(lang, value) {
+			return lang, nil
+		}
+	}
+	return "", fmt.Errorf("Invalid lang parameter '%s': must be one of %s", value, strings.Join(lookupLanguages, ", "))
+}
+
+// wantsLocalizedNames reports whether r asks for names in another language
+// than English. Invalid lang values are rejected before this is consulted.
+func wantsLocalizedNames(r *http.Request) bool {
+	lang, err := parseLang(r)
+	return err == nil && lang != "" && lang != "en"
+}
+
+// localizeNames replaces the English names of response with those in the
+// language r asks for. Names the database has no translation for stay in
+// English, as do names that did not come from the database, such as those
+// of overrides and remote lookup sources.
+func localizeNames(r *http.Request, ip net.IP, response map[string]any) {
+	if !wantsLocalizedNames(r) {
+		return
+	}
+	lang, _ := parseLang(r)
+	record, found, err := lookupNames(ip)
+	if err != nil || !found {
+		return
+	}
+	for _, f := range localizedNameFields {
+		names := f.names(record)
+		if name := names[lang]; name != "" && response[f.field] == names["en"] {
+			response[f.field] = name
+		}
+	}
+	localizeSubdivisions(response, record, lang)
+}
+
+// lookupNames reads the City record of ip for the names of its entities in
+// every language. Only MaxMind databases have them, so found is false under
+// other providers and while no database is loaded.
+func lookupNames(ip net.IP) (record *geoip2.City, found bool, err error) {
+	mm := maxMindDB()
+	if mm == nil {
+		return nil, false, nil
+	}
+	return mm.lookupCity(ip)
+}
+
+// parseAllNames parses the all_names parameter of a lookup, a boolean.
+func parseAllNames(r *http.Request) (bool, error) {
+	value := strings.TrimSpace(r.URL.Query().Get("all_names"))
+	if value == "" {
+		return false, nil
+	}
+	all, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("Invalid all_names parameter '%s': must be a boolean such as 1 or 0", value)
+	}
+	return all, nil
+}
+
+// wantsAllNames reports whether r asks for the names of every language.
+// Invalid all_names values are rejected before this is consulted.
+func wantsAllNames(r *http.Request) bool {
+	all, err := parseAllNames(r)
+	return err == nil && all
+}
+
+// addAllNames sets the names field of response to the names of its city,
+// country, continent and subdivisions in every language of the database,
+// if r asks for them. Like localizeNames, it leaves out the entities whose
+// names did not come from the database. It runs before localizeNames, which
+// changes the English names it compares.
+func addAllNames(r *http.Request, ip net.IP, response map[string]any) {
+	if !wantsAllNames(r) {
+		return
+	}
+	record, found, err := lookupNames(ip)
+	if err != nil || !found {
+		return
+	}
+	names := make(map[string]any)
+	for _, f := range localizedNameFields {
+		entityNames := f.names(record)
+		if f.entity != "" && len(entityNames) > 0 && response[f.field] == entityNames["en"] {
+			names[f.entity] = entityNames
+		}
+	}
+	if len(record.Subdivisions) > 0 && response["subdivision_name"] == record.Subdivisions[0].Names["en"] {
+		subdivisions := make([]map[string]string, len(record.Subdivisions))
+		for i, s := range record.Subdivisions {
+			subdivisions[i] = s.Names
+		}
+		names["subdivisions"] = subdivisions
+	}
+	response["names"] = names
+}
+
+// localizeSubdivisions replaces the subdivisions array of response with one
+// whose names are localized. The array holds maps as built or, decoded from
+// the shared cache, as []any; it is copied, not changed, since cached
+// responses can share it.
+func localizeSubdivisions(response map[string]any, record *geoip2.City, lang string) {
+	var subdivisions []map[string]any
+	switch v := response["subdivisions"].(type) {
+	case []map[string]any:
+		subdivisions = v
+	case []any:
+		for _, item := range v {
+			if m, ok := item.(map[string]any); ok {
+				subdivisions = append(subdivisions, m)
+			}
+		}
+	}
+	if len(subdivisions) == 0 || len(subdivisions) != len(record.Subdivisions) {
+		return
+	}
+	localized := make([]map[string]any, len(subdivisions))
+	for i, s := range record.Subdivisions {
+		localized[i] = maps.Clone(subdivisions[i])
+		if name := s.Names[lang]; name != "" && localized[i]["name"] == s.Names["en"] {
+			localized[i]["name"] = name
+		}
+	}
+	response["subdivisions"] = localized
+}
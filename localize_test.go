@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeProvider is a non-MaxMind primary database answering every lookup
+// with fields, or with no record when fields is nil.
+type fakeProvider struct {
+	fields map[string]any
+}
+
+func (p fakeProvider) lookup(ctx context.Context, ip net.IP) (map[string]any, error) {
+	if p.fields == nil {
+		return nil, nil
+	}
+	response := map[string]any{"ip": ip.String()}
+	for k, v := range p.fields {
+		response[k] = v
+	}
+	return response, nil
+}
+
+func (fakeProvider) loaded() bool             { return true }
+func (fakeProvider) databaseType() string     { return "Fake-DB1" }
+func (fakeProvider) buildTime() time.Time     { return time.Unix(1700000000, 0) }
+func (fakeProvider) metadata() map[string]any { return map[string]any{"database_type": "Fake-DB1"} }
+func (fakeProvider) reload() error            { return nil }
+func (fakeProvider) Close() error             { return nil }
+
+// useFakeProvider serves lookups from a fakeProvider for the rest of t.
+func useFakeProvider(t *testing.T, fields map[string]any) {
+	t.Helper()
+	old := primaryDB
+	primaryDB = fakeProvider{fields: fields}
+	t.Cleanup(func() { primaryDB = old })
+}
+
+// serveLookup requests target from lookupHandler and decodes the response.
+func serveLookup(t *testing.T, target string) (int, map[string]any) {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /lookup/{ip}", lookupHandler)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	var body map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("GET %s: decoding %q: %v", target, rec.Body.String(), err)
+	}
+	return rec.Code, body
+}
+
+func TestLocalizeNamesWithoutMaxMindDatabase(t *testing.T) {
+	useFakeProvider(t, map[string]any{"country_code": "US", "country_name": "United States", "city": "Mountain View"})
+
+	code, body := serveLookup(t, "/lookup/8.8.8.8?lang=de")
+	if code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %v", code, http.StatusOK, body)
+	}
+	if got := body["country_name"]; got != "United States" {
+		t.Errorf("country_name = %v, want the provider's English name", got)
+	}
+}
+
+func TestAllNamesWithoutMaxMindDatabase(t *testing.T) {
+	useFakeProvider(t, map[string]any{"country_code": "US", "country_name": "United States"})
+
+	code, body := serveLookup(t, "/lookup/8.8.8.8?all_names=1")
+	if code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %v", code, http.StatusOK, body)
+	}
+	if _, ok := body["names"]; ok {
+		t.Errorf("names = %v, want none without a MaxMind database", body["names"])
+	}
+}
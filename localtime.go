@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	// The image is built FROM scratch, which has no zoneinfo directory.
+	_ "time/tzdata"
+)
+
+// localTimeHandler serves GET /time/{ip} and /time for the caller's IP: the
+// current time in the IP's time zone, with its UTC offset and whether
+// daylight saving time is in effect.
+func localTimeHandler(w http.ResponseWriter, r *http.Request) {
+	if !databaseLoaded() {
+		writeJSONError(w, "GeoIP service not available", http.StatusInternalServerError, errCodeDBUnavailable)
+		return
+	}
+	ipStr := r.PathValue("ip")
+	if ipStr == "" {
+		start := time.Now()
+		ipStr = clientIP(r)
+		stageClientIP.since(start)
+	}
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		writeJSONError(w, fmt.Sprintf("Invalid IP address format: %s", ipStr), http.StatusBadRequest, errCodeInvalidIP)
+		return
+	}
+
+	response, err := lookupIP(r.Context(), ip)
+	if err != nil && !errors.Is(err, errNoRecord) {
+		log.Printf("GeoIP lookup for IP %s failed: %v", ip.String(), err)
+		writeJSONError(w, fmt.Sprintf("GeoIP lookup failed for IP: %s", ip.String()), http.StatusInternalServerError, errCodeDBError)
+		return
+	}
+	zone, _ := response["time_zone"].(string)
+	var location *time.Location
+	if zone != "" {
+		if location, err = time.LoadLocation(zone); err != nil {
+			log.Printf("Unknown time zone %q for IP %s: %v", zone, ip.String(), err)
+		}
+	}
+	if location == nil {
+		writeJSONError(w, fmt.Sprintf("Time zone not found for IP: %s", ip.String()), http.StatusNotFound, errCodeNotFound)
+		return
+	}
+
+	now := time.Now().In(location)
+	abbreviation, offset := now.Zone()
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]any{
+		"ip":                 ip.String(),
+		"time_zone":          zone,
+		"local_time":         now.Format(time.RFC3339),
+		"utc_offset":         now.Format("-07:00"),
+		"utc_offset_seconds": offset,
+		"abbreviation":       abbreviation,
+		"dst":                now.IsDST(),
+	}); err != nil {
+		log.Printf("Error encoding JSON response for IP %s: %v", ip.String(), err)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// lookupSource is a source of lookup records. lookupRecord consults the
+// sources of the lookup chain in order, so a new data source is added in
+// newLookupChain rather than in each surface serving lookups.
+type lookupSource interface {
+	// lookup returns the lookup fields of ip, or nil when the source has no
+	// record for it.
+	lookup(ctx context.Context, ip net.IP) (map[string]any, error)
+}
+
+// chainedSource is a source in the lookup chain.
+type chainedSource struct {
+	// name identifies the source in errors and logs.
+	name   string
+	source lookupSource
+	// timeout bounds each lookup of the source; 0 leaves it to the caller's
+	// context.
+	timeout time.Duration
+}
+
+// lookupChain is the sources answering lookups: the first with a record for
+// an IP answers it.
+type lookupChain []chainedSource
+
+// lookupSources is the lookup chain, set from the configuration in configureServices.
+var lookupSources = newLookupChain(Config{})
+
+// newLookupChain builds the lookup chain of cfg: the overrides, the
+// classification of special-purpose ranges, the databases and then the
+// remote APIs, the billed MaxMind web service last. With the shared cache,
+// the databases are read through it.
+func newLookupChain(cfg Config) lookupChain {
+	chain := lookupChain{{name: "overrides", source: overrideSource{}}}
+	if cfg.SpecialRangeBehavior != specialRangeLookup {
+		chain = append(chain, chainedSource{name: "special ranges", source: specialRangeSource{}})
+	}
+	if cfg.GroupcacheSelf != "" {
+		chain = append(chain, chainedSource{name: "database", source: sharedCacheSource{}})
+	} else {
+		chain = append(chain, chainedSource{name: "database", source: databaseSource{}})
+	}
+	if cfg.RemoteLookupURL != "" {
+		chain = append(chain, chainedSource{
+			name:    "remote lookup API",
+			source:  newRemoteLookupSource(cfg.RemoteLookupURL, cfg.RemoteLookupCacheTTL),
+			timeout: cfg.RemoteLookupTimeout,
+		})
+	}
+	if cfg.WebService != "" {
+		chain = append(chain, chainedSource{
+			name:    "MaxMind web service",
+			source:  newWebServiceClient(cfg.WebServiceURL, cfg.WebService, cfg.WebServiceAccountID, cfg.WebServiceLicenseKey, cfg.WebServiceCacheTTL),
+			timeout: cfg.WebServiceTimeout,
+		})
+	}
+	return chain
+}
+
+// lookup returns the fields of the first source with a record for ip. A
+// failing source does not stop the chain: the lookup only fails when no
+// later source answers. It returns errNoRecord when no source has a record.
+func (c lookupChain) lookup(ctx context.Context, ip net.IP) (map[string]any, error) {
+	var errs []error
+	for _, s := range c {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		fields, err := s.lookup(ctx, ip)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if fields == nil {
+			continue
+		}
+		if len(errs) > 0 {
+			log.Printf("Lookup for IP %s answered by the %s after failures: %v", ip.String(), s.name, errors.Join(errs...))
+		}
+		return fields, nil
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return nil, errNoRecord
+}
+
+func (s chainedSource) lookup(ctx context.Context, ip net.IP) (map[string]any, error) {
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	fields, err := s.source.lookup(ctx, ip)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.name, err)
+	}
+	return fields, nil
+}
+
+// overrideSource answers lookups from GEOIP_OVERRIDES_PATH.
+type overrideSource struct{}
+
+func (overrideSource) lookup(ctx context.Context, ip net.IP) (map[string]any, error) {
+	if fields, ok := lookupOverride(ip); ok {
+		return overrideResponse(ip, fields), nil
+	}
+	return nil, nil
+}
+
+// specialRangeSource answers lookups of non-public IPs, such as RFC 1918
+// and loopback addresses, with their classification, as MISS_BEHAVIOR=bogon
+// answers misses. No database or remote API has a location for them.
+type specialRangeSource struct{}
+
+func (specialRangeSource) lookup(ctx context.Context, ip net.IP) (map[string]any, error) {
+	if classifyIP(ip) == ipTypePublic {
+		return nil, nil
+	}
+	return missResponse(ip, true), nil
+}
+
+// databaseSource answers lookups from the primary database and fills the
+// fields it has no value for from the fallback databases.
+type databaseSource struct{}
+
+func (databaseSource) lookup(ctx context.Context, ip net.IP) (map[string]any, error) {
+	response, err := primaryDB.lookup(ctx, ip)
+	if err != nil {
+		return nil, err
+	}
+	return mergeFallbackFields(ip, response), nil
+}
@@ -5,90 +5,30 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/fs"
 	"log"
+	"math"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
+	"google.golang.org/grpc"
 )
 
-var geoDB *geoip2.Reader
-
-// Config holds application configuration.
-type Config struct {
-	GeoIPDBPath              string
-	ListenAddr               string
-	AllowedCORSAccessOrigins []string
-}
-
-// AppError represents a structured error response.
-type AppError struct {
-	Message string `json:"message"`
-	Code    int    `json:"code"`
-}
-
-// defaultGeoIPDir is the default directory to search for the GeoIP database.
-const defaultGeoIPDir = "/app/data"
-
-// defaultGeoIPFile is the default GeoIP database filename.
-const defaultGeoIPFile = "GeoLite2-City.mmdb"
-
-func loadConfig() (Config, error) {
-	dbPath := os.Getenv("GEOIP_DB_PATH")
-	listenAddr := os.Getenv("LISTEN_ADDR")
-
-	if listenAddr == "" {
-		listenAddr = ":8080" // Default listen address
+// roundCoordinate rounds v to the given number of decimal places.
+// A negative precision returns v unchanged.
+func roundCoordinate(v float64, precision int) float64 {
+	if precision < 0 {
+		return v
 	}
-
-	if dbPath == "" {
-		// GEOIP_DB_PATH environment variable is not set.
-		// Attempt to use a default path, which aligns with the geoipupdate service volume mount.
-		potentialDefaultPath := filepath.Join(defaultGeoIPDir, defaultGeoIPFile)
-		log.Printf("GEOIP_DB_PATH not set. Checking default location: %s", potentialDefaultPath)
-
-		if _, err := os.Stat(potentialDefaultPath); err == nil {
-			// Default file exists
-			log.Printf("Using GeoIP database found at default location: %s", potentialDefaultPath)
-			dbPath = potentialDefaultPath
-		} else if os.IsNotExist(err) {
-			// Default file does not exist
-			errMsg := fmt.Sprintf("GEOIP_DB_PATH environment variable is not set, and the default database '%s' was not found in '%s'. Please ensure the database file is available or set GEOIP_DB_PATH.", defaultGeoIPFile, defaultGeoIPDir)
-			log.Println(errMsg)
-			return Config{}, errors.New(errMsg)
-		} else {
-			// Some other error occurred when checking for the default file (e.g., permission issues)
-			errMsg := fmt.Sprintf("Error checking for default GeoIP database at '%s': %v. Please ensure the path is accessible or set GEOIP_DB_PATH.", potentialDefaultPath, err)
-			log.Println(errMsg)
-			return Config{}, errors.New(errMsg)
-		}
-	} else {
-		log.Printf("Using GeoIP database path from GEOIP_DB_PATH: %s", dbPath)
-	}
-
-	allowedOriginsEnv := os.Getenv("ALLOWED_CORS_ORIGINS")
-	var allowedOriginsList []string
-	if allowedOriginsEnv != "" {
-		allowedOriginsList = strings.Split(allowedOriginsEnv, ",")
-		for i, origin := range allowedOriginsList {
-			allowedOriginsList[i] = strings.TrimSpace(origin)
-		}
-		log.Printf("Allowed CORS origins: %v", allowedOriginsList)
-	} else {
-		log.Println("ALLOWED_CORS_ORIGINS not set. CORS headers will not be added.")
-	}
-
-	return Config{
-		GeoIPDBPath:              dbPath,
-		ListenAddr:               listenAddr,
-		AllowedCORSAccessOrigins: allowedOriginsList,
-	}, nil
+	factor := math.Pow(10, float64(precision))
+	return math.Round(v*factor) / factor
 }
 
 // corsMiddleware handles CORS headers for incoming requests.
@@ -129,12 +69,13 @@ func corsMiddleware(next http.Handler, allowedOrigins []string) http.Handler {
 		if isAllowed {
 			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, DELETE")
 			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With")
+			// Let browser clients read the back-off headers on throttled responses.
+			w.Header().Set("Access-Control-Expose-Headers", "Retry-After, RateLimit-Policy, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, API-Version")
 			// Only set Allow-Credentials if not using wildcard for origin, as per spec
 			if !hasWildcard && requestOrigin != "" {
 				w.Header().Set("Access-Control-Allow-Credentials", "true")
 			}
 
-
 			// Handle preflight OPTIONS requests
 			if r.Method == http.MethodOptions {
 				w.Header().Set("Access-Control-Max-Age", "86400") // Cache preflight for 1 day
@@ -149,128 +90,466 @@ func corsMiddleware(next http.Handler, allowedOrigins []string) http.Handler {
 	})
 }
 
-func writeJSONError(w http.ResponseWriter, message string, code int) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	json.NewEncoder(w).Encode(AppError{Message: message, Code: code})
-}
-
 func healthzHandler(w http.ResponseWriter, r *http.Request) {
-	if geoDB == nil {
-		writeJSONError(w, "GeoIP database not loaded", http.StatusInternalServerError)
+	if !databaseLoaded() {
+		writeJSONError(w, "GeoIP database not loaded", http.StatusInternalServerError, errCodeDBUnavailable)
 		return
 	}
+	if healthy, _, _ := dbHealth.healthy(); !healthy {
+		// Probe so the service recovers even when no lookups are arriving.
+		primaryDB.lookup(r.Context(), dbProbeIP)
+	}
+	if healthy, since, lastErr := dbHealth.healthy(); !healthy {
+		writeAppError(w, AppError{
+			Message:   "GeoIP database reads are failing",
+			Code:      http.StatusServiceUnavailable,
+			ErrorCode: errCodeDBError,
+			Details:   map[string]string{"unhealthy_since": since.UTC().Format(time.RFC3339), "last_error": lastErr},
+		})
+		return
+	}
+	if maxAge := appConfig.MaxDBAge; maxAge > 0 {
+		if age := time.Since(databaseBuildTime()); age > maxAge {
+			code := http.StatusOK
+			if appConfig.MaxDBAgeUnhealthy {
+				code = http.StatusServiceUnavailable
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(code)
+			json.NewEncoder(w).Encode(map[string]any{
+				"status":          "degraded",
+				"reason":          "the GeoIP database is older than MAX_DB_AGE_DAYS",
+				"db_build_time":   databaseBuildTime().UTC().Format(time.RFC3339),
+				"db_age_days":     int(age.Hours() / 24),
+				"max_db_age_days": int(maxAge.Hours() / 24),
+			})
+			return
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
 
 func rootHandler(w http.ResponseWriter, r *http.Request) {
-	// Prevent non-root paths from being handled here if mux is configured loosely
-	if r.URL.Path != "/" {
-		http.NotFound(w, r)
-		return
-	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	response := map[string]string{
 		"message":       "Welcome to the IP Lookup Service. Please use the /lookup endpoint to find GeoIP information.",
-		"example_usage": "/lookup/8.8.8.8 or /lookup/",
+		"example_usage": "/lookup/8.8.8.8 or /lookup",
 	}
 	json.NewEncoder(w).Encode(response)
 }
 
-func lookupHandler(w http.ResponseWriter, r *http.Request) {
-	if geoDB == nil {
-		log.Println("Error: GeoIP database is not loaded.")
-		writeJSONError(w, "GeoIP service not available", http.StatusInternalServerError)
-		return
+// lookupIP queries the lookup chain for ip and builds the response fields
+// shared by every lookup surface (HTTP, MCP, ...).
+func lookupIP(ctx context.Context, ip net.IP) (map[string]any, error) {
+	response, err := lookupRecord(ctx, ip)
+	if err != nil {
+		return nil, err
 	}
+	countryCode, _ := response["country_code"].(string)
+	stats.recordLookup(countryCode)
+	return response, nil
+}
 
-	ipStr := ""
-	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+// lookupRecord is lookupIP without recording the lookup in stats, for callers
+// such as policies that use the result elsewhere.
+func lookupRecord(ctx context.Context, ip net.IP) (map[string]any, error) {
+	if !databaseLoaded() {
+		return nil, errors.New("GeoIP database not loaded")
+	}
+	response, err := lookupSources.lookup(ctx, ip)
+	if err != nil {
+		return nil, err
+	}
+	addSupplementaryFields(ip, response)
+	addTorExitField(ip, response)
+	addThreatFields(ip, response)
+	addCloudFields(ip, response)
+	addFlagFields(response)
+	addGDPRFields(response)
+	return response, nil
+}
 
-	if len(pathParts) > 1 && pathParts[1] != "" {
-		ipStr = pathParts[1]
-	} else {
-		// Try X-Forwarded-For first. This header can contain a comma-separated list of IPs.
-		// The first IP is typically the original client IP.
-		xff := r.Header.Get("X-Forwarded-For")
-		if xff != "" {
-			ips := strings.Split(xff, ",")
-			// Trim whitespace from the first IP in the list.
-			firstIP := strings.TrimSpace(ips[0])
-			if firstIP != "" {
-				ipStr = firstIP
+// cityResponse builds the lookup fields of record, read from db for ip.
+func cityResponse(ip net.IP, record *geoip2.City, db *maxminddb.Reader) map[string]any {
+	if isCountryDatabase(db) {
+		response := countryFields(ip, record)
+		if isIPinfoDatabase(db) {
+			if err := addIPinfoFields(db, ip, response); err != nil {
+				log.Printf("ipinfo lookup for IP %s failed: %v", ip.String(), err)
 			}
 		}
+		return response
+	}
+	response := cityFields(ip, record)
+	if isEnterpriseDatabase(db) {
+		if err := addEnterpriseFields(db, ip, response); err != nil {
+			log.Printf("Enterprise lookup for IP %s failed: %v", ip.String(), err)
+		}
+	}
+	return response
+}
 
-		// If X-Forwarded-For is not present or didn't yield an IP, try X-Real-IP.
-		// X-Real-IP usually contains a single IP, the original client IP.
-		if ipStr == "" {
-			xri := r.Header.Get("X-Real-IP")
-			if xri != "" {
-				ipStr = strings.TrimSpace(xri)
-			}
+// countryFields returns the lookup fields of a Country record.
+func countryFields(ip net.IP, record *geoip2.City) map[string]any {
+	response := map[string]any{
+		"ip":                   ip.String(),
+		"country_code":         record.Country.IsoCode,
+		"country_name":         record.Country.Names["en"],
+		"continent":            record.Continent.Names["en"],
+		"is_in_european_union": record.Country.IsInEuropeanUnion,
+		"traits":               traitsField(record),
+	}
+	addCountryEntityFields(record, response)
+	return response
+}
+
+// addCountryEntityFields adds the registered_country and represented_country
+// fields of record to response, when the record has them: where the IP is
+// registered, and the country an IP of an overseas military base or embassy
+// represents.
+func addCountryEntityFields(record *geoip2.City, response map[string]any) {
+	if record.RegisteredCountry.IsoCode != "" {
+		response["registered_country"] = map[string]any{
+			"iso_code":             record.RegisteredCountry.IsoCode,
+			"name":                 record.RegisteredCountry.Names["en"],
+			"is_in_european_union": record.RegisteredCountry.IsInEuropeanUnion,
+		}
+	}
+	if record.RepresentedCountry.IsoCode != "" {
+		response["represented_country"] = map[string]any{
+			"iso_code":             record.RepresentedCountry.IsoCode,
+			"name":                 record.RepresentedCountry.Names["en"],
+			"is_in_european_union": record.RepresentedCountry.IsInEuropeanUnion,
+			"type":                 record.RepresentedCountry.Type,
 		}
+	}
+}
 
-		// Fallback to RemoteAddr if the headers are not present or did not provide an IP.
-		// This is less likely when behind a properly configured proxy.
-		if ipStr == "" {
-			remoteAddr := r.RemoteAddr
-			host, _, err := net.SplitHostPort(remoteAddr)
-			if err == nil {
-				ipStr = host
-			} else {
-				// If SplitHostPort fails (e.g., for Unix domain sockets or non-standard formats),
-				// use RemoteAddr directly.
-				ipStr = remoteAddr
-			}
+// traitsField returns the traits object of a lookup: the legacy network
+// flags of the record.
+func traitsField(record *geoip2.City) map[string]any {
+	return map[string]any{
+		"is_anonymous_proxy":    record.Traits.IsAnonymousProxy,
+		"is_satellite_provider": record.Traits.IsSatelliteProvider,
+		"is_anycast":            record.Traits.IsAnycast,
+	}
+}
+
+// cityFields returns the lookup fields of a City record.
+func cityFields(ip net.IP, record *geoip2.City) map[string]any {
+	response := map[string]any{
+		"ip":                   ip.String(),
+		"city":                 record.City.Names["en"],
+		"country_code":         record.Country.IsoCode,
+		"country_name":         record.Country.Names["en"],
+		"continent":            record.Continent.Names["en"],
+		"latitude":             roundCoordinate(record.Location.Latitude, appConfig.CoordinatePrecision),
+		"longitude":            roundCoordinate(record.Location.Longitude, appConfig.CoordinatePrecision),
+		"time_zone":            record.Location.TimeZone,
+		"postal_code":          record.Postal.Code,
+		"is_in_european_union": record.Country.IsInEuropeanUnion,
+		"traits":               traitsField(record),
+	}
+	if record.Subdivisions != nil && len(record.Subdivisions) > 0 {
+		response["subdivision_name"] = record.Subdivisions[0].Names["en"]
+		subdivisions := make([]map[string]any, len(record.Subdivisions))
+		for i, s := range record.Subdivisions {
+			subdivisions[i] = map[string]any{"iso_code": s.IsoCode, "name": s.Names["en"]}
 		}
+		response["subdivisions"] = subdivisions
+	}
+	if record.Location.AccuracyRadius != 0 {
+		response["accuracy_radius"] = uint(record.Location.AccuracyRadius)
+	}
+	if record.Location.MetroCode != 0 {
+		response["metro_code"] = record.Location.MetroCode
+	}
+	addCountryEntityFields(record, response)
+	addGeoNamesFields(record.City.GeoNameID, response)
+	return response
+}
+
+// errNoRecord is returned by lookupIP when the database has no record for the IP.
+var errNoRecord = errors.New("no GeoIP record for IP")
+
+// errInvalidIP is returned by lookupIPString when the input is not an IP address.
+var errInvalidIP = errors.New("invalid IP address format")
+
+// lookupIPString parses ipStr and looks it up. Parse failures wrap errInvalidIP.
+func lookupIPString(ctx context.Context, ipStr string) (map[string]any, error) {
+	ip := net.ParseIP(strings.TrimSpace(ipStr))
+	if ip == nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidIP, ipStr)
+	}
+	return lookupIP(ctx, ip)
+}
+
+// missResult applies MISS_BEHAVIOR to an IP without a database record,
+// returning the 200 response to send, or false when the miss is an error.
+func missResult(ip net.IP) (map[string]any, bool) {
+	switch appConfig.MissBehavior {
+	case missBehaviorNull:
+		return missResponse(ip, false), true
+	case missBehaviorBogon:
+		return missResponse(ip, true), true
+	}
+	return nil, false
+}
 
-		// Log if the determined IP is local, as GeoIP lookup might be limited.
-		if ipStr == "::1" || ipStr == "127.0.0.1" {
-			log.Printf("Request IP is local (%s) after checking proxy headers. GeoIP lookup might return limited or no data.", ipStr)
+// lookupBatchItem resolves a single IP for batch lookups, returning either the
+// lookup response or a per-item error object.
+func lookupBatchItem(ctx context.Context, ipStr string) (map[string]any, bool) {
+	response, err := lookupIPString(ctx, ipStr)
+	if errors.Is(err, errInvalidIP) {
+		return map[string]any{"ip": ipStr, "error": fmt.Sprintf("Invalid IP address format: %s", ipStr), "error_code": errCodeInvalidIP}, false
+	}
+	if errors.Is(err, errNoRecord) {
+		if response, ok := missResult(net.ParseIP(strings.TrimSpace(ipStr))); ok {
+			return response, true
+		}
+		return map[string]any{"ip": ipStr, "error": fmt.Sprintf("GeoIP data not found for IP: %s", ipStr), "error_code": errCodeNotFound}, false
+	}
+	if err != nil {
+		log.Printf("GeoIP lookup for IP %s failed: %v", ipStr, err)
+		return map[string]any{"ip": ipStr, "error": fmt.Sprintf("GeoIP lookup failed for IP: %s", ipStr), "error_code": errCodeDBError}, false
+	}
+	return response, true
+}
+
+// clientIP determines the caller's IP address, honoring the X-Forwarded-For
+// and X-Real-IP proxy headers before falling back to the connection address.
+func clientIP(r *http.Request) string {
+	ipStr := ""
+	// Try X-Forwarded-For first. This header can contain a comma-separated list of IPs.
+	// The first IP is typically the original client IP.
+	xff := r.Header.Get("X-Forwarded-For")
+	if xff != "" {
+		ips := strings.Split(xff, ",")
+		// Trim whitespace from the first IP in the list.
+		firstIP := strings.TrimSpace(ips[0])
+		if firstIP != "" {
+			ipStr = firstIP
 		}
 	}
 
+	// If X-Forwarded-For is not present or didn't yield an IP, try X-Real-IP.
+	// X-Real-IP usually contains a single IP, the original client IP.
 	if ipStr == "" {
-		writeJSONError(w, "Could not determine IP address from request", http.StatusBadRequest)
+		xri := r.Header.Get("X-Real-IP")
+		if xri != "" {
+			ipStr = strings.TrimSpace(xri)
+		}
+	}
+
+	// Fallback to RemoteAddr if the headers are not present or did not provide an IP.
+	// This is less likely when behind a properly configured proxy.
+	if ipStr == "" {
+		remoteAddr := r.RemoteAddr
+		host, _, err := net.SplitHostPort(remoteAddr)
+		if err == nil {
+			ipStr = host
+		} else {
+			// If SplitHostPort fails (e.g., for Unix domain sockets or non-standard formats),
+			// use RemoteAddr directly.
+			ipStr = remoteAddr
+		}
+	}
+
+	// Log if the determined IP is local, as GeoIP lookup might be limited.
+	if ipStr == "::1" || ipStr == "127.0.0.1" {
+		log.Printf("Request IP is local (%s) after checking proxy headers. GeoIP lookup might return limited or no data.", ipStr)
+	}
+	return ipStr
+}
+
+// writeLookupMiss answers a lookup of an IP without a database record
+// according to MISS_BEHAVIOR.
+func writeLookupMiss(w http.ResponseWriter, r *http.Request, ip net.IP) {
+	response, ok := missResult(ip)
+	if !ok {
+		writeJSONError(w, fmt.Sprintf("GeoIP data not found for IP: %s", ip.String()), http.StatusNotFound, errCodeNotFound)
+		return
+	}
+	addHostname(r, ip, response)
+	writeLookupBody(w, r, ip, response)
+}
+
+func lookupHandler(w http.ResponseWriter, r *http.Request) {
+	if !databaseLoaded() {
+		log.Println("Error: GeoIP database is not loaded.")
+		writeJSONError(w, "GeoIP service not available", http.StatusInternalServerError, errCodeDBUnavailable)
+		return
+	}
+
+	ipStr := r.PathValue("ip")
+	if ipStr == "" {
+		// /lookup?ip= is the query-parameter form of /lookup/{ip}.
+		ipStr = strings.TrimSpace(r.URL.Query().Get("ip"))
+	}
+	requested := ipStr != ""
+	if ipStr == "" {
+		start := time.Now()
+		ipStr = clientIP(r)
+		stageClientIP.since(start)
+	}
+
+	if ipStr == "" {
+		writeJSONError(w, "Could not determine IP address from request", http.StatusBadRequest, errCodeIPUndetermined)
 		return
 	}
 
 	ip := net.ParseIP(ipStr)
+	if ip == nil && requested && hostnames != nil && validHostname(ipStr) {
+		writeHostnameLookup(w, r, ipStr)
+		return
+	}
 	if ip == nil {
-		writeJSONError(w, fmt.Sprintf("Invalid IP address format: %s", ipStr), http.StatusBadRequest)
+		writeJSONError(w, fmt.Sprintf("Invalid IP address format: %s", ipStr), http.StatusBadRequest, errCodeInvalidIP)
 		return
 	}
 
-	record, err := geoDB.City(ip)
-	if err != nil {
-		log.Printf("Could not find GeoIP data for IP %s: %v", ip.String(), err)
-		writeJSONError(w, fmt.Sprintf("GeoIP data not found for IP: %s", ip.String()), http.StatusNotFound)
+	if rdns != nil {
+		if _, err := rdns.wanted(r); err != nil {
+			writeJSONError(w, err.Error(), http.StatusBadRequest, errCodeInvalidRequest)
+			return
+		}
+	}
+	if minFraud != nil {
+		if _, err := minFraud.wanted(r); err != nil {
+			writeJSONError(w, err.Error(), http.StatusBadRequest, errCodeInvalidRequest)
+			return
+		}
+	}
+	if dnsbl != nil {
+		if _, err := dnsbl.wanted(r); err != nil {
+			writeJSONError(w, err.Error(), http.StatusBadRequest, errCodeInvalidRequest)
+			return
+		}
+	}
+	if rdap != nil {
+		if _, err := rdap.wanted(r); err != nil {
+			writeJSONError(w, err.Error(), http.StatusBadRequest, errCodeInvalidRequest)
+			return
+		}
+	}
+	if _, err := parseFields(r); err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest, errCodeInvalidRequest)
+		return
+	}
+	if _, err := parseLang(r); err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest, errCodeInvalidRequest)
+		return
+	}
+	if _, err := parseAllNames(r); err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest, errCodeInvalidRequest)
 		return
 	}
+	if _, err := negotiateFormat(r); err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest, errCodeInvalidRequest)
+		return
+	}
+	w.Header().Add("Vary", "Accept")
 
-	response := map[string]any{
-		"ip":           ip.String(),
-		"city":         record.City.Names["en"],
-		"country_code": record.Country.IsoCode,
-		"country_name": record.Country.Names["en"],
-		"continent":    record.Continent.Names["en"],
-		"latitude":     record.Location.Latitude,
-		"longitude":    record.Location.Longitude,
-		"time_zone":    record.Location.TimeZone,
-		"postal_code":  record.Postal.Code,
+	response, err := lookupIP(r.Context(), ip)
+	if errors.Is(err, errNoRecord) {
+		writeLookupMiss(w, r, ip)
+		return
 	}
-	if record.Subdivisions != nil && len(record.Subdivisions) > 0 {
-		response["subdivision_name"] = record.Subdivisions[0].Names["en"]
+	if err != nil {
+		log.Printf("GeoIP lookup for IP %s failed: %v", ip.String(), err)
+		writeJSONError(w, fmt.Sprintf("GeoIP lookup failed for IP: %s", ip.String()), http.StatusInternalServerError, errCodeDBError)
+		return
 	}
+	addRequestFields(r, ip, response)
+	writeLookupResponse(w, r, ip, response)
+}
 
-	w.Header().Set("Content-Type", "application/json")
+// wantsRequestFields reports whether r asks for fields that are added to a
+// lookup per request, such as the hostname, or localized names.
+func wantsRequestFields(r *http.Request) bool {
+	return wantsHostname(r) || wantsRisk(r) || wantsDNSBL(r) || wantsWhois(r) || wantsLocalizedNames(r) || wantsAllNames(r)
+}
+
+// addRequestFields adds the fields r asks for to the lookup response of ip,
+// and localizes its names.
+func addRequestFields(r *http.Request, ip net.IP, response map[string]any) {
+	addAllNames(r, ip, response)
+	localizeNames(r, ip, response)
+	addHostname(r, ip, response)
+	addRisk(r, ip, response)
+	addDNSBLListings(r, ip, response)
+	addWhois(r, ip, response)
+}
+
+// writeLookupResponse sends a successful lookup response, with the geo
+// headers when they are enabled.
+func writeLookupResponse(w http.ResponseWriter, r *http.Request, ip net.IP, response map[string]any) {
+	setGeoHeaders(w, response)
+	writeLookupBody(w, r, ip, response)
+}
+
+// writeLookupBody sends the fields of response r selects, in the format it
+// asks for. Invalid format values are rejected before this is consulted.
+func writeLookupBody(w http.ResponseWriter, r *http.Request, ip net.IP, response map[string]any) {
+	format, err := negotiateFormat(r)
+	if err != nil {
+		format = jsonFormat
+	}
+	start := time.Now()
+	body, err := format.encode(selectFields(r, response))
+	stageEncode.since(start)
+	if err != nil {
+		log.Printf("Error encoding %s response for IP %s: %v", format.name, ip.String(), err)
+		writeJSONError(w, fmt.Sprintf("Could not encode the response as %s", format.name), http.StatusInternalServerError, errCodeInternal)
+		return
+	}
+	w.Header().Set("Content-Type", format.contentType)
 	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("Error encoding JSON response for IP %s: %v", ip.String(), err)
+	w.Write(body)
+}
+
+// configureServices sets up the process-wide lookup chain and the services
+// enabled by cfg, for the HTTP and MCP servers alike.
+func configureServices(cfg Config) {
+	lookupSources = newLookupChain(cfg)
+	templateFormats = cfg.ResponseTemplates
+	geoHeaders = cfg.GeoHeaders
+	policies = cfg.Policies
+	adminToken = cfg.AdminToken
+	torExitListEnabled = cfg.TorExitListEnabled
+	if cfg.SLOAvailabilityTarget > 0 || cfg.SLOLatencyTarget > 0 {
+		slos = newSLOTracker(cfg.SLOAvailabilityTarget, cfg.SLOLatencyTarget, cfg.SLOLatencyThreshold, cfg.SLOPeriod)
+		if cfg.SLOLatencyTarget > 0 {
+			stats.slowThreshold = cfg.SLOLatencyThreshold
+		}
+	}
+	if cfg.WhoisEnabled {
+		rdap = newRDAPClient(cfg.RDAPTimeout, cfg.RDAPCacheTTL, cfg.RDAPRateLimit)
+	}
+	if cfg.MinFraudEnabled {
+		minFraud = newMinFraudClient(cfg.MinFraudURL, cfg.MinFraudAccountID, cfg.MinFraudLicenseKey, cfg.MinFraudTimeout, cfg.MinFraudCacheTTL, cfg.MinFraudDefault)
+	}
+	if len(cfg.DNSBLZones) > 0 {
+		dnsbl = newDNSBLChecker(cfg.DNSBLZones, cfg.DNSBLTimeout, cfg.DNSBLCacheTTL, cfg.DNSBLDefault)
+	}
+	if cfg.RDNSEnabled {
+		rdns = newRDNSResolver(cfg.RDNSTimeout, cfg.RDNSCacheTTL, cfg.RDNSDefault)
+	}
+	if cfg.HostnameLookupEnabled {
+		hostnames = newHostnameResolver(cfg.HostnameLookupResolver, cfg.HostnameLookupTimeout)
+	}
+	if cfg.GroupcacheSelf != "" {
+		lookupCache = newSharedCache(cfg.GroupcacheSelf, cfg.GroupcacheSecret, cfg.GroupcachePeers, cfg.GroupcacheSizeBytes)
+	}
+	if cfg.RateLimitRPS > 0 {
+		limiter = newRateLimiter(cfg.RateLimitRPS, cfg.RateLimitBurst)
+		limiter.trustedProxies = cfg.RateLimitTrustedProxies
+	}
+	if cfg.MaxConcurrentRequests > 0 {
+		shedder = &loadShedder{max: int64(cfg.MaxConcurrentRequests)}
 	}
 }
 
@@ -282,27 +561,93 @@ func main() {
 		// loadConfig now logs detailed messages, so a fatal log here is sufficient.
 		log.Fatalf("Configuration error: %v", err)
 	}
+	appConfig = cfg
 
+	var updater *dbUpdater
+	if source := updateSource(cfg); source != nil {
+		updater = newDBUpdater(cfg.GeoIPDBPath, source, cfg.DBUpdateInterval, nil)
+	}
+	if cfg.GeoIPDBURL != "" {
+		// A copy left by an earlier run is used as is; the updater refreshes it.
+		if _, err := os.Stat(cfg.GeoIPDBPath); os.IsNotExist(err) {
+			log.Printf("Downloading GeoIP database from %s", cfg.GeoIPDBURL)
+			if _, err := updater.save(context.Background()); err != nil {
+				log.Fatalf("Error downloading GeoIP database from %s: %v", cfg.GeoIPDBURL, err)
+			}
+			updater.lastDownload = time.Now()
+		}
+	}
 	log.Printf("Attempting to load GeoIP database from: %s", cfg.GeoIPDBPath)
-	geoDB, err = geoip2.Open(cfg.GeoIPDBPath)
-	if err != nil {
-		log.Fatalf("Error opening GeoIP database at %s: %v", cfg.GeoIPDBPath, err)
+	if cfg.GeoIPProvider != providerMaxMind {
+		p, err := newProvider(cfg.GeoIPProvider, cfg.GeoIPDBPath)
+		if err != nil {
+			log.Fatalf("Error opening GeoIP database at %s: %v", cfg.GeoIPDBPath, err)
+		}
+		primaryDB = p
+	} else {
+		mm := &maxMindProvider{path: cfg.GeoIPDBPath}
+		primaryDB = mm
+		if err := mm.load(cfg.GeoIPDBPath); errors.Is(err, fs.ErrNotExist) && len(embeddedGeoDB) > 0 {
+			log.Printf("GeoIP database %s not found; serving the embedded database.", cfg.GeoIPDBPath)
+			if err := mm.loadEmbedded(); err != nil {
+				log.Fatalf("Error opening the embedded GeoIP database: %v", err)
+			}
+		} else if err != nil {
+			log.Fatalf("Error opening GeoIP database at %s: %v", cfg.GeoIPDBPath, err)
+		}
+		if isCountryDatabase(mm.current()) {
+			log.Printf("%s is a Country database: lookups include country and continent fields only.", mm.databaseType())
+		}
 	}
 	defer func() {
-		if err := geoDB.Close(); err != nil {
+		if err := primaryDB.Close(); err != nil {
 			log.Printf("Error closing GeoIP database: %v", err)
 		}
 	}()
 	log.Println("GeoIP database loaded successfully.")
+	configureSupplementaryDBs(cfg)
+	if err := loadSupplementaryDBs(); err != nil {
+		log.Fatalf("Error %v", err)
+	}
+	if cfg.OverridesPath != "" {
+		if err := loadOverrides(cfg.OverridesPath); err != nil {
+			log.Fatalf("Error loading IP overrides from %s: %v", cfg.OverridesPath, err)
+		}
+	}
+	if len(cfg.ThreatLists) > 0 {
+		threats = newThreatIntel("Threat list", cfg.ThreatLists)
+		threats.refresh(context.Background())
+	}
+	if len(cfg.CloudRanges) > 0 {
+		cloudRanges = newThreatIntel("Cloud range list", cfg.CloudRanges)
+	}
+	if cfg.GeoNamesPath != "" {
+		if err := loadGeoNames(cfg.GeoNamesPath); err != nil {
+			log.Fatalf("Error loading GeoNames from %s: %v", cfg.GeoNamesPath, err)
+		}
+	}
+	if cfg.JobsDir != "" {
+		if jobs, err = newJobQueue(cfg.JobsDir, cfg.JobsMaxQueued, cfg.JobsMaxUploadBytes, cfg.JobsRetention); err != nil {
+			log.Fatalf("Error opening the jobs directory %s: %v", cfg.JobsDir, err)
+		}
+	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", rootHandler) // Handle the root path
-	mux.HandleFunc("/lookup/", lookupHandler)
-	mux.HandleFunc("/healthz", healthzHandler)
+	configureServices(cfg)
+
+	if cfg.MCPTransport == "stdio" {
+		// stdout carries the protocol; logs already go to stderr.
+		log.Println("Serving MCP over stdio.")
+		if err := serveMCPStdio(os.Stdin, os.Stdout); err != nil {
+			log.Printf("MCP stdio server stopped: %v", err)
+		}
+		return
+	}
+
+	router := newRouter(cfg)
 
 	server := &http.Server{
 		Addr:              cfg.ListenAddr,
-		Handler:           corsMiddleware(mux, cfg.AllowedCORSAccessOrigins), // Apply CORS middleware
+		Handler:           traceMiddleware(statsMiddleware(corsMiddleware(validationMiddleware(throttleMiddleware(router)), cfg.AllowedCORSAccessOrigins)), cfg.AccessLogEnabled),
 		ReadTimeout:       5 * time.Second,
 		WriteTimeout:      10 * time.Second,
 		IdleTimeout:       120 * time.Second,
@@ -312,20 +657,213 @@ func main() {
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+
+	upgrade := make(chan os.Signal, 1)
+	if cfg.HotUpgradeEnabled {
+		signal.Notify(upgrade, upgradeSignal)
+	}
+
+	ln, err := upgrades.listen("tcp", cfg.ListenAddr)
+	if err != nil {
+		log.Fatalf("Could not listen on %s: %v\n", cfg.ListenAddr, err)
+	}
 	go func() {
-		log.Printf("Server starting on %s", cfg.ListenAddr)
-		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			log.Fatalf("Could not listen on %s: %v\n", cfg.ListenAddr, err)
+		v, c, _ := buildInfo()
+		log.Printf("Server %s (commit %s) starting on %s", v, c, cfg.ListenAddr)
+		if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Could not serve on %s: %v\n", cfg.ListenAddr, err)
 		}
 	}()
+
+	var coap *coapServer
+	if cfg.CoAPListenAddr != "" {
+		coap, err = newCoAPServer(cfg.CoAPListenAddr, cfg.CoAPRateLimit)
+		if err != nil {
+			log.Fatalf("Could not listen for CoAP on %s: %v", cfg.CoAPListenAddr, err)
+		}
+		go coap.serve()
+		log.Printf("CoAP server listening on udp %s", cfg.CoAPListenAddr)
+	}
+
+	var grpcServer *grpc.Server
+	if cfg.GRPCListenAddr != "" {
+		grpcLn, err := upgrades.listen("tcp", cfg.GRPCListenAddr)
+		if err != nil {
+			log.Fatalf("Could not listen for gRPC on %s: %v", cfg.GRPCListenAddr, err)
+		}
+		grpcServer = newGRPCServer()
+		go serveGRPC(grpcServer, grpcLn)
+		log.Printf("gRPC server listening on %s", cfg.GRPCListenAddr)
+	}
+
+	var tcpServers []*tcpServer
+	if cfg.RESPListenAddr != "" {
+		h := &respHandler{encode: respEncoders[cfg.RESPEncoding]}
+		srv, err := newTCPServer("RESP", cfg.RESPListenAddr, h.serveConn)
+		if err != nil {
+			log.Fatalf("Could not listen for RESP on %s: %v", cfg.RESPListenAddr, err)
+		}
+		go srv.serve()
+		tcpServers = append(tcpServers, srv)
+		log.Printf("Redis protocol server listening on %s", cfg.RESPListenAddr)
+	}
+	if cfg.MemcachedListenAddr != "" {
+		srv, err := newTCPServer("memcached", cfg.MemcachedListenAddr, serveMemcachedConn)
+		if err != nil {
+			log.Fatalf("Could not listen for memcached on %s: %v", cfg.MemcachedListenAddr, err)
+		}
+		go srv.serve()
+		tcpServers = append(tcpServers, srv)
+		log.Printf("Memcached protocol server listening on %s", cfg.MemcachedListenAddr)
+	}
+
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	if cfg.AlertWebhookURL != "" {
+		alerts = newAlerter(cfg.AlertWebhookURL, cfg.AlertCooldown)
+		go runAlertChecks(backgroundCtx, cfg.AlertDBMaxAge, cfg.AlertErrorRate, cfg.AlertRejectRate)
+	}
+	if cfg.HeartbeatURL != "" {
+		go runHeartbeat(backgroundCtx, cfg.HeartbeatURL, cfg.HeartbeatInterval)
+	}
+	canaryPercent, canaryDuration = cfg.DBCanaryPercent, cfg.DBCanaryDuration
+	if updater != nil {
+		var elector leaderElector
+		if cfg.DBUpdateLeaderElection != "" {
+			elector, err = newLeaderElector(cfg.DBUpdateLeaderElection, cfg.DBUpdateLockFile, cfg.DBUpdateLeaseName)
+			if err != nil {
+				log.Fatalf("Database updater error: %v", err)
+			}
+		}
+		updater.elector = elector
+		go updater.run(backgroundCtx)
+	}
+	if cfg.DBWatchEnabled {
+		watcher, err := newDBWatcher(cfg.GeoIPDBPath, cfg.DBWatchDebounce)
+		if err != nil {
+			log.Fatalf("Database watcher error: %v", err)
+		}
+		go watcher.run(backgroundCtx)
+	}
+	if cfg.DBRefreshInterval > 0 {
+		go runDBRefresh(backgroundCtx, cfg.GeoIPDBPath, cfg.DBRefreshInterval)
+	}
+	if threats != nil {
+		go threats.run(backgroundCtx, cfg.ThreatListsInterval)
+	}
+	if cloudRanges != nil {
+		// The feeds are on the internet, so the first read does not hold up
+		// startup; lookups report null until it is done.
+		go func() {
+			cloudRanges.refresh(backgroundCtx)
+			cloudRanges.run(backgroundCtx, cfg.CloudRangesInterval)
+		}()
+	}
+	if jobs != nil {
+		go jobs.run(backgroundCtx, cfg.JobsWorkers)
+	}
+	if cfg.TorExitListEnabled {
+		go runTorExitList(backgroundCtx, cfg.TorExitListURL, cfg.TorExitListInterval)
+	}
+	if lookupCache != nil && cfg.GroupcachePeersDNS != "" {
+		go lookupCache.discoverPeers(backgroundCtx, cfg.GroupcachePeersDNS)
+	}
+
+	var registry serviceRegistry
+	if cfg.ServiceRegistry != "" {
+		registry, err = newServiceRegistry(cfg.ServiceRegistry, cfg.ServiceRegistryAddr, cfg.ServiceRegistryAuth, cfg.ServiceInstance)
+		if err != nil {
+			log.Fatalf("Service registry error: %v", err)
+		}
+		regCtx, cancelReg := context.WithTimeout(context.Background(), 15*time.Second)
+		if err := registry.register(regCtx); err != nil {
+			// Keep serving; the instance is reachable even if discovery is down.
+			log.Printf("Could not register with %s: %v", cfg.ServiceRegistry, err)
+			registry = nil
+		} else {
+			log.Printf("Registered with %s as %s", cfg.ServiceRegistry, cfg.ServiceInstance.ID)
+		}
+		cancelReg()
+	}
+	if upgrades.inheriting() {
+		log.Println("Took over the previous process's listeners; signalling it to drain.")
+	}
+	if err := upgrades.ready(cfg.PIDFile); err != nil {
+		log.Printf("Could not complete startup handover: %v", err)
+	}
 	log.Println("Server started. Press Ctrl+C to shut down.")
 
-	<-stop
+	upgraded := false
+wait:
+	for {
+		select {
+		case <-stop:
+			break wait
+		case <-reload:
+			log.Printf("Reload requested: reopening the GeoIP database at %s...", cfg.GeoIPDBPath)
+			if err := primaryDB.reload(); err != nil {
+				log.Printf("GeoIP database reload failed, keeping the current one: %v", err)
+			}
+			reloadSupplementaryDBs()
+			if cfg.OverridesPath != "" {
+				if err := loadOverrides(cfg.OverridesPath); err != nil {
+					log.Printf("Overrides reload failed, keeping the current ones: %v", err)
+				}
+			}
+			if cfg.GeoNamesPath != "" {
+				if err := loadGeoNames(cfg.GeoNamesPath); err != nil {
+					log.Printf("GeoNames reload failed, keeping the current data: %v", err)
+				}
+			}
+		case <-upgrade:
+			log.Println("Upgrade requested: starting the new binary...")
+			pid, err := upgrades.upgrade()
+			if err != nil {
+				log.Printf("Upgrade failed, continuing to serve: %v", err)
+				continue
+			}
+			log.Printf("Upgrade complete: process %d is serving; draining this one.", pid)
+			upgraded = true
+			break wait
+		}
+	}
 	log.Println("Shutting down server...")
+	// Stop background work now so the database updater can hand over
+	// leadership while connections drain.
+	stopBackground()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
+	// Leave service discovery first so no new traffic is routed here while
+	// draining. After an upgrade the new process holds the same registration.
+	if registry != nil && !upgraded {
+		if err := registry.deregister(ctx); err != nil {
+			log.Printf("Could not deregister from %s: %v", cfg.ServiceRegistry, err)
+		} else {
+			log.Printf("Deregistered from %s", cfg.ServiceRegistry)
+		}
+	}
+
+	for _, srv := range tcpServers {
+		if err := srv.close(); err != nil {
+			log.Printf("Error closing %s listener: %v", srv.name, err)
+		}
+	}
+	if coap != nil {
+		if err := coap.close(); err != nil {
+			log.Printf("Error closing CoAP listener: %v", err)
+		}
+	}
+
+	if grpcServer != nil {
+		if err := shutdownGRPC(ctx, grpcServer); err != nil {
+			log.Printf("Error shutting down gRPC server: %v", err)
+		}
+	}
+
 	if err := server.Shutdown(ctx); err != nil {
 		log.Fatalf("Server shutdown failed: %v", err)
 	}
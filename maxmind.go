@@ -0,0 +1,147 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// defaultMaxMindDownloadURL is the base URL of MaxMind's database downloads.
+const defaultMaxMindDownloadURL = "https://download.maxmind.com"
+
+// maxMindSource downloads a database edition (e.g. GeoLite2-City) from
+// MaxMind with an account's license key, as geoipupdate does. The archive is
+// checked against the SHA-256 MaxMind publishes alongside it.
+type maxMindSource struct {
+	baseURL    string
+	accountID  string
+	licenseKey string
+	edition    string
+}
+
+// downloadURL returns the URL of the edition's archive, or of its checksum
+// when suffix is "tar.gz.sha256".
+func (s maxMindSource) downloadURL(suffix string) string {
+	return fmt.Sprintf("%s/geoip/databases/%s/download?suffix=%s",
+		strings.TrimRight(s.baseURL, "/"), url.PathEscape(s.edition), suffix)
+}
+
+// get requests the edition's file with suffix. MaxMind redirects downloads to
+// a signed storage URL; the client drops the credentials on the redirect.
+func (s maxMindSource) get(ctx context.Context, client *http.Client, suffix string, modTime time.Time) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.downloadURL(suffix), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(s.accountID, s.licenseKey)
+	if !modTime.IsZero() {
+		req.Header.Set("If-Modified-Since", modTime.UTC().Format(http.TimeFormat))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp, nil
+	case http.StatusNotModified:
+		resp.Body.Close()
+		return nil, errNotModified
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	resp.Body.Close()
+	return nil, fmt.Errorf("MaxMind returned %s for %s: %s", resp.Status, s.edition, bytes.TrimSpace(body))
+}
+
+func (s maxMindSource) fetch(ctx context.Context, client *http.Client, modTime time.Time, dst io.Writer) (time.Time, error) {
+	resp, err := s.get(ctx, client, "tar.gz", modTime)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	hash := sha256.New()
+	body := io.TeeReader(resp.Body, hash)
+	if err := extractMMDB(body, dst); err != nil {
+		return time.Time{}, fmt.Errorf("reading the %s archive: %v", s.edition, err)
+	}
+	// Hash the rest of the archive too, e.g. the tar padding.
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		return time.Time{}, err
+	}
+
+	want, err := s.checksum(ctx, client)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("fetching the %s checksum: %v", s.edition, err)
+	}
+	if got := hex.EncodeToString(hash.Sum(nil)); got != want {
+		return time.Time{}, fmt.Errorf("%s archive checksum mismatch: got %s, want %s", s.edition, got, want)
+	}
+	lastModified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return lastModified, nil
+}
+
+// checksum returns the published SHA-256 of the edition's archive. The file
+// has the sha256sum format, "<hex>  <archive name>".
+func (s maxMindSource) checksum(ctx context.Context, client *http.Client) (string, error) {
+	resp, err := s.get(ctx, client, "tar.gz.sha256", time.Time{})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+		return "", errors.New("malformed checksum file")
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+// extractMMDB copies the .mmdb file of a MaxMind tar.gz archive, which holds
+// it in a dated directory (e.g. GeoLite2-City_20240102/GeoLite2-City.mmdb)
+// next to the license files, to dst.
+func extractMMDB(archive io.Reader, dst io.Writer) error {
+	gz, err := gzip.NewReader(archive)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	if err := copyTarMMDB(gz, dst); err != nil {
+		return err
+	}
+	// Read to the end so the gzip checksum is verified.
+	_, err = io.Copy(io.Discard, gz)
+	return err
+}
+
+// copyTarMMDB copies the first .mmdb file of a tar stream to dst.
+func copyTarMMDB(r io.Reader, dst io.Writer) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return errors.New("no .mmdb file in the archive")
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag == tar.TypeReg && path.Ext(header.Name) == ".mmdb" {
+			_, err := io.Copy(dst, tr)
+			return err
+		}
+	}
+}
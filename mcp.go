@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mcpProtocolVersion is the Model Context Protocol revision implemented here.
+const mcpProtocolVersion = "2024-11-05"
+
+// maxMCPMessageSize caps the size of a single JSON-RPC message.
+const maxMCPMessageSize = 1 << 20
+
+// JSON-RPC 2.0 error codes used by the MCP server.
+const (
+	jsonRPCParseError     = -32700
+	jsonRPCInvalidRequest = -32600
+	jsonRPCMethodNotFound = -32601
+	jsonRPCInvalidParams  = -32602
+)
+
+type jsonRPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type jsonRPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *jsonRPCError   `json:"error,omitempty"`
+}
+
+type jsonRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// mcpTool describes a tool advertised through tools/list.
+type mcpTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"inputSchema"`
+}
+
+// mcpTools lists the tools exposed to MCP clients. lookup_ips takes as many
+// IPs as a POST /lookup and is not offered when BATCH_LOOKUP_MAX_SIZE
+// disables batches.
+func mcpTools() []mcpTool {
+	tools := []mcpTool{{
+		Name:        "lookup_ip",
+		Description: "Look up geolocation data (city, country, continent, coordinates, time zone, postal code) for a single IPv4 or IPv6 address.",
+		InputSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"ip": map[string]any{"type": "string", "description": "IPv4 or IPv6 address to look up"},
+			},
+			"required": []string{"ip"},
+		},
+	}}
+	if limit := appConfig.BatchLookupMaxSize; limit > 0 {
+		tools = append(tools, mcpTool{
+			Name:        "lookup_ips",
+			Description: fmt.Sprintf("Look up geolocation data for up to %d IP addresses at once. Results are returned in input order with per-item errors.", limit),
+			InputSchema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"ips": map[string]any{
+						"type":        "array",
+						"items":       map[string]any{"type": "string"},
+						"maxItems":    limit,
+						"description": "IPv4 or IPv6 addresses to look up",
+					},
+				},
+				"required": []string{"ips"},
+			},
+		})
+	}
+	if minFraud != nil {
+		tools = append(tools, mcpTool{
+			Name:        "check_ip_risk",
+			Description: "Score the fraud risk of an IPv4 or IPv6 address with minFraud Insights: risk_score is the risk of a transaction from the IP and ip_risk the risk of the IP itself, both from 0.01 to 99.",
+			InputSchema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"ip": map[string]any{"type": "string", "description": "IPv4 or IPv6 address to score"},
+				},
+				"required": []string{"ip"},
+			},
+		})
+	}
+	return tools
+}
+
+// mcpToolResult builds a tools/call result carrying a single text content block.
+func mcpToolResult(v any, isError bool) map[string]any {
+	text, ok := v.(string)
+	if !ok {
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			text, isError = fmt.Sprintf("Error encoding result: %v", err), true
+		} else {
+			text = string(encoded)
+		}
+	}
+	return map[string]any{
+		"content": []map[string]any{{"type": "text", "text": text}},
+		"isError": isError,
+	}
+}
+
+func mcpCallTool(params json.RawMessage) (any, *jsonRPCError) {
+	var call struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal(params, &call); err != nil {
+		return nil, &jsonRPCError{Code: jsonRPCInvalidParams, Message: "Invalid tools/call params"}
+	}
+
+	switch call.Name {
+	case "lookup_ip":
+		var args struct {
+			IP string `json:"ip"`
+		}
+		if err := json.Unmarshal(call.Arguments, &args); err != nil || args.IP == "" {
+			return mcpToolResult("Argument 'ip' is required", true), nil
+		}
+		result, ok := lookupBatchItem(context.Background(), args.IP)
+		if !ok {
+			return mcpToolResult(result["error"], true), nil
+		}
+		return mcpToolResult(result, false), nil
+	case "lookup_ips":
+		limit := appConfig.BatchLookupMaxSize
+		if limit == 0 {
+			break
+		}
+		var args struct {
+			IPs []string `json:"ips"`
+		}
+		if err := json.Unmarshal(call.Arguments, &args); err != nil || len(args.IPs) == 0 {
+			return mcpToolResult("Argument 'ips' must be a non-empty array of strings", true), nil
+		}
+		if len(args.IPs) > limit {
+			return mcpToolResult(fmt.Sprintf("Too many IPs: %d (maximum %d)", len(args.IPs), limit), true), nil
+		}
+		results := make([]map[string]any, 0, len(args.IPs))
+		for _, ipStr := range args.IPs {
+			result, _ := lookupBatchItem(context.Background(), ipStr)
+			results = append(results, result)
+		}
+		return mcpToolResult(results, false), nil
+	case "check_ip_risk":
+		if minFraud == nil {
+			break
+		}
+		var args struct {
+			IP string `json:"ip"`
+		}
+		if err := json.Unmarshal(call.Arguments, &args); err != nil || args.IP == "" {
+			return mcpToolResult("Argument 'ip' is required", true), nil
+		}
+		ip := net.ParseIP(strings.TrimSpace(args.IP))
+		if ip == nil {
+			return mcpToolResult(fmt.Sprintf("Invalid IP address format: %s", args.IP), true), nil
+		}
+		risk, err := minFraud.risk(ip)
+		if err != nil {
+			log.Printf("minFraud query for IP %s failed: %v", ip.String(), err)
+			return mcpToolResult("The risk of the IP could not be scored", true), nil
+		}
+		return mcpToolResult(map[string]any{"ip": ip.String(), "risk_score": risk.RiskScore, "ip_risk": risk.IPAddress.Risk}, false), nil
+	}
+	return nil, &jsonRPCError{Code: jsonRPCInvalidParams, Message: fmt.Sprintf("Unknown tool: %s", call.Name)}
+}
+
+// handleMCPMessage processes one JSON-RPC message and returns the response to
+// send back, or nil for notifications.
+func handleMCPMessage(raw []byte) *jsonRPCResponse {
+	var req jsonRPCRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return &jsonRPCResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &jsonRPCError{Code: jsonRPCParseError, Message: "Parse error"}}
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return &jsonRPCResponse{JSONRPC: "2.0", ID: idOrNull(req.ID), Error: &jsonRPCError{Code: jsonRPCInvalidRequest, Message: "Invalid request"}}
+	}
+
+	// Requests without an ID are notifications and never receive a response.
+	isNotification := len(req.ID) == 0
+
+	var result any
+	var rpcErr *jsonRPCError
+	switch req.Method {
+	case "initialize":
+		result = map[string]any{
+			"protocolVersion": mcpProtocolVersion,
+			"capabilities":    map[string]any{"tools": map[string]any{}},
+			"serverInfo":      map[string]any{"name": "ip-lookup", "version": "1.0.0"},
+		}
+	case "ping":
+		result = map[string]any{}
+	case "tools/list":
+		result = map[string]any{"tools": mcpTools()}
+	case "tools/call":
+		result, rpcErr = mcpCallTool(req.Params)
+	default:
+		if isNotification {
+			// e.g. notifications/initialized, notifications/cancelled
+			return nil
+		}
+		rpcErr = &jsonRPCError{Code: jsonRPCMethodNotFound, Message: fmt.Sprintf("Method not found: %s", req.Method)}
+	}
+
+	if isNotification {
+		return nil
+	}
+	return &jsonRPCResponse{JSONRPC: "2.0", ID: req.ID, Result: result, Error: rpcErr}
+}
+
+func idOrNull(id json.RawMessage) json.RawMessage {
+	if len(id) == 0 {
+		return json.RawMessage("null")
+	}
+	return id
+}
+
+// serveMCPStdio runs the MCP server over newline-delimited JSON on r/w until r
+// is exhausted.
+func serveMCPStdio(r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxMCPMessageSize)
+	encoder := json.NewEncoder(w)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		if resp := handleMCPMessage(line); resp != nil {
+			if err := encoder.Encode(resp); err != nil {
+				return fmt.Errorf("writing MCP response: %w", err)
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// mcpSSEServer implements the MCP HTTP+SSE transport: clients open an event
+// stream at /mcp/sse and post JSON-RPC messages to the endpoint it announces.
+type mcpSSEServer struct {
+	mu       sync.Mutex
+	sessions map[string]chan []byte
+}
+
+func newMCPSSEServer() *mcpSSEServer {
+	return &mcpSSEServer{sessions: make(map[string]chan []byte)}
+}
+
+func (s *mcpSSEServer) streamHandler(w http.ResponseWriter, r *http.Request) {
+	sessionBytes := make([]byte, 16)
+	if _, err := rand.Read(sessionBytes); err != nil {
+		log.Printf("MCP: could not generate session ID: %v", err)
+		writeJSONError(w, "Could not create MCP session", http.StatusInternalServerError, errCodeInternal)
+		return
+	}
+	sessionID := hex.EncodeToString(sessionBytes)
+	messages := make(chan []byte, 16)
+
+	s.mu.Lock()
+	s.sessions[sessionID] = messages
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.sessions, sessionID)
+		s.mu.Unlock()
+	}()
+
+	// The stream outlives the server's WriteTimeout, so lift it for this connection.
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("MCP: could not clear write deadline for SSE stream: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "event: endpoint\ndata: /mcp/messages?sessionId=%s\n\n", sessionID)
+	if err := rc.Flush(); err != nil {
+		log.Printf("MCP: streaming not supported: %v", err)
+		return
+	}
+	// The messages of the session are posted, and shed, separately.
+	releaseShedderSlot(r)
+
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg := <-messages:
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", msg)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *mcpSSEServer) messageHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.URL.Query().Get("sessionId")
+	s.mu.Lock()
+	messages, ok := s.sessions[sessionID]
+	s.mu.Unlock()
+	if !ok {
+		writeJSONError(w, "Unknown or expired MCP session", http.StatusNotFound, errCodeNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxMCPMessageSize))
+	if err != nil {
+		writeJSONError(w, "Could not read request body", http.StatusBadRequest, errCodeInvalidRequest)
+		return
+	}
+
+	if resp := handleMCPMessage(body); resp != nil {
+		encoded, err := json.Marshal(resp)
+		if err != nil {
+			log.Printf("MCP: error encoding response: %v", err)
+			writeJSONError(w, "Could not encode MCP response", http.StatusInternalServerError, errCodeInternal)
+			return
+		}
+		select {
+		case messages <- encoded:
+		case <-r.Context().Done():
+			return
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useConfig serves with cfg for the rest of t.
+func useConfig(t *testing.T, cfg Config) {
+	t.Helper()
+	old := appConfig
+	appConfig = cfg
+	t.Cleanup(func() { appConfig = old })
+}
+
+func TestMCPBatchToolUsesConfiguredLimit(t *testing.T) {
+	useConfig(t, Config{BatchLookupMaxSize: 2})
+
+	call := `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"lookup_ips","arguments":{"ips":["8.8.8.8","1.1.1.1","9.9.9.9"]}}}`
+	resp := handleMCPMessage([]byte(call))
+	result, _ := resp.Result.(map[string]any)
+	if result["isError"] != true {
+		t.Fatalf("lookup_ips of 3 IPs with a limit of 2 = %v, want an error", resp.Result)
+	}
+	text := result["content"].([]map[string]any)[0]["text"].(string)
+	if !strings.Contains(text, "maximum 2") {
+		t.Errorf("error = %q, want it to name the limit of 2", text)
+	}
+}
+
+func TestMCPBatchToolDisabled(t *testing.T) {
+	useConfig(t, Config{})
+
+	encoded, _ := json.Marshal(handleMCPMessage([]byte(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)).Result)
+	if strings.Contains(string(encoded), "lookup_ips") {
+		t.Errorf("tools/list = %s, want no lookup_ips with BATCH_LOOKUP_MAX_SIZE=0", encoded)
+	}
+	resp := handleMCPMessage([]byte(`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"lookup_ips","arguments":{"ips":["8.8.8.8"]}}}`))
+	if resp.Error == nil || resp.Error.Code != jsonRPCInvalidParams {
+		t.Errorf("lookup_ips with batches disabled = %+v, want an unknown tool error", resp)
+	}
+}
+
+func TestMCPCheckIPRisk(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"risk_score": 12.5, "ip_address": {"risk": 3.25}}`))
+	}))
+	defer server.Close()
+	old := minFraud
+	minFraud = newMinFraudClient(server.URL, "1", "key", time.Second, time.Minute, false)
+	t.Cleanup(func() { minFraud = old })
+
+	encoded, _ := json.Marshal(handleMCPMessage([]byte(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)).Result)
+	if !strings.Contains(string(encoded), "check_ip_risk") {
+		t.Errorf("tools/list = %s, want check_ip_risk with minFraud enabled", encoded)
+	}
+	resp := handleMCPMessage([]byte(`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"check_ip_risk","arguments":{"ip":"81.2.69.160"}}}`))
+	result, _ := resp.Result.(map[string]any)
+	if result["isError"] != false {
+		t.Fatalf("check_ip_risk = %+v, want a result", resp)
+	}
+	var risk map[string]any
+	json.Unmarshal([]byte(result["content"].([]map[string]any)[0]["text"].(string)), &risk)
+	if risk["risk_score"] != 12.5 || risk["ip_risk"] != 3.25 {
+		t.Errorf("check_ip_risk = %v, want risk_score 12.5 and ip_risk 3.25", risk)
+	}
+}
+
+func TestMCPStreamReleasesShedderSlot(t *testing.T) {
+	old := shedder
+	shedder = &loadShedder{max: 1}
+	t.Cleanup(func() { shedder = old })
+
+	mcpServer := newMCPSSEServer()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /mcp/sse", mcpServer.streamHandler)
+	mux.HandleFunc("POST /mcp/messages", mcpServer.messageHandler)
+	server := httptest.NewServer(throttleMiddleware(mux))
+	defer server.Close()
+
+	stream, err := http.Get(server.URL + "/mcp/sse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+	event := make([]byte, 512)
+	if _, err := stream.Body.Read(event); err != nil {
+		t.Fatalf("reading the endpoint event: %v", err)
+	}
+
+	resp, err := http.Post(server.URL+"/mcp/messages?sessionId=unknown", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("message during an open stream: status = %d, want 404 rather than a shed 503", resp.StatusCode)
+	}
+}
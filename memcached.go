@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This file implements the read path of the memcached text protocol, so
+// stacks with an existing memcached client can fetch lookups with
+// "get geo:<ip>". Values are JSON-encoded lookup responses.
+
+// memcachedMaxKeyLength is memcached's own key length limit.
+const memcachedMaxKeyLength = 250
+
+// memcachedMaxLineLength bounds a command line (a get with many keys).
+const memcachedMaxLineLength = 64 << 10
+
+func serveMemcachedConn(conn net.Conn) {
+	r := bufio.NewReaderSize(conn, 4096)
+	w := bufio.NewWriter(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(tcpIdleTimeout))
+		line, err := readLimitedLine(r, memcachedMaxLineLength)
+		if err != nil {
+			if errors.Is(err, errLineTooLong) {
+				w.WriteString("CLIENT_ERROR line too long\r\n")
+				w.Flush()
+			}
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			w.WriteString("ERROR\r\n")
+		} else if quit := memcachedDispatch(r, w, fields); quit {
+			w.Flush()
+			return
+		}
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// memcachedDispatch executes one command line, reporting whether the
+// connection should be closed.
+func memcachedDispatch(r *bufio.Reader, w *bufio.Writer, fields []string) bool {
+	switch cmd := strings.ToLower(fields[0]); cmd {
+	case "get", "gets":
+		if len(fields) < 2 {
+			w.WriteString("ERROR\r\n")
+			break
+		}
+		for _, key := range fields[1:] {
+			if len(key) > memcachedMaxKeyLength {
+				w.WriteString("CLIENT_ERROR bad command line format\r\n")
+				return false
+			}
+		}
+		// Every key is looked up before replying, so a failed lookup is
+		// answered with SERVER_ERROR alone rather than after some values.
+		values := make([][]byte, len(fields)-1)
+		for i, key := range fields[1:] {
+			value, err := lookupGeoKey("memcached", key, json.Marshal)
+			if err != nil {
+				w.WriteString("SERVER_ERROR GeoIP lookup failed\r\n")
+				return false
+			}
+			values[i] = value
+		}
+		for i, key := range fields[1:] {
+			value := values[i]
+			if value == nil {
+				continue // misses are simply omitted
+			}
+			if cmd == "gets" {
+				fmt.Fprintf(w, "VALUE %s 0 %d 0\r\n", key, len(value))
+			} else {
+				fmt.Fprintf(w, "VALUE %s 0 %d\r\n", key, len(value))
+			}
+			w.Write(value)
+			w.WriteString("\r\n")
+		}
+		w.WriteString("END\r\n")
+	case "set", "add", "replace", "append", "prepend", "cas":
+		// The data block must be consumed to stay in sync with the client.
+		return memcachedRejectStorage(r, w, fields)
+	case "delete", "incr", "decr", "touch":
+		if fields[len(fields)-1] != "noreply" {
+			w.WriteString("SERVER_ERROR read-only\r\n")
+		}
+	case "version":
+		w.WriteString("VERSION ip-lookup\r\n")
+	case "stats":
+		fmt.Fprintf(w, "STAT uptime %d\r\n", int64(time.Since(stats.started).Seconds()))
+		w.WriteString("END\r\n")
+	case "quit":
+		return true
+	default:
+		w.WriteString("ERROR\r\n")
+	}
+	return false
+}
+
+// memcachedRejectStorage discards the data block of a storage command and
+// replies with an error, since the service is read-only.
+func memcachedRejectStorage(r *bufio.Reader, w *bufio.Writer, fields []string) bool {
+	// <cmd> <key> <flags> <exptime> <bytes> [cas unique] [noreply]
+	if len(fields) < 5 {
+		w.WriteString("ERROR\r\n")
+		return false
+	}
+	size, err := strconv.Atoi(fields[4])
+	if err != nil || size < 0 || size > memcachedMaxLineLength {
+		w.WriteString("CLIENT_ERROR bad data chunk\r\n")
+		return true
+	}
+	if _, err := io.CopyN(io.Discard, r, int64(size)+2); err != nil {
+		return true
+	}
+	if fields[len(fields)-1] != "noreply" {
+		w.WriteString("SERVER_ERROR read-only\r\n")
+	}
+	return false
+}
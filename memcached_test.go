@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMemcachedConversation(t *testing.T) {
+	useFakeProvider(t, map[string]any{"country_code": "GB"})
+	record, err := lookupIPString(context.Background(), "81.2.69.142")
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, _ := json.Marshal(record)
+	size := strconv.Itoa(len(value))
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"get", "get geo:81.2.69.142\r\n", "VALUE geo:81.2.69.142 0 " + size + "\r\n" + string(value) + "\r\nEND\r\n"},
+		{"gets", "gets geo:81.2.69.142\n", "VALUE geo:81.2.69.142 0 " + size + " 0\r\n" + string(value) + "\r\nEND\r\n"},
+		{"get of several keys omits misses", "get geo:x other geo:81.2.69.142\r\n", "VALUE geo:81.2.69.142 0 " + size + "\r\n" + string(value) + "\r\nEND\r\n"},
+		{"get with no key", "get\r\n", "ERROR\r\n"},
+		{"key too long", "get " + strings.Repeat("k", memcachedMaxKeyLength+1) + "\r\n", "CLIENT_ERROR bad command line format\r\n"},
+		{"blank line", "\r\n", "ERROR\r\n"},
+		{"unknown command", "flush_all\r\n", "ERROR\r\n"},
+		{"version", "version\r\n", "VERSION ip-lookup\r\n"},
+		{"set is rejected and its data skipped", "set k 0 0 5\r\nhello\r\nversion\r\n", "SERVER_ERROR read-only\r\nVERSION ip-lookup\r\n"},
+		{"set with noreply", "set k 0 0 5 noreply\r\nhello\r\nversion\r\n", "VERSION ip-lookup\r\n"},
+		{"set with missing fields", "set k 0\r\nversion\r\n", "ERROR\r\nVERSION ip-lookup\r\n"},
+		{"set with bad size", "set k 0 0 x\r\nversion\r\n", "CLIENT_ERROR bad data chunk\r\n"},
+		{"set with negative size", "set k 0 0 -1\r\n", "CLIENT_ERROR bad data chunk\r\n"},
+		{"set with truncated data", "set k 0 0 50\r\nhello", ""},
+		{"delete", "delete k\r\ndelete k noreply\r\n", "SERVER_ERROR read-only\r\n"},
+		{"quit", "quit\r\nversion\r\n", ""},
+		{"line too long", strings.Repeat("x", memcachedMaxLineLength+1) + "\r\n", "CLIENT_ERROR line too long\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := converse(t, serveMemcachedConn, tt.input); got != tt.want {
+				t.Errorf("replies = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemcachedLookupFailure(t *testing.T) {
+	useFakeProvider(t, nil)
+	useLookupChain(t, lookupChain{{name: "database", source: failingSource{}}})
+
+	for _, input := range []string{"get geo:81.2.69.142\r\n", "get other geo:81.2.69.142\r\n"} {
+		if got := converse(t, serveMemcachedConn, input); got != "SERVER_ERROR GeoIP lookup failed\r\n" {
+			t.Errorf("%q: replies = %q, want SERVER_ERROR alone", input, got)
+		}
+	}
+}
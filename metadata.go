@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// dbMetadata describes the build of a loaded database.
+func dbMetadata(reader *maxminddb.Reader) map[string]any {
+	m := reader.Metadata
+	built := time.Unix(int64(m.BuildEpoch), 0).UTC()
+	metadata := map[string]any{
+		"database_type":         m.DatabaseType,
+		"build_epoch":           m.BuildEpoch,
+		"build_time":            built.Format(time.RFC3339),
+		"age_days":              int(time.Since(built).Hours() / 24),
+		"binary_format_version": fmt.Sprintf("%d.%d", m.BinaryFormatMajorVersion, m.BinaryFormatMinorVersion),
+		"ip_version":            m.IPVersion,
+		"node_count":            m.NodeCount,
+		"record_size":           m.RecordSize,
+		"languages":             m.Languages,
+		"description":           m.Description,
+	}
+	if compat, ok := compatibleDatabaseType(m.DatabaseType); ok {
+		metadata["compatible_type"] = compat
+	}
+	return metadata
+}
+
+// metadataHandler serves /metadata: the build of the database serving
+// lookups, a canary being rolled out and the supplementary and fallback
+// databases, so clients and monitoring can check which vintage answers them.
+func metadataHandler(w http.ResponseWriter, r *http.Request) {
+	if !databaseLoaded() {
+		writeJSONError(w, "GeoIP database not loaded", http.StatusInternalServerError, errCodeDBUnavailable)
+		return
+	}
+	response := primaryDB.metadata()
+	if canary := geoCanary.Load(); canary != nil {
+		response["canary"] = dbMetadata(canary.reader)
+	}
+	supplementary := make(map[string]any)
+	for _, d := range supplementaryDBs {
+		if reader := d.reader.Load(); reader != nil {
+			supplementary[d.name] = dbMetadata(reader)
+		}
+	}
+	if len(supplementary) > 0 {
+		response["supplementary_databases"] = supplementary
+	}
+	var fallbacks []map[string]any
+	for _, d := range fallbackDBs {
+		if reader := d.reader.Load(); reader != nil {
+			metadata := dbMetadata(reader)
+			metadata["path"] = d.path
+			fallbacks = append(fallbacks, metadata)
+		}
+	}
+	if fallbacks != nil {
+		response["fallback_databases"] = fallbacks
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error encoding metadata response: %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// metricsContentType is the Prometheus text exposition format.
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// metricsWriter writes metric families in the Prometheus text format.
+type metricsWriter struct {
+	w *bufio.Writer
+}
+
+// family writes the HELP and TYPE lines of a metric.
+func (m *metricsWriter) family(name, kind, help string) {
+	fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes one sample. labels alternate names and values.
+func (m *metricsWriter) sample(name string, value float64, labels ...string) {
+	m.w.WriteString(name)
+	if len(labels) > 0 {
+		m.w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				m.w.WriteByte(',')
+			}
+			fmt.Fprintf(m.w, `%s="%s"`, labels[i], labelValueEscaper.Replace(labels[i+1]))
+		}
+		m.w.WriteByte('}')
+	}
+	m.w.WriteByte(' ')
+	m.w.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	m.w.WriteByte('\n')
+}
+
+// labelValueEscaper escapes the characters reserved in label values.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricsHandler serves /metrics for Prometheus: request and lookup
+// counters, lookup stage histograms and, when configured, the SLO burn rates.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metricsContentType)
+	w.WriteHeader(http.StatusOK)
+	m := &metricsWriter{w: bufio.NewWriter(w)}
+	defer m.w.Flush()
+
+	snapshot := stats.snapshot()
+	counters := []struct{ name, key, help string }{
+		{"ip_lookup_requests_total", "requests", "HTTP requests served."},
+		{"ip_lookup_errors_total", "errors", "HTTP requests answered with a 5xx status."},
+		{"ip_lookup_lookups_total", "lookups", "Successful GeoIP lookups."},
+		{"ip_lookup_lookup_misses_total", "lookup_misses", "Lookups of IPs without a database record."},
+		{"ip_lookup_db_read_errors_total", "db_read_errors", "Failed GeoIP database reads, including retries."},
+	}
+	for _, c := range counters {
+		m.family(c.name, "counter", c.help)
+		m.sample(c.name, float64(snapshot[c.key].(uint64)))
+	}
+	m.family("ip_lookup_uptime_seconds", "gauge", "Seconds since the process started.")
+	m.sample("ip_lookup_uptime_seconds", float64(snapshot["uptime_seconds"].(int64)))
+	if databaseLoaded() {
+		m.family("ip_lookup_db_build_timestamp_seconds", "gauge", "Build time of the serving GeoIP database.")
+		m.sample("ip_lookup_db_build_timestamp_seconds", float64(databaseBuildTime().Unix()))
+	}
+
+	writeStageMetrics(m)
+
+	if slos != nil {
+		writeSLOMetrics(m, slos.report())
+	}
+}
+
+func writeSLOMetrics(m *metricsWriter, reports []sloObjectiveReport) {
+	m.family("ip_lookup_slo_target", "gauge", "Fraction of requests that must be good.")
+	for _, r := range reports {
+		m.sample("ip_lookup_slo_target", r.Target, "slo", r.Name)
+	}
+	m.family("ip_lookup_slo_sli", "gauge", "Fraction of good requests over the trailing window.")
+	for _, r := range reports {
+		for _, w := range sloWindows {
+			m.sample("ip_lookup_slo_sli", r.Windows[w.label].SLI, "slo", r.Name, "window", w.label)
+		}
+	}
+	m.family("ip_lookup_slo_burn_rate", "gauge", "Error budget burn rate over the trailing window; 1 spends the budget exactly over the SLO period.")
+	for _, r := range reports {
+		for _, w := range sloWindows {
+			m.sample("ip_lookup_slo_burn_rate", r.Windows[w.label].BurnRate, "slo", r.Name, "window", w.label)
+		}
+	}
+	m.family("ip_lookup_slo_error_budget_remaining", "gauge", "Estimated share of the SLO period's error budget left.")
+	for _, r := range reports {
+		m.sample("ip_lookup_slo_error_budget_remaining", r.ErrorBudgetRemaining, "slo", r.Name)
+	}
+	m.family("ip_lookup_slo_alert_firing", "gauge", "1 when the multiwindow burn-rate alert condition holds.")
+	for _, r := range reports {
+		for _, a := range r.Alerts {
+			firing := 0.0
+			if a.Firing {
+				firing = 1
+			}
+			m.sample("ip_lookup_slo_alert_firing", firing, "slo", r.Name, "severity", a.Severity, "long_window", a.LongWindow, "short_window", a.ShortWindow)
+		}
+	}
+}
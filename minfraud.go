@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/groupcache/singleflight"
+)
+
+// defaultMinFraudURL is the base URL of the minFraud web services.
+const defaultMinFraudURL = "https://minfraud.maxmind.com"
+
+// minFraudCacheSize bounds the number of cached risk scores.
+const minFraudCacheSize = 10000
+
+// maxMinFraudResponseSize caps how much of a minFraud response is read.
+const maxMinFraudResponseSize = 1 << 20
+
+// minFraudRisk is the risk minFraud Insights assigns to an IP.
+type minFraudRisk struct {
+	// RiskScore is the risk of a transaction from the IP, 0.01 to 99.
+	RiskScore float64 `json:"risk_score"`
+	IPAddress struct {
+		// Risk is the risk of the IP itself, 0.01 to 99.
+		Risk float64 `json:"risk"`
+	} `json:"ip_address"`
+}
+
+// minFraudClient scores IPs with minFraud Insights. Queries are billed, so
+// scores are cached and concurrent lookups of an IP share one query.
+type minFraudClient struct {
+	httpClient *http.Client
+	baseURL    string
+	accountID  string
+	licenseKey string
+	timeout    time.Duration
+	// alwaysOn makes lookups without a risk parameter include the scores.
+	alwaysOn bool
+	cache    *ttlCache[minFraudRisk]
+	queries  singleflight.Group
+}
+
+// minFraud is the process-wide minFraud client; nil when MINFRAUD_ENABLED is
+// off.
+var minFraud *minFraudClient
+
+func newMinFraudClient(baseURL, accountID, licenseKey string, timeout, cacheTTL time.Duration, alwaysOn bool) *minFraudClient {
+	return &minFraudClient{
+		httpClient: &http.Client{},
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		accountID:  accountID,
+		licenseKey: licenseKey,
+		timeout:    timeout,
+		alwaysOn:   alwaysOn,
+		cache:      newTTLCache[minFraudRisk](minFraudCacheSize, cacheTTL),
+	}
+}
+
+// risk returns the risk of ip, from cache when possible.
+func (c *minFraudClient) risk(ip net.IP) (minFraudRisk, error) {
+	key := ip.String()
+	if risk, ok := c.cache.get(key); ok {
+		return risk, nil
+	}
+	risk, err := c.queries.Do(key, func() (any, error) {
+		// The query is shared, so it is not cancelled with the request
+		// that started it.
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+		defer cancel()
+		risk, err := c.query(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		c.cache.set(key, risk)
+		return risk, nil
+	})
+	if err != nil {
+		return minFraudRisk{}, err
+	}
+	return risk.(minFraudRisk), nil
+}
+
+// query scores ip with a minFraud Insights request for a transaction from it.
+func (c *minFraudClient) query(ctx context.Context, ip string) (minFraudRisk, error) {
+	var risk minFraudRisk
+	body, err := json.Marshal(map[string]any{"device": map[string]string{"ip_address": ip}})
+	if err != nil {
+		return risk, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/minfraud/v2.0/insights", bytes.NewReader(body))
+	if err != nil {
+		return risk, err
+	}
+	req.SetBasicAuth(c.accountID, c.licenseKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return risk, err
+	}
+	defer resp.Body.Close()
+	decoder := json.NewDecoder(io.LimitReader(resp.Body, maxMinFraudResponseSize))
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Code  string `json:"code"`
+			Error string `json:"error"`
+		}
+		if decoder.Decode(&apiErr) != nil || apiErr.Code == "" {
+			return risk, fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		return risk, fmt.Errorf("%s: %s", apiErr.Code, apiErr.Error)
+	}
+	if err := decoder.Decode(&risk); err != nil {
+		return risk, fmt.Errorf("decoding the response: %v", err)
+	}
+	return risk, nil
+}
+
+// wanted reports whether the request asks for the risk scores: ?risk=1 does,
+// ?risk=0 does not, and without the parameter MINFRAUD_DEFAULT decides.
+func (c *minFraudClient) wanted(r *http.Request) (bool, error) {
+	value := strings.TrimSpace(r.URL.Query().Get("risk"))
+	if value == "" {
+		return c.alwaysOn, nil
+	}
+	wanted, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("Invalid risk parameter '%s': must be a boolean such as 1 or 0", value)
+	}
+	return wanted, nil
+}
+
+// wantsRisk reports whether the lookup response for r should carry the risk
+// fields. Invalid risk values are rejected before this is consulted.
+func wantsRisk(r *http.Request) bool {
+	if minFraud == nil {
+		return false
+	}
+	wanted, err := minFraud.wanted(r)
+	return err == nil && wanted
+}
+
+// addRisk sets the risk_score and ip_risk fields of response, null when
+// minFraud could not be queried, if the request asks for them.
+func addRisk(r *http.Request, ip net.IP, response map[string]any) {
+	if !wantsRisk(r) {
+		return
+	}
+	risk, err := minFraud.risk(ip)
+	if err != nil {
+		log.Printf("minFraud query for IP %s failed: %v", ip.String(), err)
+		response["risk_score"], response["ip_risk"] = nil, nil
+		return
+	}
+	response["risk_score"] = risk.RiskScore
+	response["ip_risk"] = risk.IPAddress.Risk
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// msgpackAppend appends the MessagePack encoding of v. It supports the same
+// value types as cborAppend; map keys are sorted so encodings are deterministic.
+func msgpackAppend(b []byte, v any) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+	case bool:
+		if v {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case string:
+		return msgpackAppendString(b, v), nil
+	case int:
+		return msgpackAppendInt(b, int64(v)), nil
+	case int64:
+		return msgpackAppendInt(b, v), nil
+	case uint:
+		return msgpackAppendUint(b, uint64(v)), nil
+	case uint16:
+		return msgpackAppendUint(b, uint64(v)), nil
+	case uint32:
+		return msgpackAppendUint(b, uint64(v)), nil
+	case uint64:
+		return msgpackAppendUint(b, v), nil
+	case float64:
+		if f32 := float32(v); float64(f32) == v {
+			return binary.BigEndian.AppendUint32(append(b, 0xca), math.Float32bits(f32)), nil
+		}
+		return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(v)), nil
+	case []string:
+		b = msgpackAppendLen(b, len(v), 0x90, 0xdc, 0xdd)
+		for _, item := range v {
+			b = msgpackAppendString(b, item)
+		}
+		return b, nil
+	case []any:
+		b = msgpackAppendLen(b, len(v), 0x90, 0xdc, 0xdd)
+		for _, item := range v {
+			var err error
+			if b, err = msgpackAppend(b, item); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case []map[string]any:
+		b = msgpackAppendLen(b, len(v), 0x90, 0xdc, 0xdd)
+		for _, item := range v {
+			var err error
+			if b, err = msgpackAppend(b, item); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]string:
+		m := make(map[string]any, len(v))
+		for k, s := range v {
+			m[k] = s
+		}
+		return msgpackAppend(b, m)
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b = msgpackAppendLen(b, len(v), 0x80, 0xde, 0xdf)
+		for _, k := range keys {
+			b = msgpackAppendString(b, k)
+			var err error
+			if b, err = msgpackAppend(b, v[k]); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("msgpack: unsupported type %T", v)
+	}
+}
+
+// msgpackAppendLen appends an array or map header using the fix, 16 and 32 bit forms.
+func msgpackAppendLen(b []byte, n int, fix, code16, code32 byte) []byte {
+	switch {
+	case n < 16:
+		return append(b, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, code16), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(b, code32), uint32(n))
+	}
+}
+
+func msgpackAppendString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+func msgpackAppendUint(b []byte, v uint64) []byte {
+	switch {
+	case v < 128:
+		return append(b, byte(v))
+	case v <= math.MaxUint8:
+		return append(b, 0xcc, byte(v))
+	case v <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xcd), uint16(v))
+	case v <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, 0xce), uint32(v))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, 0xcf), v)
+	}
+}
+
+func msgpackAppendInt(b []byte, v int64) []byte {
+	switch {
+	case v >= 0:
+		return msgpackAppendUint(b, uint64(v))
+	case v >= -32:
+		return append(b, byte(v))
+	case v >= math.MinInt8:
+		return append(b, 0xd0, byte(v))
+	case v >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(v))
+	case v >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(v))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(v))
+	}
+}
+
+// msgpackMarshal returns the MessagePack encoding of v.
+func msgpackMarshal(v any) ([]byte, error) {
+	return msgpackAppend(nil, v)
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var errMsgpackShort = errors.New("msgpack: unexpected end of input")
+
+// msgpackDecode decodes the value at the start of b, returning the rest.
+// Integers decode as int64 (uint64 above math.MaxInt64), floats as float64,
+// arrays as []any and maps as map[string]any; it accepts what msgpackAppend
+// writes and nothing more.
+func msgpackDecode(b []byte) (any, []byte, error) {
+	if len(b) == 0 {
+		return nil, nil, errMsgpackShort
+	}
+	c, b := b[0], b[1:]
+	// uintN reads the n-byte big-endian integer at the start of b.
+	uintN := func(n int) (uint64, error) {
+		if len(b) < n {
+			return 0, errMsgpackShort
+		}
+		var v uint64
+		for _, x := range b[:n] {
+			v = v<<8 | uint64(x)
+		}
+		b = b[n:]
+		return v, nil
+	}
+	str := func(n uint64) (any, []byte, error) {
+		if uint64(len(b)) < n {
+			return nil, nil, errMsgpackShort
+		}
+		return string(b[:n]), b[n:], nil
+	}
+	array := func(n uint64) (any, []byte, error) {
+		list := []any{}
+		for i := uint64(0); i < n; i++ {
+			var item any
+			var err error
+			if item, b, err = msgpackDecode(b); err != nil {
+				return nil, nil, err
+			}
+			list = append(list, item)
+		}
+		return list, b, nil
+	}
+	object := func(n uint64) (any, []byte, error) {
+		m := map[string]any{}
+		for i := uint64(0); i < n; i++ {
+			k, rest, err := msgpackDecode(b)
+			if err != nil {
+				return nil, nil, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, nil, fmt.Errorf("msgpack: map key %v is not a string", k)
+			}
+			if m[key], b, err = msgpackDecode(rest); err != nil {
+				return nil, nil, err
+			}
+		}
+		return m, b, nil
+	}
+	sized := func(n int, decode func(uint64) (any, []byte, error)) (any, []byte, error) {
+		size, err := uintN(n)
+		if err != nil {
+			return nil, nil, err
+		}
+		return decode(size)
+	}
+	switch {
+	case c <= 0x7f:
+		return int64(c), b, nil
+	case c >= 0xe0:
+		return int64(int8(c)), b, nil
+	case c&0xf0 == 0x80:
+		return object(uint64(c & 0x0f))
+	case c&0xf0 == 0x90:
+		return array(uint64(c & 0x0f))
+	case c&0xe0 == 0xa0:
+		return str(uint64(c & 0x1f))
+	}
+	switch c {
+	case 0xc0:
+		return nil, b, nil
+	case 0xc2:
+		return false, b, nil
+	case 0xc3:
+		return true, b, nil
+	case 0xca:
+		v, err := uintN(4)
+		return float64(math.Float32frombits(uint32(v))), b, err
+	case 0xcb:
+		v, err := uintN(8)
+		return math.Float64frombits(v), b, err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		v, err := uintN(1 << (c - 0xcc))
+		if v > math.MaxInt64 {
+			return v, b, err
+		}
+		return int64(v), b, err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		n := 1 << (c - 0xd0)
+		v, err := uintN(n)
+		// Sign-extend the n-byte two's complement value.
+		shift := 64 - 8*n
+		return int64(v<<shift) >> shift, b, err
+	case 0xd9:
+		return sized(1, str)
+	case 0xda:
+		return sized(2, str)
+	case 0xdb:
+		return sized(4, str)
+	case 0xdc:
+		return sized(2, array)
+	case 0xdd:
+		return sized(4, array)
+	case 0xde:
+		return sized(2, object)
+	case 0xdf:
+		return sized(4, object)
+	}
+	return nil, nil, fmt.Errorf("msgpack: unsupported format 0x%02x", c)
+}
+
+func TestMsgpackMarshalVectors(t *testing.T) {
+	tests := []struct {
+		v    any
+		want string
+	}{
+		{0, "00"},
+		{uint(127), "7f"},
+		{128, "cc80"},
+		{uint16(256), "cd0100"},
+		{uint32(65536), "ce00010000"},
+		{uint64(1 << 32), "cf0000000100000000"},
+		{-1, "ff"},
+		{-32, "e0"},
+		{-33, "d0df"},
+		{int64(-129), "d1ff7f"},
+		{int64(-32769), "d2ffff7fff"},
+		{int64(math.MinInt64), "d38000000000000000"},
+		{nil, "c0"},
+		{false, "c2"},
+		{true, "c3"},
+		{1.5, "ca3fc00000"},
+		{1.1, "cb3ff199999999999a"},
+		{"", "a0"},
+		{"abc", "a3616263"},
+		{[]string{"a"}, "91a161"},
+		{[]any{1, nil}, "9201c0"},
+		{map[string]any{"b": 2, "a": 1}, "82a16101a16202"},
+		{map[string]string{"k": "v"}, "81a16ba176"},
+	}
+	for _, tt := range tests {
+		got, err := msgpackMarshal(tt.v)
+		if err != nil {
+			t.Errorf("msgpackMarshal(%#v): %v", tt.v, err)
+			continue
+		}
+		if hex.EncodeToString(got) != tt.want {
+			t.Errorf("msgpackMarshal(%#v) = %x, want %s", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	response := map[string]any{
+		"ip":                       "2001:4860:4860::8888",
+		"city":                     "Mountain View",
+		"latitude":                 37.4223,
+		"longitude":                -122.085,
+		"accuracy_radius":          uint16(1000),
+		"autonomous_system_number": uint(15169),
+		"is_tor_exit":              false,
+		"metro_code":               nil,
+		"subdivisions":             []map[string]any{{"iso_code": "CA"}},
+		"names":                    map[string]string{"en": "United States"},
+		"tags":                     []string{"anycast", "dns"},
+		"offset":                   -25200,
+		"big":                      uint64(math.MaxUint64),
+	}
+	want := map[string]any{
+		"ip":                       "2001:4860:4860::8888",
+		"city":                     "Mountain View",
+		"latitude":                 37.4223,
+		"longitude":                -122.085,
+		"accuracy_radius":          int64(1000),
+		"autonomous_system_number": int64(15169),
+		"is_tor_exit":              false,
+		"metro_code":               nil,
+		"subdivisions":             []any{map[string]any{"iso_code": "CA"}},
+		"names":                    map[string]any{"en": "United States"},
+		"tags":                     []any{"anycast", "dns"},
+		"offset":                   int64(-25200),
+		"big":                      uint64(math.MaxUint64),
+	}
+	encoded, err := msgpackMarshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, rest, err := msgpackDecode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 0 {
+		t.Errorf("%d bytes after the encoded map", len(rest))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decoded %#v, want %#v", got, want)
+	}
+
+	again, _ := msgpackMarshal(response)
+	if !bytes.Equal(encoded, again) {
+		t.Error("encodings of the same map differ")
+	}
+}
+
+func TestMsgpackMarshalLengthForms(t *testing.T) {
+	tests := []struct {
+		v      any
+		prefix []byte
+	}{
+		{strings.Repeat("x", 31), []byte{0xbf}},
+		{strings.Repeat("x", 32), []byte{0xd9, 32}},
+		{strings.Repeat("x", 256), []byte{0xda, 1, 0}},
+		{strings.Repeat("x", 65536), []byte{0xdb, 0, 1, 0, 0}},
+		{make([]string, 15), []byte{0x9f}},
+		{make([]string, 16), []byte{0xdc, 0, 16}},
+		{make([]string, 65536), []byte{0xdd, 0, 1, 0, 0}},
+		{mapOfSize(15), []byte{0x8f}},
+		{mapOfSize(16), []byte{0xde, 0, 16}},
+	}
+	for _, tt := range tests {
+		encoded, err := msgpackMarshal(tt.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(encoded, tt.prefix) {
+			t.Errorf("%T of length %d encodes as %x..., want %x...", tt.v, reflect.ValueOf(tt.v).Len(), encoded[:min(len(encoded), 8)], tt.prefix)
+		}
+		if _, rest, err := msgpackDecode(encoded); err != nil || len(rest) != 0 {
+			t.Errorf("%T of length %d does not decode back: %d bytes left, %v", tt.v, reflect.ValueOf(tt.v).Len(), len(rest), err)
+		}
+	}
+}
+
+func mapOfSize(n int) map[string]any {
+	m := make(map[string]any, n)
+	for i := range n {
+		m[fmt.Sprint(i)] = i
+	}
+	return m
+}
+
+func TestMsgpackMarshalRejectsUnsupportedTypes(t *testing.T) {
+	for _, v := range []any{
+		struct{}{},
+		float32(1),
+		[]int{1},
+		map[int]any{1: "a"},
+		map[string]any{"nested": map[string]any{"bad": make(chan int)}},
+		[]any{"ok", func() {}},
+		[]map[string]any{{"bad": int8(1)}},
+	} {
+		if got, err := msgpackMarshal(v); err == nil {
+			t.Errorf("msgpackMarshal(%T) = %x, want an error", v, got)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// myIPSummaryFields are the lookup fields /myip?geo=1 adds to the IP.
+var myIPSummaryFields = []string{"country_code", "country_name", "city"}
+
+// myIPHandler serves GET /myip: the caller's IP as clientIP resolves it for
+// /lookup/, without the rest of the lookup. ?geo=1 adds a short geo summary,
+// left out when the database has no record for the IP.
+func myIPHandler(w http.ResponseWriter, r *http.Request) {
+	geo := false
+	if value := strings.TrimSpace(r.URL.Query().Get("geo")); value != "" {
+		var err error
+		if geo, err = strconv.ParseBool(value); err != nil {
+			writeJSONError(w, fmt.Sprintf("Invalid geo parameter '%s': must be a boolean such as 1 or 0", value), http.StatusBadRequest, errCodeInvalidRequest)
+			return
+		}
+	}
+
+	start := time.Now()
+	ipStr := clientIP(r)
+	stageClientIP.since(start)
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		writeJSONError(w, "Could not determine IP address from request", http.StatusBadRequest, errCodeIPUndetermined)
+		return
+	}
+	response := map[string]any{"ip": ip.String()}
+
+	if geo {
+		if !databaseLoaded() {
+			writeJSONError(w, "GeoIP service not available", http.StatusInternalServerError, errCodeDBUnavailable)
+			return
+		}
+		record, err := lookupIP(r.Context(), ip)
+		if err != nil && !errors.Is(err, errNoRecord) {
+			log.Printf("GeoIP lookup for IP %s failed: %v", ip.String(), err)
+			writeJSONError(w, fmt.Sprintf("GeoIP lookup failed for IP: %s", ip.String()), http.StatusInternalServerError, errCodeDBError)
+			return
+		}
+		for _, field := range myIPSummaryFields {
+			if value, ok := record[field]; ok && value != "" {
+				response[field] = value
+			}
+		}
+	}
+
+	// The answer depends on who asks, so shared caches must not keep it.
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error encoding JSON response for IP %s: %v", ip.String(), err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+)
+
+// networkLookupHandler serves GET /lookup/network/{cidr}: the lookup of the
+// network's first address, for data keyed by prefix such as firewall rules
+// and netflow aggregates. The response adds the queried network and the
+// database network matched, which is smaller when the queried network spans
+// several database records whose data can differ.
+func networkLookupHandler(w http.ResponseWriter, r *http.Request) {
+	if !databaseLoaded() {
+		writeJSONError(w, "GeoIP service not available", http.StatusInternalServerError, errCodeDBUnavailable)
+		return
+	}
+	cidr := r.PathValue("cidr")
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Invalid network format: %s (must be CIDR notation such as 192.0.2.0/24)", cidr), http.StatusBadRequest, errCodeInvalidIP)
+		return
+	}
+	if _, err := parseFields(r); err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest, errCodeInvalidRequest)
+		return
+	}
+	if _, err := negotiateFormat(r); err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest, errCodeInvalidRequest)
+		return
+	}
+	w.Header().Add("Vary", "Accept")
+
+	ip := network.IP
+	response, err := lookupIP(r.Context(), ip)
+	if errors.Is(err, errNoRecord) {
+		writeJSONError(w, fmt.Sprintf("GeoIP data not found for network: %s", network.String()), http.StatusNotFound, errCodeNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("GeoIP lookup for network %s failed: %v", network.String(), err)
+		writeJSONError(w, fmt.Sprintf("GeoIP lookup failed for network: %s", network.String()), http.StatusInternalServerError, errCodeDBError)
+		return
+	}
+	// The network of the database record, when the database reports one.
+	if _, matched, err := net.ParseCIDR(fmt.Sprint(response["network"])); err == nil {
+		queried, _ := network.Mask.Size()
+		prefix, _ := matched.Mask.Size()
+		response["matched_network"] = matched.String()
+		response["spans_networks"] = prefix > queried
+	}
+	response["network"] = network.String()
+	writeLookupResponse(w, r, ip, response)
+}
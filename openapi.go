@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+)
+
+// openAPIDocument is the OpenAPI 3 description of the HTTP API, maintained
+// by hand next to the handlers it describes.
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// swaggerUIVersion is the swagger-ui-dist release /docs loads.
+const swaggerUIVersion = "5.17.14"
+
+// swaggerUIScript starts Swagger UI on the embedded document.
+const swaggerUIScript = `window.onload = function () {
+  window.ui = SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui", deepLinking: true, validatorUrl: null});
+};`
+
+// swaggerUIPage is the /docs page. Swagger UI's assets are loaded from
+// unpkg, so the binary stays small; only the page and document are served.
+var swaggerUIPage = fmt.Sprintf(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>IP Lookup API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@%[1]s/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@%[1]s/swagger-ui-bundle.js" crossorigin="anonymous"></script>
+  <script>%[2]s</script>
+</body>
+</html>
+`, swaggerUIVersion, swaggerUIScript)
+
+// docsContentSecurityPolicy limits /docs to the Swagger UI assets, its
+// starting script and the API itself. Swagger UI sets inline styles.
+var docsContentSecurityPolicy = func() string {
+	sum := sha256.Sum256([]byte(swaggerUIScript))
+	return "default-src 'self'; script-src https://unpkg.com 'sha256-" + base64.StdEncoding.EncodeToString(sum[:]) + "'; " +
+		"style-src https://unpkg.com 'unsafe-inline'; img-src 'self' data:; object-src 'none'; base-uri 'none'; form-action 'self'"
+}()
+
+// openAPIHandler serves the OpenAPI document.
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDocument)
+}
+
+// docsHandler serves Swagger UI for the OpenAPI document.
+func docsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", docsContentSecurityPolicy)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	fmt.Fprint(w, swaggerUIPage)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// overrideFields are the lookup fields an override may set, and whether
+// each is a number (the coordinates) rather than a string.
+var overrideFields = map[string]bool{
+	"city":             false,
+	"country_code":     false,
+	"country_name":     false,
+	"continent":        false,
+	"subdivision_name": false,
+	"postal_code":      false,
+	"time_zone":        false,
+	"latitude":         true,
+	"longitude":        true,
+}
+
+// ipOverride is an authoritative answer for the IPs in a network.
+type ipOverride struct {
+	network netip.Prefix
+	fields  map[string]any
+}
+
+// overrides holds the loaded overrides, most specific network first; nil
+// when GEOIP_OVERRIDES_PATH is not set.
+var overrides atomic.Pointer[[]ipOverride]
+
+// loadOverrides reads the overrides file at path, a JSON object mapping
+// networks to fields or a CSV file with a network column, and swaps it in.
+func loadOverrides(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var entries []ipOverride
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		entries, err = parseJSONOverrides(f)
+	case ".csv":
+		entries, err = parseCSVOverrides(f)
+	default:
+		return fmt.Errorf("unsupported overrides file %q: must be .json or .csv", path)
+	}
+	if err != nil {
+		return err
+	}
+	// Longest prefix first, so the first match is the most specific.
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].network.Bits() > entries[j].network.Bits()
+	})
+	overrides.Store(&entries)
+	log.Printf("Loaded %d IP overrides from %s.", len(entries), path)
+	return nil
+}
+
+func parseJSONOverrides(r io.Reader) ([]ipOverride, error) {
+	var doc map[string]map[string]any
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("parsing overrides: %v", err)
+	}
+	entries := make([]ipOverride, 0, len(doc))
+	for network, raw := range doc {
+		values := make(map[string]string, len(raw))
+		for field, value := range raw {
+			switch v := value.(type) {
+			case string:
+				values[field] = v
+			case float64:
+				values[field] = strconv.FormatFloat(v, 'f', -1, 64)
+			default:
+				return nil, fmt.Errorf("override %s: %s must be a string or number", network, field)
+			}
+		}
+		entry, err := newIPOverride(network, values)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// parseCSVOverrides reads a CSV file whose header names the columns: network
+// and any of the override fields. Empty cells leave a field unset.
+func parseCSVOverrides(r io.Reader) ([]ipOverride, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading the overrides header: %v", err)
+	}
+	networkColumn := -1
+	for i, name := range header {
+		header[i] = strings.TrimSpace(name)
+		if header[i] == "network" {
+			networkColumn = i
+		}
+	}
+	if networkColumn < 0 {
+		return nil, fmt.Errorf("overrides header has no network column")
+	}
+	var entries []ipOverride
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading overrides: %v", err)
+		}
+		values := make(map[string]string, len(row))
+		for i, value := range row {
+			if value = strings.TrimSpace(value); value != "" && i != networkColumn {
+				values[header[i]] = value
+			}
+		}
+		entry, err := newIPOverride(strings.TrimSpace(row[networkColumn]), values)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// newIPOverride validates an override of network (a CIDR or a single IP).
+func newIPOverride(network string, values map[string]string) (ipOverride, error) {
+	prefix, err := netip.ParsePrefix(network)
+	if err != nil {
+		addr, addrErr := netip.ParseAddr(network)
+		if addrErr != nil {
+			return ipOverride{}, fmt.Errorf("override %q: not a CIDR or IP address", network)
+		}
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	fields := make(map[string]any, len(values))
+	for field, value := range values {
+		numeric, known := overrideFields[field]
+		if !known {
+			return ipOverride{}, fmt.Errorf("override %s: unknown field %q", network, field)
+		}
+		if !numeric {
+			fields[field] = value
+			continue
+		}
+		limit := 90.0
+		if field == "longitude" {
+			limit = 180
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		// ParseFloat accepts NaN and Inf, which JSON cannot encode.
+		if err != nil || math.IsNaN(v) || v < -limit || v > limit {
+			return ipOverride{}, fmt.Errorf("override %s: %s must be a number between -%g and %g", network, field, limit, limit)
+		}
+		fields[field] = v
+	}
+	if len(fields) == 0 {
+		return ipOverride{}, fmt.Errorf("override %s: no fields", network)
+	}
+	return ipOverride{network: prefix.Masked(), fields: fields}, nil
+}
+
+// lookupOverride returns the fields of the most specific override of ip.
+func lookupOverride(ip net.IP) (map[string]any, bool) {
+	entries := overrides.Load()
+	if entries == nil {
+		return nil, false
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return nil, false
+	}
+	addr = addr.Unmap()
+	for _, entry := range *entries {
+		if entry.network.Contains(addr) {
+			return entry.fields, true
+		}
+	}
+	return nil, false
+}
+
+// overrideResponse builds a lookup response for ip from override fields.
+func overrideResponse(ip net.IP, fields map[string]any) map[string]any {
+	response := map[string]any{"ip": ip.String()}
+	for field, value := range fields {
+		if v, ok := value.(float64); ok {
+			value = roundCoordinate(v, appConfig.CoordinatePrecision)
+		}
+		response[field] = value
+	}
+	return response
+}
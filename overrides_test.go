@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// loadOverridesFile writes content to a file named name in a temporary
+// directory and loads it, restoring the overrides when t ends.
+func loadOverridesFile(t *testing.T, name, content string) error {
+	t.Helper()
+	old := overrides.Load()
+	t.Cleanup(func() { overrides.Store(old) })
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return loadOverrides(path)
+}
+
+func TestLoadOverrides(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{"JSON", "overrides.json", `{
+			"10.0.0.0/8": {"city": "Corporate Network", "country_code": "DE"},
+			"10.1.0.0/16": {"city": "Berlin", "latitude": 52.52, "longitude": 13.405},
+			"::ffff:192.0.2.1": {"city": "Mapped"}
+		}`},
+		{"CSV", "OVERRIDES.CSV", "# corporate ranges\n" +
+			"network, city ,country_code,latitude,longitude\n" +
+			"10.0.0.0/8,Corporate Network,DE,,\n" +
+			"10.1.2.3/16, Berlin ,,52.52,13.405\n" +
+			"::ffff:192.0.2.1,Mapped,,,\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := loadOverridesFile(t, tt.file, tt.content); err != nil {
+				t.Fatal(err)
+			}
+			lookups := []struct {
+				ip   string
+				want map[string]any
+			}{
+				{"10.9.9.9", map[string]any{"city": "Corporate Network", "country_code": "DE"}},
+				// The most specific network wins; its host bits are ignored.
+				{"10.1.0.1", map[string]any{"city": "Berlin", "latitude": 52.52, "longitude": 13.405}},
+				{"192.0.2.1", map[string]any{"city": "Mapped"}},
+				{"192.0.2.2", nil},
+				{"2001:db8::1", nil},
+			}
+			for _, l := range lookups {
+				got, ok := lookupOverride(net.ParseIP(l.ip))
+				if ok != (l.want != nil) || !reflect.DeepEqual(got, l.want) {
+					t.Errorf("lookupOverride(%s) = %v, %v, want %v", l.ip, got, ok, l.want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadOverridesRejectsMalformedFiles(t *testing.T) {
+	const csvHeader = "network,city,latitude\n"
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		// message is part of the error.
+		message string
+	}{
+		{"unsupported extension", "overrides.yaml", "10.0.0.0/8: {}", "must be .json or .csv"},
+		{"empty JSON", "o.json", "", "parsing overrides"},
+		{"truncated JSON", "o.json", `{"10.0.0.0/8": {"city": "Ber`, "parsing overrides"},
+		{"JSON array", "o.json", `[{"network": "10.0.0.0/8"}]`, "parsing overrides"},
+		{"JSON fields not an object", "o.json", `{"10.0.0.0/8": "Berlin"}`, "parsing overrides"},
+		{"JSON field of the wrong type", "o.json", `{"10.0.0.0/8": {"city": true}}`, "city must be a string or number"},
+		{"JSON null field", "o.json", `{"10.0.0.0/8": {"city": null}}`, "city must be a string or number"},
+		{"JSON without fields", "o.json", `{"10.0.0.0/8": {}}`, "no fields"},
+		{"JSON latitude as text", "o.json", `{"10.0.0.0/8": {"latitude": "north"}}`, "latitude must be a number"},
+		{"empty CSV", "o.csv", "", "reading the overrides header"},
+		{"CSV of comments", "o.csv", "# nothing\n", "reading the overrides header"},
+		{"CSV without a network column", "o.csv", "cidr,city\n10.0.0.0/8,Berlin\n", "no network column"},
+		{"CSV row cut short", "o.csv", csvHeader + "10.0.0.0/8,Berlin\n", "wrong number of fields"},
+		{"CSV row too long", "o.csv", csvHeader + "10.0.0.0/8,Berlin,1,2\n", "wrong number of fields"},
+		{"CSV unterminated quote", "o.csv", csvHeader + `10.0.0.0/8,"Berlin,1` + "\n", "reading overrides"},
+		{"CSV unknown field", "o.csv", "network,town\n10.0.0.0/8,Berlin\n", `unknown field "town"`},
+		{"CSV row without fields", "o.csv", csvHeader + "10.0.0.0/8,,\n", "no fields"},
+		{"CSV invalid network", "o.csv", csvHeader + "10.0.0.0/33,Berlin,\n", "not a CIDR or IP address"},
+		{"CSV empty network", "o.csv", csvHeader + ",Berlin,\n", "not a CIDR or IP address"},
+		{"CSV hostname", "o.csv", csvHeader + "example.com,Berlin,\n", "not a CIDR or IP address"},
+		{"CSV latitude out of range", "o.csv", csvHeader + "10.0.0.0/8,,90.5\n", "latitude must be a number between -90 and 90"},
+		{"CSV NaN latitude", "o.csv", csvHeader + "10.0.0.0/8,,NaN\n", "latitude must be a number"},
+		{"CSV infinite latitude", "o.csv", csvHeader + "10.0.0.0/8,,-Inf\n", "latitude must be a number"},
+		{"JSON longitude out of range", "o.json", `{"10.0.0.0/8": {"longitude": 180.5}}`, "longitude must be a number between -180 and 180"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := loadOverridesFile(t, tt.file, tt.content)
+			if err == nil || !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("loadOverrides = %v, want an error containing %q", err, tt.message)
+			}
+			if overrides.Load() != nil {
+				t.Error("a rejected file replaced the overrides")
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// plainTextRoutes are the single-value endpoints, /{name}/{ip} and /{name}
+// for the caller's IP, by the lookup field each returns.
+var plainTextRoutes = map[string]string{
+	"country": "country_code",
+	"city":    "city",
+	"tz":      "time_zone",
+}
+
+// plainTextHandler serves the single-value endpoint for field: the value as a
+// text/plain line, for shell scripts and nginx maps. An IP whose record has
+// no value gets an empty line; errors are JSON like those of /lookup/.
+func plainTextHandler(field string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !databaseLoaded() {
+			writeJSONError(w, "GeoIP service not available", http.StatusInternalServerError, errCodeDBUnavailable)
+			return
+		}
+		ipStr := r.PathValue("ip")
+		if ipStr == "" {
+			start := time.Now()
+			ipStr = clientIP(r)
+			stageClientIP.since(start)
+		}
+		ip := net.ParseIP(ipStr)
+		if ip == nil {
+			writeJSONError(w, fmt.Sprintf("Invalid IP address format: %s", ipStr), http.StatusBadRequest, errCodeInvalidIP)
+			return
+		}
+
+		response, err := lookupIP(r.Context(), ip)
+		if errors.Is(err, errNoRecord) {
+			var ok bool
+			if response, ok = missResult(ip); !ok {
+				writeJSONError(w, fmt.Sprintf("GeoIP data not found for IP: %s", ip.String()), http.StatusNotFound, errCodeNotFound)
+				return
+			}
+		} else if err != nil {
+			log.Printf("GeoIP lookup for IP %s failed: %v", ip.String(), err)
+			writeJSONError(w, fmt.Sprintf("GeoIP lookup failed for IP: %s", ip.String()), http.StatusInternalServerError, errCodeDBError)
+			return
+		}
+
+		value, _ := response[field].(string)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, value)
+	}
+}
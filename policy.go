@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+)
+
+// defaultPolicyName is the policy used when a request does not name one, and
+// the name given to the inline POLICY expression.
+const defaultPolicyName = "default"
+
+// geoPolicy is a compiled CEL expression deciding whether an IP is allowed.
+type geoPolicy struct {
+	name    string
+	program cel.Program
+}
+
+// policyEnv declares the variables a policy expression can use. They mirror
+// the lookup record, flattened so common rules read naturally, e.g.
+// `country in ['DE', 'FR'] && !traits.is_anonymous_proxy`.
+var policyEnv = func() *cel.Env {
+	env, err := cel.NewEnv(
+		cel.Variable("ip", cel.StringType),
+		cel.Variable("found", cel.BoolType),
+		cel.Variable("ip_type", cel.StringType),
+		cel.Variable("bogon", cel.BoolType),
+		cel.Variable("country", cel.StringType),
+		cel.Variable("country_name", cel.StringType),
+		cel.Variable("continent", cel.StringType),
+		cel.Variable("is_eu", cel.BoolType),
+		cel.Variable("registered_country", cel.StringType),
+		cel.Variable("represented_country", cel.StringType),
+		cel.Variable("subdivisions", cel.ListType(cel.StringType)),
+		cel.Variable("city", cel.StringType),
+		cel.Variable("postal_code", cel.StringType),
+		cel.Variable("time_zone", cel.StringType),
+		cel.Variable("latitude", cel.DoubleType),
+		cel.Variable("longitude", cel.DoubleType),
+		cel.Variable("accuracy_radius", cel.IntType),
+		cel.Variable("traits", cel.MapType(cel.StringType, cel.BoolType)),
+		cel.Variable("asn", cel.IntType),
+		cel.Variable("as_organization", cel.StringType),
+	)
+	if err != nil {
+		panic(err)
+	}
+	return env
+}()
+
+// compilePolicy type-checks expression and prepares it for evaluation.
+func compilePolicy(name, expression string) (*geoPolicy, error) {
+	ast, issues := policyEnv.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("policy %q: %v", name, issues.Err())
+	}
+	if ast.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("policy %q: expression must evaluate to a bool, not %s", name, ast.OutputType())
+	}
+	program, err := policyEnv.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("policy %q: %v", name, err)
+	}
+	return &geoPolicy{name: name, program: program}, nil
+}
+
+// loadPolicies compiles the inline policy and the policies in file (a JSON
+// object mapping names to expressions). Either may be empty.
+func loadPolicies(inline, file string) (map[string]*geoPolicy, error) {
+	expressions := make(map[string]string)
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("reading policies file: %v", err)
+		}
+		if err := json.Unmarshal(data, &expressions); err != nil {
+			return nil, fmt.Errorf("parsing policies file %s: %v", file, err)
+		}
+	}
+	if inline != "" {
+		if _, ok := expressions[defaultPolicyName]; ok {
+			return nil, fmt.Errorf("policy %q is defined by both POLICY and POLICIES_FILE", defaultPolicyName)
+		}
+		expressions[defaultPolicyName] = inline
+	}
+
+	policies := make(map[string]*geoPolicy, len(expressions))
+	for name, expression := range expressions {
+		policy, err := compilePolicy(name, expression)
+		if err != nil {
+			return nil, err
+		}
+		policies[name] = policy
+	}
+	return policies, nil
+}
+
+// policyActivation builds the variables for evaluating a policy against ip
+// from its lookup fields, which are nil when no source has a record for ip.
+func policyActivation(ip net.IP, fields map[string]any) map[string]any {
+	ipType := classifyIP(ip)
+	vars := map[string]any{
+		"ip":                  ip.String(),
+		"found":               false,
+		"ip_type":             ipType,
+		"bogon":               ipType != ipTypePublic,
+		"country":             "",
+		"country_name":        "",
+		"continent":           "",
+		"is_eu":               false,
+		"registered_country":  "",
+		"represented_country": "",
+		"subdivisions":        []string{},
+		"city":                "",
+		"postal_code":         "",
+		"time_zone":           "",
+		"latitude":            0.0,
+		"longitude":           0.0,
+		"accuracy_radius":     0,
+		"traits": map[string]bool{
+			"is_anonymous_proxy":    false,
+			"is_anycast":            false,
+			"is_satellite_provider": false,
+		},
+		"asn":             0,
+		"as_organization": "",
+	}
+	// The ASN database is separate, so its fields are set even without a City record.
+	if asn, found := lookupASN(ip); found {
+		vars["asn"] = int(asn.AutonomousSystemNumber)
+		vars["as_organization"] = asn.AutonomousSystemOrganization
+	}
+	// Anonymity flags come from their own database and join the traits
+	// whether or not the City database has a record.
+	anonymous, anonymousOK := lookupAnonymousIP(ip)
+	if anonymousOK {
+		for field, flag := range anonymousIPFlags(anonymous) {
+			vars["traits"].(map[string]bool)[field] = flag
+		}
+	}
+	if fields != nil {
+		applyFieldPolicyVars(fields, vars)
+	}
+	return vars
+}
+
+// fieldPolicyVars maps lookup fields to the policy variables they set.
+var fieldPolicyVars = map[string]string{
+	"country_code":         "country",
+	"country_name":         "country_name",
+	"is_in_european_union": "is_eu",
+	"city":                 "city",
+	"postal_code":          "postal_code",
+	"time_zone":            "time_zone",
+	"latitude":             "latitude",
+	"longitude":            "longitude",
+}
+
+// continentCodes are the codes of the continent names in lookup fields,
+// since policies match continents by code.
+var continentCodes = map[string]string{
+	"Africa":        "AF",
+	"Antarctica":    "AN",
+	"Asia":          "AS",
+	"Europe":        "EU",
+	"North America": "NA",
+	"Oceania":       "OC",
+	"South America": "SA",
+}
+
+// applyFieldPolicyVars sets the policy variables of a record's lookup
+// fields. A record is found when it has a value for any of them: the
+// classification of special-purpose ranges has none.
+func applyFieldPolicyVars(fields map[string]any, vars map[string]any) {
+	for field, name := range fieldPolicyVars {
+		if value, ok := fields[field]; ok && value != nil {
+			vars[name] = value
+			vars["found"] = true
+		}
+	}
+	if continent, ok := fields["continent"].(string); ok {
+		vars["continent"] = continentCodes[continent]
+	}
+	for _, name := range []string{"registered_country", "represented_country"} {
+		if country, ok := fields[name].(map[string]any); ok {
+			vars[name], _ = country["iso_code"].(string)
+		}
+	}
+	// Subdivisions are maps as built or, decoded from the shared cache, []any.
+	switch subdivisions := fields["subdivisions"].(type) {
+	case []map[string]any:
+		codes := make([]string, 0, len(subdivisions))
+		for _, s := range subdivisions {
+			code, _ := s["iso_code"].(string)
+			codes = append(codes, code)
+		}
+		vars["subdivisions"] = codes
+	case []any:
+		codes := make([]string, 0, len(subdivisions))
+		for _, item := range subdivisions {
+			s, _ := item.(map[string]any)
+			code, _ := s["iso_code"].(string)
+			codes = append(codes, code)
+		}
+		vars["subdivisions"] = codes
+	}
+	switch radius := fields["accuracy_radius"].(type) {
+	case uint:
+		vars["accuracy_radius"] = int(radius)
+	case float64:
+		vars["accuracy_radius"] = int(radius)
+	}
+	if traits, ok := fields["traits"].(map[string]any); ok {
+		for name, value := range traits {
+			if flag, ok := value.(bool); ok {
+				vars["traits"].(map[string]bool)[name] = flag
+			}
+		}
+	}
+}
+
+// errPolicyEval is returned (wrapped) when a policy fails at evaluation
+// time, e.g. by reading a trait that does not exist.
+var errPolicyEval = errors.New("policy evaluation failed")
+
+// policyVars looks ip up through the lookup chain, as /lookup does, and
+// returns the policy variables of its record.
+func policyVars(ctx context.Context, ip net.IP) (map[string]any, error) {
+	fields, err := lookupRecord(ctx, ip)
+	if errors.Is(err, errNoRecord) {
+		fields, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return policyActivation(ip, fields), nil
+}
+
+// evaluate looks ip up and runs the policy against its record. Policies fail
+// closed: evaluation errors are returned and callers must deny.
+func (p *geoPolicy) evaluate(ctx context.Context, ip net.IP) (allowed bool, vars map[string]any, err error) {
+	vars, err = policyVars(ctx, ip)
+	if err != nil {
+		return false, nil, err
+	}
+	out, _, err := p.program.Eval(vars)
+	if err != nil {
+		return false, vars, fmt.Errorf("%w: policy %q: %v", errPolicyEval, p.name, err)
+	}
+	allowed, _ = out.Value().(bool)
+	return allowed, vars, nil
+}
+
+// policies holds the compiled policies, set from the configuration in configureServices.
+var policies map[string]*geoPolicy
+
+// policyNames lists the configured policy names, sorted.
+func policyNames() []string {
+	names := make([]string, 0, len(policies))
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// requestPolicy returns the policy named by the "policy" query parameter,
+// writing an UNKNOWN_POLICY error and returning nil when there is none.
+func requestPolicy(w http.ResponseWriter, r *http.Request) *geoPolicy {
+	name := strings.TrimSpace(r.URL.Query().Get("policy"))
+	if name == "" {
+		name = defaultPolicyName
+	}
+	policy, ok := policies[name]
+	if !ok {
+		writeAppError(w, AppError{
+			Message:   fmt.Sprintf("Unknown policy: %s", name),
+			Code:      http.StatusNotFound,
+			ErrorCode: errCodeUnknownPolicy,
+			Details:   map[string][]string{"policies": policyNames()},
+		})
+		return nil
+	}
+	return policy
+}
+
+// evaluatePolicy runs policy for ip, writing the error response and
+// returning false when it cannot be decided.
+func evaluatePolicy(w http.ResponseWriter, r *http.Request, policy *geoPolicy, ip net.IP) (allowed bool, vars map[string]any, ok bool) {
+	allowed, vars, err := policy.evaluate(r.Context(), ip)
+	if errors.Is(err, errPolicyEval) {
+		log.Printf("%v", err)
+		writeAppError(w, AppError{
+			Message:   fmt.Sprintf("Policy %s could not be evaluated for IP: %s", policy.name, ip.String()),
+			Code:      http.StatusInternalServerError,
+			ErrorCode: errCodePolicyError,
+			Details:   map[string]string{"error": strings.TrimPrefix(err.Error(), errPolicyEval.Error()+": ")},
+		})
+		return false, nil, false
+	}
+	if err != nil {
+		log.Printf("GeoIP lookup for IP %s failed: %v", ip.String(), err)
+		writeJSONError(w, fmt.Sprintf("GeoIP lookup failed for IP: %s", ip.String()), http.StatusInternalServerError, errCodeDBError)
+		return false, nil, false
+	}
+	return allowed, vars, true
+}
+
+// countryList is the allow or deny parameter of /check: the countries that
+// decide the answer.
+type countryList struct {
+	deny      bool
+	countries map[string]bool
+}
+
+// parseCountryList parses the allow or deny parameter of r, comma-separated
+// ISO country codes matched case-insensitively. It returns nil when r has
+// neither.
+func parseCountryList(r *http.Request) (*countryList, error) {
+	query := r.URL.Query()
+	allow, deny := strings.TrimSpace(query.Get("allow")), strings.TrimSpace(query.Get("deny"))
+	if allow == "" && deny == "" {
+		return nil, nil
+	}
+	if allow != "" && deny != "" {
+		return nil, errors.New("The allow and deny parameters cannot be combined")
+	}
+	if strings.TrimSpace(query.Get("policy")) != "" {
+		return nil, errors.New("The policy parameter cannot be combined with allow or deny")
+	}
+	name, value := "allow", allow
+	if deny != "" {
+		name, value = "deny", deny
+	}
+	list := &countryList{deny: deny != "", countries: make(map[string]bool)}
+	for _, code := range strings.Split(value, ",") {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if code == "" {
+			continue
+		}
+		if len(code) != 2 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' {
+			return nil, fmt.Errorf("Invalid %s parameter '%s': must be comma-separated two-letter ISO country codes", name, value)
+		}
+		list.countries[code] = true
+	}
+	if len(list.countries) == 0 {
+		return nil, fmt.Errorf("Invalid %s parameter '%s': must be comma-separated two-letter ISO country codes", name, value)
+	}
+	return list, nil
+}
+
+// allows reports whether country passes the list. An unknown (empty)
+// country is on no list, so it is denied by allow and allowed by deny.
+func (l *countryList) allows(country string) bool {
+	return l.countries[country] != l.deny
+}
+
+// checkHandler serves /check/{ip} and /check (client IP): the decision of a
+// policy, or of an allow or deny list of countries, for the IP, for callers
+// that want a yes/no answer.
+func checkHandler(w http.ResponseWriter, r *http.Request) {
+	ipStr := r.PathValue("ip")
+	if ipStr == "" {
+		ipStr = clientIP(r)
+	}
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		writeJSONError(w, fmt.Sprintf("Invalid IP address format: %s", ipStr), http.StatusBadRequest, errCodeInvalidIP)
+		return
+	}
+	list, err := parseCountryList(r)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest, errCodeInvalidRequest)
+		return
+	}
+	if list != nil {
+		checkCountryList(w, r, list, ip)
+		return
+	}
+	if len(policies) == 0 {
+		writeJSONError(w, "Missing allow or deny parameter: no policies are configured", http.StatusBadRequest, errCodeInvalidRequest)
+		return
+	}
+	policy := requestPolicy(w, r)
+	if policy == nil {
+		return
+	}
+	allowed, vars, ok := evaluatePolicy(w, r, policy, ip)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{
+		"ip":      ip.String(),
+		"policy":  policy.name,
+		"allowed": allowed,
+		"country": vars["country"],
+	})
+}
+
+// checkCountryList answers /check for an allow or deny list of countries.
+func checkCountryList(w http.ResponseWriter, r *http.Request, list *countryList, ip net.IP) {
+	if !databaseLoaded() {
+		writeJSONError(w, "GeoIP service not available", http.StatusInternalServerError, errCodeDBUnavailable)
+		return
+	}
+	vars, err := policyVars(r.Context(), ip)
+	if err != nil {
+		log.Printf("GeoIP lookup for IP %s failed: %v", ip.String(), err)
+		writeJSONError(w, fmt.Sprintf("GeoIP lookup failed for IP: %s", ip.String()), http.StatusInternalServerError, errCodeDBError)
+		return
+	}
+	country, _ := vars["country"].(string)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{
+		"ip":      ip.String(),
+		"allowed": list.allows(country),
+		"country": country,
+	})
+}
+
+// authzHandler serves /authz for reverse-proxy forward authentication
+// (nginx auth_request, Traefik ForwardAuth, ...): 200 when the client IP is
+// allowed by the policy, 403 otherwise. Allowed responses carry the geo
+// headers when they are enabled, for the proxy to pass upstream.
+func authzHandler(w http.ResponseWriter, r *http.Request) {
+	ipStr := clientIP(r)
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		writeJSONError(w, fmt.Sprintf("Invalid IP address format: %s", ipStr), http.StatusBadRequest, errCodeInvalidIP)
+		return
+	}
+	policy := requestPolicy(w, r)
+	if policy == nil {
+		return
+	}
+	allowed, vars, ok := evaluatePolicy(w, r, policy, ip)
+	if !ok {
+		return
+	}
+	if !allowed {
+		writeAppError(w, AppError{
+			Message:   fmt.Sprintf("Access denied by policy %s", policy.name),
+			Code:      http.StatusForbidden,
+			ErrorCode: errCodeAccessDenied,
+			Details:   map[string]any{"ip": ip.String(), "country": vars["country"]},
+		})
+		return
+	}
+	setGeoHeadersFor(r.Context(), w, ip)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{"allowed": true, "policy": policy.name})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// staticSource is a lookup source answering every IP with fields.
+type staticSource map[string]any
+
+func (s staticSource) lookup(ctx context.Context, ip net.IP) (map[string]any, error) {
+	response := map[string]any{"ip": ip.String()}
+	for k, v := range s {
+		response[k] = v
+	}
+	return response, nil
+}
+
+// useLookupChain serves lookups from chain for the rest of t.
+func useLookupChain(t *testing.T, chain lookupChain) {
+	t.Helper()
+	old := lookupSources
+	lookupSources = chain
+	t.Cleanup(func() { lookupSources = old })
+}
+
+func TestCheckUsesLookupChain(t *testing.T) {
+	useFakeProvider(t, nil)
+	useLookupChain(t, lookupChain{
+		{name: "database", source: databaseSource{}},
+		{name: "remote lookup API", source: staticSource{"country_code": "DE", "continent": "Europe", "is_in_european_union": true}},
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /check/{ip}", checkHandler)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/check/8.8.8.8?allow=DE", nil))
+	var body map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+	if rec.Code != http.StatusOK || body["allowed"] != true || body["country"] != "DE" {
+		t.Errorf("GET /check/8.8.8.8?allow=DE = %d %v, want allowed for the remote source's DE", rec.Code, body)
+	}
+}
+
+func TestPolicyVarsFromLookupFields(t *testing.T) {
+	vars := policyActivation(net.ParseIP("81.2.69.160"), map[string]any{
+		"country_code":         "GB",
+		"continent":            "Europe",
+		"is_in_european_union": false,
+		"registered_country":   map[string]any{"iso_code": "GB"},
+		"subdivisions":         []map[string]any{{"iso_code": "ENG"}, {"iso_code": "WBK"}},
+		"accuracy_radius":      uint(10),
+		"traits":               map[string]any{"is_anycast": true},
+	})
+	for name, want := range map[string]any{
+		"found":              true,
+		"country":            "GB",
+		"continent":          "EU",
+		"registered_country": "GB",
+		"accuracy_radius":    10,
+	} {
+		if vars[name] != want {
+			t.Errorf("%s = %v, want %v", name, vars[name], want)
+		}
+	}
+	if got := vars["subdivisions"].([]string); len(got) != 2 || got[0] != "ENG" || got[1] != "WBK" {
+		t.Errorf("subdivisions = %v, want [ENG WBK]", got)
+	}
+	if !vars["traits"].(map[string]bool)["is_anycast"] {
+		t.Errorf("traits.is_anycast = false, want true")
+	}
+}
+
+func TestPolicyVarsOfSpecialRange(t *testing.T) {
+	ip := net.ParseIP("10.0.0.1")
+	vars := policyActivation(ip, missResponse(ip, true))
+	if vars["found"] != false || vars["bogon"] != true || vars["country"] != "" {
+		t.Errorf("found, bogon, country = %v, %v, %q; want false, true, empty", vars["found"], vars["bogon"], vars["country"])
+	}
+}
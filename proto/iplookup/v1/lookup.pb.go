@@ -0,0 +1,471 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: iplookup/v1/lookup.proto
+
+// Package iplookup.v1 defines the RPC surface of the IP Lookup Service.
+// The service serves it over Twirp at /twirp/iplookup.v1.IPLookup/<Method>
+// and over gRPC on GRPC_LISTEN_ADDR. LookupResponse is also the body of
+// /lookup/ responses requested as application/x-protobuf.
+
+package iplookupv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type LookupRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ip            string                 `protobuf:"bytes,1,opt,name=ip,proto3" json:"ip,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LookupRequest) Reset() {
+	*x = LookupRequest{}
+	mi := &file_iplookup_v1_lookup_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LookupRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LookupRequest) ProtoMessage() {}
+
+func (x *LookupRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_iplookup_v1_lookup_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LookupRequest.ProtoReflect.Descriptor instead.
+func (*LookupRequest) Descriptor() ([]byte, []int) {
+	return file_iplookup_v1_lookup_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *LookupRequest) GetIp() string {
+	if x != nil {
+		return x.Ip
+	}
+	return ""
+}
+
+type LookupResponse struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Ip              string                 `protobuf:"bytes,1,opt,name=ip,proto3" json:"ip,omitempty"`
+	City            string                 `protobuf:"bytes,2,opt,name=city,proto3" json:"city,omitempty"`
+	CountryCode     string                 `protobuf:"bytes,3,opt,name=country_code,json=countryCode,proto3" json:"country_code,omitempty"`
+	CountryName     string                 `protobuf:"bytes,4,opt,name=country_name,json=countryName,proto3" json:"country_name,omitempty"`
+	Continent       string                 `protobuf:"bytes,5,opt,name=continent,proto3" json:"continent,omitempty"`
+	Latitude        float64                `protobuf:"fixed64,6,opt,name=latitude,proto3" json:"latitude,omitempty"`
+	Longitude       float64                `protobuf:"fixed64,7,opt,name=longitude,proto3" json:"longitude,omitempty"`
+	TimeZone        string                 `protobuf:"bytes,8,opt,name=time_zone,json=timeZone,proto3" json:"time_zone,omitempty"`
+	PostalCode      string                 `protobuf:"bytes,9,opt,name=postal_code,json=postalCode,proto3" json:"postal_code,omitempty"`
+	SubdivisionName string                 `protobuf:"bytes,10,opt,name=subdivision_name,json=subdivisionName,proto3" json:"subdivision_name,omitempty"`
+	// Set when an ASN or ISP database is configured.
+	AutonomousSystemNumber       uint32 `protobuf:"varint,11,opt,name=autonomous_system_number,json=autonomousSystemNumber,proto3" json:"autonomous_system_number,omitempty"`
+	AutonomousSystemOrganization string `protobuf:"bytes,12,opt,name=autonomous_system_organization,json=autonomousSystemOrganization,proto3" json:"autonomous_system_organization,omitempty"`
+	// Accuracy radius of the coordinates in kilometers.
+	AccuracyRadius uint32 `protobuf:"varint,13,opt,name=accuracy_radius,json=accuracyRadius,proto3" json:"accuracy_radius,omitempty"`
+	// Nielsen DMA code, set for US locations only.
+	MetroCode uint32 `protobuf:"varint,14,opt,name=metro_code,json=metroCode,proto3" json:"metro_code,omitempty"`
+	// Network of the database record, in CIDR notation.
+	Network       string `protobuf:"bytes,15,opt,name=network,proto3" json:"network,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LookupResponse) Reset() {
+	*x = LookupResponse{}
+	mi := &file_iplookup_v1_lookup_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LookupResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LookupResponse) ProtoMessage() {}
+
+func (x *LookupResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_iplookup_v1_lookup_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LookupResponse.ProtoReflect.Descriptor instead.
+func (*LookupResponse) Descriptor() ([]byte, []int) {
+	return file_iplookup_v1_lookup_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *LookupResponse) GetIp() string {
+	if x != nil {
+		return x.Ip
+	}
+	return ""
+}
+
+func (x *LookupResponse) GetCity() string {
+	if x != nil {
+		return x.City
+	}
+	return ""
+}
+
+func (x *LookupResponse) GetCountryCode() string {
+	if x != nil {
+		return x.CountryCode
+	}
+	return ""
+}
+
+func (x *LookupResponse) GetCountryName() string {
+	if x != nil {
+		return x.CountryName
+	}
+	return ""
+}
+
+func (x *LookupResponse) GetContinent() string {
+	if x != nil {
+		return x.Continent
+	}
+	return ""
+}
+
+func (x *LookupResponse) GetLatitude() float64 {
+	if x != nil {
+		return x.Latitude
+	}
+	return 0
+}
+
+func (x *LookupResponse) GetLongitude() float64 {
+	if x != nil {
+		return x.Longitude
+	}
+	return 0
+}
+
+func (x *LookupResponse) GetTimeZone() string {
+	if x != nil {
+		return x.TimeZone
+	}
+	return ""
+}
+
+func (x *LookupResponse) GetPostalCode() string {
+	if x != nil {
+		return x.PostalCode
+	}
+	return ""
+}
+
+func (x *LookupResponse) GetSubdivisionName() string {
+	if x != nil {
+		return x.SubdivisionName
+	}
+	return ""
+}
+
+func (x *LookupResponse) GetAutonomousSystemNumber() uint32 {
+	if x != nil {
+		return x.AutonomousSystemNumber
+	}
+	return 0
+}
+
+func (x *LookupResponse) GetAutonomousSystemOrganization() string {
+	if x != nil {
+		return x.AutonomousSystemOrganization
+	}
+	return ""
+}
+
+func (x *LookupResponse) GetAccuracyRadius() uint32 {
+	if x != nil {
+		return x.AccuracyRadius
+	}
+	return 0
+}
+
+func (x *LookupResponse) GetMetroCode() uint32 {
+	if x != nil {
+		return x.MetroCode
+	}
+	return 0
+}
+
+func (x *LookupResponse) GetNetwork() string {
+	if x != nil {
+		return x.Network
+	}
+	return ""
+}
+
+type BatchLookupRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ips           []string               `protobuf:"bytes,1,rep,name=ips,proto3" json:"ips,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchLookupRequest) Reset() {
+	*x = BatchLookupRequest{}
+	mi := &file_iplookup_v1_lookup_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchLookupRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchLookupRequest) ProtoMessage() {}
+
+func (x *BatchLookupRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_iplookup_v1_lookup_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchLookupRequest.ProtoReflect.Descriptor instead.
+func (*BatchLookupRequest) Descriptor() ([]byte, []int) {
+	return file_iplookup_v1_lookup_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *BatchLookupRequest) GetIps() []string {
+	if x != nil {
+		return x.Ips
+	}
+	return nil
+}
+
+type BatchLookupResult struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Set when the lookup succeeded.
+	Record *LookupResponse `protobuf:"bytes,1,opt,name=record,proto3" json:"record,omitempty"`
+	// Set when the lookup failed; record is then absent.
+	Error         string `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchLookupResult) Reset() {
+	*x = BatchLookupResult{}
+	mi := &file_iplookup_v1_lookup_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchLookupResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchLookupResult) ProtoMessage() {}
+
+func (x *BatchLookupResult) ProtoReflect() protoreflect.Message {
+	mi := &file_iplookup_v1_lookup_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchLookupResult.ProtoReflect.Descriptor instead.
+func (*BatchLookupResult) Descriptor() ([]byte, []int) {
+	return file_iplookup_v1_lookup_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *BatchLookupResult) GetRecord() *LookupResponse {
+	if x != nil {
+		return x.Record
+	}
+	return nil
+}
+
+func (x *BatchLookupResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type BatchLookupResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Results       []*BatchLookupResult   `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchLookupResponse) Reset() {
+	*x = BatchLookupResponse{}
+	mi := &file_iplookup_v1_lookup_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchLookupResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchLookupResponse) ProtoMessage() {}
+
+func (x *BatchLookupResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_iplookup_v1_lookup_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchLookupResponse.ProtoReflect.Descriptor instead.
+func (*BatchLookupResponse) Descriptor() ([]byte, []int) {
+	return file_iplookup_v1_lookup_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *BatchLookupResponse) GetResults() []*BatchLookupResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+var File_iplookup_v1_lookup_proto protoreflect.FileDescriptor
+
+const file_iplookup_v1_lookup_proto_rawDesc = "" +
+	"\n" +
+	"\x18iplookup/v1/lookup.proto\x12\viplookup.v1\"\x1f\n" +
+	"\rLookupRequest\x12\x0e\n" +
+	"\x02ip\x18\x01 \x01(\tR\x02ip\"\x9d\x04\n" +
+	"\x0eLookupResponse\x12\x0e\n" +
+	"\x02ip\x18\x01 \x01(\tR\x02ip\x12\x12\n" +
+	"\x04city\x18\x02 \x01(\tR\x04city\x12!\n" +
+	"\fcountry_code\x18\x03 \x01(\tR\vcountryCode\x12!\n" +
+	"\fcountry_name\x18\x04 \x01(\tR\vcountryName\x12\x1c\n" +
+	"\tcontinent\x18\x05 \x01(\tR\tcontinent\x12\x1a\n" +
+	"\blatitude\x18\x06 \x01(\x01R\blatitude\x12\x1c\n" +
+	"\tlongitude\x18\a \x01(\x01R\tlongitude\x12\x1b\n" +
+	"\ttime_zone\x18\b \x01(\tR\btimeZone\x12\x1f\n" +
+	"\vpostal_code\x18\t \x01(\tR\n" +
+	"postalCode\x12)\n" +
+	"\x10subdivision_name\x18\n" +
+	" \x01(\tR\x0fsubdivisionName\x128\n" +
+	"\x18autonomous_system_number\x18\v \x01(\rR\x16autonomousSystemNumber\x12D\n" +
+	"\x1eautonomous_system_organization\x18\f \x01(\tR\x1cautonomousSystemOrganization\x12'\n" +
+	"\x0faccuracy_radius\x18\r \x01(\rR\x0eaccuracyRadius\x12\x1d\n" +
+	"\n" +
+	"metro_code\x18\x0e \x01(\rR\tmetroCode\x12\x18\n" +
+	"\anetwork\x18\x0f \x01(\tR\anetwork\"&\n" +
+	"\x12BatchLookupRequest\x12\x10\n" +
+	"\x03ips\x18\x01 \x03(\tR\x03ips\"^\n" +
+	"\x11BatchLookupResult\x123\n" +
+	"\x06record\x18\x01 \x01(\v2\x1b.iplookup.v1.LookupResponseR\x06record\x12\x14\n" +
+	"\x05error\x18\x02 \x01(\tR\x05error\"O\n" +
+	"\x13BatchLookupResponse\x128\n" +
+	"\aresults\x18\x01 \x03(\v2\x1e.iplookup.v1.BatchLookupResultR\aresults2\xf4\x01\n" +
+	"\bIPLookup\x12A\n" +
+	"\x06Lookup\x12\x1a.iplookup.v1.LookupRequest\x1a\x1b.iplookup.v1.LookupResponse\x12P\n" +
+	"\vBatchLookup\x12\x1f.iplookup.v1.BatchLookupRequest\x1a .iplookup.v1.BatchLookupResponse\x12S\n" +
+	"\x11StreamBatchLookup\x12\x1a.iplookup.v1.LookupRequest\x1a\x1e.iplookup.v1.BatchLookupResult(\x010\x01B<Z:github.com/ali-issa/ip-lookup/proto/iplookup/v1;iplookupv1b\x06proto3"
+
+var (
+	file_iplookup_v1_lookup_proto_rawDescOnce sync.Once
+	file_iplookup_v1_lookup_proto_rawDescData []byte
+)
+
+func file_iplookup_v1_lookup_proto_rawDescGZIP() []byte {
+	file_iplookup_v1_lookup_proto_rawDescOnce.Do(func() {
+		file_iplookup_v1_lookup_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_iplookup_v1_lookup_proto_rawDesc), len(file_iplookup_v1_lookup_proto_rawDesc)))
+	})
+	return file_iplookup_v1_lookup_proto_rawDescData
+}
+
+var file_iplookup_v1_lookup_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_iplookup_v1_lookup_proto_goTypes = []any{
+	(*LookupRequest)(nil),       // 0: iplookup.v1.LookupRequest
+	(*LookupResponse)(nil),      // 1: iplookup.v1.LookupResponse
+	(*BatchLookupRequest)(nil),  // 2: iplookup.v1.BatchLookupRequest
+	(*BatchLookupResult)(nil),   // 3: iplookup.v1.BatchLookupResult
+	(*BatchLookupResponse)(nil), // 4: iplookup.v1.BatchLookupResponse
+}
+var file_iplookup_v1_lookup_proto_depIdxs = []int32{
+	1, // 0: iplookup.v1.BatchLookupResult.record:type_name -> iplookup.v1.LookupResponse
+	3, // 1: iplookup.v1.BatchLookupResponse.results:type_name -> iplookup.v1.BatchLookupResult
+	0, // 2: iplookup.v1.IPLookup.Lookup:input_type -> iplookup.v1.LookupRequest
+	2, // 3: iplookup.v1.IPLookup.BatchLookup:input_type -> iplookup.v1.BatchLookupRequest
+	0, // 4: iplookup.v1.IPLookup.StreamBatchLookup:input_type -> iplookup.v1.LookupRequest
+	1, // 5: iplookup.v1.IPLookup.Lookup:output_type -> iplookup.v1.LookupResponse
+	4, // 6: iplookup.v1.IPLookup.BatchLookup:output_type -> iplookup.v1.BatchLookupResponse
+	3, // 7: iplookup.v1.IPLookup.StreamBatchLookup:output_type -> iplookup.v1.BatchLookupResult
+	5, // [5:8] is the sub-list for method output_type
+	2, // [2:5] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_iplookup_v1_lookup_proto_init() }
+func file_iplookup_v1_lookup_proto_init() {
+	if File_iplookup_v1_lookup_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_iplookup_v1_lookup_proto_rawDesc), len(file_iplookup_v1_lookup_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   5,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_iplookup_v1_lookup_proto_goTypes,
+		DependencyIndexes: file_iplookup_v1_lookup_proto_depIdxs,
+		MessageInfos:      file_iplookup_v1_lookup_proto_msgTypes,
+	}.Build()
+	File_iplookup_v1_lookup_proto = out.File
+	file_iplookup_v1_lookup_proto_goTypes = nil
+	file_iplookup_v1_lookup_proto_depIdxs = nil
+}
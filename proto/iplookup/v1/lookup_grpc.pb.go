@@ -0,0 +1,207 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: iplookup/v1/lookup.proto
+
+// Package iplookup.v1 defines the RPC surface of the IP Lookup Service.
+// The service serves it over Twirp at /twirp/iplookup.v1.IPLookup/<Method>
+// and over gRPC on GRPC_LISTEN_ADDR. LookupResponse is also the body of
+// /lookup/ responses requested as application/x-protobuf.
+
+package iplookupv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	IPLookup_Lookup_FullMethodName            = "/iplookup.v1.IPLookup/Lookup"
+	IPLookup_BatchLookup_FullMethodName       = "/iplookup.v1.IPLookup/BatchLookup"
+	IPLookup_StreamBatchLookup_FullMethodName = "/iplookup.v1.IPLookup/StreamBatchLookup"
+)
+
+// IPLookupClient is the client API for IPLookup service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type IPLookupClient interface {
+	// Lookup returns geolocation data for a single IP address.
+	Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*LookupResponse, error)
+	// BatchLookup returns geolocation data for several IP addresses, in request order.
+	BatchLookup(ctx context.Context, in *BatchLookupRequest, opts ...grpc.CallOption) (*BatchLookupResponse, error)
+	// StreamBatchLookup answers each IP address the client streams with a
+	// result, in order and as soon as it is looked up, with no limit on their
+	// number. It is served over gRPC only.
+	StreamBatchLookup(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[LookupRequest, BatchLookupResult], error)
+}
+
+type iPLookupClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewIPLookupClient(cc grpc.ClientConnInterface) IPLookupClient {
+	return &iPLookupClient{cc}
+}
+
+func (c *iPLookupClient) Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*LookupResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(LookupResponse)
+	err := c.cc.Invoke(ctx, IPLookup_Lookup_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *iPLookupClient) BatchLookup(ctx context.Context, in *BatchLookupRequest, opts ...grpc.CallOption) (*BatchLookupResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BatchLookupResponse)
+	err := c.cc.Invoke(ctx, IPLookup_BatchLookup_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *iPLookupClient) StreamBatchLookup(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[LookupRequest, BatchLookupResult], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &IPLookup_ServiceDesc.Streams[0], IPLookup_StreamBatchLookup_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[LookupRequest, BatchLookupResult]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type IPLookup_StreamBatchLookupClient = grpc.BidiStreamingClient[LookupRequest, BatchLookupResult]
+
+// IPLookupServer is the server API for IPLookup service.
+// All implementations must embed UnimplementedIPLookupServer
+// for forward compatibility.
+type IPLookupServer interface {
+	// Lookup returns geolocation data for a single IP address.
+	Lookup(context.Context, *LookupRequest) (*LookupResponse, error)
+	// BatchLookup returns geolocation data for several IP addresses, in request order.
+	BatchLookup(context.Context, *BatchLookupRequest) (*BatchLookupResponse, error)
+	// StreamBatchLookup answers each IP address the client streams with a
+	// result, in order and as soon as it is looked up, with no limit on their
+	// number. It is served over gRPC only.
+	StreamBatchLookup(grpc.BidiStreamingServer[LookupRequest, BatchLookupResult]) error
+	mustEmbedUnimplementedIPLookupServer()
+}
+
+// UnimplementedIPLookupServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedIPLookupServer struct{}
+
+func (UnimplementedIPLookupServer) Lookup(context.Context, *LookupRequest) (*LookupResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Lookup not implemented")
+}
+func (UnimplementedIPLookupServer) BatchLookup(context.Context, *BatchLookupRequest) (*BatchLookupResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method BatchLookup not implemented")
+}
+func (UnimplementedIPLookupServer) StreamBatchLookup(grpc.BidiStreamingServer[LookupRequest, BatchLookupResult]) error {
+	return status.Error(codes.Unimplemented, "method StreamBatchLookup not implemented")
+}
+func (UnimplementedIPLookupServer) mustEmbedUnimplementedIPLookupServer() {}
+func (UnimplementedIPLookupServer) testEmbeddedByValue()                  {}
+
+// UnsafeIPLookupServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to IPLookupServer will
+// result in compilation errors.
+type UnsafeIPLookupServer interface {
+	mustEmbedUnimplementedIPLookupServer()
+}
+
+func RegisterIPLookupServer(s grpc.ServiceRegistrar, srv IPLookupServer) {
+	// If the following call panics, it indicates UnimplementedIPLookupServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&IPLookup_ServiceDesc, srv)
+}
+
+func _IPLookup_Lookup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LookupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IPLookupServer).Lookup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: IPLookup_Lookup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IPLookupServer).Lookup(ctx, req.(*LookupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IPLookup_BatchLookup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchLookupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IPLookupServer).BatchLookup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: IPLookup_BatchLookup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IPLookupServer).BatchLookup(ctx, req.(*BatchLookupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IPLookup_StreamBatchLookup_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(IPLookupServer).StreamBatchLookup(&grpc.GenericServerStream[LookupRequest, BatchLookupResult]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type IPLookup_StreamBatchLookupServer = grpc.BidiStreamingServer[LookupRequest, BatchLookupResult]
+
+// IPLookup_ServiceDesc is the grpc.ServiceDesc for IPLookup service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var IPLookup_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "iplookup.v1.IPLookup",
+	HandlerType: (*IPLookupServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Lookup",
+			Handler:    _IPLookup_Lookup_Handler,
+		},
+		{
+			MethodName: "BatchLookup",
+			Handler:    _IPLookup_BatchLookup_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamBatchLookup",
+			Handler:       _IPLookup_StreamBatchLookup_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "iplookup/v1/lookup.proto",
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+
+	iplookupv1 "github.com/ali-issa/ip-lookup/proto/iplookup/v1"
+)
+
+// The messages of proto/iplookup/v1/lookup.proto are generated into
+// proto/iplookup/v1 by `make proto`; this file converts lookups into them.
+
+// lookupResponseMessage converts a lookupIP response into an
+// iplookup.v1.LookupResponse.
+func lookupResponseMessage(m map[string]any) *iplookupv1.LookupResponse {
+	str := func(key string) string { s, _ := m[key].(string); return s }
+	num := func(key string) float64 { f, _ := m[key].(float64); return f }
+	integer := func(key string) uint32 {
+		// Responses decoded from the shared cache hold numbers as float64.
+		if f, ok := m[key].(float64); ok {
+			return uint32(f)
+		}
+		u, _ := m[key].(uint)
+		return uint32(u)
+	}
+	return &iplookupv1.LookupResponse{
+		Ip:              str("ip"),
+		City:            str("city"),
+		CountryCode:     str("country_code"),
+		CountryName:     str("country_name"),
+		Continent:       str("continent"),
+		Latitude:        num("latitude"),
+		Longitude:       num("longitude"),
+		TimeZone:        str("time_zone"),
+		PostalCode:      str("postal_code"),
+		SubdivisionName: str("subdivision_name"),
+
+		// The AS fields are set with GEOIP_ASN_DB_PATH or GEOIP_ISP_DB_PATH.
+		AutonomousSystemNumber:       integer("autonomous_system_number"),
+		AutonomousSystemOrganization: str("autonomous_system_organization"),
+		AccuracyRadius:               integer("accuracy_radius"),
+		MetroCode:                    integer("metro_code"),
+		Network:                      str("network"),
+	}
+}
+
+// batchLookupResultFor looks up ipStr as a batch item.
+func batchLookupResultFor(ctx context.Context, ipStr string) *iplookupv1.BatchLookupResult {
+	result, ok := lookupBatchItem(ctx, ipStr)
+	if !ok {
+		return &iplookupv1.BatchLookupResult{Error: result["error"].(string)}
+	}
+	return &iplookupv1.BatchLookupResult{Record: lookupResponseMessage(result)}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	iplookupv1 "github.com/ali-issa/ip-lookup/proto/iplookup/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestLookupResponseMessage(t *testing.T) {
+	want := &iplookupv1.LookupResponse{
+		Ip:                           "8.8.8.8",
+		City:                         "Mountain View",
+		CountryCode:                  "US",
+		Latitude:                     37.4223,
+		Longitude:                    -122.085,
+		AutonomousSystemNumber:       15169,
+		AutonomousSystemOrganization: "GOOGLE",
+		AccuracyRadius:               1000,
+		Network:                      "8.8.8.0/24",
+	}
+	for name, response := range map[string]map[string]any{
+		"lookup": {
+			"ip": "8.8.8.8", "city": "Mountain View", "country_code": "US",
+			"latitude": 37.4223, "longitude": -122.085,
+			"autonomous_system_number": uint(15169), "autonomous_system_organization": "GOOGLE",
+			"accuracy_radius": uint(1000), "network": "8.8.8.0/24",
+			// Fields without a message field are left out.
+			"flag_emoji": "🇺🇸", "is_in_european_union": false,
+		},
+		// Responses decoded from the shared cache hold numbers as float64.
+		"cache": {
+			"ip": "8.8.8.8", "city": "Mountain View", "country_code": "US",
+			"latitude": 37.4223, "longitude": -122.085,
+			"autonomous_system_number": 15169.0, "autonomous_system_organization": "GOOGLE",
+			"accuracy_radius": 1000.0, "network": "8.8.8.0/24",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			encoded, err := encodeProtobufResponse(response)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := &iplookupv1.LookupResponse{}
+			if err := proto.Unmarshal(encoded, got); err != nil {
+				t.Fatal(err)
+			}
+			if !proto.Equal(got, want) {
+				t.Errorf("decoded %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestBatchLookupResultFor(t *testing.T) {
+	useFakeProvider(t, map[string]any{"country_code": "GB"})
+
+	if got := batchLookupResultFor(context.Background(), "81.2.69.142"); got.GetRecord().GetCountryCode() != "GB" || got.GetError() != "" {
+		t.Errorf("result for a valid IP = %v", got)
+	}
+	if got := batchLookupResultFor(context.Background(), "x"); got.GetRecord() != nil || got.GetError() != "Invalid IP address format: x" {
+		t.Errorf("result for an invalid IP = %v", got)
+	}
+}
+
+func TestProtobufRejectsMalformedInput(t *testing.T) {
+	encoded, err := proto.Marshal(&iplookupv1.LookupResponse{Ip: "8.8.8.8", City: "Mountain View"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, b := range map[string][]byte{
+		"truncated":           encoded[:len(encoded)-1],
+		"length past the end": {0x0a, 0x10, '8'},
+		"truncated varint":    {0x58, 0x80},
+		"field number 0":      {0x00, 0x01},
+	} {
+		if err := proto.Unmarshal(b, &iplookupv1.LookupResponse{}); err == nil {
+			t.Errorf("%s: proto.Unmarshal(%x) succeeded", name, b)
+		}
+	}
+
+	// The Twirp route rejects a body that does not decode.
+	useFakeProvider(t, map[string]any{"country_code": "GB"})
+	srv := httptest.NewServer(newTwirpHandler())
+	t.Cleanup(srv.Close)
+	resp, err := http.Post(srv.URL+twirpPathPrefix+"Lookup", "application/protobuf", bytes.NewReader(encoded[:len(encoded)-1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), `"malformed"`) {
+		t.Errorf("malformed Twirp request got %d %s, want 400 malformed", resp.StatusCode, body)
+	}
+}
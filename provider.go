@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// GEOIP_PROVIDER values.
+const (
+	providerMaxMind     = "maxmind"
+	providerIP2Location = "ip2location"
+)
+
+// geoProvider is the backend of the primary database, selected with
+// GEOIP_PROVIDER. It is the database source of the lookup chain; the
+// supplementary and fallback databases, overrides and enrichment apply to
+// any backend.
+type geoProvider interface {
+	lookupSource
+	// loaded reports whether a database is serving lookups.
+	loaded() bool
+	databaseType() string
+	buildTime() time.Time
+	// metadata describes the loaded database for /metadata.
+	metadata() map[string]any
+	// reload reopens the database file, for SIGHUP.
+	reload() error
+	Close() error
+}
+
+// primaryDB is the backend of the primary database: MaxMind unless
+// GEOIP_PROVIDER names another. It is set in main before serving starts.
+var primaryDB geoProvider = &maxMindProvider{}
+
+// newProvider opens the primary database at path with the named backend.
+func newProvider(name, path string) (geoProvider, error) {
+	switch name {
+	case providerIP2Location:
+		return newIP2LocationProvider(path)
+	default:
+		return nil, fmt.Errorf("unknown GEOIP_PROVIDER %q", name)
+	}
+}
+
+// maxMindDB returns the primary database when it is in the MaxMind format,
+// for the features only it supports, such as canary rollouts and names in
+// every language; nil under other providers.
+func maxMindDB() *maxMindProvider {
+	p, _ := primaryDB.(*maxMindProvider)
+	return p
+}
+
+// databaseLoaded reports whether a primary database is serving lookups.
+func databaseLoaded() bool {
+	return primaryDB.loaded()
+}
+
+// databaseType returns the type of the primary database, e.g. GeoLite2-City.
+func databaseType() string {
+	return primaryDB.databaseType()
+}
+
+// databaseBuildTime returns when the primary database was built.
+func databaseBuildTime() time.Time {
+	return primaryDB.buildTime()
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rateLimitMaxClients bounds the number of per-client buckets kept in memory.
+// Full buckets are evicted first since forgetting them changes nothing.
+const rateLimitMaxClients = 100000
+
+// shedRetryAfter is the Retry-After sent with 503 responses when shedding load.
+const shedRetryAfter = time.Second
+
+// rateLimitExempt reports whether r bypasses rate limiting and load
+// shedding, so orchestrators and registries can always probe health.
+func rateLimitExempt(r *http.Request) bool {
+	_, path, _ := splitAPIVersion(r.URL.Path)
+	if path == "/healthz" {
+		return true
+	}
+	// Peer cache traffic is fleet-internal and would otherwise be limited
+	// per peer; requests without the peer secret are public traffic.
+	return lookupCache != nil && strings.HasPrefix(path, groupcacheBasePath) && lookupCache.peerAuthorized(r)
+}
+
+// tokenBucket is one client's allowance.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a per-client token bucket limiter: each client may burst up
+// to burst requests, refilled at rate requests per second.
+type rateLimiter struct {
+	rate  float64
+	burst int
+	// trustedProxies are the peers whose forwarding headers name the client.
+	trustedProxies []netip.Prefix
+
+	mu       sync.Mutex
+	clients  map[string]*tokenBucket
+	rejected uint64
+}
+
+// limiter is the process-wide rate limiter; nil when RATE_LIMIT_RPS is unset.
+var limiter *rateLimiter
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: burst, clients: make(map[string]*tokenBucket)}
+}
+
+// clientKey returns the address r is rate limited by: the peer it connects
+// from, unless that is a trusted proxy. Behind trusted proxies it is the
+// nearest untrusted hop of X-Forwarded-For, or X-Real-IP, so clients cannot
+// pick a fresh bucket by sending a header of their own.
+func (l *rateLimiter) clientKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !l.trusted(host) {
+		return host
+	}
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if hop == "" {
+				continue
+			}
+			host = hop
+			if !l.trusted(hop) {
+				break
+			}
+		}
+		return host
+	}
+	if xri := strings.TrimSpace(r.Header.Get("X-Real-IP")); xri != "" {
+		return xri
+	}
+	return host
+}
+
+// trusted reports whether addr is in one of the trusted proxy networks.
+func (l *rateLimiter) trusted(addr string) bool {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, network := range l.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimitDecision describes the outcome of one take, for response headers.
+type rateLimitDecision struct {
+	allowed    bool
+	remaining  int
+	retryAfter time.Duration // time until the next token; zero when allowed
+	reset      time.Duration // time until the bucket is full again
+}
+
+// take consumes one token for client if available.
+func (l *rateLimiter) take(client string) rateLimitDecision {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.clients[client]
+	if !ok {
+		if len(l.clients) >= rateLimitMaxClients {
+			l.prune(now)
+		}
+		b = &tokenBucket{tokens: float64(l.burst), last: now}
+		l.clients[client] = b
+	}
+	b.tokens = min(float64(l.burst), b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	d := rateLimitDecision{allowed: b.tokens >= 1}
+	if d.allowed {
+		b.tokens--
+	} else {
+		l.rejected++
+		d.retryAfter = l.refillTime(1 - b.tokens)
+	}
+	d.remaining = int(b.tokens)
+	d.reset = l.refillTime(float64(l.burst) - b.tokens)
+	return d
+}
+
+func (l *rateLimiter) refillTime(tokens float64) time.Duration {
+	return time.Duration(tokens / l.rate * float64(time.Second))
+}
+
+// prune drops buckets that have refilled completely and, if the table is
+// still full, an arbitrary half of the rest. The caller must hold l.mu.
+func (l *rateLimiter) prune(now time.Time) {
+	for client, b := range l.clients {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= float64(l.burst) {
+			delete(l.clients, client)
+		}
+	}
+	if len(l.clients) < rateLimitMaxClients {
+		return
+	}
+	evict := len(l.clients) / 2
+	for client := range l.clients {
+		if evict == 0 {
+			break
+		}
+		delete(l.clients, client)
+		evict--
+	}
+	log.Printf("Rate limiter: client table full, evicted down to %d clients", len(l.clients))
+}
+
+// rateLimiterState is the limiter state reported by /stats.
+type rateLimiterState struct {
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	Burst             int     `json:"burst"`
+	TrackedClients    int     `json:"tracked_clients"`
+	LimitedClients    int     `json:"limited_clients"`
+	Rejected          uint64  `json:"rejected"`
+}
+
+func (l *rateLimiter) state() rateLimiterState {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s := rateLimiterState{RequestsPerSecond: l.rate, Burst: l.burst, TrackedClients: len(l.clients), Rejected: l.rejected}
+	for _, b := range l.clients {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate < 1 {
+			s.LimitedClients++
+		}
+	}
+	return s
+}
+
+// loadShedder rejects requests beyond a fixed number in flight.
+type loadShedder struct {
+	max      int64
+	inFlight atomic.Int64
+	rejected atomic.Uint64
+}
+
+// shedder is the process-wide load shedder; nil when MAX_CONCURRENT_REQUESTS is unset.
+var shedder *loadShedder
+
+// loadShedderState is the shedder state reported by /stats.
+type loadShedderState struct {
+	MaxConcurrent int64  `json:"max_concurrent"`
+	InFlight      int64  `json:"in_flight"`
+	Rejected      uint64 `json:"rejected"`
+}
+
+func (s *loadShedder) state() loadShedderState {
+	return loadShedderState{MaxConcurrent: s.max, InFlight: s.inFlight.Load(), Rejected: s.rejected.Load()}
+}
+
+// ceilSeconds rounds d up to whole seconds, as the delay headers require.
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// throttleMiddleware applies load shedding (503) and then per-client rate
+// limiting (429). Allowed and limited responses carry the draft IETF
+// RateLimit header fields so clients can pace themselves.
+func throttleMiddleware(next http.Handler) http.Handler {
+	if limiter == nil && shedder == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rateLimitExempt(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if shedder != nil {
+			if shedder.inFlight.Add(1) > shedder.max {
+				shedder.inFlight.Add(-1)
+				shedder.rejected.Add(1)
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(shedRetryAfter)))
+				writeJSONError(w, "Server is overloaded, please retry later", http.StatusServiceUnavailable, errCodeOverloaded)
+				return
+			}
+			inFlight := &shedder.inFlight
+			release := sync.OnceFunc(func() { inFlight.Add(-1) })
+			defer release()
+			r = r.WithContext(context.WithValue(r.Context(), shedderSlotKey{}, release))
+		}
+
+		if limiter != nil {
+			d := limiter.take(limiter.clientKey(r))
+			window := max(1, ceilSeconds(limiter.refillTime(float64(limiter.burst))))
+			h := w.Header()
+			h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limiter.burst, window))
+			h.Set("RateLimit-Limit", strconv.Itoa(limiter.burst))
+			h.Set("RateLimit-Remaining", strconv.Itoa(d.remaining))
+			h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(d.reset)))
+			if !d.allowed {
+				retryAfter := max(1, ceilSeconds(d.retryAfter))
+				h.Set("Retry-After", strconv.Itoa(retryAfter))
+				writeAppError(w, AppError{
+					Message:   "Rate limit exceeded, please retry later",
+					Code:      http.StatusTooManyRequests,
+					ErrorCode: errCodeRateLimited,
+					Details:   map[string]int{"retry_after_seconds": retryAfter},
+				})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// shedderSlotKey is the context key of the function releasing a request's
+// load shedder slot.
+type shedderSlotKey struct{}
+
+// releaseShedderSlot gives up r's load shedder slot before r is done. Handlers
+// of long-lived streams call it once the stream is established, so idle
+// subscribers do not hold MAX_CONCURRENT_REQUESTS slots ordinary requests
+// need; the shedder still limits how many streams are opened at once.
+func releaseShedderSlot(r *http.Request) {
+	if release, ok := r.Context().Value(shedderSlotKey{}).(func()); ok {
+		release()
+	}
+}
+
+// statsHandler serves /stats: lifetime counters plus limiter state.
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	response := stats.snapshot()
+	if limiter != nil {
+		response["rate_limit"] = limiter.state()
+	}
+	if shedder != nil {
+		response["load_shedding"] = shedder.state()
+	}
+	if lookupCache != nil {
+		response["shared_cache"] = lookupCache.state()
+	}
+	if canary := geoCanary.Load(); canary != nil {
+		response["db_canary"] = canary.state()
+	}
+	if threats != nil {
+		response["threat_lists"] = threats.state()
+	}
+	if cloudRanges != nil {
+		response["cloud_ranges"] = cloudRanges.state()
+	}
+	if torExitListEnabled {
+		response["tor_exit_list"] = torExitListState()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error encoding stats response: %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"testing"
+)
+
+func TestRateLimitIgnoresSpoofedForwardedFor(t *testing.T) {
+	l := newRateLimiter(1, 2)
+	for i := range 3 {
+		r := httptest.NewRequest(http.MethodGet, "/lookup", nil)
+		r.RemoteAddr = "203.0.113.7:1234"
+		r.Header.Set("X-Forwarded-For", "198.51.100."+strconv.Itoa(i))
+		r.Header.Set("X-Real-IP", "198.51.100."+strconv.Itoa(i))
+		d := l.take(l.clientKey(r))
+		if want := i < 2; d.allowed != want {
+			t.Errorf("request %d: allowed = %v, want %v", i, d.allowed, want)
+		}
+	}
+}
+
+func TestRateLimitClientKey(t *testing.T) {
+	l := newRateLimiter(1, 1)
+	l.trustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	tests := []struct {
+		remoteAddr string
+		xff        string
+		xri        string
+		want       string
+	}{
+		{"203.0.113.7:1234", "198.51.100.1", "", "203.0.113.7"},
+		{"10.0.0.1:1234", "", "", "10.0.0.1"},
+		{"10.0.0.1:1234", "198.51.100.1", "", "198.51.100.1"},
+		{"10.0.0.1:1234", "192.0.2.9, 198.51.100.1, 10.0.0.2", "", "198.51.100.1"},
+		{"10.0.0.1:1234", "10.0.0.3, 10.0.0.2", "", "10.0.0.3"},
+		{"10.0.0.1:1234", "", "198.51.100.2", "198.51.100.2"},
+		{"[::ffff:10.0.0.1]:1234", "198.51.100.1", "", "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/lookup", nil)
+		r.RemoteAddr = tt.remoteAddr
+		if tt.xff != "" {
+			r.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		if tt.xri != "" {
+			r.Header.Set("X-Real-IP", tt.xri)
+		}
+		if got := l.clientKey(r); got != tt.want {
+			t.Errorf("clientKey(%s, XFF %q, X-Real-IP %q) = %q, want %q", tt.remoteAddr, tt.xff, tt.xri, got, tt.want)
+		}
+	}
+}
+
+func TestReleaseShedderSlot(t *testing.T) {
+	old := shedder
+	shedder = &loadShedder{max: 1}
+	t.Cleanup(func() { shedder = old })
+
+	established := make(chan struct{})
+	done := make(chan struct{})
+	stream := throttleMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		releaseShedderSlot(r)
+		releaseShedderSlot(r) // releasing twice frees one slot
+		close(established)
+		<-done
+	}))
+	go stream.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ws", nil))
+	<-established
+
+	rec := httptest.NewRecorder()
+	throttleMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/lookup/8.8.8.8", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("request during an established stream: status = %d, want 200", rec.Code)
+	}
+	if got := shedder.inFlight.Load(); got != 0 {
+		t.Errorf("in flight = %d, want 0", got)
+	}
+	close(done)
+}
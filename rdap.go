@@ -0,0 +1,433 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/groupcache/singleflight"
+)
+
+// IANA bootstrap registries mapping IP ranges to their RIR's RDAP service (RFC 9224).
+const (
+	rdapBootstrapIPv4URL = "https://data.iana.org/rdap/ipv4.json"
+	rdapBootstrapIPv6URL = "https://data.iana.org/rdap/ipv6.json"
+)
+
+// rdapBootstrapTTL is how long a fetched bootstrap registry is trusted.
+const rdapBootstrapTTL = 24 * time.Hour
+
+// rdapCacheSize bounds the number of cached RDAP answers.
+const rdapCacheSize = 10000
+
+// maxRDAPResponseSize caps how much of a registry response is read.
+const maxRDAPResponseSize = 1 << 20
+
+// errRDAPRateLimited is returned by rdapClient.lookup when a registry is not
+// queried to stay within its rate limit.
+var errRDAPRateLimited = errors.New("RDAP registry rate limit reached")
+
+// rdapInfo is the registration data extracted from an RDAP IP network object.
+type rdapInfo struct {
+	Handle       string `json:"handle,omitempty"`
+	NetName      string `json:"netname,omitempty"`
+	NetType      string `json:"type,omitempty"`
+	StartAddress string `json:"start_address,omitempty"`
+	EndAddress   string `json:"end_address,omitempty"`
+	Country      string `json:"country,omitempty"`
+	Org          string `json:"org,omitempty"`
+	OrgHandle    string `json:"org_handle,omitempty"`
+	AbuseName    string `json:"abuse_name,omitempty"`
+	AbuseEmail   string `json:"abuse_email,omitempty"`
+	AbusePhone   string `json:"abuse_phone,omitempty"`
+	Source       string `json:"source"`
+}
+
+type rdapBootstrapService struct {
+	network *net.IPNet
+	baseURL string
+}
+
+// rdapClient resolves the responsible RIR for an IP via the IANA bootstrap
+// registry, queries it, and caches the results.
+type rdapClient struct {
+	httpClient *http.Client
+	cache      *ttlCache[*rdapInfo]
+	// limiter paces the queries to each registry, keyed by base URL; nil
+	// leaves them unlimited.
+	limiter *rateLimiter
+	queries singleflight.Group
+
+	mu               sync.Mutex
+	bootstrap        []rdapBootstrapService
+	bootstrapFetched time.Time
+
+	// blockedUntil holds the registries that answered 429, by base URL,
+	// until their Retry-After passes.
+	blockMu      sync.Mutex
+	blockedUntil map[string]time.Time
+}
+
+func newRDAPClient(timeout, cacheTTL time.Duration, rateLimit float64) *rdapClient {
+	c := &rdapClient{
+		httpClient:   &http.Client{Timeout: timeout},
+		cache:        newTTLCache[*rdapInfo](rdapCacheSize, cacheTTL),
+		blockedUntil: make(map[string]time.Time),
+	}
+	if rateLimit > 0 {
+		c.limiter = newRateLimiter(rateLimit, max(1, int(math.Ceil(rateLimit))))
+	}
+	return c
+}
+
+// lookup returns registration data for ip, served from cache when possible.
+// Concurrent lookups of an IP share one query. Queries beyond the rate
+// limit of the registry, or while it asks clients to back off, fail with
+// errRDAPRateLimited rather than wait.
+func (c *rdapClient) lookup(ctx context.Context, ip net.IP) (*rdapInfo, error) {
+	key := ip.String()
+	if info, ok := c.cache.get(key); ok {
+		return info, nil
+	}
+
+	info, err := c.queries.Do(key, func() (any, error) {
+		// The query is shared, so it is not cancelled with the request that
+		// started it; the HTTP client's timeout bounds it.
+		ctx := context.WithoutCancel(ctx)
+		baseURL, err := c.serviceFor(ctx, ip)
+		if err != nil {
+			return nil, err
+		}
+		if !c.allowed(baseURL) {
+			return nil, errRDAPRateLimited
+		}
+
+		var network rdapNetwork
+		source := strings.TrimSuffix(baseURL, "/") + "/ip/" + key
+		if err := c.getJSON(ctx, source, &network); err != nil {
+			var limited *rdapRateLimitedError
+			if errors.As(err, &limited) {
+				c.block(baseURL, limited.retryAfter)
+				return nil, errRDAPRateLimited
+			}
+			return nil, err
+		}
+
+		info := network.info()
+		info.Source = source
+		c.cache.set(key, info)
+		return info, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info.(*rdapInfo), nil
+}
+
+// rdapDefaultRetryAfter is how long a registry answering 429 without a
+// usable Retry-After is left alone.
+const rdapDefaultRetryAfter = time.Minute
+
+// rdapRateLimitedError is a 429 answer of a registry.
+type rdapRateLimitedError struct {
+	url        string
+	retryAfter time.Duration
+}
+
+func (e *rdapRateLimitedError) Error() string {
+	return fmt.Sprintf("GET %s: rate limited for %s", e.url, e.retryAfter)
+}
+
+// allowed reports whether the registry at baseURL may be queried now.
+func (c *rdapClient) allowed(baseURL string) bool {
+	c.blockMu.Lock()
+	until, blocked := c.blockedUntil[baseURL]
+	if blocked && time.Now().After(until) {
+		delete(c.blockedUntil, baseURL)
+		blocked = false
+	}
+	c.blockMu.Unlock()
+	if blocked {
+		return false
+	}
+	return c.limiter == nil || c.limiter.take(baseURL).allowed
+}
+
+// block stops queries to the registry at baseURL for retryAfter.
+func (c *rdapClient) block(baseURL string, retryAfter time.Duration) {
+	log.Printf("RDAP: %s rate limited us, pausing its queries for %s", baseURL, retryAfter)
+	c.blockMu.Lock()
+	c.blockedUntil[baseURL] = time.Now().Add(retryAfter)
+	c.blockMu.Unlock()
+}
+
+// serviceFor returns the RDAP base URL responsible for ip.
+func (c *rdapClient) serviceFor(ctx context.Context, ip net.IP) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.bootstrap == nil || time.Since(c.bootstrapFetched) > rdapBootstrapTTL {
+		services, err := c.fetchBootstrap(ctx)
+		if err != nil {
+			if c.bootstrap == nil {
+				return "", err
+			}
+			// Keep serving with the previous registry; it changes rarely.
+			log.Printf("RDAP: bootstrap refresh failed, keeping previous registry: %v", err)
+		} else {
+			c.bootstrap = services
+		}
+		c.bootstrapFetched = time.Now()
+	}
+
+	best, bestLen := "", -1
+	for _, svc := range c.bootstrap {
+		if ones, _ := svc.network.Mask.Size(); svc.network.Contains(ip) && ones > bestLen {
+			best, bestLen = svc.baseURL, ones
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("no RDAP service registered for %s", ip)
+	}
+	return best, nil
+}
+
+func (c *rdapClient) fetchBootstrap(ctx context.Context) ([]rdapBootstrapService, error) {
+	var services []rdapBootstrapService
+	for _, url := range []string{rdapBootstrapIPv4URL, rdapBootstrapIPv6URL} {
+		var registry struct {
+			Services [][][]string `json:"services"`
+		}
+		if err := c.getJSON(ctx, url, &registry); err != nil {
+			return nil, fmt.Errorf("fetching RDAP bootstrap %s: %w", url, err)
+		}
+		found := len(services)
+		for _, svc := range registry.Services {
+			if len(svc) != 2 || len(svc[1]) == 0 {
+				continue
+			}
+			// Prefer an HTTPS base URL when several are listed.
+			baseURL := svc[1][0]
+			for _, u := range svc[1] {
+				if strings.HasPrefix(u, "https://") {
+					baseURL = u
+					break
+				}
+			}
+			for _, cidr := range svc[0] {
+				if _, network, err := net.ParseCIDR(cidr); err == nil {
+					services = append(services, rdapBootstrapService{network: network, baseURL: baseURL})
+				}
+			}
+		}
+		// Any JSON object decodes; one without services is not a registry.
+		if len(services) == found {
+			return nil, fmt.Errorf("fetching RDAP bootstrap %s: no services listed", url)
+		}
+	}
+	return services, nil
+}
+
+func (c *rdapClient) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/rdap+json, application/json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter := rdapDefaultRetryAfter
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+		return &rdapRateLimitedError{url: url, retryAfter: retryAfter}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxRDAPResponseSize)).Decode(v)
+}
+
+// rdapNetwork is the subset of an RDAP IP network object (RFC 9083) we use.
+type rdapNetwork struct {
+	Handle       string       `json:"handle"`
+	Name         string       `json:"name"`
+	Type         string       `json:"type"`
+	StartAddress string       `json:"startAddress"`
+	EndAddress   string       `json:"endAddress"`
+	Country      string       `json:"country"`
+	Entities     []rdapEntity `json:"entities"`
+}
+
+type rdapEntity struct {
+	Handle     string       `json:"handle"`
+	Roles      []string     `json:"roles"`
+	VCardArray []any        `json:"vcardArray"`
+	Entities   []rdapEntity `json:"entities"`
+}
+
+func (n *rdapNetwork) info() *rdapInfo {
+	info := &rdapInfo{
+		Handle:       n.Handle,
+		NetName:      n.Name,
+		NetType:      n.Type,
+		StartAddress: n.StartAddress,
+		EndAddress:   n.EndAddress,
+		Country:      n.Country,
+	}
+	if registrant := findRDAPEntity(n.Entities, "registrant"); registrant != nil {
+		info.Org = registrant.vcardValue("fn")
+		info.OrgHandle = registrant.Handle
+	}
+	// Abuse contacts are often nested under the registrant entity.
+	if abuse := findRDAPEntity(n.Entities, "abuse"); abuse != nil {
+		info.AbuseName = abuse.vcardValue("fn")
+		info.AbuseEmail = abuse.vcardValue("email")
+		info.AbusePhone = abuse.vcardValue("tel")
+	}
+	return info
+}
+
+// findRDAPEntity searches entities depth-first for the first one with role.
+func findRDAPEntity(entities []rdapEntity, role string) *rdapEntity {
+	for i := range entities {
+		for _, r := range entities[i].Roles {
+			if r == role {
+				return &entities[i]
+			}
+		}
+	}
+	for i := range entities {
+		if found := findRDAPEntity(entities[i].Entities, role); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// vcardValue returns the first text value of the named jCard (RFC 7095) property.
+func (e *rdapEntity) vcardValue(name string) string {
+	if len(e.VCardArray) != 2 {
+		return ""
+	}
+	properties, ok := e.VCardArray[1].([]any)
+	if !ok {
+		return ""
+	}
+	for _, p := range properties {
+		prop, ok := p.([]any)
+		if !ok || len(prop) < 4 {
+			continue
+		}
+		if propName, _ := prop[0].(string); propName != name {
+			continue
+		}
+		if value, ok := prop[3].(string); ok {
+			return strings.TrimPrefix(value, "tel:")
+		}
+	}
+	return ""
+}
+
+// rdap is the shared RDAP client, set by configureServices when WHOIS_ENABLED is true.
+var rdap *rdapClient
+
+// wanted reports whether the request asks for registration data with
+// ?whois=1.
+func (c *rdapClient) wanted(r *http.Request) (bool, error) {
+	value := strings.TrimSpace(r.URL.Query().Get("whois"))
+	if value == "" {
+		return false, nil
+	}
+	wanted, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("Invalid whois parameter '%s': must be a boolean such as 1 or 0", value)
+	}
+	return wanted, nil
+}
+
+// wantsWhois reports whether the lookup response for r should carry
+// registration data. Invalid whois values are rejected before this is
+// consulted.
+func wantsWhois(r *http.Request) bool {
+	if rdap == nil {
+		return false
+	}
+	wanted, err := rdap.wanted(r)
+	return err == nil && wanted
+}
+
+// addWhois sets the whois field of response to the registration data of ip,
+// or null when the registry could not be queried, if the request asks for
+// it.
+func addWhois(r *http.Request, ip net.IP, response map[string]any) {
+	if !wantsWhois(r) {
+		return
+	}
+	info, err := rdap.lookup(r.Context(), ip)
+	if err != nil {
+		log.Printf("RDAP lookup for %s failed: %v", ip.String(), err)
+		response["whois"] = nil
+		return
+	}
+	response["whois"] = info
+}
+
+// whoisHandler serves /whois/{ip}: the GeoIP record merged with RDAP
+// registration data from the responsible RIR.
+func whoisHandler(w http.ResponseWriter, r *http.Request) {
+	ipStr := r.PathValue("ip")
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		writeJSONError(w, fmt.Sprintf("Invalid IP address format: %s", ipStr), http.StatusBadRequest, errCodeInvalidIP)
+		return
+	}
+
+	response, err := lookupIP(r.Context(), ip)
+	if err != nil {
+		// Registration data is still useful for IPs without GeoIP coverage.
+		if !errors.Is(err, errNoRecord) {
+			log.Printf("GeoIP lookup for IP %s failed: %v", ip.String(), err)
+		}
+		response = map[string]any{"ip": ip.String()}
+	}
+
+	info, err := rdap.lookup(r.Context(), ip)
+	if err != nil {
+		log.Printf("RDAP lookup for %s failed: %v", ip.String(), err)
+		if _, hasGeo := response["country_code"]; !hasGeo {
+			writeJSONError(w, fmt.Sprintf("No GeoIP or registration data available for IP: %s", ip.String()), http.StatusBadGateway, errCodeUpstreamUnavailable)
+			return
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			response["whois_error"] = "Registration data lookup timed out"
+		} else if errors.Is(err, errRDAPRateLimited) {
+			response["whois_error"] = "Registration data lookup rate limited"
+		} else {
+			response["whois_error"] = "Registration data unavailable"
+		}
+	} else {
+		response["whois"] = info
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error encoding JSON response for IP %s: %v", ip.String(), err)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// handlerTransport answers the requests of an http.Client with a handler,
+// whatever their host.
+type handlerTransport struct {
+	http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	t.ServeHTTP(rec, req)
+	return rec.Result(), nil
+}
+
+const (
+	rdapTestIPv4Bootstrap = `{"services": [
+		[["81.0.0.0/8"], ["http://rdap.example/", "https://rdap.example/"]],
+		[["81.2.0.0/16", "not a network"], ["https://ripe.example/"]]
+	]}`
+	rdapTestIPv6Bootstrap = `{"services": [[["2001:db8::/32"], ["https://arin.example/"]]]}`
+	rdapTestNetwork       = `{
+		"handle": "NET-1", "name": "EXAMPLE-NET", "type": "ASSIGNED PA",
+		"startAddress": "81.2.69.0", "endAddress": "81.2.69.255", "country": "GB",
+		"entities": [{
+			"handle": "ORG-1", "roles": ["registrant"],
+			"vcardArray": ["vcard", [["version", {}, "text", "4.0"], ["fn", {}, "text", "Example Ltd"]]],
+			"entities": [{
+				"handle": "ABUSE-1", "roles": ["abuse"],
+				"vcardArray": ["vcard", [
+					["fn", {}, "text", "Abuse Desk"],
+					["email", {}, "text", "abuse@example.net"],
+					["tel", {"type": "voice"}, "uri", "tel:+44-20-0000"]
+				]]
+			}]
+		}]
+	}`
+)
+
+// rdapTestRegistry serves the bootstrap registries and ripe.example with the
+// given bodies, and counts the queries of ripe.example.
+type rdapTestRegistry struct {
+	ipv4, network           string
+	bootstrapStatus, status int
+	queries                 int
+}
+
+func (reg *rdapTestRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.String() {
+	case rdapBootstrapIPv4URL:
+		if reg.bootstrapStatus != 0 {
+			w.WriteHeader(reg.bootstrapStatus)
+		}
+		w.Write([]byte(reg.ipv4))
+	case rdapBootstrapIPv6URL:
+		w.Write([]byte(rdapTestIPv6Bootstrap))
+	case "https://ripe.example/ip/81.2.69.142":
+		reg.queries++
+		if reg.status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "60")
+		}
+		if reg.status != 0 {
+			w.WriteHeader(reg.status)
+		}
+		w.Write([]byte(reg.network))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// newTestRDAPClient returns an RDAP client querying reg.
+func newTestRDAPClient(reg *rdapTestRegistry) *rdapClient {
+	c := newRDAPClient(time.Second, time.Minute, 0)
+	c.httpClient.Transport = handlerTransport{reg}
+	return c
+}
+
+func TestRDAPLookup(t *testing.T) {
+	reg := &rdapTestRegistry{ipv4: rdapTestIPv4Bootstrap, network: rdapTestNetwork}
+	c := newTestRDAPClient(reg)
+	want := &rdapInfo{
+		Handle:       "NET-1",
+		NetName:      "EXAMPLE-NET",
+		NetType:      "ASSIGNED PA",
+		StartAddress: "81.2.69.0",
+		EndAddress:   "81.2.69.255",
+		Country:      "GB",
+		Org:          "Example Ltd",
+		OrgHandle:    "ORG-1",
+		AbuseName:    "Abuse Desk",
+		AbuseEmail:   "abuse@example.net",
+		AbusePhone:   "+44-20-0000",
+		// The most specific network's registry answers.
+		Source: "https://ripe.example/ip/81.2.69.142",
+	}
+	for range 2 {
+		info, err := c.lookup(context.Background(), net.ParseIP("81.2.69.142"))
+		if err != nil || !reflect.DeepEqual(info, want) {
+			t.Fatalf("lookup = %+v, %v, want %+v", info, err, want)
+		}
+	}
+	if reg.queries != 1 {
+		t.Errorf("the registry was queried %d times, want once", reg.queries)
+	}
+
+	// Of several base URLs, the HTTPS one is used.
+	if baseURL, err := c.serviceFor(context.Background(), net.ParseIP("81.3.0.1")); err != nil || baseURL != "https://rdap.example/" {
+		t.Errorf("serviceFor(81.3.0.1) = %q, %v, want https://rdap.example/", baseURL, err)
+	}
+}
+
+func TestRDAPLookupOfMalformedResponses(t *testing.T) {
+	tests := []struct {
+		name string
+		reg  rdapTestRegistry
+		// message is part of the error.
+		message string
+	}{
+		{"bootstrap not found", rdapTestRegistry{bootstrapStatus: http.StatusNotFound}, "unexpected status 404"},
+		{"bootstrap not JSON", rdapTestRegistry{ipv4: "<html>"}, "fetching RDAP bootstrap"},
+		{"bootstrap truncated", rdapTestRegistry{ipv4: rdapTestIPv4Bootstrap[:40]}, "unexpected EOF"},
+		{"bootstrap without services", rdapTestRegistry{ipv4: `{"version": "1.0"}`}, "no services listed"},
+		{"bootstrap of invalid networks", rdapTestRegistry{ipv4: `{"services": [[["81.0.0.0/33"], ["https://rdap.example/"]]]}`}, "no services listed"},
+		{"bootstrap services without URLs", rdapTestRegistry{ipv4: `{"services": [[["81.0.0.0/8"], []], [["82.0.0.0/8"]]]}`}, "no services listed"},
+		{"bootstrap service of the wrong shape", rdapTestRegistry{ipv4: `{"services": [["81.0.0.0/8", "https://rdap.example/"]]}`}, "cannot unmarshal"},
+		{"IP without a registry", rdapTestRegistry{ipv4: `{"services": [[["10.0.0.0/8"], ["https://rdap.example/"]]]}`}, "no RDAP service registered for 81.2.69.142"},
+		{"network not found", rdapTestRegistry{ipv4: rdapTestIPv4Bootstrap, status: http.StatusNotFound}, "unexpected status 404"},
+		{"network truncated", rdapTestRegistry{ipv4: rdapTestIPv4Bootstrap, network: rdapTestNetwork[:100]}, "unexpected EOF"},
+		{"network too large", rdapTestRegistry{ipv4: rdapTestIPv4Bootstrap, network: `{"name": "` + strings.Repeat("x", maxRDAPResponseSize) + `"}`}, "unexpected EOF"},
+		{"network not an object", rdapTestRegistry{ipv4: rdapTestIPv4Bootstrap, network: `["NET-1"]`}, "cannot unmarshal"},
+		{"network of the wrong types", rdapTestRegistry{ipv4: rdapTestIPv4Bootstrap, network: `{"entities": {"handle": "ORG-1"}}`}, "cannot unmarshal"},
+		{"registry rate limit", rdapTestRegistry{ipv4: rdapTestIPv4Bootstrap, status: http.StatusTooManyRequests}, errRDAPRateLimited.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestRDAPClient(&tt.reg)
+			info, err := c.lookup(context.Background(), net.ParseIP("81.2.69.142"))
+			if err == nil || !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("lookup = %+v, %v, want an error containing %q", info, err, tt.message)
+			}
+		})
+	}
+}
+
+func TestRDAPRateLimitedRegistryIsLeftAlone(t *testing.T) {
+	reg := &rdapTestRegistry{ipv4: rdapTestIPv4Bootstrap, status: http.StatusTooManyRequests}
+	c := newTestRDAPClient(reg)
+	for range 2 {
+		if _, err := c.lookup(context.Background(), net.ParseIP("81.2.69.142")); !errors.Is(err, errRDAPRateLimited) {
+			t.Fatalf("lookup error = %v, want errRDAPRateLimited", err)
+		}
+	}
+	if reg.queries != 1 {
+		t.Errorf("the registry was queried %d times, want once until its Retry-After passes", reg.queries)
+	}
+}
+
+func TestRDAPNetworkInfoOfMalformedEntities(t *testing.T) {
+	tests := []struct {
+		name     string
+		entities string
+		org      string
+		email    string
+	}{
+		{"no entities", `[]`, "", ""},
+		{"no vcard", `[{"roles": ["registrant"]}]`, "", ""},
+		{"vcard of one element", `[{"roles": ["registrant"], "vcardArray": ["vcard"]}]`, "", ""},
+		{"vcard properties not a list", `[{"roles": ["registrant"], "vcardArray": ["vcard", {"fn": "Example Ltd"}]}]`, "", ""},
+		{"property cut short", `[{"roles": ["registrant"], "vcardArray": ["vcard", [["fn", {}, "text"]]]}]`, "", ""},
+		{"property not a list", `[{"roles": ["registrant"], "vcardArray": ["vcard", ["fn", ["fn", {}, "text", "Example Ltd"]]]}]`, "Example Ltd", ""},
+		{"structured value", `[{"roles": ["registrant"], "vcardArray": ["vcard", [["fn", {}, "text", ["Example", "Ltd"]], ["fn", {}, "text", "Example Ltd"]]]}]`, "Example Ltd", ""},
+		{"property name not a string", `[{"roles": ["registrant"], "vcardArray": ["vcard", [[1, {}, "text", "x"], ["fn", {}, "text", "Example Ltd"]]]}]`, "Example Ltd", ""},
+		{"abuse contact nested deeper", `[{"roles": ["technical"], "entities": [{"roles": ["administrative"], "entities": [
+			{"roles": ["abuse"], "vcardArray": ["vcard", [["email", {}, "text", "abuse@example.net"]]]}]}]}]`, "", "abuse@example.net"},
+		{"several roles", `[{"roles": ["administrative", "abuse", "registrant"], "vcardArray": ["vcard", [["fn", {}, "text", "Example Ltd"], ["email", {}, "text", "noc@example.net"]]]}]`, "Example Ltd", "noc@example.net"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var network rdapNetwork
+			if err := json.Unmarshal([]byte(`{"entities": `+tt.entities+`}`), &network); err != nil {
+				t.Fatal(err)
+			}
+			info := network.info()
+			if info.Org != tt.org || info.AbuseEmail != tt.email {
+				t.Errorf("org %q and abuse email %q, want %q and %q", info.Org, info.AbuseEmail, tt.org, tt.email)
+			}
+		})
+	}
+}
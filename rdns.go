@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rdnsCacheSize bounds the number of cached PTR answers.
+const rdnsCacheSize = 10000
+
+// rdnsResolver performs PTR lookups with a strict timeout, caching the
+// answers so repeated lookups of an address do not reach the resolver.
+type rdnsResolver struct {
+	resolver *net.Resolver
+	timeout  time.Duration
+	// alwaysOn makes lookups without an rdns parameter include the hostname.
+	alwaysOn bool
+	// cache holds the first PTR name of each address, or "" when it has none.
+	cache *ttlCache[string]
+}
+
+// rdns is the process-wide reverse DNS resolver; nil when RDNS_ENABLED is off.
+var rdns *rdnsResolver
+
+func newRDNSResolver(timeout, cacheTTL time.Duration, alwaysOn bool) *rdnsResolver {
+	return &rdnsResolver{
+		resolver: net.DefaultResolver,
+		timeout:  timeout,
+		alwaysOn: alwaysOn,
+		cache:    newTTLCache[string](rdnsCacheSize, cacheTTL),
+	}
+}
+
+// hostname returns the first PTR name of ip without the trailing dot, or ""
+// when it has none or the lookup does not finish within the timeout.
+func (d *rdnsResolver) hostname(ctx context.Context, ip net.IP) string {
+	key := ip.String()
+	if name, ok := d.cache.get(key); ok {
+		return name
+	}
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	names, err := d.resolver.LookupAddr(ctx, key)
+	var dnsErr *net.DNSError
+	if err != nil && !(errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+		// Timeouts and resolver failures are not cached so the next lookup retries.
+		return ""
+	}
+	name := ""
+	if len(names) > 0 {
+		name = strings.TrimSuffix(names[0], ".")
+	}
+	d.cache.set(key, name)
+	return name
+}
+
+// wanted reports whether the request asks for the hostname: ?rdns=1 does,
+// ?rdns=0 does not, and without the parameter RDNS_DEFAULT decides.
+func (d *rdnsResolver) wanted(r *http.Request) (bool, error) {
+	value := strings.TrimSpace(r.URL.Query().Get("rdns"))
+	if value == "" {
+		return d.alwaysOn, nil
+	}
+	wanted, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("Invalid rdns parameter '%s': must be a boolean such as 1 or 0", value)
+	}
+	return wanted, nil
+}
+
+// wantsHostname reports whether the lookup response for r should carry a
+// hostname field. Invalid rdns values are rejected before this is consulted.
+func wantsHostname(r *http.Request) bool {
+	if rdns == nil {
+		return false
+	}
+	wanted, err := rdns.wanted(r)
+	return err == nil && wanted
+}
+
+// addHostname sets the hostname field of response, null when ip has no PTR
+// record or it could not be resolved in time, if the request asks for it.
+func addHostname(r *http.Request, ip net.IP, response map[string]any) {
+	if !wantsHostname(r) {
+		return
+	}
+	if name := rdns.hostname(r.Context(), ip); name != "" {
+		response["hostname"] = name
+	} else {
+		response["hostname"] = nil
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// serviceInstance describes how this process is advertised to service discovery.
+type serviceInstance struct {
+	ID      string
+	Name    string
+	Address string
+	Port    int
+	Tags    []string
+}
+
+// healthURL is the HTTP health check URL registries should probe.
+func (s serviceInstance) healthURL() string {
+	return fmt.Sprintf("http://%s:%d/healthz", s.Address, s.Port)
+}
+
+// serviceRegistry registers the instance with a discovery backend on startup
+// and removes it on graceful shutdown.
+type serviceRegistry interface {
+	register(ctx context.Context) error
+	deregister(ctx context.Context) error
+}
+
+// newServiceRegistry returns the registry for kind ("consul" or "etcd").
+func newServiceRegistry(kind, addr, token string, instance serviceInstance) (serviceRegistry, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	switch kind {
+	case "consul":
+		if addr == "" {
+			addr = "http://127.0.0.1:8500"
+		}
+		return &consulRegistry{addr: strings.TrimSuffix(addr, "/"), token: token, instance: instance, client: client}, nil
+	case "etcd":
+		if addr == "" {
+			addr = "http://127.0.0.1:2379"
+		}
+		return &etcdRegistry{addr: strings.TrimSuffix(addr, "/"), instance: instance, client: client}, nil
+	}
+	return nil, fmt.Errorf("unsupported service registry %q", kind)
+}
+
+// registryCall sends a JSON request to a registry HTTP API and decodes the
+// response into out when it is non-nil.
+func registryCall(ctx context.Context, client *http.Client, method, url string, headers map[string]string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		encoded, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s: %s", method, url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// consulRegistry registers with the local Consul agent, including an HTTP
+// health check against /healthz.
+type consulRegistry struct {
+	addr     string
+	token    string
+	instance serviceInstance
+	client   *http.Client
+}
+
+func (c *consulRegistry) headers() map[string]string {
+	if c.token == "" {
+		return nil
+	}
+	return map[string]string{"X-Consul-Token": c.token}
+}
+
+func (c *consulRegistry) register(ctx context.Context) error {
+	payload := map[string]any{
+		"ID":      c.instance.ID,
+		"Name":    c.instance.Name,
+		"Address": c.instance.Address,
+		"Port":    c.instance.Port,
+		"Tags":    c.instance.Tags,
+		"Check": map[string]any{
+			"HTTP":     c.instance.healthURL(),
+			"Interval": "10s",
+			"Timeout":  "2s",
+			// Clean up after instances that die without deregistering.
+			"DeregisterCriticalServiceAfter": "10m",
+		},
+	}
+	return registryCall(ctx, c.client, http.MethodPut, c.addr+"/v1/agent/service/register", c.headers(), payload, nil)
+}
+
+func (c *consulRegistry) deregister(ctx context.Context) error {
+	return registryCall(ctx, c.client, http.MethodPut, c.addr+"/v1/agent/service/deregister/"+c.instance.ID, c.headers(), nil, nil)
+}
+
+// etcdLeaseTTL is the lifetime of the etcd registration lease; it is kept
+// alive while the process runs, so a crashed instance disappears after this.
+const etcdLeaseTTL = 30 * time.Second
+
+// etcdRegistry writes the instance under /services/<name>/<id> using the etcd
+// v3 JSON gateway, attached to a lease that is refreshed in the background.
+type etcdRegistry struct {
+	addr     string
+	instance serviceInstance
+	client   *http.Client
+
+	leaseID string
+	stop    context.CancelFunc
+}
+
+func (e *etcdRegistry) key() string {
+	return fmt.Sprintf("/services/%s/%s", e.instance.Name, e.instance.ID)
+}
+
+func (e *etcdRegistry) register(ctx context.Context) error {
+	var lease struct {
+		ID string `json:"ID"`
+	}
+	if err := registryCall(ctx, e.client, http.MethodPost, e.addr+"/v3/lease/grant", nil,
+		map[string]any{"TTL": int(etcdLeaseTTL.Seconds())}, &lease); err != nil {
+		return fmt.Errorf("granting etcd lease: %w", err)
+	}
+	e.leaseID = lease.ID
+
+	value, err := json.Marshal(map[string]any{
+		"id":           e.instance.ID,
+		"name":         e.instance.Name,
+		"address":      e.instance.Address,
+		"port":         e.instance.Port,
+		"tags":         e.instance.Tags,
+		"health_check": e.instance.healthURL(),
+	})
+	if err != nil {
+		return err
+	}
+	if err := registryCall(ctx, e.client, http.MethodPost, e.addr+"/v3/kv/put", nil, map[string]any{
+		"key":   base64.StdEncoding.EncodeToString([]byte(e.key())),
+		"value": base64.StdEncoding.EncodeToString(value),
+		"lease": e.leaseID,
+	}, nil); err != nil {
+		return fmt.Errorf("writing etcd registration: %w", err)
+	}
+
+	keepAliveCtx, stop := context.WithCancel(context.Background())
+	e.stop = stop
+	go e.keepAlive(keepAliveCtx)
+	return nil
+}
+
+func (e *etcdRegistry) keepAlive(ctx context.Context) {
+	ticker := time.NewTicker(etcdLeaseTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := registryCall(ctx, e.client, http.MethodPost, e.addr+"/v3/lease/keepalive", nil,
+				map[string]any{"ID": e.leaseID}, nil); err != nil && ctx.Err() == nil {
+				log.Printf("etcd lease keepalive failed: %v", err)
+			}
+		}
+	}
+}
+
+func (e *etcdRegistry) deregister(ctx context.Context) error {
+	if e.stop != nil {
+		e.stop()
+	}
+	if e.leaseID == "" {
+		return nil
+	}
+	// Revoking the lease deletes the key attached to it.
+	return registryCall(ctx, e.client, http.MethodPost, e.addr+"/v3/lease/revoke", nil, map[string]any{"ID": e.leaseID}, nil)
+}
+
+// listenPort extracts the numeric port from a listen address such as ":8080".
+func listenPort(listenAddr string) (int, error) {
+	_, portStr, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(portStr)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// remoteLookupCacheSize bounds the number of cached remote lookups.
+const remoteLookupCacheSize = 10000
+
+// maxRemoteLookupResponseSize caps how much of a remote lookup response is
+// read.
+const maxRemoteLookupResponseSize = 1 << 20
+
+// remoteLookupSource answers lookups from an HTTP API that returns the lookup
+// fields of an IP as a JSON object and 404 Not Found for an IP it has no
+// record for, such as another instance's /lookup/{ip}.
+type remoteLookupSource struct {
+	httpClient *http.Client
+	// url has {ip} where the IP goes.
+	url string
+	// cache holds the lookup fields of each queried IP; nil for an IP the
+	// API has no record for.
+	cache *ttlCache[map[string]any]
+}
+
+func newRemoteLookupSource(url string, cacheTTL time.Duration) *remoteLookupSource {
+	return &remoteLookupSource{
+		httpClient: &http.Client{},
+		url:        url,
+		cache:      newTTLCache[map[string]any](remoteLookupCacheSize, cacheTTL),
+	}
+}
+
+func (s *remoteLookupSource) lookup(ctx context.Context, ip net.IP) (map[string]any, error) {
+	key := ip.String()
+	if fields, ok := s.cache.get(key); ok {
+		// Callers add fields to the response; keep the cached copy intact.
+		return maps.Clone(fields), nil
+	}
+	fields, err := s.query(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	s.cache.set(key, fields)
+	return maps.Clone(fields), nil
+}
+
+// query requests ip from the API. It returns nil for an IP the API has no
+// record for.
+func (s *remoteLookupSource) query(ctx context.Context, ip string) (map[string]any, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.ReplaceAll(s.url, "{ip}", ip), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var fields map[string]any
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxRemoteLookupResponseSize)).Decode(&fields); err != nil {
+		return nil, fmt.Errorf("decoding the response: %v", err)
+	}
+	if fields == nil {
+		return nil, nil
+	}
+	fields["ip"] = ip
+	return fields, nil
+}
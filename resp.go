@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This file implements a subset of the Redis protocol (RESP2) so any Redis
+// client can query lookups with GET geo:<ip>, including pipelined requests.
+
+// Limits on inbound RESP commands.
+const (
+	respMaxArgs      = 1024
+	respMaxBulkSize  = 64 << 10
+	respMaxInlineLen = 64 << 10
+)
+
+var errRESPProtocol = errors.New("protocol error")
+
+// respEncoders maps RESP_ENCODING values to the encoder for GET values.
+var respEncoders = map[string]func(any) ([]byte, error){
+	"json":    json.Marshal,
+	"msgpack": msgpackMarshal,
+}
+
+// respHandler serves one Redis protocol connection.
+type respHandler struct {
+	encode func(any) ([]byte, error)
+}
+
+func (h *respHandler) serveConn(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(tcpIdleTimeout))
+		args, err := readRESPCommand(r)
+		if err != nil {
+			if errors.Is(err, errRESPProtocol) || errors.Is(err, errLineTooLong) {
+				w.WriteString("-ERR Protocol error\r\n")
+				w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if quit := h.dispatch(w, args); quit {
+			w.Flush()
+			return
+		}
+		// Flush once the pipeline drains rather than after every reply.
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// dispatch executes one command, reporting whether the connection should close.
+func (h *respHandler) dispatch(w *bufio.Writer, args []string) bool {
+	switch cmd := strings.ToUpper(args[0]); cmd {
+	case "PING":
+		if len(args) > 1 {
+			writeRESPBulk(w, []byte(args[1]))
+		} else {
+			w.WriteString("+PONG\r\n")
+		}
+	case "ECHO":
+		if len(args) != 2 {
+			writeRESPArity(w, cmd)
+			break
+		}
+		writeRESPBulk(w, []byte(args[1]))
+	case "GET":
+		if len(args) != 2 {
+			writeRESPArity(w, cmd)
+			break
+		}
+		h.writeGet(w, args[1])
+	case "MGET":
+		if len(args) < 2 {
+			writeRESPArity(w, cmd)
+			break
+		}
+		fmt.Fprintf(w, "*%d\r\n", len(args)-1)
+		for _, key := range args[1:] {
+			// A failed lookup is an error element; the others are answered.
+			h.writeGet(w, key)
+		}
+	case "EXISTS":
+		if len(args) < 2 {
+			writeRESPArity(w, cmd)
+			break
+		}
+		n := 0
+		for _, key := range args[1:] {
+			value, err := h.get(key)
+			if err != nil {
+				writeRESPLookupError(w)
+				return false
+			}
+			if value != nil {
+				n++
+			}
+		}
+		fmt.Fprintf(w, ":%d\r\n", n)
+	case "QUIT":
+		w.WriteString("+OK\r\n")
+		return true
+	case "SELECT", "CLIENT", "READONLY":
+		// Accepted for client library compatibility; they have no effect here.
+		w.WriteString("+OK\r\n")
+	case "COMMAND":
+		w.WriteString("*0\r\n")
+	default:
+		fmt.Fprintf(w, "-ERR unknown command '%s'\r\n", sanitizeRESP(args[0]))
+	}
+	return false
+}
+
+// get returns the encoded lookup for a geo:<ip> key, or nil for a miss.
+func (h *respHandler) get(key string) ([]byte, error) {
+	return lookupGeoKey("RESP", key, h.encode)
+}
+
+// writeGet replies with the lookup of key: the value, a nil bulk string for
+// a miss or an error for a failed lookup.
+func (h *respHandler) writeGet(w *bufio.Writer, key string) {
+	value, err := h.get(key)
+	if err != nil {
+		writeRESPLookupError(w)
+		return
+	}
+	writeRESPBulk(w, value)
+}
+
+// writeRESPLookupError replies to a lookup that failed for a reason other
+// than a miss, such as a database read error.
+func writeRESPLookupError(w *bufio.Writer) {
+	w.WriteString("-ERR DB_ERROR GeoIP lookup failed\r\n")
+}
+
+func writeRESPBulk(w *bufio.Writer, b []byte) {
+	if b == nil {
+		w.WriteString("$-1\r\n")
+		return
+	}
+	fmt.Fprintf(w, "$%d\r\n", len(b))
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+func writeRESPArity(w *bufio.Writer, cmd string) {
+	fmt.Fprintf(w, "-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(cmd))
+}
+
+// sanitizeRESP strips characters that would break a simple-string reply.
+func sanitizeRESP(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+// readRESPCommand reads one command, either as a RESP array of bulk strings
+// or as an inline (space separated) command.
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLimitedLine(r, respMaxInlineLen)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > respMaxArgs {
+		return nil, errRESPProtocol
+	}
+	args := make([]string, 0, max(n, 0))
+	for i := 0; i < n; i++ {
+		header, err := readLimitedLine(r, respMaxInlineLen)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(header, "$") {
+			return nil, errRESPProtocol
+		}
+		size, err := strconv.Atoi(header[1:])
+		if err != nil || size < 0 || size > respMaxBulkSize {
+			return nil, errRESPProtocol
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		if buf[size] != '\r' || buf[size+1] != '\n' {
+			return nil, errRESPProtocol
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// converse serves one loopback connection with serve, sends input on it and
+// returns everything serve writes back before it returns.
+func converse(t *testing.T, serve func(net.Conn), input string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		serve(conn)
+		conn.Close()
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, input); err != nil {
+		t.Fatal(err)
+	}
+	conn.(*net.TCPConn).CloseWrite()
+	output, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(output)
+}
+
+// respCommand encodes args as a RESP array of bulk strings, as clients send
+// commands.
+func respCommand(args ...string) string {
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		b.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n")
+	}
+	return b.String()
+}
+
+func TestReadRESPCommand(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+		err   error
+	}{
+		{"array", respCommand("GET", "geo:8.8.8.8"), []string{"GET", "geo:8.8.8.8"}, nil},
+		{"binary-safe bulk string", respCommand("ECHO", "a b\r\nc"), []string{"ECHO", "a b\r\nc"}, nil},
+		{"empty bulk string", respCommand("ECHO", ""), []string{"ECHO", ""}, nil},
+		{"empty array", "*0\r\n", []string{}, nil},
+		{"null array", "*-1\r\n", []string{}, nil},
+		{"inline", "get  geo:8.8.8.8\r\n", []string{"get", "geo:8.8.8.8"}, nil},
+		{"inline with LF", "PING\n", []string{"PING"}, nil},
+		{"blank inline", "\r\n", []string{}, nil},
+		{"bad array length", "*x\r\n", nil, errRESPProtocol},
+		{"too many arguments", "*1025\r\n", nil, errRESPProtocol},
+		{"not a bulk string", "*1\r\n+GET\r\n", nil, errRESPProtocol},
+		{"bad bulk length", "*1\r\n$x\r\n", nil, errRESPProtocol},
+		{"negative bulk length", "*1\r\n$-1\r\n", nil, errRESPProtocol},
+		{"bulk string too large", "*1\r\n$65537\r\n", nil, errRESPProtocol},
+		{"bulk string without CRLF", "*1\r\n$3\r\nGETxx", nil, errRESPProtocol},
+		{"bulk string longer than its length", "*1\r\n$2\r\nGET\r\n", nil, errRESPProtocol},
+		{"truncated bulk string", "*1\r\n$3\r\nGE", nil, io.ErrUnexpectedEOF},
+		{"missing argument", "*2\r\n$3\r\nGET\r\n", nil, io.EOF},
+		{"line too long", strings.Repeat("x", respMaxInlineLen+1) + "\r\n", nil, errLineTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readRESPCommand(bufio.NewReader(strings.NewReader(tt.input)))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("args = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRESPConversation(t *testing.T) {
+	useFakeProvider(t, map[string]any{"country_code": "GB"})
+	h := &respHandler{encode: respEncoders["json"]}
+
+	record, err := lookupIPString(context.Background(), "81.2.69.142")
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, _ := json.Marshal(record)
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"ping", respCommand("PING"), "+PONG\r\n"},
+		{"echo", respCommand("ECHO", "hi"), "$2\r\nhi\r\n"},
+		{"get", respCommand("GET", "geo:81.2.69.142"), "$" + strconv.Itoa(len(value)) + "\r\n" + string(value) + "\r\n"},
+		{"get of another key", respCommand("GET", "other"), "$-1\r\n"},
+		{"get of an invalid IP", respCommand("GET", "geo:x"), "$-1\r\n"},
+		{"mget", respCommand("MGET", "geo:x", "other"), "*2\r\n$-1\r\n$-1\r\n"},
+		{"exists", respCommand("EXISTS", "geo:81.2.69.142", "geo:x"), ":1\r\n"},
+		{"arity", respCommand("GET"), "-ERR wrong number of arguments for 'get' command\r\n"},
+		{"unknown command", respCommand("FLUSHALL\r\n+OK"), "-ERR unknown command 'FLUSHALL  +OK'\r\n"},
+		{"pipelined", respCommand("PING") + "PING\r\n" + respCommand("QUIT") + respCommand("PING"), "+PONG\r\n+PONG\r\n+OK\r\n"},
+		{"protocol error", "*1\r\n$x\r\n" + respCommand("PING"), "-ERR Protocol error\r\n"},
+		{"truncated command", "*2\r\n$3\r\nGET\r\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := converse(t, h.serveConn, tt.input); got != tt.want {
+				t.Errorf("replies = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRESPMsgpackValues(t *testing.T) {
+	useFakeProvider(t, map[string]any{"country_code": "GB", "accuracy_radius": uint(10)})
+	value, err := (&respHandler{encode: respEncoders["msgpack"]}).get("geo:81.2.69.142")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, rest, err := msgpackDecode(value)
+	if err != nil || len(rest) != 0 {
+		t.Fatalf("decoding %x: %d bytes left, %v", value, len(rest), err)
+	}
+	m, _ := got.(map[string]any)
+	if m["ip"] != "81.2.69.142" || m["country_code"] != "GB" || m["accuracy_radius"] != int64(10) {
+		t.Errorf("GET value decodes to %v", got)
+	}
+}
+
+// failingSource is a lookup source whose reads fail, as a corrupt database's do.
+type failingSource struct{}
+
+func (failingSource) lookup(ctx context.Context, ip net.IP) (map[string]any, error) {
+	return nil, errors.New("read failed")
+}
+
+func TestRESPLookupFailure(t *testing.T) {
+	useFakeProvider(t, nil)
+	useLookupChain(t, lookupChain{{name: "database", source: failingSource{}}})
+	h := &respHandler{encode: respEncoders["json"]}
+
+	const failed = "-ERR DB_ERROR GeoIP lookup failed\r\n"
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"get", respCommand("GET", "geo:81.2.69.142"), failed},
+		{"get of another key", respCommand("GET", "other"), "$-1\r\n"},
+		{"mget", respCommand("MGET", "other", "geo:81.2.69.142"), "*2\r\n$-1\r\n" + failed},
+		{"exists", respCommand("EXISTS", "geo:81.2.69.142"), failed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := converse(t, h.serveConn, tt.input); got != tt.want {
+				t.Errorf("replies = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupGeoKeyMissBehavior(t *testing.T) {
+	useFakeProvider(t, nil)
+	tests := []struct {
+		behavior string
+		// want is the value, nil for a miss.
+		want map[string]any
+	}{
+		{missBehavior404, nil},
+		{missBehaviorNull, missResponse(net.ParseIP("81.2.69.142"), false)},
+		{missBehaviorBogon, missResponse(net.ParseIP("81.2.69.142"), true)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.behavior, func(t *testing.T) {
+			useConfig(t, Config{MissBehavior: tt.behavior})
+			value, err := lookupGeoKey("RESP", "geo:81.2.69.142", json.Marshal)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == nil {
+				if value != nil {
+					t.Errorf("value = %s, want a miss", value)
+				}
+				return
+			}
+			if want, _ := json.Marshal(tt.want); string(value) != string(want) {
+				t.Errorf("value = %s, want %s", value, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// routeMethods are the methods probed when deciding between 404 and 405.
+var routeMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+	http.MethodPatch, http.MethodDelete, http.MethodOptions,
+}
+
+// newRouter registers every HTTP endpoint enabled by cfg using method-aware
+// patterns and wraps the mux so unmatched requests get AppError JSON bodies.
+// The endpoints are served under each of apiVersions as well. The services
+// they depend on are set up by configureServices first.
+func newRouter(cfg Config) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", rootHandler)
+	mux.HandleFunc("GET /lookup", lookupHandler) // Client IP
+	mux.HandleFunc("GET /lookup/{$}", lookupHandler)
+	mux.HandleFunc("GET /lookup/{ip}", lookupHandler)
+	mux.HandleFunc("GET /lookup/network/{cidr...}", networkLookupHandler)
+	mux.HandleFunc("GET /myip", myIPHandler)
+	mux.HandleFunc("GET /time", localTimeHandler) // Client IP
+	mux.HandleFunc("GET /time/{ip}", localTimeHandler)
+	mux.HandleFunc("GET /distance/{ip1}/{ip2}", distanceHandler)
+	mux.HandleFunc("GET /geofence", geofenceHandler)
+	mux.HandleFunc("GET /check", checkHandler) // Client IP
+	mux.HandleFunc("GET /check/{ip}", checkHandler)
+	mux.HandleFunc("POST /geofence", geofencePolygonHandler)
+	if cfg.ASNDBPath != "" || cfg.ISPDBPath != "" {
+		mux.HandleFunc("GET /asn/{ip}", asnHandler)
+	}
+	for name, field := range plainTextRoutes {
+		mux.HandleFunc("GET /"+name, plainTextHandler(field)) // Client IP
+		mux.HandleFunc("GET /"+name+"/{ip}", plainTextHandler(field))
+	}
+	if cfg.BatchLookupMaxSize > 0 {
+		mux.HandleFunc("POST /lookup", batchLookupHandler)
+	}
+	if cfg.StreamLookupEnabled {
+		mux.HandleFunc("POST /lookup/stream", streamLookupHandler)
+	}
+	if cfg.CSVLookupEnabled {
+		mux.HandleFunc("POST /lookup/csv", csvLookupHandler)
+	}
+	if jobs != nil {
+		mux.HandleFunc("POST /jobs", submitJobHandler)
+		mux.HandleFunc("GET /jobs/{id}", jobStatusHandler)
+		mux.HandleFunc("GET /jobs/{id}/result", jobResultHandler)
+	}
+	mux.HandleFunc("GET /healthz", healthzHandler)
+	mux.HandleFunc("GET /stats", statsHandler)
+	mux.HandleFunc("GET /metrics", metricsHandler)
+	mux.HandleFunc("GET /metadata", metadataHandler)
+	mux.HandleFunc("GET /version", versionHandler)
+	mux.HandleFunc("GET /openapi.json", openAPIHandler)
+	mux.HandleFunc("GET /docs", docsHandler)
+	if slos != nil {
+		mux.HandleFunc("GET /slo", sloHandler)
+	}
+	if cfg.TwirpEnabled {
+		// Twirp reports bad methods and routes with its own error format.
+		mux.Handle(twirpPathPrefix, newTwirpHandler())
+	}
+	if cfg.GraphQLEnabled {
+		mux.HandleFunc("GET /graphql", graphQLHandler)
+		mux.HandleFunc("POST /graphql", graphQLHandler)
+	}
+	if cfg.WebSocketEnabled {
+		mux.HandleFunc("GET /ws", webSocketHandler)
+	}
+	if rdap != nil {
+		mux.HandleFunc("GET /whois/{ip}", whoisHandler)
+	}
+	if cfg.GrafanaEnabled {
+		mux.HandleFunc("GET "+grafanaPathPrefix+"{$}", grafanaTestHandler)
+		mux.HandleFunc(grafanaPathPrefix+"search", grafanaSearchHandler)
+		mux.HandleFunc("POST "+grafanaPathPrefix+"query", grafanaQueryHandler)
+		mux.HandleFunc("POST "+grafanaPathPrefix+"annotations", grafanaAnnotationsHandler)
+	}
+	if lookupCache != nil {
+		mux.HandleFunc("GET "+groupcacheBasePath, lookupCache.servePeers)
+	}
+	if cfg.UIEnabled {
+		mux.Handle("GET "+uiPathPrefix, uiHandler())
+	}
+	if geoHeaders != nil {
+		mux.HandleFunc("GET /authz/headers", geoHeadersHandler)
+	}
+	if len(policies) > 0 {
+		mux.HandleFunc("GET /authz", authzHandler)
+	}
+	if adminToken != "" {
+		mux.HandleFunc("POST /admin/db", adminDBHandler)
+	}
+	if cfg.MCPTransport == "sse" {
+		mcpServer := newMCPSSEServer()
+		mux.HandleFunc("GET /mcp/sse", mcpServer.streamHandler)
+		mux.HandleFunc("POST /mcp/messages", mcpServer.messageHandler)
+	}
+	return versionedAPI(canonicalPaths(mux, jsonFallback(mux)))
+}
+
+// canonicalIPRoutes are path prefixes followed by a single IP address
+// segment, whose canonical form is the IP's standard textual representation.
+var canonicalIPRoutes = []string{"/lookup/", "/whois/", "/check/", "/country/", "/city/", "/tz/", "/asn/", "/time/"}
+
+// canonicalPath returns the canonical form of r's path: duplicate slashes,
+// "." and ".." segments removed, a trailing slash dropped when the path
+// without it has its own route, and IP addresses in canonicalIPRoutes written as
+// net.IP.String() formats them (lowercase, compressed IPv6).
+func canonicalPath(mux *http.ServeMux, r *http.Request) string {
+	p := path.Clean("/" + r.URL.Path)
+	if p != "/" && strings.HasSuffix(r.URL.Path, "/") {
+		// Drop the slash only when the trimmed path has a route of its own;
+		// the mux reports the slash pattern for paths it would redirect back.
+		probe := r.Clone(r.Context())
+		probe.URL.Path, probe.URL.RawPath = p+"/", ""
+		_, withSlash := mux.Handler(probe)
+		probe.URL.Path = p
+		if _, trimmed := mux.Handler(probe); trimmed == "" || trimmed == withSlash {
+			p += "/"
+		}
+	}
+	for _, prefix := range canonicalIPRoutes {
+		if rest, ok := strings.CutPrefix(p, prefix); ok && !strings.Contains(rest, "/") {
+			if ip := net.ParseIP(rest); ip != nil {
+				p = prefix + ip.String()
+			}
+			break
+		}
+	}
+	return p
+}
+
+// canonicalPaths sends GET and HEAD requests for non-canonical paths a 308
+// redirect to the canonical URL, so caches and logs see one URL per resource.
+// Redirects keep the API version prefix of the request.
+// Other methods are served from the canonical path directly, since not every
+// client replays a request body on redirect.
+func canonicalPaths(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := canonicalPath(mux, r)
+		if p == r.URL.Path {
+			next.ServeHTTP(w, r)
+			return
+		}
+		u := *r.URL
+		u.Path, u.RawPath = p, ""
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			redirect := u
+			redirect.Path = apiPathPrefix(r) + p
+			http.Redirect(w, r, redirect.RequestURI(), http.StatusPermanentRedirect)
+			return
+		}
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = &u
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// jsonFallback answers requests no pattern matches with a JSON 404, or a JSON
+// 405 plus Allow header when the path exists for other methods, instead of
+// the mux's plain-text bodies.
+func jsonFallback(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		var allowed []string
+		for _, method := range routeMethods {
+			if method == r.Method {
+				continue
+			}
+			probe := r.Clone(r.Context())
+			probe.Method = method
+			if _, pattern := mux.Handler(probe); pattern != "" {
+				allowed = append(allowed, method)
+			}
+		}
+		if len(allowed) > 0 {
+			sort.Strings(allowed)
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			writeAppError(w, AppError{
+				Message:   "Method not allowed",
+				Code:      http.StatusMethodNotAllowed,
+				ErrorCode: errCodeMethodNotAllowed,
+				Details:   map[string][]string{"allowed_methods": allowed},
+			})
+			return
+		}
+		writeJSONError(w, "Not found", http.StatusNotFound, errCodeRouteNotFound)
+	})
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// cloudMetadataTimeout bounds each request to a cloud's instance or container
+// credential endpoint, which does not exist outside that cloud.
+const cloudMetadataTimeout = 2 * time.Second
+
+// s3Source downloads the database from an S3 object (GEOIP_DB_URL
+// s3://bucket/key) with the AWS SDK, which finds credentials and the region
+// the way the other AWS SDKs and the CLI do. Without credentials the object
+// is requested anonymously, for public buckets.
+type s3Source struct {
+	bucket string
+	key    string
+
+	mu sync.Mutex
+	// client is set up by the first fetch and kept, so credentials are
+	// discovered, and the instance metadata service probed, once.
+	client *s3.Client
+}
+
+func newS3Source(bucket, key string) *s3Source {
+	return &s3Source{bucket: bucket, key: key}
+}
+
+// s3Client returns the client of the source, setting it up on first use.
+func (s *s3Source) s3Client(ctx context.Context, httpClient *http.Client) (*s3.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		return s.client, nil
+	}
+	cfg, err := config.LoadDefaultConfig(ctx,
+		// The SDK's own client, unlike an *http.Client, takes AWS_CA_BUNDLE.
+		config.WithHTTPClient(awshttp.NewBuildableClient().WithTimeout(httpClient.Timeout)),
+		config.WithDefaultRegion("us-east-1"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("AWS configuration: %v", err)
+	}
+	credsCtx, cancel := context.WithTimeout(ctx, 4*cloudMetadataTimeout)
+	_, err = cfg.Credentials.Retrieve(credsCtx)
+	cancel()
+	if err != nil {
+		log.Printf("S3: no AWS credentials found (%v), requesting s3://%s anonymously", err, s.bucket)
+		cfg.Credentials = aws.AnonymousCredentials{}
+	}
+	endpoint := firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL")
+	s.client = s3.NewFromConfig(cfg, func(o *s3.Options) {
+		// S3-compatible services (e.g. MinIO) are addressed with path-style
+		// URLs, and so are buckets with dots on AWS, since they do not match
+		// the wildcard certificate.
+		o.UsePathStyle = endpoint != "" || strings.Contains(s.bucket, ".")
+	})
+	return s.client, nil
+}
+
+func (s *s3Source) fetch(ctx context.Context, client *http.Client, modTime time.Time, dst io.Writer) (time.Time, error) {
+	s3Client, err := s.s3Client(ctx, client)
+	if err != nil {
+		return time.Time{}, err
+	}
+	input := &s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(s.key)}
+	if !modTime.IsZero() {
+		input.IfModifiedSince = aws.Time(modTime)
+	}
+	out, err := s3Client.GetObject(ctx, input)
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotModified {
+		return time.Time{}, errNotModified
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer out.Body.Close()
+	if _, err := io.Copy(dst, out.Body); err != nil {
+		return time.Time{}, err
+	}
+	return aws.ToTime(out.LastModified), nil
+}
+
+// fetchObject sends an object storage GET and copies the object to dst.
+func fetchObject(client *http.Client, req *http.Request, dst io.Writer) (time.Time, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return time.Time{}, errNotModified
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return time.Time{}, fmt.Errorf("%s returned %s: %s", req.URL.Host, resp.Status, strings.TrimSpace(string(body)))
+	}
+	if _, err := io.Copy(dst, resp.Body); err != nil {
+		return time.Time{}, err
+	}
+	lastModified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return lastModified, nil
+}
+
+// objectKeyEscape percent-encodes an object key for a URL path: everything
+// but the RFC 3986 unreserved characters and "/".
+func objectKeyEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// getJSON sends req and decodes a 200 response's JSON body into v.
+func getJSON(client *http.Client, req *http.Request, v any) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// firstEnv returns the first of the named environment variables that is set.
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := strings.TrimSpace(os.Getenv(name)); value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useS3Endpoint points the AWS SDK at a fake S3 for the rest of t, with no
+// credentials but those passed as accessKeyID.
+func useS3Endpoint(t *testing.T, handler http.HandlerFunc, accessKeyID string) {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	empty := filepath.Join(t.TempDir(), "none")
+	t.Setenv("AWS_ENDPOINT_URL_S3", srv.URL)
+	t.Setenv("AWS_REGION", "eu-west-1")
+	t.Setenv("AWS_CONFIG_FILE", empty)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", empty)
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	t.Setenv("AWS_ACCESS_KEY_ID", accessKeyID)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", "")
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "")
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", "")
+}
+
+func TestS3SourceFetch(t *testing.T) {
+	lastModified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var authorization string
+	useS3Endpoint(t, func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		if r.URL.Path != "/geo.example/db/GeoLite2 City.mmdb" {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !lastModified.After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+		w.Write([]byte("database"))
+	}, "AKIDEXAMPLE")
+
+	source := newS3Source("geo.example", "db/GeoLite2 City.mmdb")
+	var dst bytes.Buffer
+	modTime, err := source.fetch(context.Background(), http.DefaultClient, time.Time{}, &dst)
+	if err != nil || dst.String() != "database" || !modTime.Equal(lastModified) {
+		t.Fatalf("fetch = %q, %v, %v; want the object, modified %v", dst.String(), modTime, err, lastModified)
+	}
+	if !strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") || !strings.Contains(authorization, "/eu-west-1/s3/aws4_request") {
+		t.Errorf("Authorization = %q, want a SigV4 signature of AKIDEXAMPLE in eu-west-1", authorization)
+	}
+
+	if _, err := source.fetch(context.Background(), http.DefaultClient, lastModified, &dst); !errors.Is(err, errNotModified) {
+		t.Errorf("fetch of an unchanged object = %v, want errNotModified", err)
+	}
+
+	missing := newS3Source("geo.example", "missing.mmdb")
+	if _, err := missing.fetch(context.Background(), http.DefaultClient, time.Time{}, &dst); err == nil || errors.Is(err, errNotModified) {
+		t.Errorf("fetch of a missing object = %v, want an error", err)
+	}
+}
+
+func TestS3SourceFetchesAnonymouslyWithoutCredentials(t *testing.T) {
+	authorized := false
+	useS3Endpoint(t, func(w http.ResponseWriter, r *http.Request) {
+		authorized = r.Header.Get("Authorization") != ""
+		w.Write([]byte("database"))
+	}, "")
+
+	var dst bytes.Buffer
+	if _, err := newS3Source("public", "db.mmdb").fetch(context.Background(), http.DefaultClient, time.Time{}, &dst); err != nil || dst.String() != "database" {
+		t.Fatalf("fetch = %q, %v", dst.String(), err)
+	}
+	if authorized {
+		t.Error("anonymous request was signed")
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/groupcache"
+)
+
+// groupcacheBasePath is where peers fetch cache entries from each other.
+const groupcacheBasePath = "/_groupcache/"
+
+// groupcacheGroupName names the group holding encoded /lookup responses.
+const groupcacheGroupName = "lookups"
+
+// groupcachePeerRefresh is how often DNS-discovered peers are re-resolved.
+const groupcachePeerRefresh = 30 * time.Second
+
+// sharedCache is a groupcache pool that lets a fleet of instances share one
+// cache of encoded lookup responses: each IP is owned by one peer, which
+// does the database lookup once and serves the bytes to the others.
+type sharedCache struct {
+	pool  *groupcache.HTTPPool
+	group *groupcache.Group
+	self  string
+	// secret is the bearer token peers send each other, so the peer
+	// endpoint does not answer, unthrottled, anyone who finds it.
+	secret string
+}
+
+// lookupCache is the process-wide shared cache; nil when GROUPCACHE_SELF is unset.
+var lookupCache *sharedCache
+
+func newSharedCache(self, secret string, peers []string, cacheBytes int64) *sharedCache {
+	c := &sharedCache{
+		pool:   groupcache.NewHTTPPoolOpts(self, &groupcache.HTTPPoolOptions{BasePath: groupcacheBasePath}),
+		self:   self,
+		secret: secret,
+	}
+	c.pool.Transport = func(context.Context) http.RoundTripper {
+		return peerTransport{secret: secret, next: http.DefaultTransport}
+	}
+	c.setPeers(peers)
+	c.group = groupcache.NewGroup(groupcacheGroupName, cacheBytes, groupcache.GetterFunc(loadLookupEntry))
+	return c
+}
+
+// setPeers replaces the peer set, always including this instance.
+func (c *sharedCache) setPeers(peers []string) {
+	if !slices.Contains(peers, c.self) {
+		peers = append([]string{c.self}, peers...)
+	}
+	c.pool.Set(peers...)
+}
+
+// discoverPeers re-resolves host (a "name:port" DNS name) until ctx is done,
+// using every returned address as an http:// peer.
+func (c *sharedCache) discoverPeers(ctx context.Context, hostPort string) {
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		log.Printf("Groupcache: invalid peer DNS name %q: %v", hostPort, err)
+		return
+	}
+	var current []string
+	ticker := time.NewTicker(groupcachePeerRefresh)
+	defer ticker.Stop()
+	for {
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Groupcache: resolving peers from %s failed, keeping %d peers: %v", host, len(current), err)
+			}
+		} else {
+			peers := make([]string, 0, len(addrs))
+			for _, addr := range addrs {
+				peers = append(peers, "http://"+net.JoinHostPort(addr, port))
+			}
+			slices.Sort(peers)
+			if !slices.Equal(peers, current) {
+				current = peers
+				c.setPeers(peers)
+				log.Printf("Groupcache: peers updated from DNS: %s", strings.Join(peers, ", "))
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// errCacheGeneration is returned to peers asking for an entry of database
+// builds other than this instance's, which they then look up themselves.
+var errCacheGeneration = errors.New("database builds differ from the requesting peer's")
+
+// cacheKey returns the shared cache key of ip: the IP prefixed with
+// databaseGeneration, so entries of a replaced database are not served after
+// a reload, and peers on different builds do not serve each other's.
+func cacheKey(ip net.IP) string {
+	return databaseGeneration() + "/" + ip.String()
+}
+
+// databaseGeneration identifies the data the database source answers from:
+// the build epochs of the primary and fallback databases and the digest of
+// the GeoNames dump.
+func databaseGeneration() string {
+	generation := strconv.FormatInt(databaseBuildTime().Unix(), 10)
+	for _, d := range fallbackDBs {
+		if reader := d.reader.Load(); reader != nil {
+			generation += "." + strconv.FormatUint(uint64(reader.Metadata.BuildEpoch), 10)
+		}
+	}
+	if digest := geoNamesDigest.Load(); digest != nil {
+		generation += "." + *digest
+	}
+	return generation
+}
+
+// loadLookupEntry is the groupcache getter: it looks up the IP of the cacheKey
+// key in the local databases and stores the encoded record. Misses are cached
+// as an empty value so they are not looked up again by every peer.
+func loadLookupEntry(ctx context.Context, key string, dest groupcache.Sink) error {
+	generation, ipStr, _ := strings.Cut(key, "/")
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return errInvalidIP
+	}
+	if generation != databaseGeneration() {
+		return errCacheGeneration
+	}
+	response, err := databaseSource{}.lookup(ctx, ip)
+	if err != nil {
+		return err
+	}
+	if response == nil {
+		return dest.SetBytes(nil)
+	}
+	encoded, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	return dest.SetBytes(encoded)
+}
+
+// get returns the database record for ip from the shared cache, decoded, so
+// numbers are float64 and arrays []any. It reports errNoRecord for cached
+// misses; any other error means the cache could not answer and the caller
+// should look up locally.
+func (c *sharedCache) get(ctx context.Context, ip net.IP) (map[string]any, error) {
+	var encoded []byte
+	if err := c.group.Get(ctx, cacheKey(ip), groupcache.AllocatingByteSliceSink(&encoded)); err != nil {
+		return nil, err
+	}
+	if len(encoded) == 0 {
+		return nil, errNoRecord
+	}
+	var response map[string]any
+	if err := json.Unmarshal(encoded, &response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// sharedCacheSource answers lookups as databaseSource does, through the
+// shared cache. It only stands in for the database source: the overrides and
+// special ranges before it and the remote APIs after it, each with its own
+// reload or cache TTL, are consulted locally on every lookup.
+type sharedCacheSource struct{}
+
+func (sharedCacheSource) lookup(ctx context.Context, ip net.IP) (map[string]any, error) {
+	if lookupCache == nil {
+		return databaseSource{}.lookup(ctx, ip)
+	}
+	start := time.Now()
+	response, err := lookupCache.get(ctx, ip)
+	stageCache.since(start)
+	if errors.Is(err, errNoRecord) {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("Groupcache: falling back to a local lookup for IP %s: %v", ip.String(), err)
+		return databaseSource{}.lookup(ctx, ip)
+	}
+	return response, nil
+}
+
+// sharedCacheState is the cache state reported by /stats.
+type sharedCacheState struct {
+	Gets         int64 `json:"gets"`
+	CacheHits    int64 `json:"cache_hits"`
+	PeerLoads    int64 `json:"peer_loads"`
+	PeerErrors   int64 `json:"peer_errors"`
+	LocalLoads   int64 `json:"local_loads"`
+	PeerRequests int64 `json:"peer_requests"`
+	MainBytes    int64 `json:"main_cache_bytes"`
+	HotBytes     int64 `json:"hot_cache_bytes"`
+}
+
+func (c *sharedCache) state() sharedCacheState {
+	s := &c.group.Stats
+	return sharedCacheState{
+		Gets:         s.Gets.Get(),
+		CacheHits:    s.CacheHits.Get(),
+		PeerLoads:    s.PeerLoads.Get(),
+		PeerErrors:   s.PeerErrors.Get(),
+		LocalLoads:   s.LocalLoads.Get(),
+		PeerRequests: s.ServerRequests.Get(),
+		MainBytes:    c.group.CacheStats(groupcache.MainCache).Bytes,
+		HotBytes:     c.group.CacheStats(groupcache.HotCache).Bytes,
+	}
+}
+
+// peerTransport sends the shared cache secret with requests to peers.
+type peerTransport struct {
+	secret string
+	next   http.RoundTripper
+}
+
+func (t peerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+t.secret)
+	return t.next.RoundTrip(r)
+}
+
+// peerAuthorized reports whether r carries the shared cache secret.
+func (c *sharedCache) peerAuthorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(c.secret)) == 1
+}
+
+// servePeers answers cache requests from other instances.
+func (c *sharedCache) servePeers(w http.ResponseWriter, r *http.Request) {
+	if !c.peerAuthorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="ip-lookup groupcache"`)
+		writeJSONError(w, "A valid groupcache secret is required", http.StatusUnauthorized, errCodeUnauthorized)
+		return
+	}
+	c.pool.ServeHTTP(w, r)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/groupcache"
+)
+
+// testCache is the shared cache of the tests: groupcache allows one pool
+// and group of a name per process.
+var testCache = sync.OnceValue(func() *sharedCache {
+	return newSharedCache("http://127.0.0.1:1", "peer-secret", nil, 1<<20)
+})
+
+// useSharedCache serves lookups through testCache for the rest of t.
+func useSharedCache(t *testing.T) *sharedCache {
+	t.Helper()
+	oldCache, oldSources := lookupCache, lookupSources
+	lookupCache = testCache()
+	lookupSources = newLookupChain(Config{GroupcacheSelf: lookupCache.self})
+	t.Cleanup(func() { lookupCache, lookupSources = oldCache, oldSources })
+	return lookupCache
+}
+
+// rebuiltProvider is a fakeProvider whose database was built at built.
+type rebuiltProvider struct {
+	fakeProvider
+	built time.Time
+}
+
+func (p rebuiltProvider) buildTime() time.Time { return p.built }
+
+func TestSharedCacheAddsListFieldsAfterRead(t *testing.T) {
+	useFakeProvider(t, map[string]any{"country_code": "US"})
+	c := useSharedCache(t)
+	oldEnabled, oldList := torExitListEnabled, torExits.Load()
+	t.Cleanup(func() {
+		torExitListEnabled = oldEnabled
+		torExits.Store(oldList)
+	})
+	torExitListEnabled = true
+	torExits.Store(&torExitList{addrs: map[netip.Addr]struct{}{}})
+
+	loads := c.group.Stats.LocalLoads.Get()
+	if _, body := serveLookup(t, "/lookup/81.2.69.77"); body["is_tor_exit"] != false {
+		t.Fatalf("is_tor_exit = %v, want false before the IP is listed", body["is_tor_exit"])
+	}
+	torExits.Store(&torExitList{addrs: map[netip.Addr]struct{}{netip.MustParseAddr("81.2.69.77"): {}}})
+	code, body := serveLookup(t, "/lookup/81.2.69.77")
+	if code != http.StatusOK || body["is_tor_exit"] != true {
+		t.Errorf("after listing: status %d, is_tor_exit = %v, want 200 and true", code, body["is_tor_exit"])
+	}
+	if got := c.group.Stats.LocalLoads.Get() - loads; got != 1 {
+		t.Errorf("database lookups = %d, want 1 with the second answered from the cache", got)
+	}
+}
+
+func TestSharedCacheAppliesOverridesAfterRead(t *testing.T) {
+	useFakeProvider(t, map[string]any{"country_code": "US"})
+	c := useSharedCache(t)
+	old := overrides.Load()
+	t.Cleanup(func() { overrides.Store(old) })
+	overrides.Store(nil)
+
+	loads := c.group.Stats.LocalLoads.Get()
+	if _, body := serveLookup(t, "/lookup/81.2.69.79"); body["country_code"] != "US" {
+		t.Fatalf("country_code = %v, want US from the database", body["country_code"])
+	}
+	// A reload of the overrides does not change the cache key.
+	override, err := newIPOverride("81.2.69.79", map[string]string{"country_code": "GB"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	overrides.Store(&[]ipOverride{override})
+	if _, body := serveLookup(t, "/lookup/81.2.69.79"); body["country_code"] != "GB" {
+		t.Errorf("after the reload: country_code = %v, want GB from the override", body["country_code"])
+	}
+	overrides.Store(nil)
+	if _, body := serveLookup(t, "/lookup/81.2.69.79"); body["country_code"] != "US" {
+		t.Errorf("after removing the override: country_code = %v, want US from the cache", body["country_code"])
+	}
+	if got := c.group.Stats.LocalLoads.Get() - loads; got != 1 {
+		t.Errorf("database lookups = %d, want 1", got)
+	}
+}
+
+func TestSharedCacheLeavesMissesToLaterSources(t *testing.T) {
+	useFakeProvider(t, nil)
+	useSharedCache(t)
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ip": "81.2.69.80", "country_code": "FR"}`))
+	}))
+	t.Cleanup(remote.Close)
+	lookupSources = newLookupChain(Config{GroupcacheSelf: lookupCache.self, RemoteLookupURL: remote.URL + "/{ip}"})
+
+	record, err := lookupRecord(context.Background(), net.ParseIP("81.2.69.80"))
+	if err != nil || record["country_code"] != "FR" {
+		t.Fatalf("lookupRecord = %v, %v, want the remote API's record", record, err)
+	}
+	// The database miss is cached, the remote answer is not.
+	var encoded []byte
+	if err := testCache().group.Get(context.Background(), cacheKey(net.ParseIP("81.2.69.80")), groupcache.AllocatingByteSliceSink(&encoded)); err != nil || len(encoded) != 0 {
+		t.Errorf("cache entry = %q, %v, want a cached miss", encoded, err)
+	}
+}
+
+func TestSharedCacheKeyFollowsGeoNames(t *testing.T) {
+	useFakeProvider(t, nil)
+	old := geoNamesDigest.Load()
+	t.Cleanup(func() { geoNamesDigest.Store(old) })
+	ip := net.ParseIP("81.2.69.81")
+
+	before := cacheKey(ip)
+	digest := "1zz"
+	geoNamesDigest.Store(&digest)
+	if after := cacheKey(ip); after == before {
+		t.Errorf("cacheKey = %q with and without GeoNames", after)
+	}
+}
+
+func TestSharedCacheKeyFollowsDatabaseBuild(t *testing.T) {
+	old := primaryDB
+	t.Cleanup(func() { primaryDB = old })
+	ip := net.ParseIP("192.0.2.78")
+
+	primaryDB = rebuiltProvider{fakeProvider{map[string]any{"country_code": "US"}}, time.Unix(1700000000, 0)}
+	before := cacheKey(ip)
+	primaryDB = rebuiltProvider{fakeProvider{map[string]any{"country_code": "CA"}}, time.Unix(1800000000, 0)}
+	after := cacheKey(ip)
+	if before == after {
+		t.Fatalf("cacheKey = %q for both database builds", before)
+	}
+
+	var encoded []byte
+	err := loadLookupEntry(context.Background(), before, groupcache.AllocatingByteSliceSink(&encoded))
+	if !errors.Is(err, errCacheGeneration) {
+		t.Errorf("loading an entry of the replaced build: err = %v, want errCacheGeneration", err)
+	}
+	if err := loadLookupEntry(context.Background(), after, groupcache.AllocatingByteSliceSink(&encoded)); err != nil {
+		t.Fatalf("loading an entry of the current build: %v", err)
+	}
+}
+
+func TestSharedCachePeerEndpointRequiresSecret(t *testing.T) {
+	c := useSharedCache(t)
+	target := groupcacheBasePath + groupcacheGroupName + "/x"
+	tests := []struct {
+		authorization string
+		authorized    bool
+	}{
+		{"", false},
+		{"Bearer wrong", false},
+		{"Bearer peer-secret", true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if tt.authorization != "" {
+			r.Header.Set("Authorization", tt.authorization)
+		}
+		rec := httptest.NewRecorder()
+		c.servePeers(rec, r)
+		if got := rec.Code != http.StatusUnauthorized; got != tt.authorized {
+			t.Errorf("Authorization %q: status = %d, authorized = %v, want %v", tt.authorization, rec.Code, got, tt.authorized)
+		}
+		if got := rateLimitExempt(r); got != tt.authorized {
+			t.Errorf("Authorization %q: rateLimitExempt = %v, want %v", tt.authorization, got, tt.authorized)
+		}
+	}
+}
+
+func TestPeerTransportSendsSecret(t *testing.T) {
+	var got string
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+	}))
+	defer peer.Close()
+
+	client := &http.Client{Transport: peerTransport{secret: "peer-secret", next: http.DefaultTransport}}
+	resp, err := client.Get(peer.URL + groupcacheBasePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got != "Bearer peer-secret" {
+		t.Errorf("Authorization = %q, want the bearer secret", got)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// sloWindow is a trailing window over which an SLI and burn rate are computed.
+type sloWindow struct {
+	label    string
+	duration time.Duration
+}
+
+// sloWindows are the windows used by the multiwindow burn-rate alerts. They
+// must fit in the 24 hours of per-minute buckets kept by stats.
+var sloWindows = []sloWindow{
+	{"5m", 5 * time.Minute},
+	{"30m", 30 * time.Minute},
+	{"1h", time.Hour},
+	{"2h", 2 * time.Hour},
+	{"6h", 6 * time.Hour},
+	{"1d", 24 * time.Hour},
+}
+
+// sloAlertPolicy is a multiwindow burn-rate alert from the Google SRE
+// workbook: it fires when both windows burn faster than the rate that would
+// spend budgetSpent of the period's error budget within longWindow.
+type sloAlertPolicy struct {
+	severity    string
+	longWindow  string
+	shortWindow string
+	budgetSpent float64
+}
+
+var sloAlertPolicies = []sloAlertPolicy{
+	{"page", "1h", "5m", 0.02},
+	{"page", "6h", "30m", 0.05},
+	{"ticket", "1d", "2h", 0.10},
+}
+
+// sloObjective is one service level objective: the fraction of requests
+// that must be good.
+type sloObjective struct {
+	name   string
+	target float64
+	// bad counts the requests in b that violate the objective.
+	bad func(b statsBucket) uint64
+}
+
+// sloTracker evaluates the configured objectives against the per-minute
+// request stats.
+type sloTracker struct {
+	objectives       []sloObjective
+	period           time.Duration
+	latencyThreshold time.Duration
+}
+
+// slos is the process-wide SLO tracker; nil when no objective is configured.
+var slos *sloTracker
+
+func newSLOTracker(availabilityTarget, latencyTarget float64, latencyThreshold, period time.Duration) *sloTracker {
+	t := &sloTracker{period: period, latencyThreshold: latencyThreshold}
+	if availabilityTarget > 0 {
+		t.objectives = append(t.objectives, sloObjective{
+			name:   "availability",
+			target: availabilityTarget,
+			bad:    func(b statsBucket) uint64 { return b.errors },
+		})
+	}
+	if latencyTarget > 0 {
+		t.objectives = append(t.objectives, sloObjective{
+			name:   "latency",
+			target: latencyTarget,
+			bad:    func(b statsBucket) uint64 { return b.slow },
+		})
+	}
+	return t
+}
+
+// sloWindowReport is an objective's SLI over one window.
+type sloWindowReport struct {
+	Requests uint64 `json:"requests"`
+	Bad      uint64 `json:"bad"`
+	// SLI is the fraction of good requests; 1 when there were none.
+	SLI float64 `json:"sli"`
+	// BurnRate is how fast the error budget is being spent: 1 spends exactly
+	// the budget over the SLO period.
+	BurnRate float64 `json:"burn_rate"`
+}
+
+// sloAlertReport is the state of one burn-rate alert policy.
+type sloAlertReport struct {
+	Severity          string  `json:"severity"`
+	LongWindow        string  `json:"long_window"`
+	ShortWindow       string  `json:"short_window"`
+	BurnRateThreshold float64 `json:"burn_rate_threshold"`
+	Firing            bool    `json:"firing"`
+}
+
+// sloObjectiveReport is the full state of one objective.
+type sloObjectiveReport struct {
+	Name             string                     `json:"name"`
+	Target           float64                    `json:"target"`
+	LatencyThreshold string                     `json:"latency_threshold,omitempty"`
+	Windows          map[string]sloWindowReport `json:"windows"`
+	// ErrorBudgetRemaining is the share of the period's budget left,
+	// estimated from the last day (or the uptime, if shorter) as though it
+	// were representative of the period. Negative when overspent.
+	ErrorBudgetRemaining float64          `json:"error_budget_remaining"`
+	Alerts               []sloAlertReport `json:"alerts"`
+}
+
+func (t *sloTracker) report() []sloObjectiveReport {
+	now := time.Now()
+	buckets := stats.series(now.Add(-24*time.Hour), now)
+	uptime := now.Sub(stats.started)
+
+	reports := make([]sloObjectiveReport, 0, len(t.objectives))
+	for _, o := range t.objectives {
+		budget := 1 - o.target
+		r := sloObjectiveReport{Name: o.name, Target: o.target, Windows: make(map[string]sloWindowReport, len(sloWindows))}
+		if o.name == "latency" {
+			r.LatencyThreshold = t.latencyThreshold.String()
+		}
+		for _, w := range sloWindows {
+			// Buckets are per minute, newest last; the current one is partial.
+			minutes := int(w.duration / time.Minute)
+			var wr sloWindowReport
+			for _, b := range buckets[max(0, len(buckets)-minutes):] {
+				wr.Requests += b.requests
+				wr.Bad += o.bad(b)
+			}
+			wr.SLI = 1
+			if wr.Requests > 0 {
+				wr.SLI = 1 - float64(wr.Bad)/float64(wr.Requests)
+			}
+			wr.BurnRate = (1 - wr.SLI) / budget
+			r.Windows[w.label] = wr
+		}
+
+		tracked := min(uptime, 24*time.Hour)
+		r.ErrorBudgetRemaining = 1 - r.Windows["1d"].BurnRate*float64(tracked)/float64(t.period)
+
+		for _, p := range sloAlertPolicies {
+			long := sloWindowDuration(p.longWindow)
+			threshold := p.budgetSpent * float64(t.period) / float64(long)
+			r.Alerts = append(r.Alerts, sloAlertReport{
+				Severity:          p.severity,
+				LongWindow:        p.longWindow,
+				ShortWindow:       p.shortWindow,
+				BurnRateThreshold: threshold,
+				Firing:            r.Windows[p.longWindow].BurnRate > threshold && r.Windows[p.shortWindow].BurnRate > threshold,
+			})
+		}
+		reports = append(reports, r)
+	}
+	return reports
+}
+
+func sloWindowDuration(label string) time.Duration {
+	for _, w := range sloWindows {
+		if w.label == label {
+			return w.duration
+		}
+	}
+	panic("unknown SLO window " + label)
+}
+
+// sloHandler serves /slo: every objective's SLIs, burn rates, remaining
+// error budget and alert states.
+func sloHandler(w http.ResponseWriter, r *http.Request) {
+	response := map[string]any{
+		"period_days": slos.period.Hours() / 24,
+		"objectives":  slos.report(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error encoding SLO response: %v", err)
+	}
+}
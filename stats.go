@@ -0,0 +1,211 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// statsBucketCount is the number of one-minute buckets kept in memory (24h).
+const statsBucketCount = 24 * 60
+
+// statsBucket aggregates the requests served during one minute.
+type statsBucket struct {
+	minute     int64 // Unix minute this bucket covers; stale buckets are reset on reuse
+	requests   uint64
+	errors     uint64 // responses with a 5xx status
+	slow       uint64 // responses slower than slowThreshold
+	lookups    uint64
+	latencySum time.Duration
+	latencyMax time.Duration
+}
+
+// statsCollector keeps in-process request statistics: lifetime totals, a
+// per-minute time series for the last 24 hours and lookup counts per country.
+type statsCollector struct {
+	mu               sync.Mutex
+	started          time.Time
+	totalRequests    uint64
+	totalErrors      uint64
+	totalLookups     uint64
+	totalMisses      uint64 // lookups of IPs without a database record
+	totalDBErrors    uint64 // failed database reads, including retried ones
+	lookupsByCountry map[string]uint64
+	buckets          [statsBucketCount]statsBucket
+	// slowThreshold is the latency SLO threshold; requests slower than it
+	// are counted as slow. Zero disables the count.
+	slowThreshold time.Duration
+}
+
+// stats is the process-wide statistics collector.
+var stats = newStatsCollector()
+
+func newStatsCollector() *statsCollector {
+	return &statsCollector{started: time.Now(), lookupsByCountry: make(map[string]uint64)}
+}
+
+// bucket returns the bucket for t, resetting it if it holds older data.
+// The caller must hold s.mu.
+func (s *statsCollector) bucket(t time.Time) *statsBucket {
+	minute := t.Unix() / 60
+	b := &s.buckets[minute%statsBucketCount]
+	if b.minute != minute {
+		*b = statsBucket{minute: minute}
+	}
+	return b
+}
+
+// recordRequest records one served HTTP request.
+func (s *statsCollector) recordRequest(status int, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.totalRequests++
+	b := s.bucket(time.Now())
+	b.requests++
+	b.latencySum += latency
+	if latency > b.latencyMax {
+		b.latencyMax = latency
+	}
+	if status >= 500 {
+		s.totalErrors++
+		b.errors++
+	}
+	if s.slowThreshold > 0 && latency > s.slowThreshold {
+		b.slow++
+	}
+}
+
+// recordLookup records one successful GeoIP lookup for countryCode.
+func (s *statsCollector) recordLookup(countryCode string) {
+	if countryCode == "" {
+		countryCode = "unknown"
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.totalLookups++
+	s.lookupsByCountry[countryCode]++
+	s.bucket(time.Now()).lookups++
+}
+
+// recordMiss records a lookup for an IP the database has no record for.
+func (s *statsCollector) recordMiss() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.totalMisses++
+}
+
+// recordDBReadError records one failed database read attempt.
+func (s *statsCollector) recordDBReadError() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.totalDBErrors++
+}
+
+// series returns copies of the buckets covering [from, to], oldest first.
+func (s *statsCollector) series(from, to time.Time) []statsBucket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	oldest := time.Now().Unix()/60 - statsBucketCount + 1
+	fromMinute, toMinute := max(from.Unix()/60, oldest), to.Unix()/60
+	var out []statsBucket
+	for minute := fromMinute; minute <= toMinute; minute++ {
+		b := s.buckets[minute%statsBucketCount]
+		if b.minute != minute {
+			b = statsBucket{minute: minute}
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// countryCount is a (country, lookups) pair.
+type countryCount struct {
+	Country string `json:"country"`
+	Lookups uint64 `json:"lookups"`
+}
+
+// countries returns lookup counts per country, most frequent first.
+func (s *statsCollector) countries() []countryCount {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]countryCount, 0, len(s.lookupsByCountry))
+	for country, n := range s.lookupsByCountry {
+		out = append(out, countryCount{Country: country, Lookups: n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Lookups != out[j].Lookups {
+			return out[i].Lookups > out[j].Lookups
+		}
+		return out[i].Country < out[j].Country
+	})
+	return out
+}
+
+// snapshot returns the lifetime counters served by /stats.
+func (s *statsCollector) snapshot() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return map[string]any{
+		"started":        s.started.UTC().Format(time.RFC3339),
+		"uptime_seconds": int64(time.Since(s.started).Seconds()),
+		"requests":       s.totalRequests,
+		"errors":         s.totalErrors,
+		"lookups":        s.totalLookups,
+		"lookup_misses":  s.totalMisses,
+		"db_read_errors": s.totalDBErrors,
+	}
+}
+
+// recentTotals sums requests and 5xx errors over the trailing window.
+func (s *statsCollector) recentTotals(window time.Duration) (requests, errors uint64) {
+	now := time.Now()
+	for _, b := range s.series(now.Add(-window), now) {
+		requests += b.requests
+		errors += b.errors
+	}
+	return requests, errors
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// statsMiddleware records the status and latency of every request.
+func statsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		stats.recordRequest(rec.status, time.Since(start))
+	})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync/atomic"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// supplementaryDB is an optional database whose fields are merged into the
+// City lookup, such as ASN or ISP data. Lookups are composed from every
+// loaded one; none of them can fail a lookup.
+type supplementaryDB struct {
+	// name describes the database in logs, e.g. "ASN".
+	name string
+	// types are the accepted database types; want describes them in errors.
+	types map[string]bool
+	want  string
+	// addFields reads ip from reader and sets its fields in response.
+	addFields func(reader *maxminddb.Reader, ip net.IP, response map[string]any) error
+
+	// path is the configured file; empty when the database is not used.
+	path   string
+	reader atomic.Pointer[maxminddb.Reader]
+}
+
+// supplementaryDBs are merged into lookups in order, so a later database's
+// fields win where two provide the same one.
+var supplementaryDBs = []*supplementaryDB{asnDB, ispDB, connectionTypeDB, domainDB, anonymousIPDB}
+
+// configureSupplementaryDBs sets the database paths from cfg.
+func configureSupplementaryDBs(cfg Config) {
+	asnDB.path = cfg.ASNDBPath
+	ispDB.path = cfg.ISPDBPath
+	connectionTypeDB.path = cfg.ConnectionTypeDBPath
+	domainDB.path = cfg.DomainDBPath
+	anonymousIPDB.path = cfg.AnonymousIPDBPath
+	fallbackDBs = nil
+	for _, path := range cfg.FallbackDBPaths {
+		fallbackDBs = append(fallbackDBs, newFallbackDB(path))
+	}
+}
+
+// optionalDBs returns the supplementary and fallback databases, which are
+// loaded and reloaded together.
+func optionalDBs() []*supplementaryDB {
+	return append(append([]*supplementaryDB{}, supplementaryDBs...), fallbackDBs...)
+}
+
+// load opens the database at d.path, checks its type and swaps it in. The
+// database it replaces is closed after dbCloseGrace.
+func (d *supplementaryDB) load() error {
+	reader, err := openMMDB(d.path)
+	if err != nil {
+		return err
+	}
+	if dbType := reader.Metadata.DatabaseType; !isDatabaseType(d.types, dbType) {
+		reader.Close()
+		return fmt.Errorf("unsupported database type %q: %s is required", dbType, d.want)
+	}
+	if old := d.reader.Swap(reader); old != nil {
+		closeGeoDBLater(old)
+	}
+	return nil
+}
+
+// loadSupplementaryDBs opens every configured supplementary and fallback
+// database.
+func loadSupplementaryDBs() error {
+	for _, d := range optionalDBs() {
+		if d.path == "" {
+			continue
+		}
+		if err := d.load(); err != nil {
+			return fmt.Errorf("opening %s database at %s: %v", d.name, d.path, err)
+		}
+		log.Printf("%s database loaded successfully.", d.name)
+	}
+	return nil
+}
+
+// reloadSupplementaryDBs reopens the configured supplementary and fallback
+// databases, keeping the loaded version of any that fails.
+func reloadSupplementaryDBs() {
+	for _, d := range optionalDBs() {
+		if d.path == "" {
+			continue
+		}
+		if err := d.load(); err != nil {
+			log.Printf("%s database reload failed, keeping the current one: %v", d.name, err)
+		}
+	}
+}
+
+// addSupplementaryFields merges the fields of the loaded supplementary
+// databases into a lookup response. A failed read is logged and only costs
+// that database's fields.
+func addSupplementaryFields(ip net.IP, response map[string]any) {
+	for _, d := range supplementaryDBs {
+		reader := d.reader.Load()
+		if reader == nil {
+			continue
+		}
+		if err := d.addFields(reader, ip, response); err != nil {
+			log.Printf("%s lookup for IP %s failed: %v", d.name, ip.String(), err)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// geoKeyPrefix is the key namespace answered by the cache protocol listeners.
+const geoKeyPrefix = "geo:"
+
+// tcpIdleTimeout closes protocol connections that stay silent this long.
+const tcpIdleTimeout = 5 * time.Minute
+
+var errLineTooLong = errors.New("line too long")
+
+// tcpServer runs an accept loop for one of the raw TCP protocol listeners
+// (RESP, memcached) and tracks open connections so they can be closed on
+// shutdown.
+type tcpServer struct {
+	name   string
+	ln     net.Listener
+	handle func(net.Conn)
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+func newTCPServer(name, addr string, handle func(net.Conn)) (*tcpServer, error) {
+	ln, err := upgrades.listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &tcpServer{name: name, ln: ln, handle: handle, conns: make(map[net.Conn]struct{})}, nil
+}
+
+// serve accepts connections until the listener is closed.
+func (s *tcpServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("%s: accept error: %v", s.name, err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, conn)
+				s.mu.Unlock()
+				conn.Close()
+			}()
+			s.handle(conn)
+		}()
+	}
+}
+
+// close stops accepting, closes open connections and waits for their
+// handlers to return.
+func (s *tcpServer) close() error {
+	err := s.ln.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// lookupGeoKey resolves a geo:<ip> cache key and returns the encoded lookup,
+// or nil for a miss: a key outside the namespace, an invalid IP or, unless
+// MISS_BEHAVIOR answers it, one the database has no record for. Other
+// errors are server faults, which are logged and returned.
+func lookupGeoKey(protocol, key string, encode func(any) ([]byte, error)) ([]byte, error) {
+	ipStr, ok := strings.CutPrefix(key, geoKeyPrefix)
+	if !ok {
+		return nil, nil
+	}
+	record, err := lookupIPString(context.Background(), ipStr)
+	if errors.Is(err, errNoRecord) {
+		if record, ok = missResult(net.ParseIP(strings.TrimSpace(ipStr))); !ok {
+			return nil, nil
+		}
+		err = nil
+	}
+	if errors.Is(err, errInvalidIP) {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("%s: GeoIP lookup for IP %s failed: %v", protocol, ipStr, err)
+		return nil, err
+	}
+	encoded, err := encode(record)
+	if err != nil {
+		log.Printf("%s: error encoding response for IP %s: %v", protocol, ipStr, err)
+		return nil, err
+	}
+	return encoded, nil
+}
+
+// readLimitedLine reads a CRLF or LF terminated line of at most limit bytes.
+func readLimitedLine(r *bufio.Reader, limit int) (string, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		line = append(line, chunk...)
+		if len(line) > limit {
+			return "", errLineTooLong
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
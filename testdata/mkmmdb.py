@@ -0,0 +1,88 @@
+#!/usr/bin/env python3
+"""Minimal MMDB writer for the test databases in this directory.
+
+Usage: mkmmdb.py spec.json out.mmdb, e.g. mkmmdb.py dbip-asn.json dbip-asn.mmdb
+"""
+import json, struct, sys, ipaddress, time
+
+def enc_size(t, size):
+    ext = t > 7
+    ctrl_t = 0 if ext else t
+    if size < 29: ctrl = (ctrl_t << 5) | size; rest = b''
+    elif size < 285: ctrl = (ctrl_t << 5) | 29; rest = bytes([size-29])
+    elif size < 65821: ctrl = (ctrl_t << 5) | 30; rest = struct.pack('>H', size-285)
+    else: ctrl = (ctrl_t << 5) | 31; rest = struct.pack('>I', size-65821)[1:]
+    out = bytes([ctrl])
+    if ext: out += bytes([t-7])
+    return out + rest
+
+def uint_bytes(v):
+    b = v.to_bytes((v.bit_length()+7)//8, 'big') if v else b''
+    return b
+
+def enc(v, key_uint=None):
+    if isinstance(v, bool): return enc_size(14, 1 if v else 0)
+    if isinstance(v, str):
+        b = v.encode(); return enc_size(2, len(b)) + b
+    if isinstance(v, float): return enc_size(3, 8) + struct.pack('>d', v)
+    if isinstance(v, int):
+        b = uint_bytes(v)
+        if v < 2**16: t = 5
+        elif v < 2**32: t = 6
+        else: t = 9
+        return enc_size(t, len(b)) + b
+    if isinstance(v, dict):
+        out = enc_size(7, len(v))
+        for k, x in v.items(): out += enc(str(k)) + enc(x)
+        return out
+    if isinstance(v, list):
+        out = enc_size(11, len(v))
+        for x in v: out += enc(x)
+        return out
+    raise TypeError(v)
+
+def main():
+    spec = json.load(open(sys.argv[1]))
+    data = b''; offsets = []
+    root = [None, None]
+    for cidr, rec in spec['networks'].items():
+        off = len(data); data += enc(rec)
+        net = ipaddress.ip_network(cidr)
+        if net.version == 4:
+            bits = [0]*96 + [int(c) for c in format(int(net.network_address), '032b')][:net.prefixlen]
+        else:
+            bits = [int(c) for c in format(int(net.network_address), '0128b')][:net.prefixlen]
+        node = root
+        for i, bit in enumerate(bits):
+            if i == len(bits)-1:
+                node[bit] = ('data', off)
+            else:
+                if not isinstance(node[bit], list): node[bit] = [None, None]
+                node = node[bit]
+    # number nodes BFS
+    nodes = []; queue = [root]
+    while queue:
+        n = queue.pop(0); nodes.append(n)
+        for c in n:
+            if isinstance(c, list): queue.append(c)
+    idx = {id(n): i for i, n in enumerate(nodes)}
+    count = len(nodes)
+    tree = b''
+    for n in nodes:
+        vals = []
+        for c in n:
+            if c is None: vals.append(count)
+            elif isinstance(c, list): vals.append(idx[id(c)])
+            else: vals.append(count + 16 + c[1])
+        tree += vals[0].to_bytes(3, 'big') + vals[1].to_bytes(3, 'big')
+    meta = {
+        'node_count': count, 'record_size': 24, 'ip_version': 6,
+        'database_type': spec['database_type'], 'languages': spec.get('languages', ['en']),
+        'binary_format_major_version': 2, 'binary_format_minor_version': 0,
+        'build_epoch': spec.get('build_epoch', int(time.time())),
+        'description': {'en': spec['database_type'] + ' test database'},
+    }
+    with open(sys.argv[2], 'wb') as f:
+        f.write(tree + b'\x00'*16 + data + b'\xab\xcd\xefMaxMind.com' + enc(meta))
+
+main()
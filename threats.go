@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxThreatLists is the number of lists a threatTree node can mark.
+const maxThreatLists = 64
+
+// maxThreatListSize caps how much of a downloaded list is read.
+const maxThreatListSize = 64 << 20
+
+// threatList is one blocklist of THREAT_LISTS: a file or an http(s) URL of
+// networks, one per line.
+type threatList struct {
+	name   string
+	source string
+	// parse reads a version of the list; nil is parseThreatList.
+	parse func(io.Reader) ([]netip.Prefix, error)
+	// networks is the last version read successfully; nil until then.
+	// It and updated are guarded by the mutex of threatIntel.
+	networks []netip.Prefix
+	updated  time.Time
+	// The validators of the last version, so unchanged lists are not read
+	// again.
+	modTime      time.Time
+	etag         string
+	lastModified string
+}
+
+// threatTree is a binary radix tree of the networks of every list, keyed
+// by the bits of the IPv6 (or IPv4-mapped) address.
+type threatTree struct {
+	root threatNode
+}
+
+type threatNode struct {
+	children [2]*threatNode
+	// lists has bit i set when list i has the network ending at the node.
+	lists uint64
+}
+
+func (t *threatTree) insert(network netip.Prefix, list int) {
+	addr := network.Addr().As16()
+	bits := network.Bits()
+	if network.Addr().Is4() {
+		bits += 96
+	}
+	node := &t.root
+	for i := 0; i < bits; i++ {
+		bit := addr[i/8] >> (7 - i%8) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &threatNode{}
+		}
+		node = node.children[bit]
+	}
+	node.lists |= 1 << list
+}
+
+// match returns the lists with a network containing addr.
+func (t *threatTree) match(addr netip.Addr) uint64 {
+	if addr.Is4() {
+		addr = netip.AddrFrom16(addr.As16())
+	}
+	bytes := addr.As16()
+	node := &t.root
+	lists := node.lists
+	for i := 0; i < 128; i++ {
+		node = node.children[bytes[i/8]>>(7-i%8)&1]
+		if node == nil {
+			break
+		}
+		lists |= node.lists
+	}
+	return lists
+}
+
+// threatIntel holds the lists of THREAT_LISTS, or of CLOUD_RANGES, and the
+// tree built from them.
+type threatIntel struct {
+	// kind names the lists in logs, e.g. "Threat list".
+	kind   string
+	client *http.Client
+	mu     sync.Mutex
+	lists  []*threatList
+	tree   atomic.Pointer[threatTree]
+	// loaded is set once a list has been read.
+	loaded atomic.Bool
+}
+
+// threats is the process-wide blocklist set; nil when THREAT_LISTS is not
+// set.
+var threats *threatIntel
+
+func newThreatIntel(kind string, lists []*threatList) *threatIntel {
+	t := &threatIntel{kind: kind, client: &http.Client{Timeout: time.Minute}, lists: lists}
+	t.tree.Store(&threatTree{})
+	return t
+}
+
+// run refreshes the lists every interval until ctx is done.
+func (t *threatIntel) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.refresh(ctx)
+		}
+	}
+}
+
+// refresh reads the lists that changed and swaps in a tree of every list. A
+// list that cannot be read is logged and its last version kept.
+func (t *threatIntel) refresh(ctx context.Context) {
+	changed := false
+	for _, list := range t.lists {
+		networks, err := list.refresh(ctx, t.client)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("%s %s refresh failed, keeping the current version: %v", t.kind, list.name, err)
+			}
+			continue
+		}
+		t.mu.Lock()
+		list.updated = time.Now()
+		if networks != nil {
+			list.networks = networks
+		}
+		t.mu.Unlock()
+		if networks != nil {
+			log.Printf("Loaded %d networks from %s %s.", len(networks), strings.ToLower(t.kind), list.name)
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	tree := &threatTree{}
+	for i, list := range t.lists {
+		for _, network := range list.networks {
+			tree.insert(network, i)
+		}
+	}
+	t.tree.Store(tree)
+	t.loaded.Store(true)
+}
+
+// refresh reads the list if it changed since the last version, returning
+// the networks of the new version or nil when it is unchanged.
+func (l *threatList) refresh(ctx context.Context, client *http.Client) ([]netip.Prefix, error) {
+	if !strings.HasPrefix(l.source, "http://") && !strings.HasPrefix(l.source, "https://") {
+		return l.refreshFile()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, l.source, nil)
+	if err != nil {
+		return nil, err
+	}
+	if l.etag != "" {
+		req.Header.Set("If-None-Match", l.etag)
+	}
+	if l.lastModified != "" {
+		req.Header.Set("If-Modified-Since", l.lastModified)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned %s", resp.Status)
+	}
+	// A list cut at the limit could end in a prefix of a network, such as
+	// 10.0.0.0/2 for 10.0.0.0/24.
+	body := &io.LimitedReader{R: resp.Body, N: maxThreatListSize + 1}
+	networks, err := l.parseList(body)
+	if body.N == 0 {
+		err = fmt.Errorf("the list exceeds %d MB", maxThreatListSize>>20)
+	}
+	if err != nil {
+		return nil, err
+	}
+	l.etag, l.lastModified = resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	return networks, nil
+}
+
+func (l *threatList) refreshFile() ([]netip.Prefix, error) {
+	info, err := os.Stat(l.source)
+	if err != nil {
+		return nil, err
+	}
+	if !l.modTime.IsZero() && info.ModTime().Equal(l.modTime) {
+		return nil, nil
+	}
+	f, err := os.Open(l.source)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	networks, err := l.parseList(f)
+	if err != nil {
+		return nil, err
+	}
+	l.modTime = info.ModTime()
+	return networks, nil
+}
+
+func (l *threatList) parseList(r io.Reader) ([]netip.Prefix, error) {
+	if l.parse != nil {
+		return l.parse(r)
+	}
+	return parseThreatList(r)
+}
+
+// parseThreatList reads a list of networks or addresses, one per line, as
+// FireHOL .netset and .ipset files and Spamhaus DROP have them: comments
+// start with # or ;, and anything after the first field, such as the other
+// columns of an AbuseIPDB CSV export, is ignored. The first line may be a
+// header; any other line that is not a network rejects the whole list, so
+// an error page is not taken for an empty list.
+func parseThreatList(r io.Reader) ([]netip.Prefix, error) {
+	networks := []netip.Prefix{}
+	scanner := bufio.NewScanner(r)
+	first, header := true, false
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		text, _, _ = strings.Cut(text, ";")
+		field := strings.FieldsFunc(text, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+		if len(field) == 0 {
+			continue
+		}
+		network, err := parseThreatNetwork(field[0])
+		if err != nil {
+			if first {
+				first, header = false, true
+				continue
+			}
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		first = false
+		networks = append(networks, network)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if header && len(networks) == 0 {
+		return nil, fmt.Errorf("no networks in the list")
+	}
+	return networks, nil
+}
+
+func parseThreatNetwork(s string) (netip.Prefix, error) {
+	s = strings.Trim(s, `"`)
+	if strings.Contains(s, "/") {
+		network, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid network %q", s)
+		}
+		return network.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid network %q", s)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// parseThreatLists parses THREAT_LISTS: comma-separated sources, each a
+// path or URL optionally preceded by its name and "=". Unnamed lists are
+// named after the file.
+func parseThreatLists(spec string) ([]*threatList, error) {
+	var lists []*threatList
+	names := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, source, ok := strings.Cut(entry, "=")
+		if !ok || strings.Contains(name, "/") {
+			name, source = "", entry
+		}
+		name, source = strings.TrimSpace(name), strings.TrimSpace(source)
+		if name == "" {
+			base := filepath.Base(strings.TrimRight(source, "/"))
+			name = strings.TrimSuffix(base, filepath.Ext(base))
+		}
+		if source == "" || name == "" {
+			return nil, fmt.Errorf("invalid entry %q", entry)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("duplicate list name %q: name the lists with name=source", name)
+		}
+		names[name] = true
+		lists = append(lists, &threatList{name: name, source: source})
+	}
+	if len(lists) > maxThreatLists {
+		return nil, fmt.Errorf("%d lists, at most %d are supported", len(lists), maxThreatLists)
+	}
+	return lists, nil
+}
+
+// addThreatFields sets the threat field of response: whether a list has ip
+// and the names of the lists that do.
+func addThreatFields(ip net.IP, response map[string]any) {
+	if threats == nil {
+		return
+	}
+	matched := threats.match(ip)
+	names := []string{}
+	for i, list := range threats.lists {
+		if matched&(1<<i) != 0 {
+			names = append(names, list.name)
+		}
+	}
+	response["threat"] = map[string]any{
+		"listed": len(names) > 0,
+		"lists":  names,
+	}
+}
+
+// match returns the lists with a network containing ip.
+func (t *threatIntel) match(ip net.IP) uint64 {
+	addr, _ := netip.AddrFromSlice(ip)
+	return t.tree.Load().match(addr.Unmap())
+}
+
+// state reports the loaded lists for /stats.
+func (t *threatIntel) state() []map[string]any {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state := make([]map[string]any, 0, len(t.lists))
+	for _, list := range t.lists {
+		entry := map[string]any{"name": list.name, "loaded": list.networks != nil}
+		if list.networks != nil {
+			entry["networks"] = len(list.networks)
+			entry["updated_at"] = list.updated.UTC().Format(time.RFC3339)
+		}
+		state = append(state, entry)
+	}
+	return state
+}
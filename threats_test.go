@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseThreatList(t *testing.T) {
+	tests := []struct {
+		name string
+		list string
+		want []string
+	}{
+		{"netset", "# firehol_level1\n#\n1.2.3.0/24\n5.6.7.8\n2001:db8::/32\n", []string{"1.2.3.0/24", "5.6.7.8/32", "2001:db8::/32"}},
+		{"Spamhaus DROP", "; Spamhaus DROP List\n1.10.16.0/20 ; SBL256894\n", []string{"1.10.16.0/20"}},
+		{"AbuseIPDB export", "ipAddress,countryCode,abuseConfidenceScore\n\"5.6.7.8\",CN,100\n9.9.9.9,US,75\n", []string{"5.6.7.8/32", "9.9.9.9/32"}},
+		{"host bits and mapped addresses", "1.2.3.4/24\n::ffff:5.6.7.8\r\n", []string{"1.2.3.0/24", "5.6.7.8/32"}},
+		{"tab-separated", "1.2.3.0/24\tcomment\n", []string{"1.2.3.0/24"}},
+		{"empty", "", []string{}},
+		{"only comments", "# nothing listed\n\n", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			networks, err := parseThreatList(strings.NewReader(tt.list))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, network := range networks {
+				got = append(got, network.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseThreatListRejectsMalformedLists(t *testing.T) {
+	tests := []struct {
+		name string
+		list string
+		// message is part of the error.
+		message string
+	}{
+		{"only a header", "ipAddress,countryCode\n", "no networks in the list"},
+		{"an error page", "<html>\n<body>Too many requests</body>\n</html>\n", `line 2: invalid network "<body>Too"`},
+		{"a bad line", "1.2.3.0/24\nexample.com\n", `line 2: invalid network "example.com"`},
+		{"prefix too long", "1.2.3.0/24\n1.2.3.0/33\n", `line 2: invalid network "1.2.3.0/33"`},
+		{"cut in a network", "1.2.3.0/24\n5.6.", `line 2: invalid network "5.6."`},
+		{"a range", "1.2.3.0/24\n1.2.3.0-1.2.3.255\n", "line 2: invalid network"},
+		{"line too long", "1.2.3.0/24\n" + strings.Repeat("1", 70000), "token too long"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			networks, err := parseThreatList(strings.NewReader(tt.list))
+			if err == nil || !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("parseThreatList = %v, %v, want an error containing %q", networks, err, tt.message)
+			}
+		})
+	}
+}
+
+func TestParseThreatLists(t *testing.T) {
+	lists, err := parseThreatLists(" https://iplists.firehol.org/files/firehol_level1.netset , abuseipdb = /data/abuseipdb.csv,,/data/drop.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, list := range lists {
+		got = append(got, list.name+"="+list.source)
+	}
+	want := []string{"firehol_level1=https://iplists.firehol.org/files/firehol_level1.netset", "abuseipdb=/data/abuseipdb.csv", "drop=/data/drop.txt"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parsed %v, want %v", got, want)
+	}
+
+	for _, spec := range []string{
+		"a=",
+		"=",
+		"/data/a.txt,/other/a.txt",
+		"l=/data/a.txt,l=/data/b.txt",
+	} {
+		if lists, err := parseThreatLists(spec); err == nil {
+			t.Errorf("parseThreatLists(%q) = %d lists, want an error", spec, len(lists))
+		}
+	}
+	many := make([]string, maxThreatLists+1)
+	for i := range many {
+		many[i] = "/data/" + strings.Repeat("x", i+1) + ".txt"
+	}
+	if _, err := parseThreatLists(strings.Join(many, ",")); err == nil {
+		t.Errorf("parseThreatLists accepted %d lists", len(many))
+	}
+}
+
+func TestThreatTreeMatch(t *testing.T) {
+	var tree threatTree
+	for i, networks := range [][]string{{"10.0.0.0/8", "2001:db8::/32"}, {"10.1.0.0/16", "192.0.2.1/32"}, {"2001::/16"}} {
+		for _, network := range networks {
+			tree.insert(netip.MustParsePrefix(network), i)
+		}
+	}
+	tests := []struct {
+		addr string
+		want uint64
+	}{
+		{"10.2.0.1", 0b001},
+		{"10.1.0.1", 0b011},
+		{"192.0.2.1", 0b010},
+		{"192.0.2.2", 0},
+		{"11.0.0.1", 0},
+		{"2001:db8::1", 0b101},
+		{"2001:db9::1", 0b100},
+	}
+	for _, tt := range tests {
+		if got := tree.match(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("match(%s) = %03b, want %03b", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestThreatIntelRefresh(t *testing.T) {
+	var body string
+	var status int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "drop.txt")
+	if err := os.WriteFile(path, []byte("192.0.2.0/24\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	intel := newThreatIntel("Threat list", []*threatList{{name: "remote", source: server.URL}, {name: "drop", source: path}})
+	intel.client = server.Client()
+	listed := func(ip string) []string {
+		var names []string
+		matched := intel.match(net.ParseIP(ip))
+		for i, list := range intel.lists {
+			if matched&(1<<i) != 0 {
+				names = append(names, list.name)
+			}
+		}
+		return names
+	}
+
+	// tooLarge cut at the limit ends in 10.0.0.0/2, which would list
+	// a quarter of the IPv4 space for 10.0.0.0/24.
+	n := maxThreatListSize - len("10.0.0.0/2")
+	tooLarge := strings.Repeat("#"+strings.Repeat("x", 1022)+"\n", n/1024) + "#" + strings.Repeat("x", n%1024-2) + "\n10.0.0.0/24\n"
+
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		// remote is whether 10.0.0.1 is listed by the remote list after the
+		// refresh.
+		remote bool
+	}{
+		{"server error", http.StatusInternalServerError, "10.0.0.0/8\n", false},
+		{"an error page", http.StatusOK, "<html>\n<body>Error</body>\n", false},
+		{"too large", http.StatusOK, tooLarge, false},
+		{"list", http.StatusOK, "10.0.0.0/8\n", true},
+		// The list is fetched with its ETag and kept.
+		{"not modified", 0, "", true},
+	}
+	for _, tt := range tests {
+		status, body = tt.status, tt.body
+		intel.refresh(context.Background())
+		want := []string{"drop"}
+		if got := listed("192.0.2.1"); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: 192.0.2.1 is listed by %v, want %v", tt.name, got, want)
+		}
+		if got := listed("10.0.0.1"); (len(got) == 1) != tt.remote {
+			t.Fatalf("%s: 10.0.0.1 is listed by %v", tt.name, got)
+		}
+	}
+	if state := intel.state(); state[0]["networks"] != 1 || state[1]["networks"] != 1 {
+		t.Errorf("state = %v, want a network in each list", state)
+	}
+	if time.Since(intel.lists[0].updated) > time.Minute {
+		t.Errorf("the remote list was updated at %s", intel.lists[0].updated)
+	}
+}
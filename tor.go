@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// defaultTorExitListURL is the Tor Project's list of exit node addresses.
+const defaultTorExitListURL = "https://check.torproject.org/torbulkexitlist"
+
+// maxTorExitListSize caps how much of the exit list is read.
+const maxTorExitListSize = 16 << 20
+
+// torExitList is a fetched version of the exit list.
+type torExitList struct {
+	addrs   map[netip.Addr]struct{}
+	fetched time.Time
+}
+
+// torExits holds the last exit list fetched successfully; nil until the
+// first fetch succeeds or when TOR_EXIT_LIST_ENABLED is off.
+var torExits atomic.Pointer[torExitList]
+
+// torExitListEnabled is set from TOR_EXIT_LIST_ENABLED.
+var torExitListEnabled bool
+
+// torExitListFetcher downloads the exit list. Requests are conditional on
+// the last version, so an unchanged list is not downloaded again.
+type torExitListFetcher struct {
+	client       *http.Client
+	url          string
+	etag         string
+	lastModified string
+}
+
+// runTorExitList fetches the exit list now and then every interval until ctx
+// is done. A failed fetch is logged and the last good list kept.
+func runTorExitList(ctx context.Context, url string, interval time.Duration) {
+	f := &torExitListFetcher{client: &http.Client{Timeout: 30 * time.Second}, url: url}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := f.refresh(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Tor exit list refresh failed, keeping the current list: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (f *torExitListFetcher) refresh(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.url, nil)
+	if err != nil {
+		return err
+	}
+	if f.etag != "" {
+		req.Header.Set("If-None-Match", f.etag)
+	}
+	if f.lastModified != "" {
+		req.Header.Set("If-Modified-Since", f.lastModified)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		if list := torExits.Load(); list != nil {
+			torExits.Store(&torExitList{addrs: list.addrs, fetched: time.Now()})
+		}
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned %s", resp.Status)
+	}
+	// A list cut at the limit could end in a prefix of an address.
+	body := &io.LimitedReader{R: resp.Body, N: maxTorExitListSize + 1}
+	addrs, err := parseTorExitList(body)
+	if body.N == 0 {
+		err = fmt.Errorf("the list exceeds %d MB", maxTorExitListSize>>20)
+	}
+	if err != nil {
+		return err
+	}
+	torExits.Store(&torExitList{addrs: addrs, fetched: time.Now()})
+	f.etag = resp.Header.Get("ETag")
+	f.lastModified = resp.Header.Get("Last-Modified")
+	log.Printf("Loaded %d Tor exit node addresses from %s.", len(addrs), f.url)
+	return nil
+}
+
+// parseTorExitList reads one address per line. Anything else, such as an
+// error page served with 200 OK, rejects the whole list.
+func parseTorExitList(r io.Reader) (map[netip.Addr]struct{}, error) {
+	addrs := make(map[netip.Addr]struct{})
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		// Lookups of an IP never match an address with a zone.
+		addr, err := netip.ParseAddr(text)
+		if err != nil || addr.Zone() != "" {
+			return nil, fmt.Errorf("line %d: invalid address %q", line, text)
+		}
+		addrs[addr.Unmap()] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("the list is empty")
+	}
+	return addrs, nil
+}
+
+// addTorExitField sets the is_tor_exit field of response, null while no
+// exit list has been fetched.
+func addTorExitField(ip net.IP, response map[string]any) {
+	if !torExitListEnabled {
+		return
+	}
+	list := torExits.Load()
+	if list == nil {
+		response["is_tor_exit"] = nil
+		return
+	}
+	addr, _ := netip.AddrFromSlice(ip)
+	_, listed := list.addrs[addr.Unmap()]
+	response["is_tor_exit"] = listed
+}
+
+// torExitListState reports the loaded exit list for /stats.
+func torExitListState() map[string]any {
+	list := torExits.Load()
+	if list == nil {
+		return map[string]any{"loaded": false}
+	}
+	return map[string]any{
+		"loaded":     true,
+		"addresses":  len(list.addrs),
+		"fetched_at": list.fetched.UTC().Format(time.RFC3339),
+	}
+}
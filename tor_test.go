@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useTorExits restores the exit list when t ends.
+func useTorExits(t *testing.T) {
+	t.Helper()
+	old := torExits.Load()
+	t.Cleanup(func() { torExits.Store(old) })
+	torExits.Store(nil)
+}
+
+func TestParseTorExitList(t *testing.T) {
+	addrs, err := parseTorExitList(strings.NewReader("# exits\n185.220.101.1\r\n\n  2001:db8::1  \n::ffff:185.220.101.2\n185.220.101.1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range []string{"185.220.101.1", "2001:db8::1", "185.220.101.2"} {
+		if _, ok := addrs[netip.MustParseAddr(addr)]; !ok {
+			t.Errorf("%s is not in the list", addr)
+		}
+	}
+	if len(addrs) != 3 {
+		t.Errorf("the list has %d addresses, want 3", len(addrs))
+	}
+}
+
+func TestParseTorExitListRejectsMalformedLists(t *testing.T) {
+	tests := []struct {
+		name string
+		list string
+		// message is part of the error.
+		message string
+	}{
+		{"empty", "", "the list is empty"},
+		{"only comments", "# no exits\n\n", "the list is empty"},
+		{"an error page", "<html><body>Service Unavailable</body></html>\n", `line 1: invalid address "<html>`},
+		{"a network", "185.220.101.1\n185.220.101.0/24\n", `line 2: invalid address "185.220.101.0/24"`},
+		{"an address with a zone", "fe80::1%eth0\n", `line 1: invalid address "fe80::1%eth0"`},
+		{"cut in an address", "185.220.101.1\n185.220.", `line 2: invalid address "185.220."`},
+		{"two addresses on a line", "185.220.101.1 185.220.101.2\n", "line 1: invalid address"},
+		{"line too long", strings.Repeat("1", 70000), "token too long"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addrs, err := parseTorExitList(strings.NewReader(tt.list))
+			if err == nil || !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("parseTorExitList = %d addresses, %v, want an error containing %q", len(addrs), err, tt.message)
+			}
+		})
+	}
+}
+
+func TestTorExitListRefresh(t *testing.T) {
+	useTorExits(t)
+	var body string
+	var status int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+	f := &torExitListFetcher{client: server.Client(), url: server.URL}
+
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		// message is part of the error; the list loads when it is empty.
+		message string
+	}{
+		{"server error", http.StatusServiceUnavailable, "185.220.101.1\n", "server returned 503"},
+		{"an error page", http.StatusOK, "<html>", "invalid address"},
+		{"too large", http.StatusOK, strings.Repeat("185.220.101.1\n", maxTorExitListSize/14+1), "the list exceeds 16 MB"},
+		{"list", http.StatusOK, "185.220.101.1\n", ""},
+		// The fetcher now sends the ETag of the list.
+		{"not modified", 0, "", ""},
+	}
+	for _, tt := range tests {
+		status, body = tt.status, tt.body
+		err := f.refresh(context.Background())
+		if tt.message == "" && err != nil || tt.message != "" && (err == nil || !strings.Contains(err.Error(), tt.message)) {
+			t.Fatalf("%s: refresh = %v, want an error containing %q", tt.name, err, tt.message)
+		}
+		list := torExits.Load()
+		if tt.message != "" && list != nil {
+			t.Fatalf("%s: a rejected list was loaded", tt.name)
+		}
+		if tt.message == "" && (list == nil || len(list.addrs) != 1 || time.Since(list.fetched) > time.Minute) {
+			t.Fatalf("%s: loaded %+v, want the list of 1 address", tt.name, list)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// traceContext is the W3C Trace Context (https://www.w3.org/TR/trace-context/)
+// of one request. Incoming traceparent and tracestate headers are honoured;
+// without a valid traceparent a new trace is started so log lines can still
+// be correlated.
+type traceContext struct {
+	TraceID      string
+	ParentSpanID string // span ID of the caller; empty when the trace started here
+	SpanID       string // span ID of this request
+	Flags        string
+	TraceState   string
+}
+
+// maxTraceStateMembers is the list member limit defined for tracestate.
+const maxTraceStateMembers = 32
+
+// parseTraceparent parses a traceparent header. Versions other than 00 are
+// parsed by their 00 prefix as the specification requires.
+func parseTraceparent(value string) (traceID, parentID, flags string, ok bool) {
+	value = strings.TrimSpace(value)
+	if len(value) < 55 || (len(value) > 55 && (value[:2] == "00" || value[55] != '-')) {
+		return "", "", "", false
+	}
+	version, traceID, parentID, flags := value[:2], value[3:35], value[36:52], value[53:55]
+	if value[2] != '-' || value[35] != '-' || value[52] != '-' || version == "ff" {
+		return "", "", "", false
+	}
+	for _, field := range []string{version, traceID, parentID, flags} {
+		if !isLowerHex(field) {
+			return "", "", "", false
+		}
+	}
+	if strings.Trim(traceID, "0") == "" || strings.Trim(parentID, "0") == "" {
+		return "", "", "", false
+	}
+	return traceID, parentID, flags, true
+}
+
+// parseTracestate validates a tracestate header, returning it normalized or
+// empty when it is malformed (in which case it must not be propagated).
+func parseTracestate(values []string) string {
+	var members []string
+	for _, value := range values {
+		for _, member := range strings.Split(value, ",") {
+			member = strings.TrimSpace(member)
+			if member == "" {
+				continue
+			}
+			key, val, found := strings.Cut(member, "=")
+			if !found || key == "" || val == "" || len(member) > 256 {
+				return ""
+			}
+			members = append(members, member)
+		}
+	}
+	if len(members) > maxTraceStateMembers {
+		return ""
+	}
+	return strings.Join(members, ",")
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// newTraceContext builds the trace context for r.
+func newTraceContext(r *http.Request) traceContext {
+	tc := traceContext{SpanID: randomHex(8), Flags: "00"}
+	if traceID, parentID, flags, ok := parseTraceparent(r.Header.Get("Traceparent")); ok {
+		tc.TraceID, tc.ParentSpanID, tc.Flags = traceID, parentID, flags
+		tc.TraceState = parseTracestate(r.Header.Values("Tracestate"))
+	} else {
+		tc.TraceID = randomHex(16)
+	}
+	return tc
+}
+
+// tracedWriter carries the request's trace context and path so writeAppError
+// can include them in error bodies.
+type tracedWriter struct {
+	http.ResponseWriter
+	trace traceContext
+	path  string
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *tracedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// tracedWriterFrom finds the tracedWriter attached by traceMiddleware by
+// unwrapping w.
+func tracedWriterFrom(w http.ResponseWriter) (*tracedWriter, bool) {
+	for {
+		switch tw := w.(type) {
+		case *tracedWriter:
+			return tw, true
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = tw.Unwrap()
+		default:
+			return nil, false
+		}
+	}
+}
+
+// traceMiddleware attaches a trace context to every request and, when
+// accessLog is set, writes one access log line per request including the
+// trace and span IDs.
+func traceMiddleware(next http.Handler, accessLog bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tc := newTraceContext(r)
+		tw := &tracedWriter{ResponseWriter: w, trace: tc, path: r.URL.Path}
+		if !accessLog {
+			next.ServeHTTP(tw, r)
+			return
+		}
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: tw}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		parent := tc.ParentSpanID
+		if parent == "" {
+			parent = "-"
+		}
+		log.Printf("access: %s %s %q %d %s trace_id=%s span_id=%s parent_span_id=%s",
+			clientIP(r), r.Method, r.URL.RequestURI(), rec.status, time.Since(start).Round(time.Microsecond),
+			tc.TraceID, tc.SpanID, parent)
+	})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// ttlCache is a size-bounded LRU cache whose entries expire after a fixed TTL.
+// It is safe for concurrent use.
+type ttlCache[V any] struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	order      *list.List // front = most recently used
+	items      map[string]*list.Element
+}
+
+type ttlCacheEntry[V any] struct {
+	key     string
+	value   V
+	expires time.Time
+}
+
+func newTTLCache[V any](maxEntries int, ttl time.Duration) *ttlCache[V] {
+	return &ttlCache[V]{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		order:      list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+// get returns the cached value for key if present and not expired.
+func (c *ttlCache[V]) get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero V
+	elem, ok := c.items[key]
+	if !ok {
+		return zero, false
+	}
+	entry := elem.Value.(*ttlCacheEntry[V])
+	if time.Now().After(entry.expires) {
+		c.order.Remove(elem)
+		delete(c.items, key)
+		return zero, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.value, true
+}
+
+// set stores value under key, evicting the least recently used entry when full.
+func (c *ttlCache[V]) set(key string, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(c.ttl)
+	if elem, ok := c.items[key]; ok {
+		entry := elem.Value.(*ttlCacheEntry[V])
+		entry.value, entry.expires = value, expires
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.order.PushFront(&ttlCacheEntry[V]{key: key, value: value, expires: expires})
+	for c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*ttlCacheEntry[V]).key)
+	}
+}
+
+// len returns the number of entries currently held, including expired ones
+// that have not been evicted yet.
+func (c *ttlCache[V]) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
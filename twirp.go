@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	iplookupv1 "github.com/ali-issa/ip-lookup/proto/iplookup/v1"
+	"github.com/twitchtv/twirp"
+)
+
+// This file serves the IPLookup service of proto/iplookup/v1/lookup.proto
+// over Twirp with the server generated by protoc-gen-twirp.
+
+// twirpPathPrefix is the route prefix of the iplookup.v1.IPLookup Twirp service.
+const twirpPathPrefix = iplookupv1.IPLookupPathPrefix
+
+// maxTwirpRequestSize caps the size of a Twirp request body.
+const maxTwirpRequestSize = 1 << 20
+
+// twirpError returns a Twirp error carrying the service's machine-readable
+// error code in meta, so clients can share handling with the REST API.
+func twirpError(code twirp.ErrorCode, errorCode, msg string) error {
+	return twirp.NewError(code, msg).WithMeta("error_code", errorCode)
+}
+
+// newTwirpHandler returns the handler of the Twirp routes under
+// twirpPathPrefix. JSON responses use the lowerCamelCase names
+// of the protobuf JSON mapping and leave out unset fields.
+func newTwirpHandler() http.Handler {
+	srv := iplookupv1.NewIPLookupServer(twirpService{},
+		twirp.WithServerJSONCamelCaseNames(true),
+		twirp.WithServerJSONSkipDefaults(true),
+	)
+	return http.MaxBytesHandler(srv, maxTwirpRequestSize)
+}
+
+// twirpService implements iplookupv1.IPLookup.
+type twirpService struct{}
+
+func (twirpService) Lookup(ctx context.Context, req *iplookupv1.LookupRequest) (*iplookupv1.LookupResponse, error) {
+	if req.GetIp() == "" {
+		return nil, twirpError(twirp.InvalidArgument, errCodeInvalidRequest, "ip is required")
+	}
+	record, err := lookupIPString(ctx, req.GetIp())
+	if errors.Is(err, errInvalidIP) {
+		return nil, twirpError(twirp.InvalidArgument, errCodeInvalidIP, fmt.Sprintf("Invalid IP address format: %s", req.GetIp()))
+	}
+	if errors.Is(err, errNoRecord) {
+		if response, ok := missResult(net.ParseIP(strings.TrimSpace(req.GetIp()))); ok {
+			return lookupResponseMessage(response), nil
+		}
+		return nil, twirpError(twirp.NotFound, errCodeNotFound, fmt.Sprintf("GeoIP data not found for IP: %s", req.GetIp()))
+	}
+	if err != nil {
+		log.Printf("Twirp: GeoIP lookup for IP %s failed: %v", req.GetIp(), err)
+		return nil, twirpError(twirp.Internal, errCodeDBError, fmt.Sprintf("GeoIP lookup failed for IP: %s", req.GetIp()))
+	}
+	return lookupResponseMessage(record), nil
+}
+
+func (twirpService) BatchLookup(ctx context.Context, req *iplookupv1.BatchLookupRequest) (*iplookupv1.BatchLookupResponse, error) {
+	// BATCH_LOOKUP_MAX_SIZE caps batches as it does POST /lookup, and 0
+	// disables them.
+	limit := appConfig.BatchLookupMaxSize
+	if limit == 0 {
+		return nil, twirpError(twirp.BadRoute, errCodeRouteNotFound, "BatchLookup is disabled")
+	}
+	if len(req.GetIps()) > limit {
+		return nil, twirpError(twirp.InvalidArgument, errCodeInvalidRequest, fmt.Sprintf("too many IPs: %d (maximum %d)", len(req.GetIps()), limit))
+	}
+	batch := &iplookupv1.BatchLookupResponse{Results: make([]*iplookupv1.BatchLookupResult, 0, len(req.GetIps()))}
+	for _, ipStr := range req.GetIps() {
+		batch.Results = append(batch.Results, batchLookupResultFor(ctx, ipStr))
+	}
+	return batch, nil
+}
+
+// StreamBatchLookup is a streaming method, which Twirp does not support;
+// protoc-gen-twirp generates it as a unary route, which is rejected.
+func (twirpService) StreamBatchLookup(ctx context.Context, req *iplookupv1.LookupRequest) (*iplookupv1.BatchLookupResult, error) {
+	return nil, twirpError(twirp.BadRoute, errCodeRouteNotFound, "StreamBatchLookup is served over gRPC only")
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	iplookupv1 "github.com/ali-issa/ip-lookup/proto/iplookup/v1"
+	"github.com/twitchtv/twirp"
+)
+
+func TestTwirpLookup(t *testing.T) {
+	useFakeProvider(t, map[string]any{"city": "London", "country_code": "GB"})
+	srv := httptest.NewServer(newTwirpHandler())
+	t.Cleanup(srv.Close)
+
+	for name, client := range map[string]iplookupv1.IPLookup{
+		"json":     iplookupv1.NewIPLookupJSONClient(srv.URL, http.DefaultClient),
+		"protobuf": iplookupv1.NewIPLookupProtobufClient(srv.URL, http.DefaultClient),
+	} {
+		t.Run(name, func(t *testing.T) {
+			resp, err := client.Lookup(context.Background(), &iplookupv1.LookupRequest{Ip: "81.2.69.142"})
+			if err != nil {
+				t.Fatalf("Lookup: %v", err)
+			}
+			if resp.GetIp() != "81.2.69.142" || resp.GetCity() != "London" || resp.GetCountryCode() != "GB" {
+				t.Errorf("Lookup = %v", resp)
+			}
+
+			_, err = client.Lookup(context.Background(), &iplookupv1.LookupRequest{Ip: "x"})
+			var twerr twirp.Error
+			if !errors.As(err, &twerr) || twerr.Code() != twirp.InvalidArgument || twerr.Meta("error_code") != errCodeInvalidIP {
+				t.Errorf("Lookup of an invalid IP: %v, want invalid_argument with error_code %s", err, errCodeInvalidIP)
+			}
+
+			_, err = client.StreamBatchLookup(context.Background(), &iplookupv1.LookupRequest{Ip: "81.2.69.142"})
+			if !errors.As(err, &twerr) || twerr.Code() != twirp.BadRoute {
+				t.Errorf("StreamBatchLookup: %v, want bad_route", err)
+			}
+		})
+	}
+}
+
+func TestTwirpBatchLookupSize(t *testing.T) {
+	useFakeProvider(t, map[string]any{"country_code": "GB"})
+	srv := httptest.NewServer(newTwirpHandler())
+	t.Cleanup(srv.Close)
+	client := iplookupv1.NewIPLookupProtobufClient(srv.URL, http.DefaultClient)
+
+	tests := []struct {
+		maxSize int
+		ips     int
+		code    twirp.ErrorCode
+	}{
+		{2, 2, twirp.NoError},
+		{2, 3, twirp.InvalidArgument},
+		{0, 1, twirp.BadRoute},
+	}
+	for _, tt := range tests {
+		useConfig(t, Config{BatchLookupMaxSize: tt.maxSize})
+		resp, err := client.BatchLookup(context.Background(), &iplookupv1.BatchLookupRequest{Ips: make([]string, tt.ips)})
+		code := twirp.NoError
+		var twerr twirp.Error
+		if errors.As(err, &twerr) {
+			code = twerr.Code()
+		}
+		if code != tt.code {
+			t.Errorf("BATCH_LOOKUP_MAX_SIZE=%d, %d IPs: code = %q, want %q (%v)", tt.maxSize, tt.ips, code, tt.code, err)
+		}
+		if err == nil && len(resp.GetResults()) != tt.ips {
+			t.Errorf("BATCH_LOOKUP_MAX_SIZE=%d: %d results, want %d", tt.maxSize, len(resp.GetResults()), tt.ips)
+		}
+	}
+}
+
+func TestTwirpLookupMissBehavior(t *testing.T) {
+	useFakeProvider(t, nil)
+	useConfig(t, Config{MissBehavior: missBehaviorNull})
+	srv := httptest.NewServer(newTwirpHandler())
+	t.Cleanup(srv.Close)
+	client := iplookupv1.NewIPLookupProtobufClient(srv.URL, http.DefaultClient)
+
+	resp, err := client.Lookup(context.Background(), &iplookupv1.LookupRequest{Ip: "81.2.69.142"})
+	if err != nil || resp.GetIp() != "81.2.69.142" || resp.GetCountryCode() != "" {
+		t.Errorf("Lookup of an IP without a record = %v, %v, want the empty record", resp, err)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// uiPathPrefix is where the embedded demo UI is mounted.
+const uiPathPrefix = "/ui/"
+
+//go:embed ui
+var uiFiles embed.FS
+
+// uiContentSecurityPolicy limits the UI to its own assets and API, plus the
+// OpenStreetMap embed used for the map preview.
+const uiContentSecurityPolicy = "default-src 'self'; frame-src https://www.openstreetmap.org; object-src 'none'; base-uri 'none'; form-action 'self'"
+
+// uiHandler serves the embedded single-page demo UI under uiPathPrefix.
+func uiHandler() http.Handler {
+	files, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err) // the embedded tree always contains ui/
+	}
+	fileServer := http.StripPrefix(uiPathPrefix, http.FileServerFS(files))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", uiContentSecurityPolicy)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		fileServer.ServeHTTP(w, r)
+	})
+}
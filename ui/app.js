@@ -0,0 +1,133 @@
+"use strict";
+
+// Fields shown for a single lookup, in display order.
+const FIELDS = [
+  ["ip", "IP address"],
+  ["country_name", "Country"],
+  ["country_code", "Country code"],
+  ["subdivision_name", "Subdivision"],
+  ["city", "City"],
+  ["postal_code", "Postal code"],
+  ["continent", "Continent"],
+  ["latitude", "Latitude"],
+  ["longitude", "Longitude"],
+  ["time_zone", "Time zone"],
+];
+
+// Batch lookups are capped like the server's batch APIs and sent a few at a time.
+const MAX_BATCH = 100;
+const BATCH_CONCURRENCY = 5;
+
+async function lookup(ip) {
+  const path = ip ? "/lookup/" + encodeURIComponent(ip) : "/lookup";
+  const resp = await fetch(path, { headers: { Accept: "application/json" } });
+  const body = await resp.json().catch(() => ({ message: resp.statusText }));
+  if (!resp.ok) {
+    throw new Error(body.message || resp.statusText);
+  }
+  return body;
+}
+
+function show(el, visible) {
+  el.hidden = !visible;
+}
+
+function renderResult(record) {
+  const table = document.getElementById("result-table");
+  table.replaceChildren();
+  for (const [key, label] of FIELDS) {
+    const value = record[key];
+    if (value === undefined || value === null || value === "") {
+      continue;
+    }
+    const row = table.insertRow();
+    const th = document.createElement("th");
+    th.textContent = label;
+    row.appendChild(th);
+    row.insertCell().textContent = String(value);
+  }
+
+  const map = document.getElementById("map");
+  const lat = record.latitude;
+  const lon = record.longitude;
+  if (typeof lat === "number" && typeof lon === "number" && (lat !== 0 || lon !== 0)) {
+    const d = 0.5;
+    const bbox = [lon - d, lat - d, lon + d, lat + d].join(",");
+    map.src = "https://www.openstreetmap.org/export/embed.html?bbox=" + encodeURIComponent(bbox) +
+      "&layer=mapnik&marker=" + encodeURIComponent(lat + "," + lon);
+    show(map, true);
+  } else {
+    map.removeAttribute("src");
+    show(map, false);
+  }
+}
+
+document.getElementById("lookup-form").addEventListener("submit", async (event) => {
+  event.preventDefault();
+  const button = event.submitter || event.target.querySelector("button");
+  const error = document.getElementById("error");
+  const result = document.getElementById("result");
+  button.disabled = true;
+  show(error, false);
+  try {
+    renderResult(await lookup(document.getElementById("ip").value.trim()));
+    show(result, true);
+  } catch (err) {
+    show(result, false);
+    error.textContent = err.message;
+    show(error, true);
+  } finally {
+    button.disabled = false;
+  }
+});
+
+function batchRow(tbody, ip) {
+  const row = tbody.insertRow();
+  row.insertCell().textContent = ip;
+  const cells = [row.insertCell(), row.insertCell(), row.insertCell(), row.insertCell()];
+  cells[0].textContent = "…";
+  return {
+    fill(record) {
+      cells[0].textContent = [record.country_name, record.country_code && "(" + record.country_code + ")"].filter(Boolean).join(" ");
+      cells[1].textContent = record.city || "";
+      cells[2].textContent = typeof record.latitude === "number" ? record.latitude + ", " + record.longitude : "";
+      cells[3].textContent = record.time_zone || "";
+    },
+    fail(message) {
+      cells[0].textContent = message;
+      cells[0].colSpan = 4;
+      cells[0].className = "error";
+      cells.slice(1).forEach((cell) => cell.remove());
+    },
+  };
+}
+
+document.getElementById("batch-form").addEventListener("submit", async (event) => {
+  event.preventDefault();
+  const button = event.submitter || event.target.querySelector("button");
+  const ips = [...new Set(document.getElementById("batch").value.split(/[\s,;]+/).filter(Boolean))];
+  const table = document.getElementById("batch-table");
+  const tbody = table.tBodies[0];
+  tbody.replaceChildren();
+  show(table, ips.length > 0);
+  if (ips.length > MAX_BATCH) {
+    batchRow(tbody, ips.length + " addresses").fail("At most " + MAX_BATCH + " addresses can be looked up at once.");
+    return;
+  }
+
+  button.disabled = true;
+  const rows = ips.map((ip) => [ip, batchRow(tbody, ip)]);
+  let next = 0;
+  const worker = async () => {
+    while (next < rows.length) {
+      const [ip, row] = rows[next++];
+      try {
+        row.fill(await lookup(ip));
+      } catch (err) {
+        row.fail(err.message);
+      }
+    }
+  };
+  await Promise.all(Array.from({ length: BATCH_CONCURRENCY }, worker));
+  button.disabled = false;
+});
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// upgradeListenersEnv lists the sockets passed to an upgraded process, as
+// comma-separated "network:address" keys in the order of its extra files
+// (starting at descriptor 3).
+const upgradeListenersEnv = "IP_LOOKUP_UPGRADE_LISTENERS"
+
+// upgradeReadyFDEnv is the descriptor of the pipe on which an upgraded
+// process reports that it is ready to take over.
+const upgradeReadyFDEnv = "IP_LOOKUP_UPGRADE_READY_FD"
+
+// upgradeTimeout bounds how long the new process may take to load the
+// database and start listening before the upgrade is abandoned.
+const upgradeTimeout = 2 * time.Minute
+
+// fileSocket is a listener or packet connection whose descriptor can be
+// duplicated for passing to a child process.
+type fileSocket interface {
+	File() (*os.File, error)
+}
+
+// upgrader hands listening sockets from a running process to a new binary,
+// so deploys do not refuse connections: the new process inherits the
+// sockets, finishes starting up, then tells the old one to drain and exit.
+type upgrader struct {
+	mu        sync.Mutex
+	inherited map[string]*os.File
+	sockets   map[string]*os.File
+	order     []string
+	readyPipe *os.File
+	upgrading bool
+}
+
+// upgrades is the process-wide upgrader, holding any sockets inherited from
+// the previous process.
+var upgrades = newUpgrader()
+
+func newUpgrader() *upgrader {
+	u := &upgrader{inherited: make(map[string]*os.File), sockets: make(map[string]*os.File)}
+	if keys := os.Getenv(upgradeListenersEnv); keys != "" {
+		for i, key := range strings.Split(keys, ",") {
+			u.inherited[key] = os.NewFile(uintptr(3+i), key)
+		}
+	}
+	if fd, err := strconv.Atoi(os.Getenv(upgradeReadyFDEnv)); err == nil {
+		u.readyPipe = os.NewFile(uintptr(fd), "upgrade-ready")
+	}
+	// Not for our own children, which get their own values.
+	os.Unsetenv(upgradeListenersEnv)
+	os.Unsetenv(upgradeReadyFDEnv)
+	return u
+}
+
+// inheriting reports whether this process was started by an upgrade.
+func (u *upgrader) inheriting() bool {
+	return u.readyPipe != nil
+}
+
+// listen returns a TCP listener for addr, reusing the socket inherited from
+// the previous process when there is one.
+func (u *upgrader) listen(network, addr string) (net.Listener, error) {
+	key := network + ":" + addr
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var ln net.Listener
+	var err error
+	if f, ok := u.inherited[key]; ok {
+		delete(u.inherited, key)
+		ln, err = net.FileListener(f)
+		f.Close()
+	} else {
+		ln, err = net.Listen(network, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := u.track(key, ln.(fileSocket)); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// listenPacket is listen for UDP sockets.
+func (u *upgrader) listenPacket(network, addr string) (net.PacketConn, error) {
+	key := network + ":" + addr
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var conn net.PacketConn
+	var err error
+	if f, ok := u.inherited[key]; ok {
+		delete(u.inherited, key)
+		conn, err = net.FilePacketConn(f)
+		f.Close()
+	} else {
+		conn, err = net.ListenPacket(network, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := u.track(key, conn.(fileSocket)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// track keeps a duplicate of the socket's descriptor to pass on in a later
+// upgrade. Callers hold u.mu.
+func (u *upgrader) track(key string, socket fileSocket) error {
+	f, err := socket.File()
+	if err != nil {
+		return fmt.Errorf("duplicating %s socket: %v", key, err)
+	}
+	u.sockets[key] = f
+	u.order = append(u.order, key)
+	return nil
+}
+
+// ready tells the previous process, if any, that this one is serving so it
+// can drain and exit, and writes pidFile when set.
+func (u *upgrader) ready(pidFile string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	// Sockets the new configuration no longer uses are closed with the old process.
+	for key, f := range u.inherited {
+		f.Close()
+		delete(u.inherited, key)
+	}
+	if pidFile != "" {
+		if err := writePIDFile(pidFile); err != nil {
+			return err
+		}
+	}
+	if u.readyPipe == nil {
+		return nil
+	}
+	_, err := u.readyPipe.Write([]byte{1})
+	u.readyPipe.Close()
+	u.readyPipe = nil
+	return err
+}
+
+// writePIDFile atomically replaces path with this process's PID, so a
+// supervisor such as systemd follows the process across upgrades.
+func writePIDFile(path string) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// upgrade starts the current executable with this process's sockets and
+// waits for it to report ready. On success the caller should shut down
+// gracefully; on failure the new process is stopped and this one keeps
+// serving.
+func (u *upgrader) upgrade() (int, error) {
+	u.mu.Lock()
+	if u.upgrading {
+		u.mu.Unlock()
+		return 0, errors.New("an upgrade is already in progress")
+	}
+	u.upgrading = true
+	files := make([]*os.File, 0, len(u.order)+1)
+	for _, key := range u.order {
+		files = append(files, u.sockets[key])
+	}
+	keys := strings.Join(u.order, ",")
+	u.mu.Unlock()
+	defer func() {
+		u.mu.Lock()
+		u.upgrading = false
+		u.mu.Unlock()
+	}()
+
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer readyR.Close()
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(files, readyW)
+	cmd.Env = append(os.Environ(),
+		upgradeListenersEnv+"="+keys,
+		upgradeReadyFDEnv+"="+strconv.Itoa(3+len(files)),
+	)
+	if err := cmd.Start(); err != nil {
+		readyW.Close()
+		return 0, err
+	}
+	readyW.Close() // the child holds its own copy
+
+	// A ready byte means success; EOF means the child exited (or closed the
+	// pipe) without becoming ready.
+	result := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		if _, err := readyR.Read(buf); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = errors.New("new process exited before becoming ready")
+			}
+			result <- err
+			return
+		}
+		result <- nil
+	}()
+	select {
+	case err = <-result:
+	case <-time.After(upgradeTimeout):
+		err = fmt.Errorf("new process not ready after %s", upgradeTimeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 0, err
+	}
+	// The child outlives us; reap it in the background in case we do not exit.
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			log.Printf("Upgraded process %d exited: %v", cmd.Process.Pid, err)
+		}
+	}()
+	return cmd.Process.Pid, nil
+}
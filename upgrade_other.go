@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// upgradeSignal is nil: hot upgrades need Unix descriptor inheritance.
+var upgradeSignal os.Signal
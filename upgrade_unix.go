@@ -0,0 +1,11 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// upgradeSignal starts a hot upgrade, as with tableflip and nginx.
+var upgradeSignal os.Signal = syscall.SIGUSR2
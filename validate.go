@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Request shape limits. Every endpoint is reachable well within them, so
+// anything larger is rejected before routing.
+const (
+	maxRequestURILength = 2048
+	maxPathSegments     = 8
+	maxQueryParams      = 20
+)
+
+// validateRequest checks r against the request shape limits, returning the
+// AppError to send when it is rejected.
+func validateRequest(r *http.Request) (AppError, bool) {
+	if n := len(r.RequestURI); n > maxRequestURILength {
+		return AppError{
+			Message:   "Request URI too long",
+			Code:      http.StatusRequestURITooLong,
+			ErrorCode: errCodeURITooLong,
+			Details:   map[string]int{"length": n, "max_length": maxRequestURILength},
+		}, false
+	}
+	if n := strings.Count(strings.Trim(r.URL.Path, "/"), "/") + 1; n > maxPathSegments {
+		return AppError{
+			Message:   "Too many path segments",
+			Code:      http.StatusBadRequest,
+			ErrorCode: errCodeInvalidRequest,
+			Details:   map[string]int{"segments": n, "max_segments": maxPathSegments},
+		}, false
+	}
+	if r.URL.RawQuery == "" {
+		return AppError{}, true
+	}
+	query, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		return AppError{
+			Message:   fmt.Sprintf("Malformed query string: %v", err),
+			Code:      http.StatusBadRequest,
+			ErrorCode: errCodeInvalidRequest,
+		}, false
+	}
+	n := 0
+	for _, values := range query {
+		n += len(values)
+	}
+	if n > maxQueryParams {
+		return AppError{
+			Message:   "Too many query parameters",
+			Code:      http.StatusBadRequest,
+			ErrorCode: errCodeInvalidRequest,
+			Details:   map[string]int{"params": n, "max_params": maxQueryParams},
+		}, false
+	}
+	return AppError{}, true
+}
+
+// validationMiddleware rejects oversized or malformed requests before they
+// reach rate limiting and handlers.
+func validationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if appErr, ok := validateRequest(r); !ok {
+			writeAppError(w, appErr)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// The build metadata of the binary, set at build time with
+//
+//	-ldflags "-X main.version=v1.2.3 -X main.commit=abc1234 -X main.buildDate=2026-01-02T15:04:05Z"
+//
+// as the Makefile, Dockerfile and release workflow do. Builds without them
+// fall back to what the Go toolchain recorded in the binary.
+var (
+	version   = ""
+	commit    = ""
+	buildDate = ""
+)
+
+// buildInfo returns the version, commit and build date of the binary.
+func buildInfo() (v, c, date string) {
+	v, c, date = version, commit, buildDate
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	if v == "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		v = info.Main.Version
+	}
+	for _, s := range info.Settings {
+		switch {
+		case s.Key == "vcs.revision" && c == "":
+			c = s.Value
+		case s.Key == "vcs.time" && date == "":
+			date = s.Value
+		}
+	}
+	if v == "" {
+		v = "dev"
+	}
+	return
+}
+
+// dbVersion identifies the build of a loaded database.
+func dbVersion(reader *maxminddb.Reader) map[string]any {
+	m := reader.Metadata
+	return map[string]any{
+		"database_type": m.DatabaseType,
+		"build_epoch":   m.BuildEpoch,
+		"build_time":    time.Unix(int64(m.BuildEpoch), 0).UTC().Format(time.RFC3339),
+	}
+}
+
+// versionHandler serves /version: the build of the binary and of the
+// databases it serves, to correlate incidents with deployments. Unlike
+// /metadata, it answers while no database is loaded.
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	v, c, date := buildInfo()
+	response := map[string]any{
+		"version":    v,
+		"commit":     c,
+		"build_date": date,
+		"go_version": runtime.Version(),
+	}
+	databases := make(map[string]any)
+	if mm := maxMindDB(); mm != nil {
+		if reader := mm.current(); reader != nil {
+			databases["primary"] = dbVersion(reader)
+		}
+	} else if primaryDB.loaded() {
+		metadata := primaryDB.metadata()
+		databases["primary"] = map[string]any{"database_type": metadata["database_type"], "build_time": metadata["build_time"]}
+	}
+	if canary := geoCanary.Load(); canary != nil {
+		databases["canary"] = dbVersion(canary.reader)
+	}
+	for _, d := range supplementaryDBs {
+		if reader := d.reader.Load(); reader != nil {
+			databases[d.name] = dbVersion(reader)
+		}
+	}
+	var fallbacks []map[string]any
+	for _, d := range fallbackDBs {
+		if reader := d.reader.Load(); reader != nil {
+			fallback := dbVersion(reader)
+			fallback["path"] = d.path
+			fallbacks = append(fallbacks, fallback)
+		}
+	}
+	if fallbacks != nil {
+		databases["fallback"] = fallbacks
+	}
+	response["databases"] = databases
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error encoding version response: %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// defaultMaxMindWebServiceURL is the base URL of the GeoIP2 web services.
+const defaultMaxMindWebServiceURL = "https://geoip.maxmind.com"
+
+// webServiceCacheSize bounds the number of cached web service answers.
+const webServiceCacheSize = 10000
+
+// maxWebServiceResponseSize caps how much of a web service response is read.
+const maxWebServiceResponseSize = 1 << 20
+
+// webServiceNoRecordCodes are the web service error codes meaning the IP has
+// no data, which are cached like answers rather than reported.
+var webServiceNoRecordCodes = map[string]bool{
+	"IP_ADDRESS_NOT_FOUND": true,
+	"IP_ADDRESS_RESERVED":  true,
+}
+
+// webServiceRecord is the subset of a GeoIP2 web service answer that
+// lookups return. The JSON has the layout of the database records.
+type webServiceRecord struct {
+	City struct {
+		GeoNameID uint              `json:"geoname_id"`
+		Names     map[string]string `json:"names"`
+	} `json:"city"`
+	Continent struct {
+		Code  string            `json:"code"`
+		Names map[string]string `json:"names"`
+	} `json:"continent"`
+	Country struct {
+		IsoCode string            `json:"iso_code"`
+		Names   map[string]string `json:"names"`
+	} `json:"country"`
+	Location struct {
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+		TimeZone  string  `json:"time_zone"`
+	} `json:"location"`
+	Postal struct {
+		Code string `json:"code"`
+	} `json:"postal"`
+	Subdivisions []struct {
+		IsoCode string            `json:"iso_code"`
+		Names   map[string]string `json:"names"`
+	} `json:"subdivisions"`
+}
+
+// webServiceClient is the lookup source querying a GeoIP2 web service (city,
+// country or insights). It caches the answers, which are billed per query.
+type webServiceClient struct {
+	httpClient *http.Client
+	baseURL    string
+	service    string
+	accountID  string
+	licenseKey string
+	// cache holds the lookup fields of each queried IP; nil for an IP the
+	// service has no data for.
+	cache *ttlCache[map[string]any]
+}
+
+func newWebServiceClient(baseURL, service, accountID, licenseKey string, cacheTTL time.Duration) *webServiceClient {
+	return &webServiceClient{
+		httpClient: &http.Client{},
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		service:    service,
+		accountID:  accountID,
+		licenseKey: licenseKey,
+		cache:      newTTLCache[map[string]any](webServiceCacheSize, cacheTTL),
+	}
+}
+
+// lookup returns the lookup fields of ip, served from cache when possible,
+// or nil when the service has no data for ip.
+func (c *webServiceClient) lookup(ctx context.Context, ip net.IP) (map[string]any, error) {
+	key := ip.String()
+	if fields, ok := c.cache.get(key); ok {
+		// Callers add fields to the response; keep the cached copy intact.
+		return maps.Clone(fields), nil
+	}
+	record, err := c.query(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]any
+	if record != nil {
+		city := record.cityRecord()
+		if c.service == "country" {
+			fields = countryFields(ip, city)
+		} else {
+			fields = cityFields(ip, city)
+		}
+	}
+	c.cache.set(key, fields)
+	return maps.Clone(fields), nil
+}
+
+// query requests ip from the service. It returns nil for an IP the service
+// has no data for.
+func (c *webServiceClient) query(ctx context.Context, ip string) (*webServiceRecord, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/geoip/v2.1/%s/%s", c.baseURL, c.service, ip), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.accountID, c.licenseKey)
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body := io.LimitReader(resp.Body, maxWebServiceResponseSize)
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Code  string `json:"code"`
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(body).Decode(&apiErr) != nil || apiErr.Code == "" {
+			return nil, fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		if webServiceNoRecordCodes[apiErr.Code] {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%s: %s", apiErr.Code, apiErr.Error)
+	}
+	var record webServiceRecord
+	if err := json.NewDecoder(body).Decode(&record); err != nil {
+		return nil, fmt.Errorf("decoding the response: %v", err)
+	}
+	return &record, nil
+}
+
+// cityRecord converts r to the database record layout.
+func (r *webServiceRecord) cityRecord() *geoip2.City {
+	var record geoip2.City
+	record.City.GeoNameID = r.City.GeoNameID
+	record.City.Names = r.City.Names
+	record.Continent.Code = r.Continent.Code
+	record.Continent.Names = r.Continent.Names
+	record.Country.IsoCode = r.Country.IsoCode
+	record.Country.Names = r.Country.Names
+	record.Location.Latitude = r.Location.Latitude
+	record.Location.Longitude = r.Location.Longitude
+	record.Location.TimeZone = r.Location.TimeZone
+	record.Postal.Code = r.Postal.Code
+	record.Subdivisions = slices.Grow(record.Subdivisions, len(r.Subdivisions))[:len(r.Subdivisions)]
+	for i, subdivision := range r.Subdivisions {
+		record.Subdivisions[i].IsoCode = subdivision.IsoCode
+		record.Subdivisions[i].Names = subdivision.Names
+	}
+	return &record
+}
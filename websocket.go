@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// This file serves lookups over WebSocket at /ws, using
+// github.com/coder/websocket for the protocol.
+
+// wsMaxMessageSize caps a client message; lookups are a few dozen bytes.
+const wsMaxMessageSize = 4096
+
+// wsMaxInFlight caps the lookups of a connection run at a time. Reading
+// stops while they are all busy.
+const wsMaxInFlight = 16
+
+// wsIdleTimeout closes connections that send nothing, not even a ping, for
+// this long.
+const wsIdleTimeout = 5 * time.Minute
+
+// wsWriteTimeout bounds the write of a result to a slow client.
+const wsWriteTimeout = 10 * time.Second
+
+// wsRequest is a JSON message: an IP and an optional ID echoed in its
+// result, so clients can match results that arrive out of order.
+type wsRequest struct {
+	ID any    `json:"id"`
+	IP string `json:"ip"`
+}
+
+// wsOriginAllowed reports whether a browser page of r's origin may open a
+// connection: one of the same origin or of ALLOWED_CORS_ORIGINS. Browsers do
+// not apply CORS to WebSocket, so without the check any page could make
+// lookups from its visitors' browsers. Clients other than browsers send no
+// Origin and are allowed.
+func wsOriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range appConfig.AllowedCORSAccessOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// webSocketHandler serves GET /ws. Each text message is an IP, or a JSON
+// object with an ip and an id, answered with a text message holding its
+// lookup as a batch item. Lookups run concurrently, so results can arrive in
+// another order than their requests.
+func webSocketHandler(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimSpace(r.Header.Get("Sec-WebSocket-Key"))
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") || key == "" {
+		writeJSONError(w, "Expected a WebSocket upgrade request", http.StatusBadRequest, errCodeInvalidRequest)
+		return
+	}
+	if !wsOriginAllowed(r) {
+		writeJSONError(w, fmt.Sprintf("WebSocket connections from origin %s are not allowed", r.Header.Get("Origin")), http.StatusForbidden, errCodeAccessDenied)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeJSONError(w, "Unsupported WebSocket version: only 13 is supported", http.StatusUpgradeRequired, errCodeInvalidRequest)
+		return
+	}
+	if !databaseLoaded() {
+		writeJSONError(w, "GeoIP service not available", http.StatusInternalServerError, errCodeDBUnavailable)
+		return
+	}
+	// The server's read and write timeouts would otherwise apply to the
+	// connection after the upgrade.
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	var idle *time.Timer
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+		// wsOriginAllowed has checked the origin.
+		InsecureSkipVerify: true,
+		OnPingReceived: func(context.Context, []byte) bool {
+			idle.Reset(wsIdleTimeout)
+			return true
+		},
+	})
+	if err != nil {
+		log.Printf("WebSocket: handshake failed: %v", err)
+		return
+	}
+	defer conn.CloseNow()
+	// The lookups of the connection are shed by wsMaxInFlight instead.
+	releaseShedderSlot(r)
+	conn.SetReadLimit(wsMaxMessageSize)
+	idle = time.AfterFunc(wsIdleTimeout, func() { conn.CloseNow() })
+	defer idle.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, wsMaxInFlight)
+	code, reason := websocket.StatusNormalClosure, ""
+	for {
+		typ, msg, err := conn.Read(ctx)
+		if err != nil {
+			// The library has answered the client's close, or closed the
+			// connection over the error, itself.
+			break
+		}
+		idle.Reset(wsIdleTimeout)
+		if typ != websocket.MessageText {
+			code, reason = websocket.StatusUnsupportedData, "binary messages are not supported"
+			break
+		}
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-slots; wg.Done() }()
+			result, err := json.Marshal(wsLookup(ctx, msg))
+			if err == nil {
+				writeCtx, cancelWrite := context.WithTimeout(ctx, wsWriteTimeout)
+				err = conn.Write(writeCtx, websocket.MessageText, result)
+				cancelWrite()
+			}
+			if err != nil {
+				cancel()
+			}
+		}()
+	}
+	// Lookups still running answer before the connection closes.
+	wg.Wait()
+	conn.Close(code, reason)
+}
+
+// wsLookup answers a message with the lookup of its IP.
+func wsLookup(ctx context.Context, msg []byte) map[string]any {
+	text := strings.TrimSpace(string(msg))
+	if !strings.HasPrefix(text, "{") {
+		result, _ := lookupBatchItem(ctx, text)
+		return result
+	}
+	var req wsRequest
+	if err := json.Unmarshal(msg, &req); err != nil || req.IP == "" {
+		return map[string]any{"error": `Invalid message: must be an IP address or a JSON object with an "ip"`, "error_code": errCodeInvalidRequest}
+	}
+	result, _ := lookupBatchItem(ctx, req.IP)
+	if req.ID != nil {
+		// The result may be shared with other lookups, so it is copied.
+		withID := make(map[string]any, len(result)+1)
+		for k, v := range result {
+			withID[k] = v
+		}
+		withID["id"] = req.ID
+		result = withID
+	}
+	return result
+}
+
+// headerHasToken reports whether a comma-separated header of h contains
+// token, case-insensitively.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+func TestWebSocketOriginCheck(t *testing.T) {
+	useConfig(t, Config{AllowedCORSAccessOrigins: []string{"https://app.example.com"}})
+
+	for _, tc := range []struct {
+		origin string
+		want   int
+	}{
+		{"https://evil.example.net", http.StatusForbidden},
+		{"https://app.example.com", http.StatusUpgradeRequired},
+		{"http://geo.example.com", http.StatusUpgradeRequired},
+		{"", http.StatusUpgradeRequired},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://geo.example.com/ws", nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		// An unsupported version stops allowed handshakes before the upgrade.
+		req.Header.Set("Sec-WebSocket-Version", "8")
+		if tc.origin != "" {
+			req.Header.Set("Origin", tc.origin)
+		}
+		rec := httptest.NewRecorder()
+		webSocketHandler(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("Origin %q: status = %d, want %d", tc.origin, rec.Code, tc.want)
+		}
+	}
+}
+
+// dialWebSocket opens a connection to the /ws endpoint of srv.
+func dialWebSocket(t *testing.T, ctx context.Context, srv *httptest.Server) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.CloseNow() })
+	return conn
+}
+
+func TestWebSocketLookup(t *testing.T) {
+	useFakeProvider(t, map[string]any{"country_code": "GB"})
+	old := shedder
+	shedder = &loadShedder{max: 1}
+	t.Cleanup(func() { shedder = old })
+	srv := httptest.NewServer(throttleMiddleware(http.HandlerFunc(webSocketHandler)))
+	t.Cleanup(srv.Close)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn := dialWebSocket(t, ctx, srv)
+	conn.Write(ctx, websocket.MessageText, []byte(`{"id": 7, "ip": "81.2.69.142"}`))
+	typ, payload, err := conn.Read(ctx)
+	if err != nil || typ != websocket.MessageText {
+		t.Fatalf("reply: type %v, %v", typ, err)
+	}
+	var result map[string]any
+	if err := json.Unmarshal(payload, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result["id"] != 7.0 || result["ip"] != "81.2.69.142" || result["country_code"] != "GB" {
+		t.Errorf("result = %v", result)
+	}
+
+	conn.Write(ctx, websocket.MessageText, []byte(`{"id": 8}`))
+	if _, payload, err = conn.Read(ctx); err != nil || !strings.Contains(string(payload), errCodeInvalidRequest) {
+		t.Errorf("reply to a message without an ip = %s, %v", payload, err)
+	}
+
+	// CloseRead reads the pong in the background.
+	conn.CloseRead(ctx)
+	if err := conn.Ping(ctx); err != nil {
+		t.Errorf("ping: %v", err)
+	}
+
+	// The open connection does not hold a load shedder slot.
+	resp, err := http.Get(srv.URL + "/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("request during an open connection: status = %d, want 400 rather than a shed 503", resp.StatusCode)
+	}
+
+	if err := conn.Close(websocket.StatusNormalClosure, ""); err != nil {
+		t.Errorf("close: %v", err)
+	}
+}
+
+func TestWebSocketCloseCodes(t *testing.T) {
+	useFakeProvider(t, map[string]any{"country_code": "GB"})
+	srv := httptest.NewServer(http.HandlerFunc(webSocketHandler))
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		name string
+		typ  websocket.MessageType
+		msg  []byte
+		want websocket.StatusCode
+	}{
+		{"binary", websocket.MessageBinary, []byte{1}, websocket.StatusUnsupportedData},
+		{"too large", websocket.MessageText, make([]byte, wsMaxMessageSize+1), websocket.StatusMessageTooBig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			conn := dialWebSocket(t, ctx, srv)
+			conn.Write(ctx, tt.typ, tt.msg)
+			_, _, err := conn.Read(ctx)
+			if got := websocket.CloseStatus(err); got != tt.want {
+				t.Errorf("close status = %v (%v), want %v", got, err, tt.want)
+			}
+		})
+	}
+}